@@ -25,6 +25,7 @@ import (
 	"github.com/clearlinux/clr-installer/conf"
 	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/timezone"
 	spflag "github.com/spf13/pflag"
 )
 
@@ -34,8 +35,12 @@ const (
 	kernelCmdlineLog          = "clri.loglevel"
 	kernelCmdlineHighContrast = "clri.hc"
 	// KernelMediaCheck is used to create a verufy ISO media boot menu
-	KernelMediaCheck  = "clri.mediacheck"
-	logFileEnvironVar = "CLR_INSTALLER_LOG_FILE"
+	KernelMediaCheck     = "clri.mediacheck"
+	logFileEnvironVar    = "CLR_INSTALLER_LOG_FILE"
+	resultFileEnvironVar = "CLR_INSTALLER_RESULT_FILE"
+
+	// CryptPassEnvironVar is the env var holding the cryptsetup passphrase
+	CryptPassEnvironVar = "CLR_CRYPT_PASS"
 )
 
 var (
@@ -50,17 +55,26 @@ type Args struct {
 	Offline                 bool
 	OfflineSet              bool
 	LogFile                 string
+	LockFile                string
+	TempDir                 string
+	ResultFile              string
 	ConfigFile              string
+	ConfigSHA256            string
 	CfDownloaded            bool
 	CfPurge                 bool
 	CfPurgeSet              bool
 	AllowInsecureHTTP       bool
 	AllowInsecureHTTPSet    bool
+	AddressFamily           string
+	AddressFamilySet        bool
 	CryptPassFile           string
+	CryptPassStdin          bool
 	SwupdSkipOptional       bool
 	SwupdSkipOptionalSet    bool
+	SwupdMaxParallel        uint
 	SwupdMirror             string
 	SwupdStateDir           string
+	SwupdCacheDir           string
 	SwupdCertPath           string
 	SwupdStateClean         bool
 	SwupdFormat             string
@@ -82,10 +96,16 @@ type Args struct {
 	ArchiveSet              bool
 	DemoMode                bool
 	Bundles                 []string
+	BundlesFile             string
 	BlockDevices            []string
 	StubImage               bool
+	RootfsOnly              string
 	ConvertConfigFile       string
+	ConvertYAMLConfigFile   string
 	TemplateConfigFile      string
+	InteractiveConfigFile   string
+	CloneLayout             string
+	VerifyInstall           bool
 	MakeISO                 bool
 	MakeISOSet              bool
 	KeepImage               bool
@@ -101,7 +121,17 @@ type Args struct {
 	SkipValidationAll       bool
 	SkipValidationAllSet    bool
 	SwapFileSize            string
+	NoSwap                  bool
 	ForceDestructive        bool
+	ForceUnmount            bool
+	FixClock                bool
+	TimezoneGeolocation     bool
+	TimezoneGeolocationURL  string
+	SmartCheck              string
+	PostInstallVerify       bool
+	PostInstallVerifyWarn   bool
+	LogJournald             bool
+	Hostname                string
 }
 
 func (args *Args) setKernelArgs() (err error) {
@@ -242,6 +272,16 @@ func (args *Args) setCommandLineArgs() (err error) {
 		&args.Bundles, "bundles", "B", args.Bundles, "Comma-separated list of bundles to install",
 	)
 
+	flag.StringVar(
+		&args.BundlesFile, "bundles-file", args.BundlesFile,
+		"File listing the bundles to install, one per line; blank and # lines are skipped",
+	)
+
+	flag.StringVar(
+		&args.RootfsOnly, "rootfs-only", args.RootfsOnly,
+		"Install the OS content into a directory, i.e. for a container base image; no partitioning nor boot loader",
+	)
+
 	flag.StringSliceVarP(
 		&args.BlockDevices, "block-device", "b", args.BlockDevices,
 		"Adds a new block-device's entry to configuration file. Format: <alias:filename>",
@@ -251,10 +291,22 @@ func (args *Args) setCommandLineArgs() (err error) {
 		&args.ConfigFile, "config", "c", args.ConfigFile, "Installation configuration file",
 	)
 
+	flag.StringVar(
+		&args.ConfigSHA256, "config-sha256", args.ConfigSHA256,
+		"Expected SHA-256 digest of the configuration file; abort the install on mismatch",
+	)
+
+	// The cryptsetup passphrase is read from the first available source:
+	// --crypt-file, then the CLR_CRYPT_PASS env var, then stdin with --crypt-stdin
 	flag.StringVar(
 		&args.CryptPassFile, "crypt-file", args.CryptPassFile, "File containing the cryptsetup password",
 	)
 
+	flag.BoolVar(
+		&args.CryptPassStdin, "crypt-stdin", false,
+		"Read the cryptsetup password from stdin if neither --crypt-file nor "+CryptPassEnvironVar+" is set",
+	)
+
 	flag.StringVar(
 		&args.SwupdMirror, "swupd-mirror", args.SwupdMirror, "Swupd --url; sets target mirror",
 	)
@@ -263,6 +315,11 @@ func (args *Args) setCommandLineArgs() (err error) {
 		&args.SwupdStateDir, "swupd-state", args.SwupdStateDir, "Swupd --statedir",
 	)
 
+	flag.StringVar(
+		&args.SwupdCacheDir, "swupd-cache", args.SwupdCacheDir,
+		"Swupd --statedir-cache, kept and refreshed after each install to reuse the content",
+	)
+
 	flag.StringVar(
 		&args.SwupdCertPath, "swupd-cert", args.SwupdCertPath, "Swupd --certpath",
 	)
@@ -314,11 +371,31 @@ func (args *Args) setCommandLineArgs() (err error) {
 		"Converts ister JSON config to clr-installer YAML config",
 	)
 
+	flag.StringVar(
+		&args.ConvertYAMLConfigFile, "yaml-json", args.ConvertYAMLConfigFile,
+		"Converts clr-installer YAML config to indented ister JSON config",
+	)
+
 	flag.StringVarP(
 		&args.TemplateConfigFile, "template", "T", args.TemplateConfigFile,
 		"Generates a template clr-installer YAML config file",
 	)
 
+	flag.StringVar(
+		&args.InteractiveConfigFile, "interactive-config", args.InteractiveConfigFile,
+		"Builds a clr-installer YAML config file by prompting on the command line",
+	)
+
+	flag.StringVar(
+		&args.CloneLayout, "clone-layout", args.CloneLayout,
+		"Clone the partition layout of an existing disk onto the target media",
+	)
+
+	flag.BoolVar(
+		&args.VerifyInstall, "verify-install", false,
+		"Verify an installed target matches the configuration, without modifying it, and exit",
+	)
+
 	flag.StringVar(
 		&args.TelemetryURL, "telemetry-url", args.TelemetryURL, "Telemetry server URL",
 	)
@@ -345,16 +422,31 @@ func (args *Args) setCommandLineArgs() (err error) {
 			log.LogLevelDebug, log.LogLevelInfo, log.LogLevelWarning, log.LogLevelError),
 	)
 
+	flag.BoolVar(
+		&args.LogJournald, "log-journald", false,
+		"Also send the log to the systemd journal",
+	)
+
 	flag.BoolVar(
 		&args.AllowInsecureHTTP, "allow-insecure-http", false,
 		"Allow installation over insecure connections",
 	)
 
+	flag.StringVar(
+		&args.AddressFamily, "address-family", "auto",
+		"Address family of the configuration and content fetches: auto, ipv4 or ipv6",
+	)
+
 	flag.BoolVar(
 		&args.SwupdSkipOptional, "swupd-skip-optional", false,
 		"Swupd --skip-optional; don't install optionally included bundles",
 	)
 
+	flag.UintVar(
+		&args.SwupdMaxParallel, "swupd-max-parallel", args.SwupdMaxParallel,
+		"Swupd --max-parallel-downloads; swupd's own default when 0",
+	)
+
 	flag.BoolVar(
 		&args.Archive, "archive", true, "Archive data to target after finishing",
 	)
@@ -381,6 +473,27 @@ func (args *Args) setCommandLineArgs() (err error) {
 		&args.LogFile, "log-file", defaultLogFile, "The log file path",
 	)
 
+	flag.StringVar(
+		&args.LockFile, "lock-file", args.LockFile,
+		"The install lock file path, derived from the log file path by default",
+	)
+
+	flag.StringVar(
+		&args.TempDir, "temp-dir", args.TempDir,
+		"The directory holding the temporary install root, removed after each run",
+	)
+
+	var defaultResultFile string
+
+	// use the env var CLR_INSTALLER_RESULT_FILE to determine the result file path
+	if defaultResultFile = os.Getenv(resultFileEnvironVar); defaultResultFile == "" {
+		defaultResultFile = filepath.Join(usr.HomeDir, conf.ResultFile)
+	}
+
+	flag.StringVar(
+		&args.ResultFile, "result-file", defaultResultFile, "The installation result file path",
+	)
+
 	flag.BoolVar(
 		&args.MakeISO, "iso", false, "Generate Hybrid ISO image (Legacy/UEFI bootable)",
 	)
@@ -427,6 +540,14 @@ func (args *Args) setCommandLineArgs() (err error) {
 		&args.SwapFileSize, "swap-file-size", args.SwapFileSize, "Size of the swapfile; <size>[B|K|M|G]",
 	)
 
+	flag.BoolVar(
+		&args.NoSwap, "no-swap", false, "Do not create any swap partition nor swapfile",
+	)
+
+	flag.StringVar(
+		&args.Hostname, "hostname", args.Hostname, "Hostname of the target system",
+	)
+
 	flag.BoolVar(
 		&args.ForceDestructive, "force-destructive",
 		false,
@@ -434,6 +555,41 @@ func (args *Args) setCommandLineArgs() (err error) {
 			" "+"RAID, lvm etc. Proceed with caution!",
 	)
 
+	flag.BoolVar(
+		&args.ForceUnmount, "force-unmount", false,
+		"Unmount the partitions and close the LUKS or LVM volumes of the target media left in use, i.e. by a previous failed install",
+	)
+
+	flag.BoolVar(
+		&args.FixClock, "fix-clock", false,
+		"Set the system clock from the swupd content server when it is not sane instead of only warning",
+	)
+
+	flag.BoolVar(
+		&args.TimezoneGeolocation, "timezone-geolocation", false,
+		"Query the time zone from a geolocation service when none is configured",
+	)
+
+	flag.StringVar(
+		&args.TimezoneGeolocationURL, "timezone-geolocation-url", "",
+		"Geolocation service answering the plain time zone name, defaults to "+timezone.DefaultGeolocationURL,
+	)
+
+	flag.StringVar(
+		&args.SmartCheck, "smart-check", "",
+		"Check the SMART health of the target disks; 'warn' or 'block' the install on failing disks",
+	)
+
+	flag.BoolVar(
+		&args.PostInstallVerify, "post-install-verify", false,
+		"Verify the installed files with swupd once the content is installed",
+	)
+
+	flag.BoolVar(
+		&args.PostInstallVerifyWarn, "post-install-verify-warn", false,
+		"Only warn, instead of failing the install, when the post-install verification finds corrupted files",
+	)
+
 	spflag.ErrHelp = errors.New("Clear Linux Installer program")
 
 	saveConfigFile := args.ConfigFile
@@ -472,9 +628,62 @@ func (args *Args) setCommandLineArgs() (err error) {
 		}
 	}
 
+	if args.BundlesFile != "" {
+		if len(args.Bundles) > 0 {
+			return errors.New("--bundles and --bundles-file are mutually exclusive")
+		}
+
+		if args.Bundles, err = readBundlesFile(args.BundlesFile); err != nil {
+			return err
+		}
+	}
+
+	if args.ConfigSHA256 != "" && args.ConfigFile == "" {
+		return errors.New("--config-sha256 requires a configuration file, use --config")
+	}
+
+	if args.RootfsOnly != "" {
+		if args.ConfigFile == "" {
+			return errors.New("--rootfs-only requires a configuration file, use --config")
+		}
+
+		if args.StubImage {
+			return errors.New("--rootfs-only and --stub-image are mutually exclusive")
+		}
+
+		if args.ForceTUI || args.ForceGUI {
+			return errors.New("--rootfs-only is only supported by the non-interactive installer")
+		}
+	}
+
 	return nil
 }
 
+// readBundlesFile returns the bundles listed in file, one per line, skipping
+// the blank and # comment lines
+func readBundlesFile(file string) ([]string, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read --bundles-file: %v", err)
+	}
+
+	bundles := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		bundles = append(bundles, line)
+	}
+
+	if len(bundles) == 0 {
+		return nil, fmt.Errorf("No bundle listed in --bundles-file %s", file)
+	}
+
+	return bundles, nil
+}
+
 // setBoolFlagCheck determines whether or not boolean arguments were set on
 // the command line
 func (args *Args) setBoolFlagCheck(flag *spflag.FlagSet) {
@@ -513,6 +722,13 @@ func (args *Args) setBoolFlagCheck(flag *spflag.FlagSet) {
 		}
 	}
 
+	fflag = flag.Lookup("address-family")
+	if fflag != nil {
+		if fflag.Changed {
+			args.AddressFamilySet = true
+		}
+	}
+
 	fflag = flag.Lookup("swupd-skip-optional")
 	if fflag != nil {
 		if fflag.Changed {
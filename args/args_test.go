@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/clearlinux/clr-installer/log"
@@ -419,6 +420,26 @@ func TestConvertArg(t *testing.T) {
 	}
 }
 
+func TestConvertYAMLArg(t *testing.T) {
+	var testArgs Args
+
+	currArgs := make([]string, len(os.Args))
+	copy(currArgs, os.Args)
+
+	os.Args = []string{currArgs[0], currArgs[1], currArgs[2], "--yaml-json", "fubar.yaml"}
+	t.Logf("Current os.Args: %v", os.Args)
+
+	err := testArgs.setCommandLineArgs()
+
+	os.Args = currArgs
+	if err != nil {
+		t.Fatal("Failed to parse arguments")
+	}
+	if testArgs.ConvertYAMLConfigFile != "fubar.yaml" {
+		t.Fatal("Failed to parse config file for --yaml-json")
+	}
+}
+
 func TestBundleArg(t *testing.T) {
 	var testArgs Args
 
@@ -440,6 +461,55 @@ func TestBundleArg(t *testing.T) {
 	}
 }
 
+func TestBundlesFileArg(t *testing.T) {
+	var testArgs Args
+
+	file, err := makeTestKernelCmd("# Desktop bundles\n  os-core  \n\nos-core-update\n\t# editors\nvim\n")
+	defer func() { _ = os.Remove(file) }()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currArgs := make([]string, len(os.Args))
+	copy(currArgs, os.Args)
+
+	os.Args = []string{currArgs[0], currArgs[1], currArgs[2], "--bundles-file", file}
+	err = testArgs.setCommandLineArgs()
+	os.Args = currArgs
+	if err != nil {
+		t.Fatalf("Failed to parse arguments: %v", err)
+	}
+
+	expected := []string{"os-core", "os-core-update", "vim"}
+	if fmt.Sprintf("%v", testArgs.Bundles) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("Expected bundles %v, got %v", expected, testArgs.Bundles)
+	}
+
+	testArgs = Args{}
+	os.Args = []string{currArgs[0], currArgs[1], currArgs[2], "--bundles-file", file, "--bundles", "vim"}
+	err = testArgs.setCommandLineArgs()
+	os.Args = currArgs
+	if err == nil {
+		t.Fatal("--bundles and --bundles-file should be mutually exclusive")
+	}
+
+	empty, err := makeTestKernelCmd("# no bundles\n\n")
+	defer func() { _ = os.Remove(empty) }()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []string{empty, "/tmp/missing-bundles-file"} {
+		testArgs = Args{}
+		os.Args = []string{currArgs[0], currArgs[1], currArgs[2], "--bundles-file", bad}
+		err = testArgs.setCommandLineArgs()
+		os.Args = currArgs
+		if err == nil {
+			t.Fatalf("--bundles-file %s should be invalid", bad)
+		}
+	}
+}
+
 func TestTemplateArg(t *testing.T) {
 	var testArgs Args
 
@@ -645,3 +715,69 @@ func TestKernelAndCommandlineAllArgs(t *testing.T) {
 		t.Errorf("Command Line '--skip-validation-all' is not defaulted to 'false'")
 	}
 }
+
+func TestRootfsOnlyArg(t *testing.T) {
+	currArgs := make([]string, len(os.Args))
+	copy(currArgs, os.Args)
+	defer func() { os.Args = currArgs }()
+
+	tests := []struct {
+		args  []string
+		valid bool
+	}{
+		{[]string{"--config", "container.yaml", "--rootfs-only", "/var/tmp/rootfs"}, true},
+		{[]string{"--rootfs-only", "/var/tmp/rootfs"}, false},
+		{[]string{"--config", "container.yaml", "--rootfs-only", "/var/tmp/rootfs", "--stub-image"}, false},
+		{[]string{"--config", "container.yaml", "--rootfs-only", "/var/tmp/rootfs", "--tui"}, false},
+	}
+
+	for _, curr := range tests {
+		var testArgs Args
+
+		os.Args = append([]string{currArgs[0], currArgs[1], currArgs[2]}, curr.args...)
+		err := testArgs.setCommandLineArgs()
+		if curr.valid && err != nil {
+			t.Fatalf("Failed to parse %v: %v", curr.args, err)
+		}
+		if !curr.valid && err == nil {
+			t.Fatalf("Arguments %v should be refused", curr.args)
+		}
+
+		if curr.valid && testArgs.RootfsOnly != "/var/tmp/rootfs" {
+			t.Fatalf("Expected the rootfs directory /var/tmp/rootfs, got %q", testArgs.RootfsOnly)
+		}
+	}
+}
+
+func TestConfigSHA256Arg(t *testing.T) {
+	currArgs := make([]string, len(os.Args))
+	copy(currArgs, os.Args)
+	defer func() { os.Args = currArgs }()
+
+	digest := strings.Repeat("0", 64)
+
+	tests := []struct {
+		args  []string
+		valid bool
+	}{
+		{[]string{"--config", "clr-installer.yaml", "--config-sha256", digest}, true},
+		{[]string{"--config-sha256", digest}, false},
+	}
+
+	for _, curr := range tests {
+		var testArgs Args
+
+		os.Args = append([]string{currArgs[0], currArgs[1], currArgs[2]}, curr.args...)
+		err := testArgs.setCommandLineArgs()
+		if curr.valid && err != nil {
+			t.Fatalf("Failed to parse %v: %v", curr.args, err)
+		}
+		if !curr.valid && err == nil {
+			t.Fatalf("Arguments %v should be refused", curr.args)
+		}
+
+		if curr.valid && testArgs.ConfigSHA256 != digest {
+			t.Fatalf("Expected the digest %s, got %q", digest, testArgs.ConfigSHA256)
+		}
+	}
+}
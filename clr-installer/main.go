@@ -5,7 +5,9 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/signal"
@@ -18,6 +20,7 @@ import (
 	"syscall"
 
 	"github.com/nightlyone/lockfile"
+	"gopkg.in/yaml.v2"
 
 	"github.com/clearlinux/clr-installer/args"
 	"github.com/clearlinux/clr-installer/cmd"
@@ -25,6 +28,8 @@ import (
 	"github.com/clearlinux/clr-installer/encrypt"
 	"github.com/clearlinux/clr-installer/errors"
 	"github.com/clearlinux/clr-installer/frontend"
+	"github.com/clearlinux/clr-installer/hostname"
+	"github.com/clearlinux/clr-installer/interactive"
 	"github.com/clearlinux/clr-installer/keyboard"
 	"github.com/clearlinux/clr-installer/language"
 	"github.com/clearlinux/clr-installer/log"
@@ -39,11 +44,16 @@ import (
 	"github.com/clearlinux/clr-installer/utils"
 )
 
+// exitInsufficientSpace is the exit code of an installation running out of
+// disk space, the ENOSPC errno value
+const exitInsufficientSpace = 28
+
 var (
 	frontEndImpls []frontend.Frontend
 	classExp      = regexp.MustCompile(`(?im)(\w+)`)
 	lockFile      = "/root/clr-installer.lock"
 	lock          lockfile.Lockfile
+	cryptStdin    io.Reader = os.Stdin
 )
 
 func validateTelemetry(options args.Args, md *model.SystemInstall) error {
@@ -116,6 +126,14 @@ func main() {
 	}
 	log.SetLogLevel(options.LogLevel)
 
+	if options.LogJournald {
+		if err = log.SetJournaldOutput(); err != nil {
+			fmt.Println("Set Journald Log Error: " + err.Error())
+			os.Exit(1)
+		}
+		defer log.CloseJournaldOutput()
+	}
+
 	// Begin installer execution
 	if err := execute(options); err != nil {
 		// Print and log errors with stack traces. To include stack traces, the
@@ -123,6 +141,9 @@ func main() {
 		fmt.Println(err.Error())
 		log.Error("%s", err)
 		_ = f.Close()
+		if errors.IsInsufficientSpaceError(err) {
+			os.Exit(exitInsufficientSpace)
+		}
 		os.Exit(1)
 	}
 }
@@ -148,6 +169,9 @@ func callFrontEnd(options args.Args, md *model.SystemInstall, installReboot *boo
 			if errors.IsValidationError(err) {
 				fmt.Println("Error: Invalid configuration:")
 				errChan <- err
+			} else if errors.IsInsufficientSpaceError(err) {
+				fmt.Println("Error: Insufficient disk space, choose a larger target or fewer bundles:")
+				errChan <- err
 			} else {
 				log.RequestCrashInfo()
 				errChan <- err
@@ -188,6 +212,15 @@ func checkAndLoadConfigFile(options args.Args, md **model.SystemInstall) (string
 		return "", errors.Errorf("Cannot access configuration file %q", options.ConfigFile)
 	}
 
+	// Verify the file as provided, before any JSON conversion
+	if options.ConfigSHA256 != "" {
+		if err = network.VerifyConfigFileSHA256(cf, options.ConfigSHA256); err != nil {
+			fmt.Printf("Cannot verify configuration file %q: %s\n", options.ConfigFile, err)
+			return "", err
+		}
+		log.Info("Verified SHA-256 digest of configuration file %q", options.ConfigFile)
+	}
+
 	if filepath.Ext(cf) == ".json" {
 		_, err = model.JSONtoYAMLConfig(cf)
 		if err != nil {
@@ -218,6 +251,9 @@ func processSwupdOptions(options args.Args, md *model.SystemInstall) {
 	if options.SwupdSkipOptionalSet {
 		md.SwupdSkipOptional = options.SwupdSkipOptional
 	}
+	if options.SwupdMaxParallel != 0 {
+		md.SwupdMaxParallel = options.SwupdMaxParallel
+	}
 	if options.SwupdVersion != "" {
 		if version, err := utils.VersionStringUint(options.SwupdVersion); err == nil {
 			md.Version = version
@@ -234,6 +270,10 @@ func processSwupdOptions(options args.Args, md *model.SystemInstall) {
 		md.AllowInsecureHTTP = options.AllowInsecureHTTP
 	}
 
+	if options.AddressFamilySet {
+		md.AddressFamily = options.AddressFamily
+	}
+
 	if !md.AutoUpdate.IsSet() {
 		osVersion, err := strconv.ParseUint(utils.ClearVersion, 10, 32)
 		if err == nil {
@@ -271,8 +311,11 @@ func processPamSaltOption(options args.Args) error {
 func processNotStubImageOption(options args.Args, md *model.SystemInstall) error {
 	var err error
 	if !options.StubImage {
-		// Now validate the mirror from the config or command line
-		if md.SwupdMirror != "" {
+		// Now validate the mirror from the config or command line, a local
+		// content directory does not use the mirror at all
+		if md.LocalContentDir != "" {
+			log.Info("Using local swupd content directory %q, skipping mirror validation", md.LocalContentDir)
+		} else if md.SwupdMirror != "" {
 			var url string
 			url, err = swupd.SetHostMirror(md.SwupdMirror, md.AllowInsecureHTTP)
 			if err != nil {
@@ -284,6 +327,70 @@ func processNotStubImageOption(options args.Args, md *model.SystemInstall) error
 		if err = validateTelemetry(options, md); err != nil {
 			return err
 		}
+
+		if err = checkCryptPassOption(options, md); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkWorkDirOptions checks the temp and swupd cache directories, when set,
+// are existing directories the installer can write to
+func checkWorkDirOptions(options args.Args) error {
+	dirs := []struct {
+		flag string
+		path string
+	}{
+		{"temp-dir", options.TempDir},
+		{"swupd-cache", options.SwupdCacheDir},
+	}
+
+	for _, dir := range dirs {
+		if dir.path == "" {
+			continue
+		}
+
+		if info, err := os.Stat(dir.path); err != nil || !info.IsDir() {
+			return errors.Errorf("%s %s is not a directory", dir.flag, dir.path)
+		}
+
+		probe, err := ioutil.TempFile(dir.path, ".clr-installer-")
+		if err != nil {
+			return errors.Errorf("%s %s is not writable: %v", dir.flag, dir.path, err)
+		}
+		_ = probe.Close()
+		_ = os.Remove(probe.Name())
+	}
+
+	return nil
+}
+
+// checkCryptPassOption fails fast when a non-interactive install encrypts a
+// partition without a valid passphrase, as there is no frontend to prompt for
+// one; the interactive frontends prompt for it themselves
+func checkCryptPassOption(options args.Args, md *model.SystemInstall) error {
+	if options.ConfigFile == "" || options.ForceTUI || options.ForceGUI {
+		return nil
+	}
+
+	advanced := false
+	for _, tm := range md.TargetMedias {
+		advanced = advanced || tm.IsAdvancedConfiguration()
+	}
+
+	if !md.EncryptionRequiresPassphrase(advanced) {
+		return nil
+	}
+
+	if md.CryptPass == "" {
+		return errors.Errorf("Encryption requires a passphrase, provide it with --crypt-file, %s or --crypt-stdin",
+			args.CryptPassEnvironVar)
+	}
+
+	if ok, msg := storage.IsValidPassphrase(md.CryptPass); !ok {
+		return errors.Errorf("Invalid encryption passphrase: %s", msg)
 	}
 
 	return nil
@@ -327,20 +434,170 @@ func processTemplateConfigFileOption(options args.Args, md *model.SystemInstall)
 	return nil
 }
 
+// processInteractiveConfigOption builds the configuration by prompting on the
+// command line, writes it to the --interactive-config file and returns true
+// if the installation should proceed with it
+func processInteractiveConfigOption(options args.Args, md *model.SystemInstall) (bool, error) {
+	if filepath.Ext(options.InteractiveConfigFile) != ".yaml" {
+		return false, errors.Errorf("Interactive config file '%s' must end in '.yaml'",
+			options.InteractiveConfigFile)
+	}
+
+	return interactive.Run(md, options.InteractiveConfigFile, os.Stdin, os.Stdout)
+}
+
+// processCloneLayoutOption replaces the target media by the partition layout
+// of the --clone-layout disk; the layout is cloned onto the configured target
+// media, or the disk itself when none is configured, and printed for review
+func processCloneLayoutOption(options args.Args, md *model.SystemInstall) error {
+	if options.CloneLayout == "" {
+		return nil
+	}
+
+	if len(md.TargetMedias) > 1 {
+		return errors.Errorf("--clone-layout requires a single target media, %d configured",
+			len(md.TargetMedias))
+	}
+
+	devices, err := storage.ListBlockDevices(nil)
+	if err != nil {
+		return err
+	}
+
+	source := storage.FindBlockDevice(devices, options.CloneLayout)
+	if source == nil {
+		return errors.Errorf("Could not find the disk %q to clone", options.CloneLayout)
+	}
+
+	target := source
+	if len(md.TargetMedias) == 1 {
+		if target = storage.FindBlockDevice(devices, md.TargetMedias[0].Name); target == nil {
+			return errors.Errorf("Could not find the target media %q", md.TargetMedias[0].Name)
+		}
+	}
+
+	layout, err := storage.CloneLayout(source, target)
+	if err != nil {
+		return err
+	}
+
+	md.TargetMedias = nil
+	md.AddTargetMedia(layout)
+
+	out, err := yaml.Marshal(map[string][]*storage.BlockDevice{"targetMedia": md.TargetMedias})
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Info("Cloned the layout of %s onto %s:\n%s", source.Name, target.Name, out)
+	fmt.Printf("Cloned the layout of %s onto %s:\n%s", source.Name, target.Name, out)
+
+	return nil
+}
+
+// processVerifyInstallOption compares the installed target with the
+// configuration, the partition layout and the OS version found on the / (root)
+// partition mounted read-only, and reports the mismatches diff style
+func processVerifyInstallOption(md *model.SystemInstall) error {
+	devices, err := storage.ListBlockDevices(nil)
+	if err != nil {
+		return err
+	}
+
+	results := storage.VerifyLayout(md.TargetMedias, devices)
+
+	mountDir, err := ioutil.TempDir("", "verify-")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer func() { _ = os.RemoveAll(mountDir) }()
+
+	version, err := storage.ReadInstalledVersion(md.TargetMedias, mountDir)
+	if err != nil {
+		log.Warning("Could not read the installed version: %v", err)
+		fmt.Printf("Could not read the installed version: %v\n", err)
+	} else if md.Version != 0 && version != fmt.Sprintf("%d", md.Version) {
+		results = append(results,
+			fmt.Sprintf("- version: %d", md.Version),
+			fmt.Sprintf("+ version: %s", version))
+	}
+
+	if len(results) > 0 {
+		report := strings.Join(results, "\n")
+		log.Info("Configuration (-) and installed target (+) differences:\n%s", report)
+		fmt.Printf("Configuration (-) and installed target (+) differences:\n%s\n", report)
+
+		return errors.Errorf("The installed target does not match the configuration")
+	}
+
+	log.Info("The installed target matches the configuration")
+	fmt.Println("The installed target matches the configuration")
+
+	return nil
+}
+
+const (
+	lockFree = iota
+	lockHeld
+	lockStale
+)
+
+// lockState returns whether the lock is free, held by a running process, whose
+// pid is returned, or stale, i.e. left by a dead process or holding an invalid pid
+func lockState(lock lockfile.Lockfile) (int, int, error) {
+	owner, err := lock.GetOwner()
+	switch {
+	case err == nil:
+		return lockHeld, owner.Pid, nil
+	case err == lockfile.ErrDeadOwner, err == lockfile.ErrInvalidPid:
+		return lockStale, 0, nil
+	case os.IsNotExist(err):
+		return lockFree, 0, nil
+	}
+
+	return lockFree, 0, err
+}
+
 func createAndAcquireLock(options args.Args, md *model.SystemInstall) (lockfile.Lockfile, error) {
-	lockFile = strings.TrimSuffix(options.LogFile, ".log") + ".lock"
+	if lockFile = options.LockFile; lockFile == "" {
+		lockFile = strings.TrimSuffix(options.LogFile, ".log") + ".lock"
+	}
+
+	if absLockFile, err := filepath.Abs(lockFile); err == nil {
+		lockFile = absLockFile
+	}
+
 	lock, err := lockfile.New(lockFile)
 	if err != nil {
 		fmt.Printf("Cannot initialize lock. reason: %v\n", err)
 		return "", err
 	}
 
-	err = lock.TryLock()
+	state, pid, err := lockState(lock)
 	if err != nil {
+		log.Warning("Cannot read the owner of the lock %q: %v", lockFile, err)
+	}
+
+	if state == lockHeld && pid != os.Getpid() {
+		fmt.Printf("Another installer is running (pid %d), holding the lock %q\n", pid, lockFile)
+		return "", errors.Errorf("Another installer is running (pid %d)", pid)
+	}
+
+	// TryLock removes the stale lock before taking it
+	err = lock.TryLock()
+	if err == lockfile.ErrBusy {
+		fmt.Printf("Another installer is running, holding the lock %q\n", lockFile)
+		return "", errors.Errorf("Another installer is running")
+	} else if err != nil {
 		fmt.Printf("Cannot lock %q, reason: %v\n", lock, err)
 		return "", err
 	}
 
+	if state == lockStale {
+		log.Warning("Reclaimed the stale lock %q left by a previous run", lockFile)
+		fmt.Printf("Reclaimed the stale lock %q left by a previous run\n", lockFile)
+	}
+
 	// Store the name of the LockFile which is needed during
 	// interactive installs when launch the external partitioning tool
 	md.LockFile = lockFile
@@ -348,15 +605,46 @@ func createAndAcquireLock(options args.Args, md *model.SystemInstall) (lockfile.
 	return lock, nil
 }
 
-func processCryptPassFileOption(options args.Args, md *model.SystemInstall) {
+// processCryptPassOption sets the cryptsetup passphrase from the first
+// configured source: --crypt-file, the CLR_CRYPT_PASS env var, then stdin
+// when --crypt-stdin is given; the env var is cleared once read so it is not
+// inherited by the child processes, and the passphrase value is never logged
+func processCryptPassOption(options args.Args, md *model.SystemInstall) error {
+	var source, passphrase string
+
 	if options.CryptPassFile != "" {
+		source = "--crypt-file"
 		content, cryptErr := ioutil.ReadFile(options.CryptPassFile)
 		if cryptErr != nil {
-			log.Warning("Could not read --crypt-file: %v", cryptErr)
-		} else {
-			md.CryptPass = strings.TrimSpace(string(content))
+			return errors.Errorf("Could not read --crypt-file: %v", cryptErr)
+		}
+		passphrase = string(content)
+	} else if content, found := os.LookupEnv(args.CryptPassEnvironVar); found {
+		source = args.CryptPassEnvironVar
+		passphrase = content
+		if cryptErr := os.Unsetenv(args.CryptPassEnvironVar); cryptErr != nil {
+			log.Warning("Could not unset %s: %v", args.CryptPassEnvironVar, cryptErr)
+		}
+	} else if options.CryptPassStdin {
+		source = "--crypt-stdin"
+		content, cryptErr := bufio.NewReader(cryptStdin).ReadString('\n')
+		if cryptErr != nil && cryptErr != io.EOF {
+			return errors.Errorf("Could not read --crypt-stdin: %v", cryptErr)
 		}
+		passphrase = content
+	} else {
+		return nil
+	}
+
+	passphrase = strings.TrimSpace(passphrase)
+	if ok, msg := storage.IsValidPassphrase(passphrase); !ok {
+		return errors.Errorf("Invalid cryptsetup passphrase from %s: %s", source, msg)
 	}
+
+	log.Info("Using the cryptsetup passphrase from %s", source)
+	md.CryptPass = passphrase
+
+	return nil
 }
 
 func processRebootOption(options args.Args, installReboot bool, md *model.SystemInstall) error {
@@ -410,6 +698,12 @@ func checkKybdTzoneLangOptions(md *model.SystemInstall) error {
 		return fmt.Errorf("Invalid Time Zone '%s'", md.Timezone.Code)
 	}
 
+	if md.Language != nil {
+		if err := language.IsValidLocaleCategories(md.Language.Categories); err != nil {
+			return err
+		}
+	}
+
 	if md.Language != nil && !language.IsValidLanguage(md.Language) {
 		return fmt.Errorf("Invalid Language '%s'", md.Language.Code)
 	}
@@ -425,6 +719,14 @@ func processConvertConfigFileOption(options args.Args, md *model.SystemInstall)
 		return copyModel, errors.Errorf("options --json-yaml and --template are mutually exclusive")
 	}
 
+	if options.ConvertYAMLConfigFile != "" && options.TemplateConfigFile != "" {
+		return copyModel, errors.Errorf("options --yaml-json and --template are mutually exclusive")
+	}
+
+	if options.ConvertConfigFile != "" && options.ConvertYAMLConfigFile != "" {
+		return copyModel, errors.Errorf("options --json-yaml and --yaml-json are mutually exclusive")
+	}
+
 	if options.ConvertConfigFile != "" {
 		if filepath.Ext(options.ConvertConfigFile) == ".json" {
 			copyModel, err = model.JSONtoYAMLConfig(options.ConvertConfigFile)
@@ -433,6 +735,14 @@ func processConvertConfigFileOption(options args.Args, md *model.SystemInstall)
 		}
 	}
 
+	if options.ConvertYAMLConfigFile != "" {
+		if ext := filepath.Ext(options.ConvertYAMLConfigFile); ext == ".yaml" || ext == ".yml" {
+			copyModel, err = model.LoadFile(options.ConvertYAMLConfigFile, options)
+		} else {
+			err = errors.Errorf("Config file '%s' must end in '.yaml'", options.ConvertYAMLConfigFile)
+		}
+	}
+
 	return copyModel, err
 }
 
@@ -461,19 +771,49 @@ func processOptionsSaveIfSet(options args.Args, md *model.SystemInstall) {
 		md.MediaOpts.SwapFileSet = true
 	}
 
+	if options.NoSwap {
+		md.MediaOpts.NoSwap = true
+	}
+
+	if options.SmartCheck != "" {
+		md.SmartCheck = options.SmartCheck
+	}
+
+	if options.PostInstallVerify {
+		md.PostInstallVerify = true
+	}
+
+	if options.PostInstallVerifyWarn {
+		md.PostInstallVerifyWarn = true
+	}
+
 	if options.ForceDestructive {
 		md.MediaOpts.ForceDestructive = options.ForceDestructive
 	}
+
+	if options.ForceUnmount {
+		md.MediaOpts.ForceUnmount = options.ForceUnmount
+	}
+
+	// The rootfs is not booted, the host is never rebooted
+	if options.RootfsOnly != "" {
+		md.RootfsOnly = true
+		md.PostReboot = false
+	}
 }
 
-func processOptionsToModel(options args.Args, md *model.SystemInstall) {
-	processCryptPassFileOption(options, md)
+func processOptionsToModel(options args.Args, md *model.SystemInstall) error {
+	if err := processCryptPassOption(options, md); err != nil {
+		return err
+	}
 
 	processOptionsSaveIfSet(options, md)
 
 	processSwupdOptions(options, md)
 
 	processISOSetOption(options, md)
+
+	return nil
 }
 
 // execute is called by main to begin execution of the installer
@@ -498,6 +838,12 @@ func execute(options args.Args) error {
 		return nil
 	}
 
+	if err = network.SetAddressFamily(options.AddressFamily); err != nil {
+		return err
+	}
+
+	storage.LogBlockDevicesSnapshot("at startup")
+
 	var md *model.SystemInstall
 
 	// Load config values from file to model
@@ -525,11 +871,38 @@ func execute(options args.Args) error {
 		return err
 	}
 
-	processOptionsToModel(options, md)
+	if err := processOptionsToModel(options, md); err != nil {
+		return err
+	}
+
+	// The configuration file may select the address family of the content fetches
+	if err := network.SetAddressFamily(md.AddressFamily); err != nil {
+		return err
+	}
+
+	if options.Hostname != "" {
+		if msg := hostname.IsValidHostname(options.Hostname); msg != "" {
+			return errors.Errorf("Invalid hostname %q: %s", options.Hostname, msg)
+		}
+		md.Hostname = options.Hostname
+	}
 
 	if len(options.Bundles) > 0 {
+		source := "command line"
+		if options.BundlesFile != "" {
+			source = options.BundlesFile
+		}
+
 		md.OverrideBundles(options.Bundles)
-		log.Info("Overriding bundle list from command line: %s", strings.Join(md.Bundles, ", "))
+		log.Info("Overriding bundle list from %s: %s", source, strings.Join(md.Bundles, ", "))
+	}
+
+	if err = processCloneLayoutOption(options, md); err != nil {
+		return err
+	}
+
+	if options.VerifyInstall {
+		return processVerifyInstallOption(md)
 	}
 
 	if options.ConvertConfigFile != "" {
@@ -541,10 +914,31 @@ func execute(options args.Args) error {
 		return nil
 	}
 
+	if options.ConvertYAMLConfigFile != "" {
+		_, err := md.WriteJSONConfig(options.ConvertYAMLConfigFile)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}
+
 	if options.TemplateConfigFile != "" {
 		return processTemplateConfigFileOption(options, md)
 	}
 
+	if options.InteractiveConfigFile != "" {
+		install, err := processInteractiveConfigOption(options, md)
+		if err != nil || !install {
+			return err
+		}
+
+		// The mass installer runs the configuration just written
+		options.ConfigFile = options.InteractiveConfigFile
+		options.ForceTUI = false
+		options.ForceGUI = false
+	}
+
 	// exit if certain conditions fail for certain options
 	osExitForOptions(options)
 
@@ -564,7 +958,12 @@ func execute(options args.Args) error {
 		syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGILL, syscall.SIGTRAP,
 		syscall.SIGABRT, syscall.SIGSTKFLT, syscall.SIGSYS)
 
-	rootDir, err := ioutil.TempDir("", "install-")
+	if err := checkWorkDirOptions(options); err != nil {
+		return err
+	}
+
+	// An explicit temp dir is kept, only the install root created in it is removed
+	rootDir, err := ioutil.TempDir(options.TempDir, "install-")
 	if err != nil {
 		return err
 	}
@@ -588,7 +987,7 @@ func execute(options args.Args) error {
 
 	// Run system check and exit
 	if options.SystemCheck {
-		return syscheck.RunSystemCheck(false)
+		return syscheck.RunSystemCheck(false, md.SystemRequirements)
 	}
 
 	installReboot := false
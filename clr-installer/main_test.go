@@ -0,0 +1,229 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nightlyone/lockfile"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/storage"
+)
+
+func TestLockState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := filepath.Join(dir, "clr-installer.lock")
+	lock, err := lockfile.New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		content string
+		state   int
+	}{
+		{"", lockFree},
+		{fmt.Sprintf("%d\n", os.Getpid()), lockHeld},
+		{"999999999\n", lockStale},
+		{"not a pid\n", lockStale},
+	}
+
+	for _, curr := range tests {
+		_ = os.Remove(file)
+		if curr.content != "" {
+			if err = ioutil.WriteFile(file, []byte(curr.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		state, _, err := lockState(lock)
+		if err != nil {
+			t.Fatalf("Unexpected error for lock content %q: %v", curr.content, err)
+		}
+
+		if state != curr.state {
+			t.Fatalf("Expected state %d for lock content %q, got %d", curr.state, curr.content, state)
+		}
+	}
+}
+
+func TestReclaimStaleLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := filepath.Join(dir, "custom.lock")
+	if err = ioutil.WriteFile(file, []byte("999999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	md := &model.SystemInstall{}
+	options := args.Args{LogFile: filepath.Join(dir, "clr-installer.log"), LockFile: file}
+
+	acquired, err := createAndAcquireLock(options, md)
+	if err != nil {
+		t.Fatalf("Failed to reclaim the stale lock: %v", err)
+	}
+	defer func() { _ = acquired.Unlock() }()
+
+	if md.LockFile != file {
+		t.Fatalf("Expected the lock file %q, got %q", file, md.LockFile)
+	}
+
+	state, pid, err := lockState(acquired)
+	if err != nil || state != lockHeld || pid != os.Getpid() {
+		t.Fatalf("The reclaimed lock should be held by the installer: %d %d %v", state, pid, err)
+	}
+}
+
+func TestCheckCryptPassOption(t *testing.T) {
+	md := &model.SystemInstall{}
+	bd := &storage.BlockDevice{Name: "sda", Type: storage.BlockDeviceTypeDisk}
+	bd.AddChild(&storage.BlockDevice{Name: "sda1", Type: storage.BlockDeviceTypeCrypt,
+		FsType: "ext4", MountPoint: "/"})
+	md.AddTargetMedia(bd)
+
+	options := args.Args{ConfigFile: "clr-installer.yaml"}
+
+	if err := checkCryptPassOption(options, md); err == nil {
+		t.Fatal("A non-interactive encrypted install without a passphrase should fail")
+	}
+
+	md.CryptPass = "short"
+	if err := checkCryptPassOption(options, md); err == nil {
+		t.Fatal("A non-interactive encrypted install with an invalid passphrase should fail")
+	}
+
+	md.CryptPass = "Cl3ar-L1nux-Crypt!"
+	if err := checkCryptPassOption(options, md); err != nil {
+		t.Fatalf("A valid passphrase should be accepted: %v", err)
+	}
+
+	// The interactive frontends prompt for the passphrase
+	md.CryptPass = ""
+	for _, curr := range []args.Args{{}, {ConfigFile: "clr-installer.yaml", ForceTUI: true}} {
+		if err := checkCryptPassOption(curr, md); err != nil {
+			t.Fatalf("An interactive install should not require a passphrase: %v", err)
+		}
+	}
+
+	// Without encryption no passphrase is required
+	bd.Children[0].Type = storage.BlockDeviceTypePart
+	if err := checkCryptPassOption(options, md); err != nil {
+		t.Fatalf("An install without encryption should not require a passphrase: %v", err)
+	}
+}
+
+func TestProcessCryptPassOption(t *testing.T) {
+	savedStdin := cryptStdin
+	defer func() {
+		cryptStdin = savedStdin
+		_ = os.Unsetenv(args.CryptPassEnvironVar)
+	}()
+
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	passFile := filepath.Join(dir, "crypt-pass")
+	if err = ioutil.WriteFile(passFile, []byte("Cl3ar-L1nux-Crypt!\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	md := &model.SystemInstall{}
+	if err = processCryptPassOption(args.Args{CryptPassFile: passFile}, md); err != nil {
+		t.Fatalf("A valid --crypt-file passphrase should be accepted: %v", err)
+	}
+	if md.CryptPass != "Cl3ar-L1nux-Crypt!" {
+		t.Fatalf("Expected the --crypt-file passphrase, got %q", md.CryptPass)
+	}
+
+	if err = ioutil.WriteFile(passFile, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	md = &model.SystemInstall{}
+	if err = processCryptPassOption(args.Args{CryptPassFile: passFile}, md); err == nil {
+		t.Fatal("An invalid --crypt-file passphrase should fail")
+	}
+	if md.CryptPass != "" {
+		t.Fatal("An invalid passphrase should not be set")
+	}
+
+	if err = processCryptPassOption(args.Args{CryptPassFile: filepath.Join(dir, "missing")}, md); err == nil {
+		t.Fatal("A missing --crypt-file should fail")
+	}
+
+	// The env var is cleared once read
+	if err = os.Setenv(args.CryptPassEnvironVar, "Cl3ar-L1nux-Env!"); err != nil {
+		t.Fatal(err)
+	}
+	if err = processCryptPassOption(args.Args{}, md); err != nil || md.CryptPass != "Cl3ar-L1nux-Env!" {
+		t.Fatalf("Expected the %s passphrase, got %q: %v", args.CryptPassEnvironVar, md.CryptPass, err)
+	}
+	if _, found := os.LookupEnv(args.CryptPassEnvironVar); found {
+		t.Fatalf("%s should be unset once read", args.CryptPassEnvironVar)
+	}
+
+	md = &model.SystemInstall{}
+	cryptStdin = strings.NewReader("Cl3ar-L1nux-Stdin!\n")
+	if err = processCryptPassOption(args.Args{CryptPassStdin: true}, md); err != nil || md.CryptPass != "Cl3ar-L1nux-Stdin!" {
+		t.Fatalf("Expected the --crypt-stdin passphrase, got %q: %v", md.CryptPass, err)
+	}
+
+	// Without a source the passphrase is left to the frontends
+	md = &model.SystemInstall{}
+	if err = processCryptPassOption(args.Args{}, md); err != nil || md.CryptPass != "" {
+		t.Fatalf("No passphrase should be set without a source, got %q: %v", md.CryptPass, err)
+	}
+}
+
+func TestCheckWorkDirOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if err = checkWorkDirOptions(args.Args{}); err != nil {
+		t.Fatalf("The default directories should be valid: %v", err)
+	}
+
+	if err = checkWorkDirOptions(args.Args{TempDir: dir, SwupdCacheDir: dir}); err != nil {
+		t.Fatalf("Writable directories should be valid: %v", err)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("The writable probe should be removed: %v", files)
+	}
+
+	if err = checkWorkDirOptions(args.Args{TempDir: filepath.Join(dir, "missing")}); err == nil {
+		t.Fatal("A missing temp dir should be invalid")
+	}
+
+	file := filepath.Join(dir, "file")
+	if err = ioutil.WriteFile(file, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = checkWorkDirOptions(args.Args{SwupdCacheDir: file}); err == nil {
+		t.Fatal("A file should not be a valid swupd cache")
+	}
+}
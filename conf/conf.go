@@ -19,6 +19,9 @@ const (
 	// LogFile is the installation log file name
 	LogFile = "clr-installer.log"
 
+	// ResultFile is the machine readable installation result file name
+	ResultFile = "clr-installer-result.json"
+
 	// ConfigFile is the install descriptor
 	ConfigFile = "clr-installer.yaml"
 
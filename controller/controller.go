@@ -5,13 +5,14 @@
 package controller
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -21,6 +22,7 @@ import (
 	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/conf"
 	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/files"
 	"github.com/clearlinux/clr-installer/hostname"
 	"github.com/clearlinux/clr-installer/isoutils"
 	"github.com/clearlinux/clr-installer/kernel"
@@ -31,8 +33,10 @@ import (
 	"github.com/clearlinux/clr-installer/network"
 	"github.com/clearlinux/clr-installer/progress"
 	"github.com/clearlinux/clr-installer/proxy"
+	"github.com/clearlinux/clr-installer/services"
 	"github.com/clearlinux/clr-installer/storage"
 	"github.com/clearlinux/clr-installer/swupd"
+	"github.com/clearlinux/clr-installer/syscheck"
 	"github.com/clearlinux/clr-installer/telemetry"
 	"github.com/clearlinux/clr-installer/timezone"
 	cuser "github.com/clearlinux/clr-installer/user"
@@ -43,28 +47,55 @@ var (
 	// NetworkPassing is used to track if the latest network configuration
 	// is passing; changes in proxy, etc.
 	NetworkPassing bool
+
+	// downloadSizeCache avoids querying the content server again on install
+	downloadSizeCache      = map[string]string{}
+	downloadSizeCacheMutex sync.Mutex
 )
 
 const (
 	// NetWorkManager is the application to manage network.
 	NetWorkManager = "Network Manager"
+
+	// DownloadSizeUnknown is reported when the download size can not be determined
+	DownloadSizeUnknown = "unknown"
 )
 
-func sortMountPoint(bds []*storage.BlockDevice) []*storage.BlockDevice {
-	sort.Slice(bds[:], func(i, j int) bool {
-		return filepath.HasPrefix(bds[j].MountPoint, bds[i].MountPoint)
-	})
+// Install is the main install controller, this is the entry point for a full
+// installation; the result, partial on failure or crash, is always written to
+// the options.ResultFile
+func Install(rootDir string, model *model.SystemInstall, options args.Args) (err error) {
+	installResult = newInstallResult()
+
+	defer func() {
+		if r := recover(); r != nil {
+			installResult.finish(model, errors.Errorf("%v", r))
+			if werr := installResult.write(options.ResultFile); werr != nil {
+				log.ErrorError(werr)
+			}
+			panic(r)
+		}
+
+		installResult.finish(model, err)
+		if werr := installResult.write(options.ResultFile); werr != nil {
+			log.ErrorError(werr)
+		}
+	}()
+
+	// The content of a rootfs only install is left in the requested directory
+	if options.RootfsOnly != "" {
+		rootDir = options.RootfsOnly
+	}
 
-	return bds
+	return install(rootDir, model, options)
 }
 
-// Install is the main install controller, this is the entry point for a full
-// installation
 // nolint: gocyclo  // TODO: Refactor this
-func Install(rootDir string, model *model.SystemInstall, options args.Args) error {
+func install(rootDir string, model *model.SystemInstall, options args.Args) error {
 	var err error
 	var prg progress.Progress
 	var encryptedUsed, softRaidUsed, lvmRootUsed, lvmOtherUsed bool
+	var lvmRoot *storage.BlockDevice
 
 	vars := map[string]string{
 		"chrootDir": rootDir,
@@ -75,55 +106,34 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		vars[k] = v
 	}
 
-	preConfFile := log.GetPreConfFile()
-
-	if err = model.WriteFile(preConfFile); err != nil {
-		log.Error("Failed to write pre-install YAML file (%v) %q", err, preConfFile)
-	}
-
-	advanced := false
-	for _, tm := range model.TargetMedias {
-		advanced = advanced || tm.IsAdvancedConfiguration()
-	}
-
-	if model.EncryptionRequiresPassphrase(advanced) && model.CryptPass == "" {
-		model.CryptPass = storage.GetPassPhrase()
-		if model.CryptPass == "" {
-			return errors.Errorf("Can not create encrypted file system, no passphrase")
-		}
-	}
-
-	if !options.StubImage {
-		if err = applyHooks("pre-install", vars, model.PreInstall); err != nil {
-			return err
-		}
-	}
-
-	version := utils.VersionUintString(model.Version)
-
-	log.Debug("Clear Linux OS version: %s", version)
-
-	// do we have the minimum required to install a system?
-	if err = model.Validate(); err != nil {
+	version, err := checkPrerequisites(vars, model, options)
+	if err != nil {
 		return err
 	}
 
-	// Using MassInstaller (non-UI) the network will not have been checked yet
-	if !NetworkPassing &&
-		!options.StubImage &&
-		!swupd.OfflineIsUsable(version, options) &&
-		len(model.UserBundles) != 0 {
-		if err = ConfigureNetwork(model); err != nil {
-			return err
-		}
+	if options.RootfsOnly != "" {
+		return installRootfs(rootDir, version, vars, model, options)
 	}
 
+	setPhase(phaseMedia)
+
 	expandMe := []*storage.BlockDevice{}
 	detachMe := []string{}
 	removeMe := []string{}
 	aliasMap := map[string]string{}
 	usingPhysicalMedia := true
 
+	// check the image formats are supported before creating any image
+	for _, alias := range model.StorageAlias {
+		if alias.DeviceFile {
+			continue
+		}
+
+		if err = storage.CheckImageFormatTools(alias.ImageFormat()); err != nil {
+			return err
+		}
+	}
+
 	// prepare image file, case the user has declared image alias then create
 	// the image, setup the loop device, prepare the variable expansion
 	for _, alias := range model.StorageAlias {
@@ -136,7 +146,7 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		// create the image and add the alias name to the variable expansion list
 		for _, tm := range model.TargetMedias {
 			if tm.Name == fmt.Sprintf("${%s}", alias.Name) {
-				if err = storage.MakeImage(tm, alias.File); err != nil {
+				if err = storage.MakeImage(tm, alias.File, alias.ImageFormat()); err != nil {
 					return err
 				}
 
@@ -148,7 +158,7 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		// Add the image file to the hooks variables
 		vars["imageFile"] = alias.File
 
-		file, err = storage.SetupLoopDevice(alias.File)
+		file, err = storage.AttachImage(alias.File, alias.ImageFormat())
 		if err != nil {
 			return errors.Wrap(err)
 		}
@@ -167,7 +177,7 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 
 			if ok, err = utils.FileExists(file); err != nil {
 				for _, file := range detachMe {
-					storage.DetachLoopDevice(file)
+					storage.DetachImage(file)
 				}
 
 				return errors.Wrap(err)
@@ -185,7 +195,7 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 	// defer detaching used loop devices
 	defer func() {
 		for _, file := range detachMe {
-			storage.DetachLoopDevice(file)
+			storage.DetachImage(file)
 		}
 
 		// Now that image is unmounted, run post-image hooks
@@ -240,6 +250,38 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		}
 	}
 
+	// every selected media, i.e. a separate /home disk, must still be present
+	if err = storage.CheckTargetMediasPresent(model.TargetMedias); err != nil {
+		return err
+	}
+
+	// the hardware requirements are checked on the system being installed,
+	// a stub image is installed on other hardware
+	if !options.StubImage {
+		if err = model.SystemRequirements.Check(); err != nil {
+			return err
+		}
+	}
+
+	// the SMART health check runs before any change is made to the disks
+	if model.SmartCheck != "" && !options.StubImage {
+		if err = checkDisksHealth(model); err != nil {
+			return err
+		}
+	}
+
+	// record the target disks layout before any change is made to them
+	if model.RecordPartitionLayout {
+		model.PreInstallLayout = storage.CapturePartitionLayouts(model.InstallSelected, model.TargetMedias)
+	}
+
+	// partitions left mounted would make parted fail to modify the targets
+	if err = storage.ReleaseTargetMounts(model.InstallSelected, model.MediaOpts.ForceUnmount); err != nil {
+		return err
+	}
+
+	storage.LogBlockDevicesSnapshot("before partitioning")
+
 	// prepare all the target block devices
 	if err := storage.PrepareInstallationMedia(model.InstallSelected,
 		model.TargetMedias, model.MediaOpts, nil); err != nil {
@@ -247,6 +289,11 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		return err
 	}
 
+	// partprobe returns before udev creates all of the partition device nodes
+	if err = storage.WaitForDeviceNodes(model.TargetMedias, model.MediaOpts.DeviceNodeTimeout); err != nil {
+		return err
+	}
+
 	// First create a list of all children we need to check
 	var childrenToCheck []*storage.BlockDevice
 
@@ -257,16 +304,32 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		childrenToCheck = append(childrenToCheck, curr.FindAllChildren()...)
 	}
 
+	setPhase(phaseFileSystems)
+
+	// a multi-device btrfs root is created on all of its members at once
+	storage.LinkBtrfsMembers(model.TargetMedias)
+
 	// prepare the blockdevice's partitions filesystem
 	for _, ch := range childrenToCheck {
+		if ch.CreateOnly {
+			log.Debug("Leaving create only partition %s empty", ch.Name)
+			continue
+		}
+
 		if ch.Type == storage.BlockDeviceTypeCrypt {
 			encryptedUsed = true
 
-			if ch.FsTypeNotSwap() {
+			// The swap uses a random key unless it must persist for hibernation
+			if ch.FsTypeNotSwap() || model.MediaOpts.PersistentSwapKey {
 				msg := utils.Locale.Get("Mapping %s partition to an encrypted partition", ch.Name)
 				prg = progress.NewLoop(msg)
 				log.Info(msg)
-				if err = ch.MapEncrypted(model.CryptPass); err != nil {
+				var passphrase string
+				if passphrase, err = ch.Passphrase(model.CryptPass); err != nil {
+					prg.Failure()
+					return err
+				}
+				if err = ch.MapEncrypted(passphrase); err != nil {
 					prg.Failure()
 					return err
 				}
@@ -277,13 +340,14 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		if ch.Type == storage.BlockDeviceTypeLVM2Volume {
 			if ch.MountPoint == "/" {
 				lvmRootUsed = true
+				lvmRoot = ch
 			} else {
 				lvmOtherUsed = true
 			}
 		}
 
 		// if we have a mount point set it for future mounting
-		if ch.MountPoint != "" {
+		if ch.MountPoint != "" && !storage.IsSecondaryBoot(ch, model.MediaOpts) {
 			mountPoints = append(mountPoints, ch)
 		}
 
@@ -298,9 +362,18 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		if ch.MountPoint != "" {
 			msg = msg + fmt.Sprintf(" '%s'", ch.MountPoint)
 		}
-		prg = progress.NewLoop(msg)
 		log.Info(msg)
-		if err = ch.MakeFs(); err != nil {
+
+		// Each file system is its own step, with the mkfs progress when reported
+		var partial func(percent int)
+		if ch.MakeFsReportsProgress() {
+			prg = progress.MultiStep(100, msg)
+			partial = prg.Partial
+		} else {
+			prg = progress.NewLoop(msg)
+		}
+
+		if err = ch.MakeFsWithProgress(partial); err != nil {
 			prg.Failure()
 			return err
 		}
@@ -317,10 +390,10 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 	}
 
 	// mount all the prepared partitions
-	for _, curr := range sortMountPoint(mountPoints) {
+	for _, curr := range storage.SortByMountPoint(mountPoints) {
 		log.Info("Mounting: %s", curr.MountPoint)
 
-		if err = curr.Mount(rootDir); err != nil {
+		if err = curr.Mount(rootDir, model.MediaOpts); err != nil {
 			return err
 		}
 	}
@@ -343,71 +416,80 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		return err
 	}
 
-	// If we are using NetworkManager add the basic bundle
-	if network.IsNetworkManagerActive() {
-		log.Info("Adding bundle '%s' to enable networking", network.RequiredBundle)
-		model.AddBundle(network.RequiredBundle)
-	}
-
-	// Add in the User Defined bundles
-	for _, curr := range model.UserBundles {
-		log.Info("Adding bundle '%s' from user selection", curr)
-		model.AddBundle(curr)
-	}
-
-	if model.Telemetry.Enabled {
-		log.Info("Adding bundle '%s' to enable telemetry", telemetry.RequiredBundle)
-		model.AddBundle(telemetry.RequiredBundle)
-	}
-
-	if len(model.Users) > 0 {
-		log.Info("Adding bundle '%s' to support non-root users", cuser.RequiredBundle)
-		model.AddBundle(cuser.RequiredBundle)
-	}
-
-	if model.Timezone.Code != timezone.DefaultTimezone {
-		log.Info("Adding bundle '%s' due to non-default timezone '%s'",
-			timezone.RequiredBundle, model.Timezone.Code)
-		model.AddBundle(timezone.RequiredBundle)
-	}
-
-	if model.Keyboard.Code != keyboard.DefaultKeyboard {
-		log.Info("Adding bundle '%s' due to non-default keyboard '%s'",
-			keyboard.RequiredBundle, model.Keyboard.Code)
-		model.AddBundle(keyboard.RequiredBundle)
-	}
-
-	if model.Language.Code != language.DefaultLanguage {
-		log.Info("Adding bundle '%s' due to non-default language '%s'",
-			language.RequiredBundle, model.Language.Code)
-		model.AddBundle(language.RequiredBundle)
-	}
+	addConfiguredBundles(model)
 
 	if encryptedUsed || softRaidUsed || lvmRootUsed {
 		log.Info("Adding bundle '%s' to enable encryption, sw RAID, or LVM root", storage.RequiredBundle)
 		model.AddBundle(storage.RequiredBundle)
 	}
-	if lvmOtherUsed {
+	if model.MediaOpts.ExpandLVMRoot && !lvmRootUsed {
+		return errors.Errorf("Expanding the LVM root requires the root file system on a logical volume")
+	}
+	if bundle := storage.BootloaderBundle(model.MediaOpts.GetBootloader()); bundle != "" {
+		log.Info("Adding bundle '%s' for the %s boot loader", bundle, model.MediaOpts.GetBootloader())
+		model.AddBundle(bundle)
+	}
+	if lvmOtherUsed || model.MediaOpts.ExpandLVMRoot {
 		log.Info("Adding bundle '%s' to enable LVM", storage.RequiredBundleLVM)
 		model.AddBundle(storage.RequiredBundleLVM)
 	}
+	if model.MediaOpts.GrowRoot {
+		log.Info("Adding bundle '%s' to grow the root partition", storage.RequiredBundleGrowRoot)
+		model.AddBundle(storage.RequiredBundleGrowRoot)
+	}
 	if encryptedUsed {
 		kernelArgs := []string{storage.KernelArgument}
 		model.AddExtraKernelArguments(kernelArgs)
 	}
+	if btrfsArgs := storage.BtrfsKernelArguments(model.TargetMedias); len(btrfsArgs) > 0 {
+		log.Info("Adding btrfs RAID kernel arguments: %s", strings.Join(btrfsArgs, " "))
+		model.AddExtraKernelArguments(btrfsArgs)
+	}
+	if err = model.AddModuleSigEnforceArgument(); err != nil {
+		return err
+	}
+	model.AddZswapArguments()
+	for _, warning := range model.ZswapWarnings() {
+		log.Warning(warning)
+	}
+
+	swapFileCreated := false
+	if model.MediaOpts.HibernationSupport {
+		// The swapfile must exist to locate it before the boot loader is installed
+		if model.MediaOpts.SwapFileSize != "" {
+			if swapFileCreated, err = createSwapFile(rootDir, model); err != nil {
+				return err
+			}
+		}
+
+		resumeArgs, resumeErr := storage.ResumeKernelArguments(rootDir, model.TargetMedias, swapFileCreated)
+		if resumeErr != nil {
+			return resumeErr
+		}
+		log.Info("Adding hibernation kernel arguments: %s", strings.Join(resumeArgs, " "))
+		model.AddExtraKernelArguments(resumeArgs)
+	}
 
 	msg := utils.Locale.Get("Writing mount files")
 	prg = progress.NewLoop(msg)
 	log.Info(msg)
-	if err = storage.GenerateTabFiles(rootDir, model.TargetMedias); err != nil {
+	if err = storage.GenerateTabFiles(rootDir, model.TargetMedias, model.MediaOpts); err != nil {
 		prg.Failure()
 		return err
 	}
+
+	// The swapfile created for hibernation is appended to the generated fstab
+	if swapFileCreated {
+		if err = storage.AddSwapFileFstab(rootDir, storage.SwapFilePartition(model.TargetMedias)); err != nil {
+			prg.Failure()
+			return err
+		}
+	}
 	prg.Success()
 
 	if model.KernelArguments != nil && len(model.KernelArguments.Add) > 0 {
-		cmdlineDir := filepath.Join(rootDir, "etc", "kernel")
-		cmdlineFile := filepath.Join(cmdlineDir, "cmdline")
+		cmdlineDir := filepath.Join(rootDir, "etc", "kernel", "cmdline.d")
+		cmdlineFile := filepath.Join(cmdlineDir, "clr-installer.conf")
 		cmdline := strings.Join(model.KernelArguments.Add, " ")
 
 		if err = utils.MkdirAll(cmdlineDir, 0755); err != nil {
@@ -433,22 +515,298 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		}
 	}
 
+	downloadSize := EstimateDownloadSize(model, options)
+	log.Info("Estimated download size: %s", downloadSize)
+	if errLog := model.Telemetry.LogRecord("downloadsize", 1, downloadSize); errLog != nil {
+		log.Error("Failed to log Telemetry download size record")
+	}
+
+	setPhase(phaseContent)
+
 	if prg, err = contentInstall(rootDir, version, model, options); err != nil {
 		prg.Failure()
 		return err
 	}
 
-	if model.MediaOpts.SwapFileSize != "" {
-		msg := utils.Locale.Get("Creating %s", storage.SwapfileName)
-		prg = progress.NewLoop(msg)
-		log.Info(msg)
-		if err = storage.CreateSwapFile(rootDir, model.MediaOpts.SwapFileSize); err != nil {
-			prg.Failure()
+	// the target is still mounted, UmountAll only runs once install returns
+	if model.PostInstallVerify {
+		if err = postInstallVerify(rootDir, version, model, options); err != nil {
 			return err
 		}
-		prg.Success()
 	}
 
+	setPhase(phaseConfiguration)
+
+	if model.MediaOpts.SwapFileSize != "" && !model.MediaOpts.HibernationSupport {
+		var created bool
+		if created, err = createSwapFile(rootDir, model); err != nil {
+			return err
+		}
+
+		if created {
+			if err = storage.AddSwapFileFstab(rootDir, storage.SwapFilePartition(model.TargetMedias)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = configureTarget(rootDir, model); err != nil {
+		return err
+	}
+
+	if model.MediaOpts.ExpandLVMRoot {
+		if err = storage.InstallLVMExpandUnit(rootDir, lvmRoot); err != nil {
+			return err
+		}
+	}
+
+	if model.MediaOpts.GrowRoot {
+		if err = storage.InstallGrowRootUnit(rootDir, model.TargetMedias); err != nil {
+			return err
+		}
+	}
+
+	if model.MediaOpts.ReadOnlyRoot {
+		if err = storage.InstallReadOnlyRootUnits(rootDir); err != nil {
+			return err
+		}
+	}
+
+	if err = copyHostConfiguration(rootDir, model); err != nil {
+		return err
+	}
+
+	return finishInstall(rootDir, vars, model, options)
+}
+
+// checkPrerequisites validates the configuration and checks everything the
+// install depends on before any change is made, returning the version to install
+func checkPrerequisites(vars map[string]string, model *model.SystemInstall, options args.Args) (string, error) {
+	var err error
+
+	// The telemetry choice is recorded with the pre-install configuration
+	model.ApplyTelemetryChoice()
+
+	preConfFile := log.GetPreConfFile()
+
+	if err = model.WriteFile(preConfFile); err != nil {
+		log.Error("Failed to write pre-install YAML file (%v) %q", err, preConfFile)
+	}
+
+	advanced := false
+	for _, tm := range model.TargetMedias {
+		advanced = advanced || tm.IsAdvancedConfiguration()
+	}
+
+	if model.EncryptionRequiresPassphrase(advanced) && model.CryptPass == "" {
+		model.CryptPass = storage.GetPassPhrase()
+		if model.CryptPass == "" {
+			return "", errors.Errorf("Can not create encrypted file system, no passphrase")
+		}
+	}
+
+	if err = storage.ValidatePassphrases(model.TargetMedias, model.MediaOpts, model.CryptPass); err != nil {
+		return "", err
+	}
+
+	if !options.StubImage {
+		if err = applyHooks("pre-install", vars, model.PreInstall); err != nil {
+			return "", err
+		}
+	}
+
+	version := utils.VersionUintString(model.Version)
+	installResult.Version = version
+
+	log.Debug("Clear Linux OS version: %s", version)
+
+	// do we have the minimum required to install a system?
+	if err = model.Validate(); err != nil {
+		return "", err
+	}
+
+	if model.LocalContentDir != "" && !options.StubImage {
+		if err = swupd.ValidateLocalContentDir(model.LocalContentDir); err != nil {
+			return "", err
+		}
+		log.Info("Using local swupd content: %s", model.LocalContentDir)
+	}
+
+	// Using MassInstaller (non-UI) the network will not have been checked yet
+	if !NetworkPassing &&
+		!options.StubImage &&
+		model.LocalContentDir == "" &&
+		!swupd.OfflineIsUsable(version, options) &&
+		len(model.UserBundles) != 0 {
+		if err = ConfigureNetwork(model); err != nil {
+			return "", err
+		}
+	}
+
+	if (model.TimezoneGeolocation || options.TimezoneGeolocation) &&
+		model.Timezone.IsDefaulted() && !options.StubImage && !model.Offline {
+		geolocateTimezone(model, options)
+	}
+
+	// A wrong clock breaks the HTTPS connections to the swupd content server,
+	// the offline installs do not connect to it
+	if !options.StubImage && model.LocalContentDir == "" && !model.Offline &&
+		!swupd.OfflineIsUsable(version, options) {
+		checkSystemClock(model, options)
+	}
+
+	// A pinned version missing from the mirror fails before downloading content,
+	// the offline and local contents are checked by swupd itself
+	if !options.StubImage && model.LocalContentDir == "" && !swupd.OfflineIsUsable(version, options) {
+		if version, err = checkSwupdVersion(model, options, version, preConfFile); err != nil {
+			return "", err
+		}
+	}
+
+	// An old version needs an old swupd format, fail before downloading content
+	if !options.StubImage && !model.SwupdSkipFormatCheck && !swupd.OfflineIsUsable(version, options) {
+		if err = checkSwupdFormat(model, options, version); err != nil {
+			return "", err
+		}
+	}
+
+	// Integrator policies run last, before any change is made to the medias
+	if model.PreInstallValidationScript != "" && !options.StubImage {
+		var config []byte
+
+		if config, err = model.MarshalJSONConfig(); err != nil {
+			return "", err
+		}
+
+		if err = syscheck.RunValidationScript(model.PreInstallValidationScript, config); err != nil {
+			return "", err
+		}
+	}
+
+	return version, nil
+}
+
+// installRootfs installs the OS content and configuration into the rootDir
+// directory, i.e. for a container base image; no media is partitioned nor
+// formatted and no boot loader is installed
+func installRootfs(rootDir string, version string, vars map[string]string,
+	model *model.SystemInstall, options args.Args) error {
+	if err := prepareRootfsDir(rootDir); err != nil {
+		return err
+	}
+
+	if err := storage.MountMetaFs(rootDir); err != nil {
+		return err
+	}
+
+	// Only the meta file systems are unmounted, the rootfs is kept
+	defer func() {
+		if storage.UmountAll() != nil {
+			log.Warning("Failed to umount the rootfs meta file systems")
+		}
+	}()
+
+	addConfiguredBundles(model)
+
+	setPhase(phaseContent)
+
+	if prg, err := contentInstall(rootDir, version, model, options); err != nil {
+		prg.Failure()
+		return err
+	}
+
+	if model.PostInstallVerify {
+		if err := postInstallVerify(rootDir, version, model, options); err != nil {
+			return err
+		}
+	}
+
+	setPhase(phaseConfiguration)
+
+	if err := configureTarget(rootDir, model); err != nil {
+		return err
+	}
+
+	if err := copyHostConfiguration(rootDir, model); err != nil {
+		return err
+	}
+
+	if err := finishInstall(rootDir, vars, model, options); err != nil {
+		return err
+	}
+
+	log.Info("The rootfs is installed in %s", rootDir)
+
+	return nil
+}
+
+// prepareRootfsDir creates the rootfs directory, refusing to install over the
+// content of an existing one
+func prepareRootfsDir(rootDir string) error {
+	if err := utils.MkdirAll(rootDir, 0755); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(rootDir)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if len(entries) > 0 {
+		return errors.Errorf("The rootfs directory %s is not empty", rootDir)
+	}
+
+	return nil
+}
+
+// addConfiguredBundles adds the bundles required by the configuration, other
+// than the ones required by the storage and boot setup
+func addConfiguredBundles(model *model.SystemInstall) {
+	// If we are using NetworkManager add the basic bundle
+	if network.IsNetworkManagerActive() {
+		log.Info("Adding bundle '%s' to enable networking", network.RequiredBundle)
+		model.AddBundle(network.RequiredBundle)
+	}
+
+	// Add in the User Defined bundles
+	for _, curr := range model.UserBundles {
+		log.Info("Adding bundle '%s' from user selection", curr)
+		model.AddBundle(curr)
+	}
+
+	if model.Telemetry.Enabled {
+		log.Info("Adding bundle '%s' to enable telemetry", telemetry.RequiredBundle)
+		model.AddBundle(telemetry.RequiredBundle)
+	}
+
+	if len(model.Users) > 0 {
+		log.Info("Adding bundle '%s' to support non-root users", cuser.RequiredBundle)
+		model.AddBundle(cuser.RequiredBundle)
+	}
+
+	if model.Timezone.Code != timezone.DefaultTimezone {
+		log.Info("Adding bundle '%s' due to non-default timezone '%s'",
+			timezone.RequiredBundle, model.Timezone.Code)
+		model.AddBundle(timezone.RequiredBundle)
+	}
+
+	if model.Keyboard.Code != keyboard.DefaultKeyboard {
+		log.Info("Adding bundle '%s' due to non-default keyboard '%s'",
+			keyboard.RequiredBundle, model.Keyboard.Code)
+		model.AddBundle(keyboard.RequiredBundle)
+	}
+
+	if model.Language.RequiresLocaleBundle() {
+		log.Info("Adding bundle '%s' due to non-default language '%s'",
+			language.RequiredBundle, model.Language.Code)
+		model.AddBundle(language.RequiredBundle)
+	}
+}
+
+// configureTarget applies the system configuration, i.e. locale and users, to the target
+func configureTarget(rootDir string, model *model.SystemInstall) error {
+	var err error
+
 	if err = configureTimezone(rootDir, model); err != nil {
 		// Just log the error, not setting the timezone is not reason to fail the install
 		log.Error("Error setting timezone: %v", err)
@@ -464,16 +822,41 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		log.Error("Error setting language locale: %v", err)
 	}
 
-	if err = cuser.Apply(rootDir, model.Users); err != nil {
+	if err = cuser.Apply(rootDir, model.Users, model.LockRoot); err != nil {
+		return err
+	}
+
+	if model.Hostname != "" {
+		if err = hostname.SetTargetHostname(rootDir, model.Hostname); err != nil {
+			return err
+		}
+	}
+
+	// The policy is recorded in the saved configuration
+	if model.MachineIDPolicy == "" {
+		model.MachineIDPolicy = hostname.MachineIDPopulated
+	}
+
+	if err = hostname.SetTargetMachineID(rootDir, model.MachineIDPolicy); err != nil {
+		return err
+	}
+
+	if err = model.Resolved.WriteDropIn(rootDir); err != nil {
 		return err
 	}
 
-	if model.Hostname != "" {
-		if err = hostname.SetTargetHostname(rootDir, model.Hostname); err != nil {
-			return err
-		}
+	// Written after the users so their names resolve for the owners
+	if err = files.Write(rootDir, model.WriteFiles); err != nil {
+		return err
 	}
 
+	return nil
+}
+
+// copyHostConfiguration copies the network, swupd and telemetry configurations to the target
+func copyHostConfiguration(rootDir string, model *model.SystemInstall) error {
+	var err error
+
 	if model.CopyNetwork {
 		if err = network.CopyNetworkInterfaces(rootDir); err != nil {
 			return err
@@ -494,21 +877,28 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		}
 	}
 
-	if err = applyHooks("post-install", vars, model.PostInstall); err != nil {
+	return nil
+}
+
+// finishInstall runs the post-install hooks and saves the installation results
+func finishInstall(rootDir string, vars map[string]string, model *model.SystemInstall, options args.Args) error {
+	setPhase(phasePostInstall)
+
+	if err := applyHooks("post-install", vars, model.PostInstall); err != nil {
 		return err
 	}
 
-	msg = utils.Locale.Get("Saving the installation results")
-	prg = progress.NewLoop(msg)
+	msg := utils.Locale.Get("Saving the installation results")
+	prg := progress.NewLoop(msg)
 	log.Info(msg)
-	if err = saveInstallResults(rootDir, model); err != nil {
+	if err := saveInstallResults(rootDir, model); err != nil {
 		log.ErrorError(err)
 	}
 	prg.Success()
 
 	if model.MakeISO {
 		log.Info("Generating ISO image")
-		if err = generateISO(rootDir, model, options); err != nil {
+		if err := generateISO(rootDir, model, options); err != nil {
 			log.ErrorError(err)
 		}
 	}
@@ -563,6 +953,83 @@ func runInstallHook(vars map[string]string, hook *model.InstallHook) error {
 	return nil
 }
 
+// createSwapFile creates the swapfile in the target, it returns false if the
+// swap or the swapfile are disabled
+func createSwapFile(rootDir string, md *model.SystemInstall) (bool, error) {
+	if md.MediaOpts.NoSwap {
+		log.Info("Swap is disabled, not creating a swapfile")
+		return false, nil
+	}
+
+	size, err := storage.ParseVolumeSize(md.MediaOpts.SwapFileSize)
+	if err != nil {
+		return false, err
+	}
+
+	// A size of 0 disables the swapfile
+	if size == 0 {
+		log.Info("Swapfile size is 0, not creating a swapfile")
+		return false, nil
+	}
+
+	partition := storage.SwapFilePartition(md.TargetMedias)
+	if partition == nil {
+		return false, errors.Errorf("Could not find the partition to hold the swapfile")
+	}
+	swapFile := storage.SwapFilePath(partition)
+
+	msg := utils.Locale.Get("Creating %s", swapFile)
+	prg := progress.NewLoop(msg)
+	log.Info(msg)
+
+	swapDir := filepath.Dir(filepath.Join(rootDir, swapFile))
+	if err = utils.MkdirAll(swapDir, 0755); err != nil {
+		prg.Failure()
+		return false, err
+	}
+
+	if err = storage.CreateSwapFile(rootDir, partition, size); err != nil {
+		prg.Failure()
+		return false, err
+	}
+	prg.Success()
+
+	return true, nil
+}
+
+// setDefaultKernel makes the most recent kernel of the selected bundle the
+// clr-boot-manager default kernel of the target, set-kernel updates the boot
+// loader with the same environment as the initial update
+func setDefaultKernel(cbmPath string, rootDir string, k *kernel.Kernel, envVars map[string]string) error {
+	w := bytes.NewBuffer(nil)
+
+	if err := cmd.Run(w, cbmPath, "list-kernels", fmt.Sprintf("--path=%s", rootDir)); err != nil {
+		return errors.Wrap(err)
+	}
+
+	// Other kernel bundles keep the clr-boot-manager default kernel
+	name, err := k.FindBootKernel(w.String())
+	if err != nil {
+		log.Warning("Not setting the default kernel: %v", err)
+		return nil
+	}
+
+	log.Info("Setting the default kernel to %s", name)
+
+	args := []string{
+		cbmPath,
+		"set-kernel",
+		name,
+		fmt.Sprintf("--path=%s", rootDir),
+	}
+
+	if err := cmd.RunAndLogWithEnv(envVars, args...); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
 // use the current host's version to bootstrap the sysroot, then update to the
 // latest one and start adding new bundles
 // for the bootstrap we use the hosts's swupd and the following operations are
@@ -625,6 +1092,15 @@ func contentInstall(rootDir string, version string,
 	msg := utils.Locale.Get("Installing base OS and configured bundles")
 	log.Info(msg)
 
+	// Report a missing kernel bundle before swupd starts installing the OS,
+	// the offline content is checked by swupd itself
+	if md.Kernel.Bundle != "none" && !swupd.OfflineIsUsable(version, options) {
+		if err := sw.CheckBundles(version, []string{md.Kernel.Bundle}); err != nil {
+			prg = progress.NewLoop(msg)
+			return prg, err
+		}
+	}
+
 	log.Debug("Installing bundles: %s", strings.Join(bundles, ", "))
 	if err := sw.OSInstall(version, swupd.TargetPrefix, bundles); err != nil {
 		// If the swupd command failed to run there wont be a progress
@@ -633,6 +1109,37 @@ func contentInstall(rootDir string, version string,
 		return prg, err
 	}
 
+	// Refreshing the cache only speeds up the next installs, it is not a failure
+	if options.SwupdCacheDir != "" {
+		if err := sw.UpdateCache(options.SwupdCacheDir); err != nil {
+			log.Warning("Failed to update the swupd cache %s: %s", options.SwupdCacheDir, err)
+		}
+	}
+
+	// The deferred bundles are only installed on first boot, make sure they
+	// exist now as swupd did for the installed ones
+	if len(md.DeferredBundles) > 0 {
+		if err := sw.CheckBundles(version, md.DeferredBundles); err != nil {
+			prg = progress.NewLoop(msg)
+			return prg, err
+		}
+
+		// Skip the deferred bundles added back by the install requirements
+		deferred := []string{}
+		for _, bundle := range md.DeferredBundles {
+			if !md.ContainsBundle(bundle) {
+				deferred = append(deferred, bundle)
+			}
+		}
+
+		if len(deferred) > 0 {
+			if err := swupd.InstallDeferredBundlesUnit(rootDir, deferred); err != nil {
+				prg = progress.NewLoop(msg)
+				return prg, err
+			}
+		}
+	}
+
 	// Create custom config in the installer image to override default bundle list
 	if md.TargetBundles != nil {
 		if err := writeCustomConfig(rootDir, md); err != nil {
@@ -654,6 +1161,7 @@ func contentInstall(rootDir string, version string,
 			language.RequiredBundle,
 			storage.RequiredBundle,
 			storage.RequiredBundleLVM,
+			storage.RequiredBundleBootloader,
 		}
 
 		// Load default config from chroot for required bundles list
@@ -698,8 +1206,67 @@ func contentInstall(rootDir string, version string,
 		prg.Success()
 	}
 
-	msg = utils.Locale.Get("Installing boot loader")
-	prg = progress.NewLoop(msg)
+	// The units are provided by the bundles, so they only exist now
+	if len(md.DisableServices) > 0 || len(md.MaskServices) > 0 {
+		msg := utils.Locale.Get("Disabling services")
+		prg = progress.NewLoop(msg)
+		log.Info(msg)
+		if err := services.Disable(rootDir, md.DisableServices); err != nil {
+			return prg, err
+		}
+		if err := services.Mask(rootDir, md.MaskServices); err != nil {
+			return prg, err
+		}
+		prg.Success()
+	}
+
+	// The default target is only changed when requested, the bundles set their own
+	if md.DefaultTarget != "" {
+		msg := utils.Locale.Get("Setting the default target")
+		prg = progress.NewLoop(msg)
+		log.Info(msg)
+		if err := services.SetDefault(rootDir, md.DefaultTarget); err != nil {
+			return prg, err
+		}
+		prg.Success()
+	}
+
+	if options.RootfsOnly == "" && storage.TrimTimerEnabled(md.TargetMedias, md.MediaOpts) {
+		msg := utils.Locale.Get("Enabling periodic TRIM")
+		prg = progress.NewLoop(msg)
+		log.Info(msg)
+		if err := services.Enable(rootDir, []string{storage.TrimTimerUnit}); err != nil {
+			return prg, err
+		}
+		prg.Success()
+	}
+
+	// A rootfs only install is not booted by itself, i.e. a container base image
+	if options.RootfsOnly == "" {
+		if prg, err := installBootloader(rootDir, md, options); err != nil {
+			return prg, err
+		}
+	}
+
+	// Clean-up State Directory content
+	if options.SwupdStateClean {
+		msg = utils.Locale.Get("Cleaning Swupd state directory")
+		prg = progress.NewLoop(msg)
+		log.Info(msg)
+		if err := sw.CleanUpState(); err != nil {
+			log.ErrorError(err)
+		}
+		prg.Success()
+	}
+
+	return nil, nil
+}
+
+// installBootloader installs the boot loader of the target with clr-boot-manager
+// and requests the enrollment of the Machine Owner Key, if any
+func installBootloader(rootDir string, md *model.SystemInstall, options args.Args) (progress.Progress, error) {
+	msg := utils.Locale.Get("Installing boot loader")
+	prg := progress.NewLoop(msg)
 	log.Info(msg)
 
 	cbmPath := options.CBMPath
@@ -718,7 +1285,7 @@ func contentInstall(rootDir string, version string,
 		"CBM_DEBUG": "1",
 	}
 
-	if md.MediaOpts.LegacyBios {
+	if md.MediaOpts.LegacyBoot() {
 		envVars["CBM_FORCE_LEGACY"] = "1"
 	}
 
@@ -726,15 +1293,32 @@ func contentInstall(rootDir string, version string,
 	if err != nil {
 		return prg, errors.Wrap(err)
 	}
+
+	// clr-boot-manager manages /boot, the XBOOTLDR of a split boot layout,
+	// the firmware boots systemd-boot from the ESP
+	if md.MediaOpts.SplitBoot {
+		if err = storage.InstallSplitBootESP(rootDir); err != nil {
+			return prg, err
+		}
+	}
+
+	// Without a configured kernel clr-boot-manager picks the default one
+	if md.Kernel != nil && md.Kernel.Bundle != "" && md.Kernel.Bundle != "none" {
+		if err = setDefaultKernel(cbmPath, rootDir, md.Kernel, envVars); err != nil {
+			return prg, err
+		}
+	}
 	prg.Success()
 
-	// Clean-up State Directory content
-	if options.SwupdStateClean {
-		msg = utils.Locale.Get("Cleaning Swupd state directory")
+	if md.MOKEnrollment() {
+		msg = utils.Locale.Get("Enrolling the Machine Owner Key")
 		prg = progress.NewLoop(msg)
 		log.Info(msg)
-		if err = sw.CleanUpState(); err != nil {
-			log.ErrorError(err)
+		if err = storage.MountEfivarFs(rootDir); err != nil {
+			return prg, err
+		}
+		if err = syscheck.EnrollMOK(rootDir, md.SecureBoot.MOKCert, md.SecureBoot.MOKPasswordFile); err != nil {
+			return prg, err
 		}
 		prg.Success()
 	}
@@ -742,6 +1326,60 @@ func contentInstall(rootDir string, version string,
 	return nil, nil
 }
 
+// EstimateDownloadSize returns a human readable approximation of the content
+// swupd will download for the install, or DownloadSizeUnknown if the size can
+// not be determined
+func EstimateDownloadSize(md *model.SystemInstall, options args.Args) string {
+	version := utils.VersionUintString(md.Version)
+
+	// Offline content is used, there is nothing to query
+	if md.Offline || swupd.OfflineIsUsable(version, options) {
+		return DownloadSizeUnknown
+	}
+
+	bundles := append([]string{}, md.Bundles...)
+	bundles = append(bundles, md.UserBundles...)
+
+	if md.Kernel != nil && md.Kernel.Bundle != "none" {
+		bundles = append(bundles, md.Kernel.Bundle)
+	}
+
+	key := version + ":" + strings.Join(bundles, ",")
+
+	downloadSizeCacheMutex.Lock()
+	sizeStr, found := downloadSizeCache[key]
+	downloadSizeCacheMutex.Unlock()
+	if found {
+		return sizeStr
+	}
+
+	sw := swupd.New("", options, md)
+
+	size, err := sw.DownloadSize(version, bundles)
+	if err != nil {
+		log.Warning("Could not determine the download size: %v", err)
+		return DownloadSizeUnknown
+	}
+
+	if sizeStr, err = storage.HumanReadableSizeXiBWithPrecision(size, 1); err != nil {
+		return DownloadSizeUnknown
+	}
+
+	downloadSizeCacheMutex.Lock()
+	downloadSizeCache[key] = sizeStr
+	downloadSizeCacheMutex.Unlock()
+
+	return sizeStr
+}
+
+// EstimateDownloadSizeAsync estimates the download size in the background and
+// calls done with the result, the confirm dialogs do not wait for the network
+func EstimateDownloadSizeAsync(md *model.SystemInstall, options args.Args, done func(string)) {
+	go func() {
+		done(EstimateDownloadSize(md, options))
+	}()
+}
+
 func copyOfflineToStatedir(rootDir, stateDir string) error {
 	// Force an error for testing
 	if testFail, _ := utils.FileExists(path.Join(conf.OfflineContentDir, "FAIL")); testFail {
@@ -787,6 +1425,180 @@ func copyOfflineToStatedir(rootDir, stateDir string) error {
 	return nil
 }
 
+// postInstallVerify re-verifies the installed files with swupd to catch a
+// corrupted download, the corrupted files fail the install unless only a
+// warning is requested; the outcome is recorded in telemetry
+func postInstallVerify(rootDir string, version string, md *model.SystemInstall, options args.Args) error {
+	msg := utils.Locale.Get("Verifying the installed files")
+	prg := progress.NewLoop(msg)
+	log.Info(msg)
+
+	sw := swupd.New(rootDir, options, md)
+	failed, err := sw.Verify(version)
+	if err != nil {
+		prg.Failure()
+		if errLog := md.Telemetry.LogRecord("verify", 3, err.Error()); errLog != nil {
+			log.Error("Failed to log Telemetry verify record")
+		}
+		return err
+	}
+
+	if len(failed) == 0 {
+		prg.Success()
+		if errLog := md.Telemetry.LogRecord("verify", 1, "Installed files verified"); errLog != nil {
+			log.Error("Failed to log Telemetry verify record")
+		}
+		return nil
+	}
+
+	for _, file := range failed {
+		log.Warning("Verification failed for: %s", file)
+	}
+
+	msg = utils.Locale.Get("%d installed files failed the verification", len(failed))
+	if errLog := md.Telemetry.LogRecord("verify", 2, msg); errLog != nil {
+		log.Error("Failed to log Telemetry verify record")
+	}
+
+	if !md.PostInstallVerifyWarn {
+		prg.Failure()
+		return errors.Errorf("%s", msg)
+	}
+
+	// Only reported, the install goes on
+	prg.Failure()
+	log.Warning(msg)
+
+	return nil
+}
+
+// checkDisksHealth runs the SMART health check of the target disks and records
+// each status in telemetry; failing disks only block the install in block mode
+func checkDisksHealth(md *model.SystemInstall) error {
+	msg := utils.Locale.Get("Checking the SMART health of the target disks")
+	prg := progress.NewLoop(msg)
+	log.Info(msg)
+
+	devices := []string{}
+	for _, tm := range md.TargetMedias {
+		devices = append(devices, tm.GetDeviceFile())
+	}
+
+	failing := []string{}
+	for _, health := range syscheck.CheckDisksHealth(devices) {
+		severity := 1
+		if health.Failing() {
+			severity = 2
+			failing = append(failing, fmt.Sprintf("%s (%s)", health.Device, health.Status))
+		}
+
+		if errLog := md.Telemetry.LogRecord("smart", severity, health.Device+": "+health.Status); errLog != nil {
+			log.Error("Failed to log Telemetry SMART record for: %s", health.Device)
+		}
+	}
+
+	if len(failing) == 0 {
+		prg.Success()
+		return nil
+	}
+
+	prg.Failure()
+
+	msg = utils.Locale.Get("SMART health check failed for: %s", strings.Join(failing, ", "))
+	if md.SmartCheck == syscheck.SmartCheckBlock {
+		return errors.Errorf("%s", msg)
+	}
+
+	log.Warning(msg)
+
+	return nil
+}
+
+// checkSystemClock warns when the system clock is not sane, when --fix-clock
+// was requested the clock is set from the swupd content server; a wrong clock
+// is left to fail the swupd connections
+func checkSystemClock(md *model.SystemInstall, options args.Args) {
+	clockErr := syscheck.CheckClock(time.Now(), model.BuildDate)
+	if clockErr == nil {
+		return
+	}
+
+	if !options.FixClock {
+		log.Warning("%v; use --fix-clock to set it from the content server", clockErr)
+		return
+	}
+
+	log.Warning("%v", clockErr)
+
+	url := swupd.New("", options, md).ContentURL()
+	if url == "" {
+		log.Warning("No content server to set the system clock from")
+		return
+	}
+
+	msg := utils.Locale.Get("Setting the system clock")
+	prg := progress.NewLoop(msg)
+	log.Info(msg)
+	if err := syscheck.FixClock(url); err != nil {
+		// The content server may not be reachable yet, let swupd report it
+		prg.Failure()
+		log.Warning("Could not set the system clock from %s: %v", url, err)
+		return
+	}
+	prg.Success()
+
+	if err := syscheck.CheckClock(time.Now(), model.BuildDate); err != nil {
+		log.Warning("%v", err)
+	}
+}
+
+// checkSwupdVersion makes sure the content server publishes the version to
+// install and records the resolved version in the pre-install configuration
+// and the result; the recorded latest install keeps its auto update default
+func checkSwupdVersion(model *model.SystemInstall, options args.Args, version, preConfFile string) (string, error) {
+	resolved, err := swupd.New("", options, model).CheckVersion(version)
+	if err != nil {
+		return version, err
+	}
+
+	if resolved == version {
+		return version, nil
+	}
+
+	number, err := utils.VersionStringUint(resolved)
+	if err != nil {
+		return version, errors.Errorf("Invalid resolved version %q: %v", resolved, err)
+	}
+
+	if !model.AutoUpdate.IsSet() {
+		model.AutoUpdate.SetValue(model.AutoUpdate.Value())
+	}
+	model.Version = number
+	installResult.Version = resolved
+
+	if err = model.WriteFile(preConfFile); err != nil {
+		log.Error("Failed to write pre-install YAML file (%v) %q", err, preConfFile)
+	}
+
+	return resolved, nil
+}
+
+// checkSwupdFormat makes sure the requested version and swupd format are
+// compatible and records the format resolved from the content server
+func checkSwupdFormat(model *model.SystemInstall, options args.Args, version string) error {
+	format, err := swupd.New("", options, model).CheckFormat(version)
+	if err != nil {
+		return err
+	}
+
+	if model.SwupdFormat == "" {
+		log.Info("Using the swupd format %s of version %s", format, version)
+		model.SwupdFormat = format
+	}
+
+	return nil
+}
+
 // ConfigureNetwork applies the model/configured network interfaces
 func ConfigureNetwork(model *model.SystemInstall) error {
 	prg, err := configureNetwork(model)
@@ -859,13 +1671,28 @@ func configureNetwork(model *model.SystemInstall) (progress.Progress, error) {
 	return nil, nil
 }
 
-// configureTimezone applies the model/configured Timezone to the target
-func configureTimezone(rootDir string, model *model.SystemInstall) error {
-	if model.Timezone.Code == timezone.DefaultTimezone {
-		log.Debug("Skipping setting timezone " + model.Timezone.Code)
-		return nil
+// geolocateTimezone replaces the default timezone by the one reported by the
+// geolocation service; failing to geolocate is not reason to fail the install
+func geolocateTimezone(model *model.SystemInstall, options args.Args) {
+	url := timezone.DefaultGeolocationURL
+	if options.TimezoneGeolocationURL != "" {
+		url = options.TimezoneGeolocationURL
+	} else if model.TimezoneGeolocationURL != "" {
+		url = model.TimezoneGeolocationURL
+	}
+
+	tz, err := timezone.Geolocate(url)
+	if err != nil {
+		log.Warning("Keeping the default timezone %s: %v", model.Timezone.Code, err)
+		return
 	}
 
+	log.Info("Using the geolocated timezone %s", tz.Code)
+	model.Timezone = tz
+}
+
+// configureTimezone applies the model/configured Timezone to the target
+func configureTimezone(rootDir string, model *model.SystemInstall) error {
 	msg := "Setting Timezone to " + model.Timezone.Code
 	prg := progress.NewLoop(msg)
 	log.Info(msg)
@@ -903,7 +1730,7 @@ func configureKeyboard(rootDir string, model *model.SystemInstall) error {
 
 // configureLanguage applies the model/configured language to the target
 func configureLanguage(rootDir string, model *model.SystemInstall) error {
-	if model.Language.Code == language.DefaultLanguage {
+	if !model.Language.RequiresLocaleBundle() {
 		log.Debug("Skipping setting language locale " + model.Language.Code)
 		return nil
 	}
@@ -912,7 +1739,7 @@ func configureLanguage(rootDir string, model *model.SystemInstall) error {
 	prg := progress.NewLoop(msg)
 	log.Info(msg)
 
-	err := language.SetTargetLanguage(rootDir, model.Language.Code)
+	err := language.SetTargetLocale(rootDir, model.Language)
 	if err != nil {
 		prg.Failure()
 		return err
@@ -1037,7 +1864,7 @@ func generateISO(rootDir string, md *model.SystemInstall, options args.Args) err
 	var err error
 	log.Info("Building ISO image")
 
-	if !md.MediaOpts.LegacyBios {
+	if !md.MediaOpts.LegacyBoot() {
 		for _, alias := range md.StorageAlias {
 			if err = isoutils.MakeIso(rootDir, strings.TrimSuffix(alias.File,
 				filepath.Ext(alias.File)), md, options); err != nil {
@@ -0,0 +1,111 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// ResultSuccess is the outcome of a completed installation
+	ResultSuccess = "success"
+
+	// ResultFail is the outcome of a failed or crashed installation
+	ResultFail = "fail"
+
+	// Installation phases reported as the failing phase
+	phaseValidation    = "validation"
+	phaseMedia         = "media"
+	phaseFileSystems   = "filesystems"
+	phaseContent       = "content"
+	phaseConfiguration = "configuration"
+	phasePostInstall   = "post-install"
+)
+
+// InstallResult is the machine readable summary of an installation
+type InstallResult struct {
+	Outcome        string   `json:"outcome"`
+	Phase          string   `json:"phase,omitempty"`
+	Error          string   `json:"error,omitempty"`
+	TargetMedia    []string `json:"targetMedia"`
+	Bundles        []string `json:"bundles"`
+	Version        string   `json:"version"`
+	ElapsedSeconds float64  `json:"elapsedSeconds"`
+	start          time.Time
+}
+
+// installResult is the result of the running installation
+var installResult = newInstallResult()
+
+func newInstallResult() *InstallResult {
+	return &InstallResult{
+		Phase: phaseValidation,
+		start: time.Now(),
+	}
+}
+
+// setPhase records the installation phase being run, reported if it fails
+func setPhase(phase string) {
+	log.Debug("Entering the %s installation phase", phase)
+	installResult.Phase = phase
+}
+
+// finish completes the result with the final state of the model and err,
+// the failing phase is only kept if the installation failed
+func (r *InstallResult) finish(md *model.SystemInstall, err error) {
+	r.ElapsedSeconds = time.Since(r.start).Seconds()
+	r.TargetMedia = []string{}
+	r.Bundles = []string{}
+
+	for _, tm := range md.TargetMedias {
+		r.TargetMedia = append(r.TargetMedia, tm.GetDeviceFile())
+	}
+
+	r.Bundles = append(r.Bundles, md.Bundles...)
+	if md.Kernel != nil && md.Kernel.Bundle != "none" && !md.ContainsBundle(md.Kernel.Bundle) {
+		r.Bundles = append(r.Bundles, md.Kernel.Bundle)
+	}
+
+	if err != nil {
+		r.Outcome = ResultFail
+		r.Error = err.Error()
+	} else {
+		r.Outcome = ResultSuccess
+		r.Phase = ""
+	}
+}
+
+// write saves the result as JSON to the file, nothing is written if no
+// file is configured
+func (r *InstallResult) write(file string) error {
+	if file == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err = utils.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Info("Writing the installation result to %s", file)
+
+	if err = ioutil.WriteFile(file, append(data, '\n'), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
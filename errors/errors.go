@@ -31,6 +31,23 @@ type ValidationError struct {
 	What string
 }
 
+// InsufficientSpaceError is a type of error used to report the target ran out of
+// disk space while creating a file system or installing the content. Like the
+// ValidationError it is not an internal malfunctioning, the user is shown the What
+// attribute and the installer exits with a dedicated exit code.
+type InsufficientSpaceError struct {
+	When time.Time
+	What string
+}
+
+// noSpaceMessages are the, lower case, messages reporting the device is full
+var noSpaceMessages = []string{
+	"no space left on device",
+	"enospc",
+	"not enough disk space",
+	"not enough free space",
+}
+
 func getTraceIdx(idx int) (string, string, int) {
 	pc := make([]uintptr, 10)
 	runtime.Callers(2, pc)
@@ -104,3 +121,38 @@ func IsValidationError(err error) bool {
 	}
 	return false
 }
+
+func (se InsufficientSpaceError) Error() string {
+	return se.What
+}
+
+// InsufficientSpaceErrorf formats a new InsufficientSpaceError
+func InsufficientSpaceErrorf(format string, a ...interface{}) error {
+	return InsufficientSpaceError{
+		When: time.Now(),
+		What: fmt.Sprintf(format, a...),
+	}
+}
+
+// IsInsufficientSpaceError returns true if err is an InsufficientSpaceError
+// returns false otherwise
+func IsInsufficientSpaceError(err error) bool {
+	if _, ok := err.(InsufficientSpaceError); ok {
+		return true
+	}
+	return false
+}
+
+// IsNoSpaceMessage returns true if the command output msg reports the
+// device ran out of space (ENOSPC)
+func IsNoSpaceMessage(msg string) bool {
+	msg = strings.ToLower(msg)
+
+	for _, curr := range noSpaceMessages {
+		if strings.Contains(msg, curr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -65,3 +65,40 @@ func TestValidationError(t *testing.T) {
 		t.Fatal("IsValidationError() should return false for a TraceableError")
 	}
 }
+
+func TestInsufficientSpaceError(t *testing.T) {
+	msg := "Not enough space on sda3"
+	se := InsufficientSpaceErrorf(msg)
+
+	if se.Error() != msg {
+		t.Fatal("Wrong insufficient space error message")
+	}
+
+	if !IsInsufficientSpaceError(se) {
+		t.Fatal("IsInsufficientSpaceError() should report true")
+	}
+
+	if IsInsufficientSpaceError(ValidationErrorf("A validation error")) {
+		t.Fatal("IsInsufficientSpaceError() should return false for a ValidationError")
+	}
+
+	if IsValidationError(se) {
+		t.Fatal("IsValidationError() should return false for an InsufficientSpaceError")
+	}
+}
+
+func TestIsNoSpaceMessage(t *testing.T) {
+	tests := map[string]bool{
+		"mkfs.ext4: No space left on device while writing out and closing file system": true,
+		"write failed: ENOSPC":                             true,
+		"Error: There is not enough disk space to install": true,
+		"mkfs.ext4: Device size reported to be zero":       false,
+		"": false,
+	}
+
+	for msg, expected := range tests {
+		if IsNoSpaceMessage(msg) != expected {
+			t.Fatalf("IsNoSpaceMessage(%q) should return %v", msg, expected)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/user"
+)
+
+const (
+	// DefaultMode is the mode of a written file declaring none
+	DefaultMode = "0644"
+)
+
+var (
+	// chown changes the owner of the file path in the target rootDir,
+	// replaceable for testing
+	chown = func(rootDir string, owner string, path string) error {
+		return cmd.RunAndLog("chroot", rootDir, "/usr/bin/chown", owner, path)
+	}
+)
+
+// WriteFile is a file to be written into the target after the content install
+type WriteFile struct {
+	Path    string `yaml:"path,omitempty,flow"`
+	Content string `yaml:"content,omitempty,flow"`
+	Mode    string `yaml:"mode,omitempty,flow"`
+	Owner   string `yaml:"owner,omitempty,flow"`
+}
+
+// fileMode returns the file permissions of the octal mode string
+func fileMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		mode = DefaultMode
+	}
+
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 07777 {
+		return 0, errors.ValidationErrorf("Invalid mode %q, must be an octal value such as %s", mode, DefaultMode)
+	}
+
+	perm := os.FileMode(value & 0777)
+	if value&04000 != 0 {
+		perm |= os.ModeSetuid
+	}
+	if value&02000 != 0 {
+		perm |= os.ModeSetgid
+	}
+	if value&01000 != 0 {
+		perm |= os.ModeSticky
+	}
+
+	return perm, nil
+}
+
+// isValidOwnerID checks id is either a numeric id or an account name
+func isValidOwnerID(id string) bool {
+	if _, err := strconv.ParseUint(id, 10, 32); err == nil {
+		return true
+	}
+
+	valid, _ := user.IsValidLogin(id)
+	return valid
+}
+
+// validateOwner checks owner is in the chown form user, user:group or :group
+func validateOwner(owner string) error {
+	if owner == "" {
+		return nil
+	}
+
+	fields := strings.Split(owner, ":")
+	if len(fields) > 2 || (fields[0] == "" && len(fields) == 1) {
+		return errors.ValidationErrorf("Invalid owner %q, must be user, user:group or :group", owner)
+	}
+
+	for i, id := range fields {
+		// Both user and group may be omitted, not the two of them
+		if id == "" && (len(fields) == 2 && fields[1-i] != "") {
+			continue
+		}
+
+		if !isValidOwnerID(id) {
+			return errors.ValidationErrorf("Invalid owner %q, %q is not a name or a numeric id", owner, id)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks every file is an absolute path within the target root,
+// listed only once, with a valid mode and owner
+func Validate(files []*WriteFile) error {
+	seen := map[string]bool{}
+
+	for _, curr := range files {
+		if curr == nil || curr.Path == "" {
+			return errors.ValidationErrorf("writeFiles entries must provide a path")
+		}
+
+		if !filepath.IsAbs(curr.Path) {
+			return errors.ValidationErrorf("writeFiles path %q must be absolute", curr.Path)
+		}
+
+		for _, elem := range strings.Split(curr.Path, "/") {
+			if elem == ".." {
+				return errors.ValidationErrorf("writeFiles path %q must not contain \"..\"", curr.Path)
+			}
+		}
+
+		path := filepath.Clean(curr.Path)
+		if path == "/" {
+			return errors.ValidationErrorf("writeFiles path %q is not a file", curr.Path)
+		}
+
+		if seen[path] {
+			return errors.ValidationErrorf("File %s is listed more than once in writeFiles", path)
+		}
+		seen[path] = true
+
+		if _, err := fileMode(curr.Mode); err != nil {
+			return err
+		}
+
+		if err := validateOwner(curr.Owner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkNoSymlink fails if any existing element of path below rootDir is a
+// symbolic link, a link could otherwise redirect the write outside the target
+func checkNoSymlink(rootDir string, path string) error {
+	curr := rootDir
+
+	for _, elem := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		curr = filepath.Join(curr, elem)
+
+		fi, err := os.Lstat(curr)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return errors.Errorf("Can not write %s, %s is a symbolic link", path,
+				strings.TrimPrefix(curr, rootDir))
+		}
+	}
+
+	return nil
+}
+
+// write writes a single file into the target rootDir
+func (wf *WriteFile) write(rootDir string) error {
+	path := filepath.Clean(wf.Path)
+	target := filepath.Join(rootDir, path)
+
+	perm, err := fileMode(wf.Mode)
+	if err != nil {
+		return err
+	}
+
+	if err = checkNoSymlink(rootDir, path); err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return errors.Wrap(err)
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if _, err = f.WriteString(wf.Content); err != nil {
+		return errors.Wrap(err)
+	}
+
+	// The names are looked up in the target accounts, as for the users
+	if wf.Owner != "" {
+		if err = chown(rootDir, wf.Owner, path); err != nil {
+			return errors.Errorf("Failed to set the owner %s of %s: %v", wf.Owner, path, err)
+		}
+	}
+
+	// Enforce the mode regardless of the umask or an existing file, after
+	// chown which clears the setuid and setgid bits
+	if err = f.Chmod(perm); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// Write writes the files into the target rootDir, creating the missing
+// parent directories
+func Write(rootDir string, files []*WriteFile) error {
+	for _, curr := range files {
+		log.Info("Writing file %s", curr.Path)
+
+		if err := curr.write(rootDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package files
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []*WriteFile{
+		{Path: "/etc/hosts", Content: "127.0.0.1 localhost\n"},
+		{Path: "/etc/sysctl.d/50-swappiness.conf", Mode: "0600", Owner: "root"},
+		{Path: "/home/clrlinux/.profile", Owner: "clrlinux:"},
+		{Path: "/var/lib/app/config", Mode: "4755", Owner: "1000:1000"},
+		{Path: "/var/lib/app/data", Owner: ":wheel"},
+	}
+
+	if err := Validate(valid); err != nil {
+		t.Fatalf("Files should be valid: %v", err)
+	}
+
+	invalid := [][]*WriteFile{
+		{{Path: ""}},
+		{{Path: "etc/hosts"}},
+		{{Path: "/etc/../../hosts"}},
+		{{Path: "/etc/.."}},
+		{{Path: "/"}},
+		{{Path: "/etc/hosts"}, {Path: "/etc//hosts"}},
+		{{Path: "/etc/hosts", Mode: "0899"}},
+		{{Path: "/etc/hosts", Mode: "rw-r--r--"}},
+		{{Path: "/etc/hosts", Mode: "17777"}},
+		{{Path: "/etc/hosts", Owner: ":"}},
+		{{Path: "/etc/hosts", Owner: "root:root:root"}},
+		{{Path: "/etc/hosts", Owner: "Root User"}},
+	}
+
+	for _, curr := range invalid {
+		if err := Validate(curr); err == nil {
+			t.Fatalf("Files %+v should be invalid", *curr[len(curr)-1])
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "clr-installer-files-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	owners := map[string]string{}
+	defer func(orig func(string, string, string) error) { chown = orig }(chown)
+	chown = func(root string, owner string, path string) error {
+		owners[path] = owner
+		return nil
+	}
+
+	wfs := []*WriteFile{
+		{Path: "/etc/sysctl.d/50-swappiness.conf", Content: "vm.swappiness = 10\n"},
+		{Path: "/etc/sudoers.d/clrlinux", Content: "clrlinux ALL=(ALL) ALL\n", Mode: "0440", Owner: "0:0"},
+	}
+
+	if err = Write(rootDir, wfs); err != nil {
+		t.Fatalf("Failed to write the files: %v", err)
+	}
+
+	for _, curr := range wfs {
+		target := filepath.Join(rootDir, curr.Path)
+
+		content, err := ioutil.ReadFile(target)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", target, err)
+		}
+
+		if string(content) != curr.Content {
+			t.Fatalf("Expected content %q for %s, got %q", curr.Content, curr.Path, content)
+		}
+
+		fi, err := os.Stat(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		perm, _ := fileMode(curr.Mode)
+		if fi.Mode().Perm() != perm {
+			t.Fatalf("Expected mode %v for %s, got %v", perm, curr.Path, fi.Mode().Perm())
+		}
+
+		if owners[curr.Path] != curr.Owner {
+			t.Fatalf("Expected owner %q for %s, got %q", curr.Owner, curr.Path, owners[curr.Path])
+		}
+	}
+
+	// A link must not redirect the write out of the target
+	outside, err := ioutil.TempDir("", "clr-installer-files-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(outside) }()
+
+	if err = os.Symlink(outside, filepath.Join(rootDir, "etc", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Write(rootDir, []*WriteFile{{Path: "/etc/link/escaped"}}); err == nil {
+		t.Fatal("Writing through a symbolic link should fail")
+	}
+
+	if _, err = os.Stat(filepath.Join(outside, "escaped")); !os.IsNotExist(err) {
+		t.Fatal("The file should not have been written out of the target")
+	}
+}
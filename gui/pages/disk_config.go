@@ -19,6 +19,7 @@ import (
 	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/model"
 	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/swupd"
 	"github.com/clearlinux/clr-installer/utils"
 )
 
@@ -46,6 +47,10 @@ type DiskConfig struct {
 	destructiveButton     *gtk.RadioButton
 	advancedButton        *gtk.RadioButton
 	chooserCombo          *gtk.ComboBox
+	homeCheck             *gtk.CheckButton
+	homeCombo             *gtk.ComboBox
+	homeTargets           []storage.InstallTarget
+	tempHomeTarget        string
 	isSafeSelected        bool
 	isDestructiveSelected bool
 	isAdvancedSelected    bool
@@ -280,6 +285,45 @@ func NewDiskConfigPage(controller Controller, model *model.SystemInstall) (Page,
 
 	disk.mediaGrid.Attach(disk.chooserCombo, 1, 0, 1, 2)
 
+	// Optional separate /home media for the Safe Install
+	disk.homeCheck, err = gtk.CheckButtonNew()
+	if err != nil {
+		return nil, err
+	}
+
+	disk.homeCheck.SetLabel("  " + utils.Locale.Get("Place /home on a separate media"))
+	disk.homeCheck.SetMarginStart(common.StartEndMargin)
+	disk.homeCheck.SetHAlign(gtk.ALIGN_START)
+	disk.homeCheck.SetTooltipText(utils.Locale.Get("Only available for the Safe Installation."))
+	_ = disk.homeCheck.Connect("toggled", disk.populateHomeCombo)
+	disk.mediaGrid.Attach(disk.homeCheck, 0, 2, 1, 1)
+
+	disk.homeCombo, err = gtk.ComboBoxNew()
+	if err != nil {
+		log.Warning("Failed to make disk.homeCombo")
+		return nil, err
+	}
+
+	_ = disk.homeCombo.Connect("changed", disk.onHomeComboChanged)
+
+	homeMediaRenderer, _ := gtk.CellRendererPixbufNew()
+	disk.homeCombo.PackStart(homeMediaRenderer, true)
+	disk.homeCombo.AddAttribute(homeMediaRenderer, "pixbuf", 0)
+
+	homeFriendlyRenderer, _ := gtk.CellRendererTextNew()
+	disk.homeCombo.PackStart(homeFriendlyRenderer, true)
+	disk.homeCombo.AddAttribute(homeFriendlyRenderer, "text", 1)
+
+	homeNameRenderer, _ := gtk.CellRendererTextNew()
+	disk.homeCombo.PackStart(homeNameRenderer, true)
+	disk.homeCombo.AddAttribute(homeNameRenderer, "text", 2)
+
+	homeSizeRenderer, _ := gtk.CellRendererTextNew()
+	disk.homeCombo.PackStart(homeSizeRenderer, true)
+	disk.homeCombo.AddAttribute(homeSizeRenderer, "text", 4)
+
+	disk.mediaGrid.Attach(disk.homeCombo, 1, 2, 1, 1)
+
 	disk.mediaGrid.SetRowSpacing(10)
 	disk.mediaGrid.SetColumnSpacing(10)
 	disk.mediaGrid.SetColumnHomogeneous(true)
@@ -526,6 +570,7 @@ func (disk *DiskConfig) onRescanClick() {
 	disk.createRescanDialog()
 	disk.rescanDialog.ShowAll()
 	go func() {
+		previousMedia := disk.devs
 		scannedMedia, err := storage.RescanBlockDevices(disk.model.TargetMedias)
 		if err != nil {
 			log.Warning("Error scanning media %s", err.Error())
@@ -533,6 +578,10 @@ func (disk *DiskConfig) onRescanClick() {
 		disk.controller.SetScanMedia(scannedMedia)
 		disk.devs = disk.controller.GetScanMedia()
 
+		// Keep the selections for the media which are still present
+		disk.model.InstallSelected, disk.model.TargetMedias = storage.MergeRescannedSelection(
+			disk.model.InstallSelected, disk.model.TargetMedias, previousMedia, disk.devs)
+
 		// Check if the active device is still present
 		var found bool
 		for _, bd := range disk.devs {
@@ -544,7 +593,6 @@ func (disk *DiskConfig) onRescanClick() {
 		if !found {
 			disk.activeSerial = ""
 			disk.activeDisk = nil
-			disk.model.TargetMedias = nil
 		}
 		disk.refreshPage()
 		disk.rescanDialog.Close() // Unlike Destroy(), Close() closes the dialog window and seems to not crash
@@ -752,6 +800,68 @@ func (disk *DiskConfig) onChooserComboChanged(combo *gtk.ComboBox) {
 	} else {
 		log.Warning("Failed to get ComboBox iter: %v", iterErr)
 	}
+
+	// The /home media must differ from the selected / media
+	disk.populateHomeCombo()
+}
+
+func (disk *DiskConfig) onHomeComboChanged(combo *gtk.ComboBox) {
+	if active := combo.GetActive(); active >= 0 && active < len(disk.homeTargets) {
+		disk.tempHomeTarget = disk.homeTargets[active].Name
+		log.Debug("Home ComboBox entry selected is: %v", disk.tempHomeTarget)
+	}
+}
+
+// populateHomeCombo lists the safe targets, other than the selected / media,
+// which can hold a separate /home; only the Safe Install supports it
+func (disk *DiskConfig) populateHomeCombo() {
+	homeStore, err := newListStoreMedia()
+	if err != nil {
+		log.Warning("ListStoreNew homeStore failed")
+		return
+	}
+
+	disk.homeTargets = nil
+	selected := 0
+
+	if disk.isSafeSelected {
+		for _, target := range disk.safeTargets {
+			if target.Name == disk.tempSelectedTarget {
+				continue
+			}
+
+			if err := addListStoreMediaRow(homeStore, target); err != nil {
+				log.Warning("SetValue homeStore")
+				return
+			}
+
+			if target.Name == disk.tempHomeTarget {
+				selected = len(disk.homeTargets)
+			}
+			disk.homeTargets = append(disk.homeTargets, target)
+		}
+	}
+
+	disk.homeCheck.SetSensitive(len(disk.homeTargets) > 0)
+	if len(disk.homeTargets) == 0 {
+		disk.homeCheck.SetActive(false)
+	}
+
+	disk.homeCombo.SetModel(homeStore)
+	disk.homeCombo.SetActive(selected)
+	disk.homeCombo.SetSensitive(disk.homeCheck.GetActive() && len(disk.homeTargets) > 0)
+}
+
+// separateHomeMedia returns the media only holding the /home partition of a
+// Safe Install, nil when / and /home share the same media
+func separateHomeMedia(medias []*storage.BlockDevice) *storage.BlockDevice {
+	for _, bd := range medias {
+		if len(bd.Children) == 1 && bd.Children[0].MountPoint == "/home" {
+			return bd
+		}
+	}
+
+	return nil
 }
 
 // populateComboBoxes populates the scrollBox with usable widget things
@@ -793,6 +903,8 @@ func (disk *DiskConfig) populateComboBoxes() error {
 		return nil
 	}
 
+	disk.populateHomeCombo()
+
 	if disk.isAdvancedSelected {
 		disk.chooserCombo.SetModel(emptyStore)
 
@@ -904,18 +1016,18 @@ func (disk *DiskConfig) buildMediaLists() error {
 	if disk.model.MediaOpts.SwapFileSet {
 		checkSwapSize, _ = storage.ParseVolumeSize(disk.model.MediaOpts.SwapFileSize)
 	}
-	minSize := storage.MinimumDesktopInstallSize + checkSwapSize
+	minSize := swupd.MinimumInstallSize(disk.model, storage.MinimumDesktopInstallSize) + checkSwapSize
 	if disk.model.MediaOpts.SkipValidationSize {
 		minSize = 0
 	}
-	disk.safeTargets = storage.FindSafeInstallTargets(minSize, disk.devs)
-	disk.destructiveTargets = storage.FindAllInstallTargets(minSize, disk.devs)
+	disk.safeTargets = storage.FindSafeInstallTargets(minSize, disk.devs, disk.model.MediaOpts)
+	disk.destructiveTargets = storage.FindAllInstallTargets(minSize, disk.devs, disk.model.MediaOpts)
 
-	for _, curr := range storage.FindAdvancedInstallTargets(disk.devs) {
+	for _, curr := range storage.FindAdvancedInstallTargets(disk.devs, disk.model.MediaOpts) {
 		disk.model.AddTargetMedia(curr)
 		log.Debug("AddTargetMedia %+v", curr)
 		disk.model.InstallSelected[curr.Name] = storage.InstallTarget{Name: curr.Name,
-			Friendly: curr.Model, Removable: curr.RemovableDevice}
+			Friendly: curr.Model, Removable: curr.RemovableDevice, ActiveMembers: curr.ActiveMembers()}
 		disk.isAdvancedSelected = true
 	}
 
@@ -993,6 +1105,7 @@ func (disk *DiskConfig) GetTitle() string {
 // StoreChanges will store this pages changes into the model
 func (disk *DiskConfig) StoreChanges() {
 	var installBlockDevice *storage.BlockDevice
+	var rootName string
 	disk.saveMedias = nil
 
 	if disk.safeButton.GetActive() {
@@ -1000,7 +1113,17 @@ func (disk *DiskConfig) StoreChanges() {
 		log.Debug("Safe Install chooserCombo selected %v", disk.chooserCombo.GetActive())
 		selected := disk.safeTargets[disk.chooserCombo.GetActive()]
 		disk.model.InstallSelected[selected.Name] = selected
+		rootName = selected.Name
 		log.Debug("Safe Install Target %v", selected)
+
+		// Optionally place /home on a second media
+		home := disk.homeCombo.GetActive()
+		if disk.homeCheck.GetActive() && home >= 0 && home < len(disk.homeTargets) &&
+			disk.homeTargets[home].Name != rootName {
+			disk.model.InstallSelected[disk.homeTargets[home].Name] = disk.homeTargets[home]
+			log.Debug("Safe Install /home Target %v", disk.homeTargets[home])
+		}
+
 		disk.model.TargetMedias = nil
 		disk.saveButton = disk.safeButton
 	} else if disk.destructiveButton.GetActive() {
@@ -1008,6 +1131,7 @@ func (disk *DiskConfig) StoreChanges() {
 		log.Debug("Destructive Install chooserCombo selected %v", disk.chooserCombo.GetActive())
 		selected := disk.destructiveTargets[disk.chooserCombo.GetActive()]
 		disk.model.InstallSelected[selected.Name] = selected
+		rootName = selected.Name
 		log.Debug("Destructive Install Target %v", selected)
 		disk.model.TargetMedias = nil
 		disk.saveButton = disk.destructiveButton
@@ -1027,26 +1151,45 @@ func (disk *DiskConfig) StoreChanges() {
 	}
 
 	for _, selected := range disk.model.InstallSelected {
+		found := false
+
 		for _, curr := range bds {
-			if curr.Name == selected.Name {
-				installBlockDevice = curr.Clone()
-				// Using the whole disk
+			if curr.Name != selected.Name {
+				continue
+			}
+
+			found = true
+			bd := curr.Clone()
+
+			if selected.Name != rootName {
+				// The separate /home media fills its space with /home
 				if selected.WholeDisk {
-					storage.NewStandardPartitions(installBlockDevice)
+					storage.NewHomePartitions(bd)
 				} else {
-					// Partial Disk, Add our partitions
-					size := selected.FreeEnd - selected.FreeStart
-					size = size - storage.AddBootStandardPartition(installBlockDevice)
-					storage.AddRootStandardPartition(installBlockDevice, size)
+					storage.AddHomeStandardPartition(bd, selected.FreeEnd-selected.FreeStart)
 				}
-				// Give the active disk to the model
-				disk.model.AddTargetMedia(installBlockDevice)
-				break
+			} else if selected.WholeDisk {
+				// Using the whole disk
+				storage.NewStandardPartitions(bd)
+				installBlockDevice = bd
+			} else {
+				// Partial Disk, Add our partitions
+				size := selected.FreeEnd - selected.FreeStart
+				size = size - storage.AddBootStandardPartition(bd)
+				storage.AddRootStandardPartition(bd, size)
+				installBlockDevice = bd
 			}
+			// Give the active disk to the model
+			disk.model.AddTargetMedia(bd)
+			break
+		}
+
+		if !found {
+			log.Warning("Selected media %s is no longer present", selected.Name)
 		}
 	}
 
-	if disk.encryptCheck.GetActive() {
+	if disk.encryptCheck.GetActive() && installBlockDevice != nil {
 		for _, child := range installBlockDevice.Children {
 			if child.MountPoint == "/" {
 				child.Type = storage.BlockDeviceTypeCrypt
@@ -1073,7 +1216,7 @@ func (disk *DiskConfig) ResetChanges() {
 
 		// Set default installation type
 		if disk.saveButton == nil {
-			if len(storage.FindAdvancedInstallTargets(disk.devs)) != 0 {
+			if len(storage.FindAdvancedInstallTargets(disk.devs, disk.model.MediaOpts)) != 0 {
 				disk.isAdvancedSelected = true
 			}
 
@@ -1193,14 +1336,21 @@ func (disk *DiskConfig) GetConfiguredValue() string {
 		return utils.Locale.Get("Warning: %s", strings.Join(results, ", "))
 	}
 
+	home := separateHomeMedia(tm)
+
 	if len(tm) == 0 {
 		return utils.Locale.Get("No Media Selected")
-	} else if len(tm) > 1 {
-		log.Warning("Too many media found, one 1 supported: %+v", tm)
+	} else if len(tm) > 2 || (len(tm) == 2 && home == nil) {
+		log.Warning("Too many media found, only / and a separate /home supported: %+v", tm)
 		return utils.Locale.Get("Too many media found")
+	} else if len(tm) == 1 && home != nil {
+		return utils.Locale.Get("No Media Selected")
 	}
 
 	bd := tm[0]
+	if bd == home {
+		bd = tm[1]
+	}
 	target := disk.model.InstallSelected[bd.Name]
 	portion := storage.FormatInstallPortion(target)
 
@@ -1214,7 +1364,12 @@ func (disk *DiskConfig) GetConfiguredValue() string {
 		}
 	}
 
-	return fmt.Sprintf("%s (%s) %s%s %s", target.Friendly, target.Name, portion, encrypted, size)
+	homeDescription := ""
+	if home != nil {
+		homeDescription = ", " + utils.Locale.Get("/home on %s", home.Name)
+	}
+
+	return fmt.Sprintf("%s (%s) %s%s %s%s", target.Friendly, target.Name, portion, encrypted, size, homeDescription)
 }
 
 func (disk *DiskConfig) runDiskPartitionTool() {
@@ -194,7 +194,7 @@ func (page *LanguagePage) GetTitle() string {
 // StoreChanges will store this pages changes into the model
 func (page *LanguagePage) StoreChanges() {
 	page.controller.SetButtonState(ButtonNext, false)
-	page.model.Language = page.selected
+	page.model.Language = page.selected.KeepCategories(page.model.Language)
 	language.SetSelectionLanguage(page.model.Language.Code)
 	utils.SetLocale(page.model.Language.Code)
 }
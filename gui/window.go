@@ -297,7 +297,7 @@ func (window *Window) createWelcomePage() (*Window, error) {
 	window.ActivatePage(window.menu.welcomePage)
 
 	// Create syscheck pop-up when system check fails
-	if syscheckErr := syscheck.RunSystemCheck(true); syscheckErr != nil {
+	if syscheckErr := syscheck.RunSystemCheck(true, window.model.SystemRequirements); syscheckErr != nil {
 		_ = glib.IdleAdd(func() { displaySyscheckDialog(syscheckErr) })
 	}
 
@@ -734,7 +734,9 @@ func writeToConfirmInstallDialog(buffer *gtk.TextBuffer, dryRunResults *storage.
 
 func setConfirmButtonState(dialog *gtk.Dialog, window *Window) error {
 	var err error
-	if storage.GetImpactOnOtherDisks() && !window.model.MediaOpts.ForceDestructive {
+	// Erasing a member of an active RAID array or volume group requires --force-destructive
+	forceRequired := storage.GetImpactOnOtherDisks() || storage.HasActiveMemberTargets(window.model.InstallSelected)
+	if forceRequired && !window.model.MediaOpts.ForceDestructive {
 		if buttonIWidget, err := dialog.GetWidgetForResponse(gtk.RESPONSE_OK); err == nil {
 			confirmButton := buttonIWidget.ToWidget()
 			confirmButton.SetSensitive(false)
@@ -881,11 +883,22 @@ func (window *Window) confirmInstall() {
 		}
 	}
 
-	dryRunResults := storage.GetPlannedMediaChanges(window.model.InstallSelected, window.model.TargetMedias,
-		window.model.MediaOpts)
+	dryRunResults := model.BuildPlan(window.model).DryRun()
 
 	writeToConfirmInstallDialog(buffer, dryRunResults)
 
+	// The manifests are fetched in the background, the dialog does not wait
+	if controller.NetworkPassing {
+		controller.EstimateDownloadSizeAsync(window.model, window.options, func(downloadSize string) {
+			_ = glib.IdleAdd(func() {
+				buffer.Insert(buffer.GetEndIter(), utils.Locale.Get("Estimated download size: %s", downloadSize)+"\n")
+			})
+		})
+	} else {
+		buffer.Insert(buffer.GetEndIter(),
+			utils.Locale.Get("Estimated download size: %s", controller.DownloadSizeUnknown)+"\n")
+	}
+
 	if err = setConfirmButtonState(dialog, window); err != nil {
 		log.Error("Error setting Confirm button state", err)
 	}
@@ -16,6 +16,7 @@ import (
 
 var (
 	startsWithExp = regexp.MustCompile(`^[0-9A-Za-z]`)
+	endsWithExp   = regexp.MustCompile(`[0-9A-Za-z]$`)
 	hostnameExp   = regexp.MustCompile(`^[0-9A-Za-z]+[0-9A-Za-z-]*$`)
 )
 
@@ -25,11 +26,15 @@ const (
 )
 
 // IsValidHostname returns error message or nil if is valid
+// following the RFC 1123 label rules
 // https://en.wikipedia.org/wiki/Hostname
 func IsValidHostname(hostname string) string {
 	if !startsWithExp.MatchString(hostname) {
 		return utils.Locale.Get("Hostname can only start with alphanumeric")
 	}
+	if !endsWithExp.MatchString(hostname) {
+		return utils.Locale.Get("Hostname can only end with alphanumeric")
+	}
 	if !hostnameExp.MatchString(hostname) {
 		return utils.Locale.Get("Hostname can only contain alphanumeric and hyphen")
 	}
@@ -40,6 +40,11 @@ func TestInvalidHostnames(t *testing.T) {
 	if err = IsValidHostname(host); err == "" {
 		t.Fatalf("Hostname %q should fail", host)
 	}
+
+	host = "nogood-"
+	if err = IsValidHostname(host); err == "" {
+		t.Fatalf("Hostname %q should fail", host)
+	}
 }
 
 func TestTooLongHostname(t *testing.T) {
@@ -157,3 +162,56 @@ func TestFailedToWrite(t *testing.T) {
 		t.Fatal("Should have failed to write hostname file")
 	}
 }
+
+func TestMachineIDPolicy(t *testing.T) {
+	for _, policy := range []string{"", MachineIDPopulated, MachineIDBlank} {
+		if err := ValidateMachineIDPolicy(policy); err != nil {
+			t.Fatalf("Machine-id policy %q should be valid: %v", policy, err)
+		}
+	}
+
+	if err := ValidateMachineIDPolicy("random"); err == nil {
+		t.Fatal("Machine-id policy \"random\" should be invalid")
+	}
+
+	rootDir, err := ioutil.TempDir("", "testhost-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	idFile := filepath.Join(rootDir, "etc", "machine-id")
+	if err = SetTargetMachineID(rootDir, MachineIDPopulated); err != nil {
+		t.Fatalf("Failed to apply the populated policy: %v", err)
+	}
+
+	if _, err = os.Stat(idFile); !os.IsNotExist(err) {
+		t.Fatal("The populated policy should not write the machine-id")
+	}
+
+	dbusDir := filepath.Join(rootDir, "var", "lib", "dbus")
+	for _, dir := range []string{filepath.Dir(idFile), dbusDir} {
+		if err = utils.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, file := range []string{idFile, filepath.Join(dbusDir, "machine-id")} {
+		if err = ioutil.WriteFile(file, []byte("0123456789abcdef0123456789abcdef\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = SetTargetMachineID(rootDir, MachineIDBlank); err != nil {
+		t.Fatalf("Failed to apply the blank policy: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(idFile)
+	if err != nil || len(content) != 0 {
+		t.Fatalf("Expected an empty machine-id, got %q: %v", content, err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dbusDir, "machine-id")); !os.IsNotExist(err) {
+		t.Fatal("The blank policy should remove the dbus machine-id copy")
+	}
+}
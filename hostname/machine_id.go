@@ -0,0 +1,66 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package hostname
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// MachineIDPopulated leaves the machine-id to be generated as usual
+	MachineIDPopulated = "populated"
+
+	// MachineIDBlank leaves an empty machine-id so every clone of a golden
+	// image generates its own one on first boot
+	MachineIDBlank = "blank"
+)
+
+// ValidateMachineIDPolicy checks policy is a known machine-id policy, the
+// empty policy being the populated one
+func ValidateMachineIDPolicy(policy string) error {
+	switch policy {
+	case "", MachineIDPopulated, MachineIDBlank:
+		return nil
+	}
+
+	return errors.ValidationErrorf("Invalid machine-id policy %q, valid values are %s and %s",
+		policy, MachineIDPopulated, MachineIDBlank)
+}
+
+// SetTargetMachineID applies the machine-id policy to the target; a blank
+// machine-id is an empty /etc/machine-id, systemd regenerates it on first boot
+func SetTargetMachineID(rootDir string, policy string) error {
+	if policy != MachineIDBlank {
+		return nil
+	}
+
+	etcDir := filepath.Join(rootDir, "etc")
+	if err := utils.MkdirAll(etcDir, 0755); err != nil {
+		return errors.Errorf("Failed to create directory (%v) %q", err, etcDir)
+	}
+
+	idFile := filepath.Join(etcDir, "machine-id")
+	if err := ioutil.WriteFile(idFile, []byte{}, 0444); err != nil {
+		return errors.Errorf("Failed to truncate the machine-id file (%v) %q", err, idFile)
+	}
+
+	// An old dbus copy would keep the id the clones share
+	dbusFile := filepath.Join(rootDir, "var", "lib", "dbus", "machine-id")
+	if info, err := os.Lstat(dbusFile); err == nil && info.Mode().IsRegular() {
+		if err = os.Remove(dbusFile); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	log.Debug("Blanked the installation target machine-id %q", idFile)
+
+	return nil
+}
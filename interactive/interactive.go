@@ -0,0 +1,394 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package interactive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/keyboard"
+	"github.com/clearlinux/clr-installer/language"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/timezone"
+	"github.com/clearlinux/clr-installer/user"
+)
+
+var (
+	// listDevices returns the disks offered as install target
+	listDevices = func() ([]*storage.BlockDevice, error) {
+		return storage.ListAvailableBlockDevices(nil)
+	}
+
+	// the validators are replaceable for testing, they depend on the host
+	isValidKeyboard = keyboard.IsValidKeyboard
+	isValidTimezone = timezone.IsValidTimezone
+	isValidLanguage = language.IsValidLanguage
+	isValidPassword = user.IsValidPassword
+
+	// bundleExp matches a single bundle name
+	bundleExp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._+-]*$`)
+)
+
+// prompter asks questions one line at a time, without any terminal
+// control sequence, so it works on a dumb terminal or a serial console
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// readSecret reads a line without echoing it when possible
+	readSecret func() (string, error)
+}
+
+// newPrompter returns a prompter reading the answers from in; secrets are
+// read without echo when in is a terminal
+func newPrompter(in io.Reader, out io.Writer) *prompter {
+	p := &prompter{in: bufio.NewReader(in), out: out}
+	p.readSecret = p.readLine
+
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		p.readSecret = func() (string, error) {
+			secret, err := term.ReadPassword(int(f.Fd()))
+			fmt.Fprintln(p.out)
+			return string(secret), err
+		}
+	}
+
+	return p
+}
+
+// readLine reads a line of input without its line ending; an input ending
+// without a new line returns the last line, then io.EOF
+func (p *prompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// ask prints the question and returns the answer, or defValue when empty;
+// the question is asked again until the answer passes validate
+func (p *prompter) ask(question string, defValue string, validate func(string) error) (string, error) {
+	return p.askWith(p.readLine, question, defValue, validate)
+}
+
+// askSecret is ask without echoing the answer and without a default
+func (p *prompter) askSecret(question string, validate func(string) error) (string, error) {
+	return p.askWith(p.readSecret, question, "", validate)
+}
+
+func (p *prompter) askWith(read func() (string, error), question string, defValue string,
+	validate func(string) error) (string, error) {
+	for {
+		if defValue != "" {
+			fmt.Fprintf(p.out, "%s [%s]: ", question, defValue)
+		} else {
+			fmt.Fprintf(p.out, "%s: ", question)
+		}
+
+		answer, err := read()
+		if err != nil {
+			return "", errors.Wrap(err)
+		}
+
+		if answer = strings.TrimSpace(answer); answer == "" {
+			answer = defValue
+		}
+
+		if validate == nil {
+			return answer, nil
+		}
+
+		if err = validate(answer); err == nil {
+			return answer, nil
+		}
+
+		fmt.Fprintf(p.out, "%s\n", err)
+	}
+}
+
+// confirm asks a yes or no question
+func (p *prompter) confirm(question string, defValue bool) (bool, error) {
+	def := "n"
+	if defValue {
+		def = "y"
+	}
+
+	answer, err := p.ask(question+" (y/n)", def, func(value string) error {
+		switch strings.ToLower(value) {
+		case "y", "yes", "n", "no":
+			return nil
+		}
+		return errors.Errorf("Please answer y or n")
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return strings.HasPrefix(strings.ToLower(answer), "y"), nil
+}
+
+// Run prompts for the target disk, bundles, user, time zone, keyboard and
+// language, the current md values being the defaults, writes the resulting
+// configuration to path and returns true if the installation should proceed
+func Run(md *model.SystemInstall, path string, in io.Reader, out io.Writer) (bool, error) {
+	p := newPrompter(in, out)
+
+	steps := []func(*prompter, *model.SystemInstall) error{
+		askDisk, askBundles, askUser, askTimezone, askKeyboard, askLanguage,
+	}
+
+	for _, step := range steps {
+		if err := step(p, md); err != nil {
+			return false, err
+		}
+	}
+
+	if err := md.WriteFile(path); err != nil {
+		return false, errors.Errorf("Failed to write YAML file (%v) %q", err, path)
+	}
+
+	log.Info("Interactive configuration written to %s", path)
+	fmt.Fprintf(p.out, "Configuration written to %s\n", path)
+
+	return p.confirm("Install now?", false)
+}
+
+// askDisk lists the available disks and replaces the target media by the
+// standard partitions of the chosen one
+func askDisk(p *prompter, md *model.SystemInstall) error {
+	devices, err := listDevices()
+	if err != nil {
+		return err
+	}
+
+	if len(devices) == 0 {
+		return errors.Errorf("No available disk to install to")
+	}
+
+	fmt.Fprintln(p.out, "Available disks:")
+	for i, bd := range devices {
+		size, _ := bd.HumanReadableSizeXiB()
+		fmt.Fprintf(p.out, "  %d) %s %s %s\n", i+1, bd.Name, size, bd.Model)
+	}
+
+	var target *storage.BlockDevice
+
+	_, err = p.ask("Target disk, number or name", devices[0].Name, func(value string) error {
+		bd := storage.FindBlockDevice(devices, value)
+		if idx, err := strconv.Atoi(value); err == nil && idx > 0 && idx <= len(devices) {
+			bd = devices[idx-1]
+		}
+
+		if bd == nil {
+			return errors.Errorf("Unknown disk %q", value)
+		}
+
+		if bd.Size < storage.MinimumServerInstallSize {
+			size, _ := storage.HumanReadableSizeXB(storage.MinimumServerInstallSize)
+			return errors.Errorf("Disk %s is too small, the minimum size is %s", bd.Name, size)
+		}
+
+		target = bd
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ok, err := p.confirm(fmt.Sprintf("All data on %s will be erased, continue?", target.Name), false)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errors.Errorf("Aborted, %s was not selected", target.Name)
+	}
+
+	bd := target.Clone()
+	bd.Children = nil
+	storage.NewStandardPartitions(bd)
+
+	md.TargetMedias = nil
+	md.AddTargetMedia(bd)
+
+	log.Info("Interactive configuration: target disk %s", bd.Name)
+
+	return nil
+}
+
+// askBundles adds the comma or space separated bundles to the user bundles
+func askBundles(p *prompter, md *model.SystemInstall) error {
+	fmt.Fprintf(p.out, "Base bundles: %s\n", strings.Join(md.Bundles, ", "))
+
+	answer, err := p.ask("Additional bundles, comma separated, empty for none",
+		strings.Join(md.UserBundles, ","), func(value string) error {
+			for _, bundle := range splitList(value) {
+				if !bundleExp.MatchString(bundle) {
+					return errors.Errorf("Invalid bundle name %q", bundle)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	for _, bundle := range splitList(answer) {
+		md.AddUserBundle(bundle)
+	}
+
+	return nil
+}
+
+// splitList splits a comma or space separated list
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// askUser optionally creates a user, the password is asked twice
+func askUser(p *prompter, md *model.SystemInstall) error {
+	create, err := p.confirm("Create a user?", len(md.Users) == 0)
+	if err != nil || !create {
+		return err
+	}
+
+	login, err := p.ask("Login", "", func(value string) error {
+		return validity(user.IsValidLogin(value))
+	})
+	if err != nil {
+		return err
+	}
+
+	username, err := p.ask("Full name, empty for none", "", func(value string) error {
+		if value == "" {
+			return nil
+		}
+		return validity(user.IsValidUsername(value))
+	})
+	if err != nil {
+		return err
+	}
+
+	var pwd string
+
+	for {
+		if pwd, err = p.askSecret("Password", func(value string) error {
+			return validity(isValidPassword(value))
+		}); err != nil {
+			return err
+		}
+
+		confirmation, err := p.askSecret("Confirm the password", nil)
+		if err != nil {
+			return err
+		}
+
+		if confirmation == pwd {
+			break
+		}
+
+		fmt.Fprintln(p.out, "The passwords do not match")
+	}
+
+	admin, err := p.confirm("Administrative rights?", true)
+	if err != nil {
+		return err
+	}
+
+	usr, err := user.NewUser(login, username, pwd, admin)
+	if err != nil {
+		return err
+	}
+
+	md.AddUser(usr)
+
+	return nil
+}
+
+// validity converts the (bool, string) results of the user validators
+func validity(valid bool, msg string) error {
+	if valid {
+		return nil
+	}
+
+	return errors.Errorf("%s", msg)
+}
+
+func askTimezone(p *prompter, md *model.SystemInstall) error {
+	def := timezone.DefaultTimezone
+	if md.Timezone != nil {
+		def = md.Timezone.Code
+	}
+
+	answer, err := p.ask("Time zone", def, func(value string) error {
+		if !isValidTimezone(&timezone.TimeZone{Code: value}) {
+			return errors.Errorf("Invalid Time Zone '%s'", value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	md.Timezone = &timezone.TimeZone{Code: answer}
+
+	return nil
+}
+
+func askKeyboard(p *prompter, md *model.SystemInstall) error {
+	def := keyboard.DefaultKeyboard
+	if md.Keyboard != nil {
+		def = md.Keyboard.Code
+	}
+
+	answer, err := p.ask("Keyboard", def, func(value string) error {
+		if !isValidKeyboard(&keyboard.Keymap{Code: value}) {
+			return errors.Errorf("Invalid Keyboard '%s'", value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	md.Keyboard = &keyboard.Keymap{Code: answer}
+
+	return nil
+}
+
+func askLanguage(p *prompter, md *model.SystemInstall) error {
+	def := language.DefaultLanguage
+	if md.Language != nil {
+		def = md.Language.Code
+	}
+
+	answer, err := p.ask("Language", def, func(value string) error {
+		if !isValidLanguage(&language.Language{Code: value}) {
+			return errors.Errorf("Invalid Language '%s'", value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	md.Language = (&language.Language{Code: answer}).KeepCategories(md.Language)
+
+	return nil
+}
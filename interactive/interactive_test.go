@@ -0,0 +1,125 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package interactive
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/keyboard"
+	"github.com/clearlinux/clr-installer/language"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/timezone"
+)
+
+func TestAskRetries(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := newPrompter(strings.NewReader("bad\n\n"), out)
+
+	tries := 0
+	answer, err := p.ask("Question", "good", func(value string) error {
+		tries++
+		if value != "good" {
+			return os.ErrInvalid
+		}
+		return nil
+	})
+
+	if err != nil || answer != "good" || tries != 2 {
+		t.Fatalf("Expected the default after a retry, got %q after %d tries: %v", answer, tries, err)
+	}
+
+	if strings.Count(out.String(), "Question [good]: ") != 2 {
+		t.Fatalf("The question should have been asked twice: %q", out.String())
+	}
+
+	// The input ends before an answer passes the validation
+	if _, err = p.ask("Question", "", func(string) error { return os.ErrInvalid }); err == nil {
+		t.Fatal("Running out of input should fail")
+	}
+}
+
+func TestRun(t *testing.T) {
+	defer func(devices func() ([]*storage.BlockDevice, error), kbd func(*keyboard.Keymap) bool,
+		tz func(*timezone.TimeZone) bool, lang func(*language.Language) bool,
+		pwd func(string) (bool, string)) {
+		listDevices = devices
+		isValidKeyboard = kbd
+		isValidTimezone = tz
+		isValidLanguage = lang
+		isValidPassword = pwd
+	}(listDevices, isValidKeyboard, isValidTimezone, isValidLanguage, isValidPassword)
+
+	listDevices = func() ([]*storage.BlockDevice, error) {
+		return []*storage.BlockDevice{
+			{Name: "sda", Size: 1024 * 1024 * 1024, Type: storage.BlockDeviceTypeDisk},
+			{Name: "sdb", Size: 64 * 1024 * 1024 * 1024, Type: storage.BlockDeviceTypeDisk,
+				Children: []*storage.BlockDevice{{Name: "sdb1"}}},
+		}, nil
+	}
+	isValidKeyboard = func(k *keyboard.Keymap) bool { return k.Code == "de" }
+	isValidTimezone = func(tz *timezone.TimeZone) bool { return tz.Code == "Europe/Berlin" }
+	isValidLanguage = func(l *language.Language) bool { return l.Code == language.DefaultLanguage }
+	isValidPassword = func(pwd string) (bool, string) { return len(pwd) >= 8, "Too short" }
+
+	dir, err := ioutil.TempDir("", "clr-installer-interactive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	answers := []string{
+		"sdc", "1", "2", "y", // unknown disk, too small, then sdb
+		"vim, git", // bundles
+		"", "clrlinux", "Clear Linux", "short", "secret123", "secret12", "secret123", "secret123", "",
+		"Mars/Olympus", "Europe/Berlin", // time zone
+		"xx", "de", // keyboard
+		"", // language
+		"n",
+	}
+
+	md := &model.SystemInstall{}
+	md.InitializeDefaults()
+
+	path := filepath.Join(dir, "interactive.yaml")
+	install, err := Run(md, path, strings.NewReader(strings.Join(answers, "\n")+"\n"), ioutil.Discard)
+	if err != nil {
+		t.Fatalf("Interactive configuration failed: %v", err)
+	}
+
+	if install {
+		t.Fatal("The installation should not proceed")
+	}
+
+	loaded, err := model.LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("Failed to load the written configuration: %v", err)
+	}
+
+	if len(loaded.TargetMedias) != 1 || loaded.TargetMedias[0].Name != "sdb" ||
+		len(loaded.TargetMedias[0].Children) == 0 || loaded.TargetMedias[0].Children[0].MountPoint != "/boot" {
+		t.Fatalf("Expected the standard partitions of sdb, got %+v", loaded.TargetMedias)
+	}
+
+	if strings.Join(loaded.UserBundles, ",") != "git,vim" {
+		t.Fatalf("Unexpected bundles %v", loaded.UserBundles)
+	}
+
+	if len(loaded.Users) != 1 || loaded.Users[0].Login != "clrlinux" || !loaded.Users[0].Admin {
+		t.Fatalf("Unexpected users %+v", loaded.Users)
+	}
+
+	if loaded.Timezone.Code != "Europe/Berlin" || loaded.Keyboard.Code != "de" ||
+		loaded.Language.Code != language.DefaultLanguage {
+		t.Fatalf("Unexpected localization %s %s %s", loaded.Timezone.Code, loaded.Keyboard.Code,
+			loaded.Language.Code)
+	}
+}
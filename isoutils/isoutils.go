@@ -16,6 +16,7 @@ import (
 
 	"github.com/clearlinux/clr-installer/args"
 	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
 	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/model"
 	"github.com/clearlinux/clr-installer/progress"
@@ -79,20 +80,125 @@ func mkTmpDirs() error {
 	return err
 }
 
-func mkRootfs() error {
+// defaultSquashfsCompression is the compressor of the live image when
+// model.ISOCompression is not set
+const defaultSquashfsCompression = "gzip"
+
+// squashfsHelp returns the mksquashfs usage, replaceable for testing
+var squashfsHelp = func() (string, error) {
+	var out bytes.Buffer
+
+	err := cmd.Run(&out, "mksquashfs", "-help")
+
+	return out.String(), err
+}
+
+// squashfsCompressors returns the compressors listed by the mksquashfs
+// usage help, the ones mksquashfs was built with
+func squashfsCompressors(help string) []string {
+	compressors := []string{}
+	listed := false
+
+	for _, line := range strings.Split(help, "\n") {
+		if strings.HasPrefix(line, "Compressors available") {
+			listed = true
+			continue
+		}
+
+		if !listed || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// The list ends with the next unindented section
+		if !strings.HasPrefix(line, "\t") {
+			break
+		}
+
+		// The compressor options and their descriptions are indented further
+		if line[1] != ' ' && line[1] != '\t' {
+			compressors = append(compressors, strings.Fields(line)[0])
+		}
+	}
+
+	return compressors
+}
+
+// checkSquashfsCompression fails when the installed mksquashfs does not
+// support compression, before anything is installed for the ISO
+func checkSquashfsCompression(compression string) error {
+	if compression == "" {
+		compression = defaultSquashfsCompression
+	}
+
+	help, err := squashfsHelp()
+	compressors := squashfsCompressors(help)
+	if len(compressors) == 0 {
+		log.Warning("Could not list the mksquashfs compressors, not checking %s: %v", compression, err)
+		return nil
+	}
+
+	if !utils.StringSliceContains(compressors, compression) {
+		return errors.Errorf("mksquashfs does not support the isoCompression %q, available: %s",
+			compression, strings.Join(compressors, ", "))
+	}
+
+	return nil
+}
+
+// mkLiveRootfs installs the model.ISOBundles and the kernel of the installed
+// system in a temporary directory, the live image is then smaller than the
+// installed system; the caller removes the returned directory
+func mkLiveRootfs(version string, model *model.SystemInstall, options args.Args) (string, error) {
+	msg := "Installing the bundles of the live image"
+	log.Info(msg)
+
+	liveRoot, err := ioutil.TempDir(options.TempDir, "clr_live_")
+	if err != nil {
+		return "", err
+	}
+
+	bundles := append([]string{}, model.ISOBundles...)
+	if model.Kernel != nil && model.Kernel.Bundle != "none" {
+		bundles = append(bundles, model.Kernel.Bundle)
+	}
+
+	// The swupd state is kept out of the live image to keep it small
+	if options.SwupdStateDir, err = ioutil.TempDir(options.TempDir, "clr_live_state_"); err != nil {
+		_ = os.RemoveAll(liveRoot)
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(options.SwupdStateDir) }()
+
+	sw := swupd.New(liveRoot, options, model)
+
+	if err = sw.OSInstall(version, swupd.IsoPrefix, bundles); err != nil {
+		progress.NewLoop(msg).Failure()
+		_ = os.RemoveAll(liveRoot)
+		return "", err
+	}
+	progress.NewLoop(msg).Success()
+
+	return liveRoot, nil
+}
+
+func mkRootfs(rootfs string, compression string) error {
 	msg := "Making squashfs of rootfs"
 	prg := progress.NewLoop(msg)
 	log.Info(msg)
 
+	if compression == "" {
+		compression = defaultSquashfsCompression
+	}
+
 	/* TODO: This takes a long time to run, it'd be nice to see it's output as it's running */
 	args := []string{
 		"mksquashfs",
-		tmpPaths[clrRootfs],
+		rootfs,
 		tmpPaths[clrCdroot] + "/images/rootfs.img",
 		"-b",
 		"131072",
 		"-comp",
-		"gzip",
+		compression,
 		"-e",
 		"boot/",
 		"-e",
@@ -706,12 +812,25 @@ func MakeIso(rootDir string, imgName string, model *model.SystemInstall, options
 		return err
 	}
 
+	if err = checkSquashfsCompression(model.ISOCompression); err != nil {
+		return err
+	}
+
 	if err = mkTmpDirs(); err != nil {
 		return err
 	}
 	defer cleanup()
 
-	if err = mkRootfs(); err != nil {
+	// The live image is the installed system unless its bundles are set
+	rootfs := rootDir
+	if len(model.ISOBundles) > 0 {
+		if rootfs, err = mkLiveRootfs(string(version), model, options); err != nil {
+			return err
+		}
+		defer func() { _ = os.RemoveAll(rootfs) }()
+	}
+
+	if err = mkRootfs(rootfs, model.ISOCompression); err != nil {
 		return err
 	}
 
@@ -0,0 +1,61 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package isoutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/clearlinux/clr-installer/errors"
+)
+
+const squashfsUsage = `SYNTAX:mksquashfs source1 source2 ...  dest [options] [-e list of exclude dirs/files]
+
+Filesystem build options:
+-comp <comp>		select <comp> compression
+			Compressors available:
+				gzip (default)
+				xz
+-b <block_size>		set data block to <block_size>.  Default 128 Kbytes
+
+Compressors available and compressor specific options:
+	gzip (default)
+	  -Xcompression-level <compression-level>
+		<compression-level> should be 1 .. 9 (default 9)
+	lzma (no options)
+	xz
+	  -Xbcj filter1,filter2,...,filterN
+	zstd
+	  -Xcompression-level <compression-level>
+		<compression-level> should be 1 .. 22 (default 15)
+`
+
+func TestCheckSquashfsCompression(t *testing.T) {
+	savedHelp := squashfsHelp
+	defer func() { squashfsHelp = savedHelp }()
+
+	compressors := squashfsCompressors(squashfsUsage)
+	if expected := []string{"gzip", "lzma", "xz", "zstd"}; !reflect.DeepEqual(compressors, expected) {
+		t.Fatalf("Expected the compressors %v, got %v", expected, compressors)
+	}
+
+	squashfsHelp = func() (string, error) { return squashfsUsage, errors.Errorf("exit status 1") }
+
+	for _, curr := range []string{"", "xz", "zstd"} {
+		if err := checkSquashfsCompression(curr); err != nil {
+			t.Fatalf("The compression %q should be supported: %v", curr, err)
+		}
+	}
+
+	if err := checkSquashfsCompression("lz4"); err == nil {
+		t.Fatal("mksquashfs was not built with lz4")
+	}
+
+	// The compression is not checked when mksquashfs can not be probed
+	squashfsHelp = func() (string, error) { return "", errors.Errorf("mksquashfs not found") }
+	if err := checkSquashfsCompression("lz4"); err != nil {
+		t.Fatalf("The compression should not be checked without mksquashfs: %v", err)
+	}
+}
@@ -6,7 +6,11 @@ package kernel
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/clearlinux/clr-installer/conf"
 	"github.com/clearlinux/clr-installer/errors"
@@ -27,6 +31,40 @@ type Arguments struct {
 	Remove []string // Remove is the set of arguments to be removed
 }
 
+// ValidateArgument checks arg is a well formed kernel command line token
+func ValidateArgument(arg string) error {
+	if arg == "" {
+		return errors.ValidationErrorf("Kernel argument must not be empty")
+	}
+
+	if strings.HasPrefix(arg, "=") {
+		return errors.ValidationErrorf("Kernel argument %q is missing a parameter name", arg)
+	}
+
+	if strings.Count(arg, "\"")%2 != 0 {
+		return errors.ValidationErrorf("Kernel argument %q has unbalanced quotes", arg)
+	}
+
+	for _, r := range arg {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return errors.ValidationErrorf("Kernel argument %q has invalid characters", arg)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks all of the arguments to be added or removed are well formed
+func (a *Arguments) Validate() error {
+	for _, curr := range append(a.Add, a.Remove...) {
+		if err := ValidateArgument(curr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // LoadKernelList loads the kernel definitions
 func LoadKernelList() ([]*Kernel, error) {
 	return LoadKernelListChroot("")
@@ -87,3 +125,46 @@ func (k *Kernel) Equals(comp *Kernel) bool {
 
 	return k.Bundle == comp.Bundle
 }
+
+// Flavor returns the kernel flavor installed by the bundle, i.e. native
+// for kernel-native or kernel-native-dkms, as used in the clr-boot-manager
+// kernel names
+func (k *Kernel) Flavor() string {
+	return strings.TrimSuffix(strings.TrimPrefix(k.Bundle, "kernel-"), "-dkms")
+}
+
+// FindBootKernel returns, out of the clr-boot-manager list-kernels output,
+// the name of the most recent kernel provided by the bundle
+func (k *Kernel) FindBootKernel(list string) (string, error) {
+	prefix := fmt.Sprintf("org.clearlinux.%s.", k.Flavor())
+	found := ""
+	release := -1
+
+	for _, line := range strings.Split(list, "\n") {
+		name := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "*"))
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		idx := strings.LastIndex(name, "-")
+		if idx < 0 {
+			continue
+		}
+
+		curr, err := strconv.Atoi(name[idx+1:])
+		if err != nil {
+			continue
+		}
+
+		if curr > release {
+			found = name
+			release = curr
+		}
+	}
+
+	if found == "" {
+		return "", errors.Errorf("No kernel found for the bundle %s", k.Bundle)
+	}
+
+	return found, nil
+}
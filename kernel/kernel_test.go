@@ -0,0 +1,73 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package kernel
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindBootKernel(t *testing.T) {
+	list := `  org.clearlinux.lts.4.19.84-95
+* org.clearlinux.native.5.3.11-867
+  org.clearlinux.native.5.4.2-870
+  org.clearlinux.kvm.5.3.11-431
+`
+
+	tests := []struct {
+		bundle string
+		name   string
+	}{
+		{"kernel-native", "org.clearlinux.native.5.4.2-870"},
+		{"kernel-lts", "org.clearlinux.lts.4.19.84-95"},
+		{"kernel-kvm", "org.clearlinux.kvm.5.3.11-431"},
+		{"kernel-native-dkms", "org.clearlinux.native.5.4.2-870"},
+	}
+
+	for _, curr := range tests {
+		k := &Kernel{Bundle: curr.bundle}
+
+		name, err := k.FindBootKernel(list)
+		if err != nil {
+			t.Fatalf("Failed to find the %s kernel: %v", curr.bundle, err)
+		}
+
+		if name != curr.name {
+			t.Fatalf("Expected %s for %s, got %s", curr.name, curr.bundle, name)
+		}
+	}
+
+	k := &Kernel{Bundle: "kernel-iot-lts2018"}
+	if _, err := k.FindBootKernel(list); err == nil {
+		t.Fatalf("Should fail to find a kernel not installed")
+	}
+}
+
+func TestZswap(t *testing.T) {
+	var disabled *Zswap
+	if args := disabled.Arguments(); len(args) != 0 {
+		t.Fatalf("zswap should be disabled by default: %v", args)
+	}
+
+	if args := (&Zswap{Compressor: "zstd"}).Arguments(); len(args) != 0 {
+		t.Fatalf("zswap should be opt-in: %v", args)
+	}
+
+	z := &Zswap{Enabled: true, Compressor: "zstd", MaxPoolPercent: 25}
+	if err := z.Validate(); err != nil {
+		t.Fatalf("Valid zswap settings refused: %v", err)
+	}
+
+	expected := "zswap.enabled=1 zswap.compressor=zstd zswap.max_pool_percent=25"
+	if args := strings.Join(z.Arguments(), " "); args != expected {
+		t.Fatalf("Expected the zswap arguments %q, got %q", expected, args)
+	}
+
+	for _, z := range []*Zswap{{Enabled: true, Compressor: "gzip"}, {Enabled: true, MaxPoolPercent: 101}} {
+		if err := z.Validate(); err == nil {
+			t.Fatalf("Invalid zswap settings should be refused: %+v", z)
+		}
+	}
+}
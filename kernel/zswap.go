@@ -0,0 +1,74 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package kernel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+)
+
+const (
+	// ZswapArgumentPrefix is the prefix of the zswap kernel arguments
+	ZswapArgumentPrefix = "zswap."
+)
+
+var (
+	// zswapCompressors are the compressors the kernel crypto API provides for zswap
+	zswapCompressors = []string{"lzo", "lzo-rle", "lz4", "lz4hc", "zstd", "deflate", "842"}
+)
+
+// Zswap describes the compressed swap cache of the target system, it is
+// disabled unless Enabled is set
+type Zswap struct {
+	Enabled        bool   `yaml:"enabled,omitempty,flow"`
+	Compressor     string `yaml:"compressor,omitempty,flow"`
+	MaxPoolPercent uint   `yaml:"maxPoolPercent,omitempty,flow"`
+}
+
+// Validate checks the compressor is known and the pool size is a percentage
+func (z *Zswap) Validate() error {
+	if z.Compressor != "" {
+		known := false
+		for _, curr := range zswapCompressors {
+			if curr == z.Compressor {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return errors.ValidationErrorf("Invalid zswap compressor %q, valid values are: %s",
+				z.Compressor, strings.Join(zswapCompressors, ", "))
+		}
+	}
+
+	if z.MaxPoolPercent > 100 {
+		return errors.ValidationErrorf("Invalid zswap maxPoolPercent %d, must be at most 100", z.MaxPoolPercent)
+	}
+
+	return nil
+}
+
+// Arguments returns the kernel arguments enabling and tuning zswap, unset
+// parameters keep the kernel default
+func (z *Zswap) Arguments() []string {
+	if z == nil || !z.Enabled {
+		return []string{}
+	}
+
+	args := []string{ZswapArgumentPrefix + "enabled=1"}
+
+	if z.Compressor != "" {
+		args = append(args, ZswapArgumentPrefix+"compressor="+z.Compressor)
+	}
+
+	if z.MaxPoolPercent > 0 {
+		args = append(args, fmt.Sprintf("%smax_pool_percent=%d", ZswapArgumentPrefix, z.MaxPoolPercent))
+	}
+
+	return args
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
 
 	"golang.org/x/text/language"
 	"golang.org/x/text/language/display"
@@ -23,9 +24,13 @@ import (
 type Language struct {
 	Code        string
 	Tag         language.Tag
+	Categories  map[string]string // LC_* categories overriding the language locale
 	userDefined bool
 }
 
+// languageYAMLMarshal is the mapping form of a language with locale categories
+type languageYAMLMarshal map[string]string
+
 const (
 	// DefaultLanguage is the default language string
 	DefaultLanguage = "en_US.UTF-8"
@@ -41,9 +46,52 @@ var localizedLanguages = map[string]bool{ // TODO: Remove this when localization
 	"es_MX.UTF-8": false,
 }
 
+// languageBundles maps the languages, by their base language code, to the
+// bundles providing the fonts and input methods needed to render and type
+// them; the languages not listed only need RequiredBundle
+var languageBundles = map[string][]string{
+	"ja": {"desktop-locales"},
+	"ko": {"desktop-locales"},
+	"zh": {"desktop-locales"},
+	"th": {"desktop-locales"},
+	"hi": {"desktop-locales"},
+	"bn": {"desktop-locales"},
+	"ta": {"desktop-locales"},
+	"ar": {"desktop-locales"},
+	"fa": {"desktop-locales"},
+	"he": {"desktop-locales"},
+}
+
 // validLanguages stores the list of all valid, known languages
 var validLanguages []*Language
 
+// installableLocales caches the locales found by InstallableLocales
+var installableLocales []string
+
+// localeCategories are the LC_* categories which may be set in locale.conf
+var localeCategories = []string{
+	"LC_ADDRESS",
+	"LC_COLLATE",
+	"LC_CTYPE",
+	"LC_IDENTIFICATION",
+	"LC_MEASUREMENT",
+	"LC_MESSAGES",
+	"LC_MONETARY",
+	"LC_NAME",
+	"LC_NUMERIC",
+	"LC_PAPER",
+	"LC_TELEPHONE",
+	"LC_TIME",
+}
+
+// listLocales returns the output of "locale -a", replaceable for testing
+var listLocales = func() (string, error) {
+	w := bytes.NewBuffer(nil)
+	err := cmd.Run(w, "locale", "-a")
+
+	return w.String(), err
+}
+
 // displayLanguage is The default language to display all language value
 var displayLanguage *display.Dictionary
 
@@ -98,17 +146,39 @@ func (l *Language) GetConfValues() (string, string) {
 	return name, l.Code
 }
 
-// MarshalYAML marshals Language into YAML format
+// MarshalYAML marshals Language into YAML format, the language code alone
+// unless locale categories are set
 func (l *Language) MarshalYAML() (interface{}, error) {
-	return l.Code, nil
+	if len(l.Categories) == 0 {
+		return l.Code, nil
+	}
+
+	lm := languageYAMLMarshal{"code": l.Code}
+	for category, code := range l.Categories {
+		lm[category] = code
+	}
+
+	return lm, nil
 }
 
-// UnmarshalYAML unmarshals Language from YAML format
+// UnmarshalYAML unmarshals Language from YAML format, either the language
+// code or a mapping of the language code and its LC_* categories
 func (l *Language) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var code string
 
 	if err := unmarshal(&code); err != nil {
-		return err
+		var lm languageYAMLMarshal
+
+		if err = unmarshal(&lm); err != nil {
+			return err
+		}
+
+		code = lm["code"]
+		delete(lm, "code")
+
+		if len(lm) > 0 {
+			l.Categories = lm
+		}
 	}
 
 	l.Code = code
@@ -171,13 +241,12 @@ func Load() ([]*Language, error) {
 
 	uniqLang := make(map[string]*Language)
 
-	w := bytes.NewBuffer(nil)
-	err := cmd.Run(w, "locale", "-a")
+	locales, err := listLocales()
 	if err != nil {
 		return nil, err
 	}
 
-	tks := strings.Split(w.String(), "\n")
+	tks := strings.Split(locales, "\n")
 	for _, curr := range tks {
 		if curr == "" {
 			continue
@@ -218,13 +287,174 @@ func IsValidLanguage(l *Language) bool {
 		}
 	}
 
+	if result && IsValidLocaleCategories(l.Categories) != nil {
+		result = false
+	}
+
+	return result
+}
+
+// normalizeLocale returns the locale name with a canonical codeset,
+// "locale -a" reports en_US.UTF-8 as en_US.utf8
+func normalizeLocale(locale string) string {
+	parts := strings.SplitN(locale, ".", 2)
+	if len(parts) == 1 {
+		return locale
+	}
+
+	codeset := strings.ToLower(strings.ReplaceAll(parts[1], "-", ""))
+
+	return parts[0] + "." + codeset
+}
+
+// InstallableLocales returns the locales which can be installed, as listed
+// by "locale -a"; they are available in the target with the glibc-locale bundle
+func InstallableLocales() ([]string, error) {
+	if installableLocales != nil {
+		return installableLocales, nil
+	}
+
+	output, err := listLocales()
+	if err != nil {
+		return nil, err
+	}
+
+	locales := []string{}
+	for _, curr := range strings.Split(output, "\n") {
+		if curr = strings.TrimSpace(curr); curr != "" {
+			locales = append(locales, curr)
+		}
+	}
+	sort.Strings(locales)
+
+	installableLocales = locales
+
+	return installableLocales, nil
+}
+
+// isInstallableLocale checks the locale is one of the installable locales
+func isInstallableLocale(locale string) bool {
+	locales, err := InstallableLocales()
+	if err != nil {
+		return false
+	}
+
+	for _, curr := range locales {
+		if normalizeLocale(curr) == normalizeLocale(locale) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsValidLocaleCategories verifies the categories are known LC_* categories
+// set to installable locales
+func IsValidLocaleCategories(categories map[string]string) error {
+	for category, locale := range categories {
+		if !utils.StringSliceContains(localeCategories, category) {
+			return fmt.Errorf("Invalid locale category '%s'", category)
+		}
+
+		if !isInstallableLocale(locale) {
+			return fmt.Errorf("Invalid locale '%s' for %s", locale, category)
+		}
+	}
+
+	return nil
+}
+
+// RequiresLocaleBundle returns true if the language or any of its categories
+// uses a locale other than the default one
+func (l *Language) RequiresLocaleBundle() bool {
+	if l.Code != DefaultLanguage {
+		return true
+	}
+
+	for _, locale := range l.Categories {
+		if normalizeLocale(locale) != normalizeLocale(DefaultLanguage) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// baseLanguage returns the language code of a locale, ie: ja for ja_JP.UTF-8
+func baseLanguage(locale string) string {
+	code := strings.SplitN(locale, ".", 2)[0]
+	code = strings.SplitN(code, "@", 2)[0]
+
+	return strings.ToLower(strings.SplitN(code, "_", 2)[0])
+}
+
+// SuggestedBundles returns the bundles providing the fonts and input methods
+// of the language and of its LC_MESSAGES category, if any
+func (l *Language) SuggestedBundles() []string {
+	locales := []string{l.Code}
+	if locale, ok := l.Categories["LC_MESSAGES"]; ok {
+		locales = append(locales, locale)
+	}
+
+	seen := map[string]bool{}
+	result := []string{}
+
+	for _, locale := range locales {
+		for _, bundle := range languageBundles[baseLanguage(locale)] {
+			if !seen[bundle] {
+				seen[bundle] = true
+				result = append(result, bundle)
+			}
+		}
+	}
+
 	return result
 }
 
+// MissingBundles returns the suggested bundles of the language for which
+// hasBundle returns false
+func (l *Language) MissingBundles(hasBundle func(string) bool) []string {
+	result := []string{}
+
+	for _, bundle := range l.SuggestedBundles() {
+		if !hasBundle(bundle) {
+			result = append(result, bundle)
+		}
+	}
+
+	return result
+}
+
+// KeepCategories returns a copy of l with the locale categories of prev, the
+// categories set by the configuration survive an interactive language choice
+func (l *Language) KeepCategories(prev *Language) *Language {
+	if prev == nil || len(prev.Categories) == 0 {
+		return l
+	}
+
+	lang := *l
+	lang.Categories = prev.Categories
+
+	return &lang
+}
+
 // SetTargetLanguage creates a locale locale.conf on the target
 func SetTargetLanguage(rootDir string, language string) error {
+	return SetTargetLocale(rootDir, &Language{Code: language})
+}
+
+// SetTargetLocale creates the locale.conf of the target with the LANG of l
+// followed by its LC_* categories
+func SetTargetLocale(rootDir string, l *Language) error {
 	targetLocaleFile := filepath.Join(rootDir, "/etc/locale.conf")
 
+	lines := []string{"LANG=" + l.Code}
+	for _, category := range localeCategories {
+		if locale, found := l.Categories[category]; found {
+			lines = append(lines, category+"="+locale)
+		}
+	}
+
 	filehandle, err := os.OpenFile(targetLocaleFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("Could not create locale file")
@@ -234,7 +464,7 @@ func SetTargetLanguage(rootDir string, language string) error {
 		_ = filehandle.Close()
 	}()
 
-	if _, err := filehandle.Write([]byte("LANG=" + language + "\n")); err != nil {
+	if _, err := filehandle.Write([]byte(strings.Join(lines, "\n") + "\n")); err != nil {
 		return fmt.Errorf("Could not write keyboard file")
 	}
 
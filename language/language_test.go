@@ -0,0 +1,129 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package language
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestLocaleCategories(t *testing.T) {
+	defer func(orig func() (string, error)) {
+		listLocales = orig
+		installableLocales = nil
+		validLanguages = nil
+	}(listLocales)
+
+	listLocales = func() (string, error) {
+		return "C\nPOSIX\nde_DE.utf8\nen_US.utf8\nfr_FR\n", nil
+	}
+	installableLocales = nil
+
+	var l Language
+	config := "{code: en_US.UTF-8, LC_NUMERIC: de_DE.UTF-8, LC_TIME: de_DE.UTF-8}"
+	if err := yaml.Unmarshal([]byte(config), &l); err != nil {
+		t.Fatalf("Failed to parse the language: %v", err)
+	}
+
+	if l.Code != DefaultLanguage || len(l.Categories) != 2 || l.Categories["LC_TIME"] != "de_DE.UTF-8" {
+		t.Fatalf("Unexpected language %+v", l)
+	}
+
+	if err := IsValidLocaleCategories(l.Categories); err != nil {
+		t.Fatalf("The categories should be valid: %v", err)
+	}
+
+	if !l.RequiresLocaleBundle() {
+		t.Fatal("The de_DE.UTF-8 categories require the locale bundle")
+	}
+
+	// A single language is still written as its code
+	data, err := yaml.Marshal(&Language{Code: DefaultLanguage})
+	if err != nil || string(data) != DefaultLanguage+"\n" {
+		t.Fatalf("Unexpected single language YAML %q: %v", data, err)
+	}
+
+	data, err = yaml.Marshal(&l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed Language
+	if err = yaml.Unmarshal(data, &parsed); err != nil || parsed.Categories["LC_NUMERIC"] != "de_DE.UTF-8" {
+		t.Fatalf("Language categories lost in %q: %v", data, err)
+	}
+
+	invalid := []map[string]string{
+		{"LC_ALL": "de_DE.UTF-8"},
+		{"LANGUAGE": "de_DE.UTF-8"},
+		{"LC_TIME": "xx_XX.UTF-8"},
+		{"LC_TIME": ""},
+	}
+
+	for _, curr := range invalid {
+		if err = IsValidLocaleCategories(curr); err == nil {
+			t.Fatalf("Categories %v should be invalid", curr)
+		}
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-language-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	if err = os.MkdirAll(filepath.Join(rootDir, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = SetTargetLocale(rootDir, &l); err != nil {
+		t.Fatalf("Failed to write locale.conf: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "locale.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "LANG=en_US.UTF-8\nLC_NUMERIC=de_DE.UTF-8\nLC_TIME=de_DE.UTF-8\n"
+	if string(content) != expected {
+		t.Fatalf("Expected locale.conf %q, got %q", expected, content)
+	}
+}
+
+func TestSuggestedBundles(t *testing.T) {
+	tests := []struct {
+		lang     *Language
+		expected []string
+	}{
+		{&Language{Code: DefaultLanguage}, []string{}},
+		{&Language{Code: "de_DE.UTF-8"}, []string{}},
+		{&Language{Code: "ja_JP.UTF-8"}, []string{"desktop-locales"}},
+		{&Language{Code: "zh_CN.UTF-8"}, []string{"desktop-locales"}},
+		{&Language{Code: DefaultLanguage, Categories: map[string]string{"LC_MESSAGES": "ko_KR.UTF-8"}},
+			[]string{"desktop-locales"}},
+		{&Language{Code: DefaultLanguage, Categories: map[string]string{"LC_TIME": "ko_KR.UTF-8"}}, []string{}},
+	}
+
+	for _, curr := range tests {
+		bundles := curr.lang.SuggestedBundles()
+		if len(bundles) != len(curr.expected) || (len(bundles) > 0 && bundles[0] != curr.expected[0]) {
+			t.Fatalf("Language %+v suggests %v, expected %v", curr.lang, bundles, curr.expected)
+		}
+	}
+
+	lang := &Language{Code: "ja_JP.UTF-8"}
+	if missing := lang.MissingBundles(func(string) bool { return true }); len(missing) != 0 {
+		t.Fatalf("No bundle should be missing: %v", missing)
+	}
+
+	if missing := lang.MissingBundles(func(string) bool { return false }); len(missing) != 1 {
+		t.Fatalf("desktop-locales should be missing: %v", missing)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// journaldIdentifier is the SYSLOG_IDENTIFIER of the journal entries
+	journaldIdentifier = "clr-installer"
+)
+
+var (
+	// journaldSocket is the journald native protocol socket, replaceable for testing
+	journaldSocket = "/run/systemd/journal/socket"
+
+	// journalConn is the journald sink, nil unless SetJournaldOutput was called
+	journalConn net.Conn
+
+	// journaldPriority maps the log tags to syslog priorities
+	journaldPriority = map[string]int{
+		"ERR": 3,
+		"WRN": 4,
+		"INF": 6,
+		"DBG": 7,
+	}
+)
+
+// SetJournaldOutput sends the log entries to the systemd journal in
+// addition to the log file set with SetOutputFilename
+func SetJournaldOutput() error {
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return err
+	}
+
+	if journalConn != nil {
+		_ = journalConn.Close()
+	}
+	journalConn = conn
+
+	return nil
+}
+
+// CloseJournaldOutput stops sending the log entries to the systemd journal
+func CloseJournaldOutput() {
+	if journalConn == nil {
+		return
+	}
+
+	_ = journalConn.Close()
+	journalConn = nil
+}
+
+// appendJournalField appends a journald native protocol field to buf, using
+// the binary length prefixed form when the value spans multiple lines
+func appendJournalField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name)
+
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// writeJournal sends one log entry, already filtered by the log level, to
+// the journal; failures are ignored so the file sink is never affected
+func writeJournal(tag string, output string) {
+	if journalConn == nil {
+		return
+	}
+
+	priority, found := journaldPriority[tag]
+	if !found {
+		priority = journaldPriority["INF"]
+	}
+
+	buf := bytes.NewBuffer(nil)
+	appendJournalField(buf, "PRIORITY", strconv.Itoa(priority))
+	appendJournalField(buf, "SYSLOG_IDENTIFIER", journaldIdentifier)
+	appendJournalField(buf, "MESSAGE", strings.TrimSuffix(output, "\n"))
+
+	_, _ = journalConn.Write(buf.Bytes())
+}
@@ -72,6 +72,12 @@ func SetLogLevel(l int) {
 	}
 }
 
+// IsLevelEnabled returns true if the entries of level l are logged, allowing
+// to skip gathering costly diagnostics which would not be logged
+func IsLevelEnabled(l int) bool {
+	return level >= l
+}
+
 // SetOutputFilename ... sets the default log output to filename instead of stdout/stderr
 func SetOutputFilename(logFile string) (*os.File, error) {
 	logFileName = logFile
@@ -166,6 +172,12 @@ func LevelStr(level int) (string, error) {
 	return "", fmt.Errorf("Invalid log level: %d", level)
 }
 
+// logPrint writes output to the log file and, if set, to the journal
+func logPrint(tag string, output string) {
+	log.Print(output)
+	writeJournal(tag, output)
+}
+
 func logTag(tag string, format string, a ...interface{}) {
 	// If there are no variable to pass to the format,
 	// then we can escape any % signs.
@@ -177,7 +189,7 @@ func logTag(tag string, format string, a ...interface{}) {
 	output := fmt.Sprintf(f, a...)
 
 	if level >= LogLevelVerbose {
-		log.Print(output)
+		logPrint(tag, output)
 		return
 	}
 
@@ -190,10 +202,10 @@ func logTag(tag string, format string, a ...interface{}) {
 			}
 
 			repeat := fmt.Sprintf("[%s] [Previous line repeated %d time%s]\n", tag, lineCount, plural)
-			log.Print(repeat)
+			logPrint(tag, repeat)
 		}
 
-		log.Print(output)
+		logPrint(tag, output)
 
 		lineLast = output
 		lineCount = 0
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -161,6 +162,20 @@ func TestLogLevel(t *testing.T) {
 	}
 }
 
+func TestIsLevelEnabled(t *testing.T) {
+	defer SetLogLevel(LogLevelInfo)
+
+	SetLogLevel(LogLevelInfo)
+	if !IsLevelEnabled(LogLevelInfo) || IsLevelEnabled(LogLevelDebug) {
+		t.Fatal("Only the levels up to LogLevelInfo should be enabled")
+	}
+
+	SetLogLevel(LogLevelVerbose)
+	if !IsLevelEnabled(LogLevelDebug) {
+		t.Fatal("LogLevelDebug should be enabled with LogLevelVerbose")
+	}
+}
+
 func TestGetLogFileNameStr(t *testing.T) {
 	if GetLogFileName() == "" {
 		t.Fatalf("GetLogFileName returned an empty string")
@@ -316,3 +331,67 @@ func TestGetPreConfFile(t *testing.T) {
 func TestRequestCrashInfo(t *testing.T) {
 	RequestCrashInfo()
 }
+
+func TestJournaldOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-journald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	saved := journaldSocket
+	journaldSocket = filepath.Join(dir, "socket")
+	defer func() { journaldSocket = saved }()
+
+	if err = SetJournaldOutput(); err == nil {
+		t.Fatal("Should have failed without a journal socket")
+	}
+
+	journal, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = journal.Close() }()
+
+	fh := setLog(t)
+	defer func() { _ = os.Remove(fh.Name()) }()
+
+	if err = SetJournaldOutput(); err != nil {
+		t.Fatalf("Could not set the journald output: %v", err)
+	}
+	defer CloseJournaldOutput()
+
+	SetLogLevel(LogLevelInfo)
+	Debug("filtered by the log level")
+	Warning("journald %s", "entry")
+	Info("multi\nline")
+
+	received := []string{}
+	buf := make([]byte, 4096)
+	for i := 0; i < 2; i++ {
+		n, readErr := journal.Read(buf)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		received = append(received, string(buf[:n]))
+	}
+
+	if !strings.Contains(received[0], "PRIORITY=4\n") ||
+		!strings.Contains(received[0], "SYSLOG_IDENTIFIER=clr-installer\n") ||
+		!strings.Contains(received[0], "MESSAGE=[WRN] journald entry\n") {
+		t.Fatalf("Unexpected journal entry: %q", received[0])
+	}
+
+	if !strings.Contains(received[1], "PRIORITY=6\n") ||
+		!strings.Contains(received[1], "MESSAGE\n") ||
+		!strings.Contains(received[1], "[INF] multi\nline\n") {
+		t.Fatalf("Unexpected multi line journal entry: %q", received[1])
+	}
+
+	// The file output is kept unchanged
+	contents := readLog(t).String()
+	if strings.Contains(contents, "filtered") ||
+		!strings.Contains(contents, "[WRN] journald entry") {
+		t.Fatalf("Unexpected log file contents: %q", contents)
+	}
+}
@@ -120,6 +120,53 @@ func (mi *MassInstall) MustRun(args *args.Args) bool {
 	return args.ConfigFile != "" && (!args.ForceTUI && !args.ForceGUI)
 }
 
+// addFreeSpacePartitions returns the scanned disk of the configured media with
+// its reused partitions formatted in place and its free space partitions added,
+// the other existing partitions are kept
+func addFreeSpacePartitions(configured *storage.BlockDevice) (*storage.BlockDevice, error) {
+	devs, err := storage.ListAvailableBlockDevices(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, curr := range devs {
+		if curr.Name != configured.Name {
+			continue
+		}
+
+		if err = storage.ReuseExistingPartitions(curr, configured); err != nil {
+			return nil, err
+		}
+
+		if err = storage.AddFreeSpacePartitions(curr, configured); err != nil {
+			return nil, err
+		}
+
+		return curr, nil
+	}
+
+	return nil, errors.Errorf("Target media %s not found", configured.Name)
+}
+
+// detectedActiveMembers returns the active RAID arrays and logical volumes
+// using the detected device name, the media declared in the configuration
+// file do not know them
+func detectedActiveMembers(name string) []string {
+	devs, err := storage.ListAvailableBlockDevices(nil)
+	if err != nil {
+		log.Warning("Could not detect the active members of %s: %v", name, err)
+		return nil
+	}
+
+	for _, curr := range devs {
+		if curr.Name == name {
+			return curr.ActiveMembers()
+		}
+	}
+
+	return nil
+}
+
 // Run is part of the Frontend implementation and is the actual entry point for the
 // "mass installer" frontend
 func (mi *MassInstall) Run(md *model.SystemInstall, rootDir string, options args.Args) (bool, error) {
@@ -128,8 +175,10 @@ func (mi *MassInstall) Run(md *model.SystemInstall, rootDir string, options args
 	var results []string
 
 	// If there are no media defined, then we should look for
-	// Advanced Configuration labels
-	if len(md.TargetMedias) > 0 {
+	// Advanced Configuration labels; a rootfs install uses no media at all
+	if options.RootfsOnly != "" {
+		log.Debug("Mass installer installing the rootfs into %s", options.RootfsOnly)
+	} else if len(md.TargetMedias) > 0 {
 		// If the partitions are defined from the configuration file,
 		// assume the user knows what they are doing and ignore validation checks
 		if !options.SkipValidationSizeSet && !options.SkipValidationAllSet {
@@ -147,8 +196,23 @@ func (mi *MassInstall) Run(md *model.SystemInstall, rootDir string, options args
 
 		// Need to ensure the partitioner knows we are running from
 		// the command line and will be using the whole disk
-		for _, curr := range md.TargetMedias {
-			md.InstallSelected[curr.Name] = storage.InstallTarget{Name: curr.Name, WholeDisk: true}
+		for idx, curr := range md.TargetMedias {
+			if len(curr.FreeSpacePartitions()) > 0 || len(curr.ReusedPartitions()) > 0 {
+				disk, err := addFreeSpacePartitions(curr)
+				if err != nil {
+					fmt.Printf("Error using the existing partitions of %s: %s\n", curr.Name, err)
+					return false, err
+				}
+
+				md.TargetMedias[idx] = disk
+				md.InstallSelected[curr.Name] = storage.InstallTarget{Name: curr.Name, Friendly: disk.Model,
+					Removable: disk.RemovableDevice, ActiveMembers: disk.ActiveMembers()}
+				log.Debug("Mass installer using the existing partitions of %s defined in YAML", curr.Name)
+				continue
+			}
+
+			md.InstallSelected[curr.Name] = storage.InstallTarget{Name: curr.Name, WholeDisk: true,
+				ActiveMembers: detectedActiveMembers(curr.Name)}
 			log.Debug("Mass installer using defined media in YAML")
 		}
 
@@ -180,12 +244,12 @@ func (mi *MassInstall) Run(md *model.SystemInstall, rootDir string, options args
 			return false, err
 		}
 
-		devs = storage.FindAdvancedInstallTargets(devs)
+		devs = storage.FindAdvancedInstallTargets(devs, md.MediaOpts)
 		for _, curr := range devs {
 			md.AddTargetMedia(curr)
 			log.Debug("massinstall: AddTargetMedia %+v", curr)
 			md.InstallSelected[curr.Name] = storage.InstallTarget{Name: curr.Name, Friendly: curr.Model,
-				Removable: curr.RemovableDevice}
+				Removable: curr.RemovableDevice, ActiveMembers: curr.ActiveMembers()}
 			isAdvancedSelected = true
 		}
 
@@ -212,6 +276,11 @@ func (mi *MassInstall) Run(md *model.SystemInstall, rootDir string, options args
 		}
 	}
 
+	for _, warning := range md.LanguageWarnings() {
+		log.Warning("%s", warning)
+		fmt.Printf("Warning: %s\n", warning)
+	}
+
 	progress.Set(mi)
 
 	log.Debug("Starting install")
@@ -222,7 +291,7 @@ func (mi *MassInstall) Run(md *model.SystemInstall, rootDir string, options args
 
 	instError = controller.Install(rootDir, md, options)
 	if instError != nil {
-		if !errors.IsValidationError(instError) {
+		if !errors.IsValidationError(instError) && !errors.IsInsufficientSpaceError(instError) {
 			fmt.Printf("ERROR: Installation has failed!\n")
 		}
 		return false, instError
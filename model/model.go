@@ -5,6 +5,7 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -18,11 +19,15 @@ import (
 	"github.com/clearlinux/clr-installer/args"
 	"github.com/clearlinux/clr-installer/boolset"
 	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/files"
+	"github.com/clearlinux/clr-installer/hostname"
 	"github.com/clearlinux/clr-installer/kernel"
 	"github.com/clearlinux/clr-installer/keyboard"
 	"github.com/clearlinux/clr-installer/language"
 	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/services"
 	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/syscheck"
 	"github.com/clearlinux/clr-installer/telemetry"
 	"github.com/clearlinux/clr-installer/timezone"
 	"github.com/clearlinux/clr-installer/user"
@@ -34,8 +39,24 @@ const (
 	// when running in demo (aka documentation mode). We will
 	// now use this as a flag to not include the version in UI.
 	DemoVersion = "X.Y.Z"
+
+	// ModuleSigEnforceEnabled requires every kernel module to be signed
+	ModuleSigEnforceEnabled = "enabled"
+
+	// ModuleSigEnforceDisabled allows unsigned kernel modules to be loaded
+	ModuleSigEnforceDisabled = "disabled"
+
+	// moduleSigEnforceArg is the kernel argument controlling module signature enforcement
+	moduleSigEnforceArg = "module.sig_enforce"
+
+	// MaxSwupdParallel is the largest number of parallel swupd downloads
+	MaxSwupdParallel = 64
 )
 
+// ISOCompressions are the compressors known to mksquashfs, the ISO build checks
+// the installed mksquashfs was built with the selected one
+var ISOCompressions = []string{"gzip", "lz4", "lzma", "lzo", "xz", "zstd"}
+
 // Version of Clear Installer.
 // Also used by the Makefile for releases.
 // Default to the version of the program
@@ -47,52 +68,83 @@ var Version = "undefined"
 var BuildDate = "undefined"
 var testAlias = []string{}
 
+// secureBootState reads the firmware Secure Boot state, replaceable for testing
+var secureBootState = syscheck.SecureBootState
+
 // SystemInstall represents the system install "configuration", the target
 // medias, bundles to install and whatever state a install may require
 type SystemInstall struct {
-	InstallSelected   map[string]storage.InstallTarget `yaml:"-"`
-	TargetMedias      []*storage.BlockDevice           `yaml:"targetMedia"`
-	NetworkInterfaces []*network.Interface             `yaml:"networkInterfaces,omitempty,flow"`
-	Keyboard          *keyboard.Keymap                 `yaml:"keyboard,omitempty,flow"`
-	Language          *language.Language               `yaml:"language,omitempty,flow"`
-	Bundles           []string                         `yaml:"bundles,omitempty,flow"`
-	TargetBundles     []string                         `yaml:"targetBundles,omitempty,flow"`
-	UserBundles       []string                         `yaml:"userBundles,omitempty,flow"`
-	Offline           bool                             `yaml:"offline,omitempty,flow"`
-	HTTPSProxy        string                           `yaml:"httpsProxy,omitempty,flow"`
-	Telemetry         *telemetry.Telemetry             `yaml:"telemetry,omitempty,flow"`
-	Timezone          *timezone.TimeZone               `yaml:"timezone,omitempty,flow"`
-	Users             []*user.User                     `yaml:"users,omitempty,flow"`
-	KernelArguments   *kernel.Arguments                `yaml:"kernel-arguments,omitempty,flow"`
-	Kernel            *kernel.Kernel                   `yaml:"kernel,omitempty,flow"`
-	PostReboot        bool                             `yaml:"postReboot,omitempty,flow"`
-	SwupdMirror       string                           `yaml:"swupdMirror,omitempty,flow"`
-	AllowInsecureHTTP bool                             `yaml:"allowInsecureHTTP,omitempty,flow"`
-	SwupdSkipOptional bool                             `yaml:"swupdSkipOptional,omitempty,flow"`
-	PostArchive       *boolset.BoolSet                 `yaml:"postArchive,omitempty,flow"`
-	Hostname          string                           `yaml:"hostname,omitempty,flow"`
-	AutoUpdate        *boolset.BoolSet                 `yaml:"autoUpdate,flow"`
-	TelemetryURL      string                           `yaml:"telemetryURL,omitempty,flow"`
-	TelemetryTID      string                           `yaml:"telemetryTID,omitempty,flow"`
-	TelemetryPolicy   string                           `yaml:"telemetryPolicy,omitempty,flow"`
-	PreInstall        []*InstallHook                   `yaml:"pre-install,omitempty,flow"`
-	PostInstall       []*InstallHook                   `yaml:"post-install,omitempty,flow"`
-	PostImage         []*InstallHook                   `yaml:"post-image,omitempty,flow"`
-	SwupdFormat       string                           `yaml:"swupdFormat,omitempty,flow"`
-	Version           uint                             `yaml:"version,omitempty,flow"`
-	StorageAlias      []*StorageAlias                  `yaml:"block-devices,omitempty,flow"`
-	CopyNetwork       bool                             `yaml:"copyNetwork,omitempty,flow"`
-	CopySwupd         bool                             `yaml:"copySwupd,omitempty,flow"`
-	Environment       map[string]string                `yaml:"env,omitempty,flow"`
-	CryptPass         string                           `yaml:"-"`
-	MakeISO           bool                             `yaml:"iso,omitempty,flow"`
-	ISOPublisher      string                           `yaml:"isoPublisher,omitempty,flow"`
-	ISOApplicationID  string                           `yaml:"isoApplicationId,omitempty,flow"`
-	KeepImage         bool                             `yaml:"keepImage,omitempty,flow"`
-	LockFile          string                           `yaml:"-"`
-	ClearCfFile       string                           `yaml:"-"`
-	PreCheckDone      bool                             `yaml:"preCheckDone,omitempty,flow"`
-	MediaOpts         storage.MediaOpts                `yaml:",inline"`
+	InstallSelected            map[string]storage.InstallTarget `yaml:"-"`
+	TargetMedias               []*storage.BlockDevice           `yaml:"targetMedia"`
+	NetworkInterfaces          []*network.Interface             `yaml:"networkInterfaces,omitempty,flow"`
+	Keyboard                   *keyboard.Keymap                 `yaml:"keyboard,omitempty,flow"`
+	Language                   *language.Language               `yaml:"language,omitempty,flow"`
+	Bundles                    []string                         `yaml:"bundles,omitempty,flow"`
+	TargetBundles              []string                         `yaml:"targetBundles,omitempty,flow"`
+	UserBundles                []string                         `yaml:"userBundles,omitempty,flow"`
+	DeferredBundles            []string                         `yaml:"deferredBundles,omitempty,flow"`
+	DisableServices            []string                         `yaml:"disableServices,omitempty,flow"`
+	MaskServices               []string                         `yaml:"maskServices,omitempty,flow"`
+	DefaultTarget              string                           `yaml:"defaultTarget,omitempty,flow"`
+	Offline                    bool                             `yaml:"offline,omitempty,flow"`
+	HTTPSProxy                 string                           `yaml:"httpsProxy,omitempty,flow"`
+	AddressFamily              string                           `yaml:"addressFamily,omitempty,flow"`
+	Telemetry                  *telemetry.Telemetry             `yaml:"telemetry,omitempty,flow"`
+	Timezone                   *timezone.TimeZone               `yaml:"timezone,omitempty,flow"`
+	Users                      []*user.User                     `yaml:"users,omitempty,flow"`
+	LockRoot                   bool                             `yaml:"lockRoot,omitempty,flow"`
+	KernelArguments            *kernel.Arguments                `yaml:"kernel-arguments,omitempty,flow"`
+	ModuleSigEnforce           string                           `yaml:"moduleSigEnforce,omitempty,flow"`
+	Zswap                      *kernel.Zswap                    `yaml:"zswap,omitempty,flow"`
+	Kernel                     *kernel.Kernel                   `yaml:"kernel,omitempty,flow"`
+	PostReboot                 bool                             `yaml:"postReboot,omitempty,flow"`
+	SwupdMirror                string                           `yaml:"swupdMirror,omitempty,flow"`
+	AllowInsecureHTTP          bool                             `yaml:"allowInsecureHTTP,omitempty,flow"`
+	SwupdSkipOptional          bool                             `yaml:"swupdSkipOptional,omitempty,flow"`
+	SwupdMaxParallel           uint                             `yaml:"swupdMaxParallel,omitempty,flow"`
+	LocalContentDir            string                           `yaml:"localContentDir,omitempty,flow"`
+	AllowNoSigCheck            bool                             `yaml:"allowNoSigCheck,omitempty,flow"`
+	PostArchive                *boolset.BoolSet                 `yaml:"postArchive,omitempty,flow"`
+	Hostname                   string                           `yaml:"hostname,omitempty,flow"`
+	MachineIDPolicy            string                           `yaml:"machineIDPolicy,omitempty,flow"`
+	SecureBoot                 *SecureBoot                      `yaml:"secureBoot,omitempty,flow"`
+	AutoUpdate                 *boolset.BoolSet                 `yaml:"autoUpdate,flow"`
+	TelemetryURL               string                           `yaml:"telemetryURL,omitempty,flow"`
+	TelemetryTID               string                           `yaml:"telemetryTID,omitempty,flow"`
+	TelemetryPolicy            string                           `yaml:"telemetryPolicy,omitempty,flow"`
+	PreInstall                 []*InstallHook                   `yaml:"pre-install,omitempty,flow"`
+	PostInstall                []*InstallHook                   `yaml:"post-install,omitempty,flow"`
+	PostImage                  []*InstallHook                   `yaml:"post-image,omitempty,flow"`
+	SwupdFormat                string                           `yaml:"swupdFormat,omitempty,flow"`
+	SwupdSkipFormatCheck       bool                             `yaml:"swupdSkipFormatCheck,omitempty,flow"`
+	Version                    uint                             `yaml:"version,omitempty,flow"`
+	StorageAlias               []*StorageAlias                  `yaml:"block-devices,omitempty,flow"`
+	CopyNetwork                bool                             `yaml:"copyNetwork,omitempty,flow"`
+	Resolved                   *network.Resolved                `yaml:"resolved,omitempty,flow"`
+	CopySwupd                  bool                             `yaml:"copySwupd,omitempty,flow"`
+	Environment                map[string]string                `yaml:"env,omitempty,flow"`
+	CryptPass                  string                           `yaml:"-"`
+	MakeISO                    bool                             `yaml:"iso,omitempty,flow"`
+	ISOPublisher               string                           `yaml:"isoPublisher,omitempty,flow"`
+	ISOApplicationID           string                           `yaml:"isoApplicationId,omitempty,flow"`
+	ISOCompression             string                           `yaml:"isoCompression,omitempty,flow"`
+	ISOBundles                 []string                         `yaml:"isoBundles,omitempty,flow"`
+	KeepImage                  bool                             `yaml:"keepImage,omitempty,flow"`
+	LockFile                   string                           `yaml:"-"`
+	ClearCfFile                string                           `yaml:"-"`
+	RootfsOnly                 bool                             `yaml:"-"`
+	PreCheckDone               bool                             `yaml:"preCheckDone,omitempty,flow"`
+	PreInstallValidationScript string                           `yaml:"preInstallValidationScript,omitempty,flow"`
+	TimezoneGeolocation        bool                             `yaml:"timezoneGeolocation,omitempty,flow"`
+	TimezoneGeolocationURL     string                           `yaml:"timezoneGeolocationURL,omitempty,flow"`
+	SystemRequirements         *syscheck.Requirements           `yaml:"systemRequirements,omitempty,flow"`
+	SmartCheck                 string                           `yaml:"smartCheck,omitempty,flow"`
+	PostInstallVerify          bool                             `yaml:"postInstallVerify,omitempty,flow"`
+	PostInstallVerifyWarn      bool                             `yaml:"postInstallVerifyWarn,omitempty,flow"`
+	WriteFiles                 []*files.WriteFile               `yaml:"writeFiles,omitempty,flow"`
+	RecordPartitionLayout      bool                             `yaml:"recordPartitionLayout,omitempty,flow"`
+	PreInstallLayout           []*storage.DiskLayout            `yaml:"preInstallLayout,omitempty,flow"`
+	MediaOpts                  storage.MediaOpts                `yaml:",inline"`
 }
 
 // SystemUsage is used to include additional information into the telemetry payload
@@ -107,6 +159,12 @@ type InstallHook struct {
 	Cmd    string `yaml:"cmd,omitempty,flow"`
 }
 
+// SecureBoot is the Machine Owner Key enrolled for the shim of the target
+type SecureBoot struct {
+	MOKCert         string `yaml:"mokCert,omitempty,flow"`
+	MOKPasswordFile string `yaml:"mokPasswordFile,omitempty,flow"`
+}
+
 // StorageAlias is used to expand variables in the targetMedia definitions
 // a partition's block device name attribute could be declared in the form of:
 //
@@ -122,6 +180,12 @@ type StorageAlias struct {
 	Name       string `yaml:"name,omitempty,flow"`
 	File       string `yaml:"file,omitempty,flow"`
 	DeviceFile bool   `yaml:"devicefile,omitempty,flow"`
+	Format     string `yaml:"format,omitempty,flow"`
+}
+
+// ImageFormat returns the format of the image file created for the alias
+func (sa *StorageAlias) ImageFormat() string {
+	return storage.ImageFormat(sa.File, sa.Format)
 }
 
 // InitializeDefaults ensure defaults are set such
@@ -164,7 +228,7 @@ func (si *SystemInstall) AddExtraKernelArguments(args []string) {
 	}
 
 	for _, curr := range args {
-		if utils.StringSliceContains(si.KernelArguments.Add, curr) {
+		if curr == "" || utils.StringSliceContains(si.KernelArguments.Add, curr) {
 			continue
 		}
 
@@ -190,7 +254,7 @@ func (si *SystemInstall) RemoveKernelArguments(args []string) {
 	}
 
 	for _, curr := range args {
-		if utils.StringSliceContains(si.KernelArguments.Remove, curr) {
+		if curr == "" || utils.StringSliceContains(si.KernelArguments.Remove, curr) {
 			continue
 		}
 
@@ -300,6 +364,138 @@ func (si *SystemInstall) OverrideBundles(overrideBundles []string) {
 	}
 }
 
+// BuildPlan returns the structured installation plan for the selected install
+// targets, it allows embedding tools to present the planned changes in their own UI
+func BuildPlan(md *SystemInstall) *storage.InstallPlan {
+	plan := storage.NewInstallPlan(md.InstallSelected, md.TargetMedias, md.MediaOpts)
+
+	state := secureBootState()
+	plan.TargetResults = append(plan.TargetResults, utils.Locale.Get("Secure Boot: %s", state))
+	plan.Warnings = append(plan.Warnings, md.SecureBootWarnings(state)...)
+	plan.Warnings = append(plan.Warnings, md.LanguageWarnings()...)
+	plan.Warnings = append(plan.Warnings, md.ZswapWarnings()...)
+
+	return plan
+}
+
+// zramConfigured returns true if a zram swap is configured by the extra
+// kernel arguments or a zram-generator configuration file
+func (si *SystemInstall) zramConfigured() bool {
+	if si.KernelArguments != nil {
+		for _, curr := range si.KernelArguments.Add {
+			if strings.HasPrefix(curr, "zram.") || strings.HasPrefix(curr, "systemd.zram") {
+				return true
+			}
+		}
+	}
+
+	for _, curr := range si.WriteFiles {
+		if curr != nil && strings.Contains(curr.Path, "zram-generator") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ZswapWarnings warns when zswap is enabled together with a zram swap, zswap
+// would compress the pages already going to a compressed zram device
+func (si *SystemInstall) ZswapWarnings() []string {
+	results := []string{}
+
+	if si.Zswap != nil && si.Zswap.Enabled && si.zramConfigured() {
+		results = append(results, utils.Locale.Get("zswap is enabled together with a zram swap, "+
+			"pages would be compressed twice; consider enabling only one of them"))
+	}
+
+	return results
+}
+
+// MOKEnrollment returns true if a Machine Owner Key is enrolled for the target
+func (si *SystemInstall) MOKEnrollment() bool {
+	return si.SecureBoot != nil && si.SecureBoot.MOKCert != ""
+}
+
+// SecureBootWarnings warns when the firmware enforces Secure Boot, the
+// installed boot loader is not signed and would not boot; enrolling a Machine
+// Owner Key does not sign it
+func (si *SystemInstall) SecureBootWarnings(state string) []string {
+	results := []string{}
+
+	if state != syscheck.SecureBootEnabled {
+		return results
+	}
+
+	if si.MOKEnrollment() {
+		results = append(results, utils.Locale.Get("Secure Boot is enabled, the installed boot loader is "+
+			"not signed; it will not boot unless it is signed with the enrolled Machine Owner Key or "+
+			"Secure Boot is disabled"))
+	} else {
+		results = append(results, utils.Locale.Get("Secure Boot is enabled, the installed boot loader is "+
+			"not signed and will not boot unless Secure Boot is disabled or a signing key is enrolled"))
+	}
+
+	return results
+}
+
+// LanguageWarnings returns a warning for each bundle the language needs to be
+// rendered which is not part of the bundle list; these do not block the install
+func (si *SystemInstall) LanguageWarnings() []string {
+	results := []string{}
+
+	if si.Language == nil {
+		return results
+	}
+
+	hasBundle := func(bundle string) bool {
+		return si.ContainsBundle(bundle) || si.ContainsUserBundle(bundle)
+	}
+
+	for _, bundle := range si.Language.MissingBundles(hasBundle) {
+		results = append(results, utils.Locale.Get("Language %s requires the bundle %s, consider adding it",
+			si.Language.Code, bundle))
+	}
+
+	return results
+}
+
+// ValidateSwupdMaxParallel checks the number of parallel swupd downloads, 0
+// uses the swupd default
+func (si *SystemInstall) ValidateSwupdMaxParallel() error {
+	if si.SwupdMaxParallel > MaxSwupdParallel {
+		return errors.ValidationErrorf("swupdMaxParallel must be between 1 and %d", MaxSwupdParallel)
+	}
+
+	return nil
+}
+
+// ValidateDeferredBundles checks the bundles deferred to the first boot, they
+// must not be needed to boot the target and must not be installed right away
+func (si *SystemInstall) ValidateDeferredBundles() error {
+	seen := map[string]bool{}
+
+	for _, bundle := range si.DeferredBundles {
+		if bundle == "" || strings.ContainsAny(bundle, ", \t") {
+			return errors.ValidationErrorf("Invalid deferred bundle name %q", bundle)
+		}
+
+		if seen[bundle] {
+			return errors.ValidationErrorf("Deferred bundle %s is listed more than once", bundle)
+		}
+		seen[bundle] = true
+
+		if strings.HasPrefix(bundle, "os-core") || strings.HasPrefix(bundle, "kernel-") {
+			return errors.ValidationErrorf("Bundle %s is required to boot and can not be deferred", bundle)
+		}
+
+		if si.ContainsBundle(bundle) || si.ContainsUserBundle(bundle) {
+			return errors.ValidationErrorf("Bundle %s can not be both installed and deferred", bundle)
+		}
+	}
+
+	return nil
+}
+
 // IsTargetDesktopInstall determines if this installation is a Desktop
 // installation by check all bundle lists for any desktop bundles.
 func (si *SystemInstall) IsTargetDesktopInstall() bool {
@@ -342,6 +538,18 @@ func (si *SystemInstall) AddUser(usr *user.User) {
 	si.Users = append(si.Users, usr)
 }
 
+// HasAdminUser returns true if a user other than root is granted the admin
+// (sudo) privileges, i.e. the system can still be administered with a locked root
+func (si *SystemInstall) HasAdminUser() bool {
+	for _, usr := range si.Users {
+		if usr.Admin && usr.Login != "root" {
+			return true
+		}
+	}
+
+	return false
+}
+
 // EncryptionRequiresPassphrase checks all partition to see if encryption was enabled
 func (si *SystemInstall) EncryptionRequiresPassphrase(isAdvanced bool) bool {
 	enabled := false
@@ -361,18 +569,24 @@ func (si *SystemInstall) Validate() error {
 		return errors.ValidationErrorf("model is nil")
 	}
 
-	if si.TargetMedias == nil || len(si.TargetMedias) == 0 {
-		return errors.ValidationErrorf("System Installation must provide a target media")
-	}
-
-	var results []string
-	if si.IsTargetDesktopInstall() {
-		results = storage.DesktopValidatePartitions(si.TargetMedias, si.MediaOpts)
+	if si.RootfsOnly {
+		if err := si.validateRootfsOnly(); err != nil {
+			return err
+		}
 	} else {
-		results = storage.ServerValidatePartitions(si.TargetMedias, si.MediaOpts)
-	}
-	if len(results) > 0 && !si.MediaOpts.SkipValidationAll {
-		return errors.ValidationErrorf(strings.Join(results, ", "))
+		if si.TargetMedias == nil || len(si.TargetMedias) == 0 {
+			return errors.ValidationErrorf("System Installation must provide a target media")
+		}
+
+		var results []string
+		if si.IsTargetDesktopInstall() {
+			results = storage.DesktopValidatePartitions(si.TargetMedias, si.MediaOpts)
+		} else {
+			results = storage.ServerValidatePartitions(si.TargetMedias, si.MediaOpts)
+		}
+		if len(results) > 0 && !si.MediaOpts.SkipValidationAll {
+			return errors.ValidationErrorf(strings.Join(results, ", "))
+		}
 	}
 
 	if si.Timezone == nil {
@@ -387,6 +601,16 @@ func (si *SystemInstall) Validate() error {
 		return errors.ValidationErrorf("System Language not set")
 	}
 
+	if si.Hostname != "" {
+		if msg := hostname.IsValidHostname(si.Hostname); msg != "" {
+			return errors.ValidationErrorf("Invalid hostname %q: %s", si.Hostname, msg)
+		}
+	}
+
+	if err := hostname.ValidateMachineIDPolicy(si.MachineIDPolicy); err != nil {
+		return err
+	}
+
 	if si.Telemetry == nil {
 		return errors.ValidationErrorf("Telemetry not acknowledged")
 	}
@@ -395,6 +619,128 @@ func (si *SystemInstall) Validate() error {
 		return errors.ValidationErrorf("A kernel must be provided")
 	}
 
+	if err := storage.ValidateExcludeDevices(si.MediaOpts.ExcludeDevices); err != nil {
+		return err
+	}
+
+	if err := storage.ValidateLabelPolicy(si.MediaOpts.LabelPolicy); err != nil {
+		return err
+	}
+
+	if err := storage.ValidateTrim(si.MediaOpts.Trim); err != nil {
+		return err
+	}
+
+	if err := storage.ValidateAtimeMode(si.MediaOpts.AtimeMode); err != nil {
+		return err
+	}
+
+	if err := storage.ValidatePassphraseFiles(si.TargetMedias); err != nil {
+		return err
+	}
+
+	for _, alias := range si.StorageAlias {
+		if alias.DeviceFile {
+			continue
+		}
+
+		if err := storage.ValidateImageFormat(alias.ImageFormat()); err != nil {
+			return err
+		}
+	}
+
+	if err := storage.ValidateBootloader(si.MediaOpts); err != nil {
+		return err
+	}
+
+	if err := storage.ValidatePartitionTable(si.TargetMedias, si.MediaOpts); err != nil {
+		return err
+	}
+
+	if err := storage.ValidatePartitionAttributes(si.TargetMedias, si.MediaOpts); err != nil {
+		return err
+	}
+
+	if err := storage.ValidateReadOnlyRoot(si.TargetMedias, si.MediaOpts); err != nil {
+		return err
+	}
+
+	if err := storage.ValidateGrowRoot(si.TargetMedias, si.MediaOpts); err != nil {
+		return err
+	}
+
+	if si.MediaOpts.ReadOnlyRoot && si.AutoUpdate.Value() {
+		return errors.ValidationErrorf("readOnlyRoot requires autoUpdate false, swupd can not update a read-only root")
+	}
+
+	if si.MediaOpts.DeterministicGUIDs {
+		if err := storage.ValidateGUIDSeed(si.MediaOpts.GUIDSeed); err != nil {
+			return err
+		}
+	}
+
+	if si.KernelArguments != nil {
+		if err := si.KernelArguments.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := si.ModuleSigEnforceArgument(); err != nil {
+		return err
+	}
+
+	if si.Zswap != nil {
+		if err := si.Zswap.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := si.ValidateDeferredBundles(); err != nil {
+		return err
+	}
+
+	if err := services.ValidateUnits("disableServices", si.DisableServices); err != nil {
+		return err
+	}
+
+	if err := services.ValidateUnits("maskServices", si.MaskServices); err != nil {
+		return err
+	}
+
+	if err := services.ValidateDefaultTarget(si.DefaultTarget); err != nil {
+		return err
+	}
+
+	if si.Resolved != nil {
+		if err := si.Resolved.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := syscheck.ValidateSmartCheck(si.SmartCheck); err != nil {
+		return err
+	}
+
+	if si.SystemRequirements != nil {
+		if err := si.SystemRequirements.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if si.SecureBoot != nil {
+		if err := syscheck.ValidateMOK(si.SecureBoot.MOKCert, si.SecureBoot.MOKPasswordFile); err != nil {
+			return err
+		}
+	}
+
+	if err := files.Validate(si.WriteFiles); err != nil {
+		return err
+	}
+
+	if si.LockRoot && !si.HasAdminUser() {
+		return errors.ValidationErrorf("lockRoot requires an admin (sudo) user other than root")
+	}
+
 	if len(si.ISOPublisher) > 128 {
 		return errors.ValidationErrorf("isoPublisher must be shorter than 128 characters")
 	}
@@ -403,6 +749,67 @@ func (si *SystemInstall) Validate() error {
 		return errors.ValidationErrorf("isoApplicationId must be shorter than 128 characters")
 	}
 
+	if err := si.ValidateSwupdMaxParallel(); err != nil {
+		return err
+	}
+
+	if err := si.ValidateISOLiveImage(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateISOLiveImage checks the squashfs compression and the bundles of the
+// live image of an ISO, the live image is the installed system when unset
+func (si *SystemInstall) ValidateISOLiveImage() error {
+	if si.ISOCompression != "" && !utils.StringSliceContains(ISOCompressions, si.ISOCompression) {
+		return errors.ValidationErrorf("Invalid isoCompression %q, must be one of: %s",
+			si.ISOCompression, strings.Join(ISOCompressions, ", "))
+	}
+
+	seen := map[string]bool{}
+
+	for _, bundle := range si.ISOBundles {
+		if bundle == "" || strings.ContainsAny(bundle, ", \t") {
+			return errors.ValidationErrorf("Invalid iso bundle name %q", bundle)
+		}
+
+		if seen[bundle] {
+			return errors.ValidationErrorf("ISO bundle %s is listed more than once", bundle)
+		}
+		seen[bundle] = true
+
+		// The live image boots the kernel of the installed system
+		if strings.HasPrefix(bundle, "kernel-") {
+			return errors.ValidationErrorf("ISO bundle %s can not be set, the live image uses the installed kernel",
+				bundle)
+		}
+	}
+
+	return nil
+}
+
+// validateRootfsOnly makes sure no media nor boot setting is configured when
+// the content is only installed into a directory, the other storage options
+// are ignored
+func (si *SystemInstall) validateRootfsOnly() error {
+	if len(si.TargetMedias) > 0 {
+		return errors.ValidationErrorf("targetMedia can not be used when only installing the rootfs into a directory")
+	}
+
+	if len(si.StorageAlias) > 0 {
+		return errors.ValidationErrorf("block-devices can not be used when only installing the rootfs into a directory")
+	}
+
+	if si.MakeISO {
+		return errors.ValidationErrorf("iso can not be used when only installing the rootfs into a directory")
+	}
+
+	if si.MOKEnrollment() {
+		return errors.ValidationErrorf("secureBoot can not be used when only installing the rootfs into a directory")
+	}
+
 	return nil
 }
 
@@ -433,6 +840,66 @@ func (si *SystemInstall) AddNetworkInterface(iface *network.Interface) {
 	si.NetworkInterfaces = append(si.NetworkInterfaces, iface)
 }
 
+// ModuleSigEnforceArgument returns the kernel argument matching the configured
+// module signature enforcement state, an empty string means the kernel default is kept
+func (si *SystemInstall) ModuleSigEnforceArgument() (string, error) {
+	switch si.ModuleSigEnforce {
+	case "":
+		return "", nil
+	case ModuleSigEnforceEnabled:
+		return moduleSigEnforceArg + "=1", nil
+	case ModuleSigEnforceDisabled:
+		return moduleSigEnforceArg + "=0", nil
+	}
+
+	return "", errors.ValidationErrorf("Invalid moduleSigEnforce value %q, expected %q or %q",
+		si.ModuleSigEnforce, ModuleSigEnforceEnabled, ModuleSigEnforceDisabled)
+}
+
+// AddModuleSigEnforceArgument adds the module signature enforcement kernel argument
+// replacing any module.sig_enforce value previously set in the extra kernel arguments
+func (si *SystemInstall) AddModuleSigEnforceArgument() error {
+	arg, err := si.ModuleSigEnforceArgument()
+	if err != nil || arg == "" {
+		return err
+	}
+
+	if si.KernelArguments != nil {
+		add := []string{}
+		for _, curr := range si.KernelArguments.Add {
+			if !strings.HasPrefix(curr, moduleSigEnforceArg+"=") {
+				add = append(add, curr)
+			}
+		}
+		si.KernelArguments.Add = add
+	}
+
+	si.AddExtraKernelArguments([]string{arg})
+
+	return nil
+}
+
+// AddZswapArguments adds the kernel arguments of the configured zswap,
+// replacing any zswap value previously set in the extra kernel arguments
+func (si *SystemInstall) AddZswapArguments() {
+	args := si.Zswap.Arguments()
+	if len(args) == 0 {
+		return
+	}
+
+	if si.KernelArguments != nil {
+		add := []string{}
+		for _, curr := range si.KernelArguments.Add {
+			if !strings.HasPrefix(curr, kernel.ZswapArgumentPrefix) {
+				add = append(add, curr)
+			}
+		}
+		si.KernelArguments.Add = add
+	}
+
+	si.AddExtraKernelArguments(args)
+}
+
 // LoadFile loads a model from a yaml file pointed by path
 func LoadFile(path string, options args.Args) (*SystemInstall, error) {
 	var result SystemInstall
@@ -449,11 +916,38 @@ func LoadFile(path string, options args.Args) (*SystemInstall, error) {
 		}
 	}
 
+	if result.Hostname != "" {
+		if msg := hostname.IsValidHostname(result.Hostname); msg != "" {
+			return nil, errors.ValidationErrorf("Invalid hostname %q: %s", result.Hostname, msg)
+		}
+	}
+
+	for _, usr := range result.Users {
+		for _, key := range usr.SSHKeys {
+			if user.IsSSHKeyFile(key) {
+				if _, err := user.ReadSSHKeyFile(key); err != nil {
+					return nil, errors.ValidationErrorf("Invalid SSH key file for user %q: %v", usr.Login, err)
+				}
+				continue
+			}
+
+			if ok, msg := user.IsValidSSHKey(key); !ok {
+				return nil, errors.ValidationErrorf("Invalid SSH key for user %q: %s", usr.Login, msg)
+			}
+		}
+
+		for _, group := range usr.Groups {
+			if ok, msg := user.IsValidGroup(group); !ok {
+				return nil, errors.ValidationErrorf("Invalid group %q for user %q: %s", group, usr.Login, msg)
+			}
+		}
+	}
+
 	result.InitializeDefaults()
 
 	// Set default Timezone if not defined
 	if result.Timezone == nil {
-		result.Timezone = &timezone.TimeZone{Code: timezone.DefaultTimezone}
+		result.Timezone = timezone.NewDefault()
 	}
 
 	// Set default Keyboard if not defined
@@ -585,6 +1079,24 @@ func (si *SystemInstall) IsTelemetryEnabled() bool {
 	return si.Telemetry.Enabled
 }
 
+// ApplyTelemetryChoice carries a disabled telemetry into the installed
+// system: the telemetry bundle is not installed and its units are added to
+// the MaskServices, unless already listed, in case a bundle pulls it in
+func (si *SystemInstall) ApplyTelemetryChoice() {
+	if si.Telemetry == nil || si.Telemetry.Enabled {
+		return
+	}
+
+	si.RemoveBundle(telemetry.RequiredBundle)
+	si.RemoveUserBundle(telemetry.RequiredBundle)
+
+	for _, unit := range telemetry.Units {
+		if !utils.StringSliceContains(si.MaskServices, unit) {
+			si.MaskServices = append(si.MaskServices, unit)
+		}
+	}
+}
+
 // IsTelemetryInstalled return true if telemetry tooling is present, false otherwise
 func (si *SystemInstall) IsTelemetryInstalled() bool {
 	return si.Telemetry.Installed("")
@@ -654,6 +1166,48 @@ func (si *SystemInstall) WriteFile(path string) error {
 	return nil
 }
 
+// MarshalJSONConfig returns the model as JSON using the same keys, and
+// omitting the same items, as the YAML configuration file
+func (si *SystemInstall) MarshalJSONConfig() ([]byte, error) {
+	var config interface{}
+
+	confBytes, err := yaml.Marshal(si)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if err = yaml.Unmarshal(confBytes, &config); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	b, err := json.Marshal(jsonCompatible(config))
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return b, nil
+}
+
+// jsonCompatible converts the map[interface{}]interface{} produced by the
+// yaml decoder into map[string]interface{} which can be encoded as JSON
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[string]interface{}{}
+		for key, val := range v {
+			result[fmt.Sprintf("%v", key)] = jsonCompatible(val)
+		}
+		return result
+	case []interface{}:
+		for idx, val := range v {
+			v[idx] = jsonCompatible(val)
+		}
+		return v
+	}
+
+	return value
+}
+
 // WriteScrubModelTargetMedias writes out a copy the model with the
 // TargetMedias removed to a temporary file
 func (si *SystemInstall) WriteScrubModelTargetMedias() (string, error) {
@@ -706,7 +1260,7 @@ func (si *SystemInstall) InteractiveOptionsValid() error {
 // SetDefaultSwapFileSize defines the swapfile sized based on
 // the storage default swapfile size
 func (si *SystemInstall) SetDefaultSwapFileSize() {
-	if si.MediaOpts.SwapFileSize == "" {
+	if si.MediaOpts.SwapFileSize == "" && !si.MediaOpts.NoSwap {
 		si.MediaOpts.SwapFileSize, _ = storage.HumanReadableSizeXiBWithPrecision(storage.SwapFileSizeDefault, 1)
 	}
 }
@@ -12,11 +12,14 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
+	"gopkg.in/yaml.v2"
+
 	"github.com/clearlinux/clr-installer/errors"
-	"github.com/clearlinux/clr-installer/kernel"
 	"github.com/clearlinux/clr-installer/keyboard"
 	"github.com/clearlinux/clr-installer/language"
 	"github.com/clearlinux/clr-installer/log"
@@ -27,6 +30,50 @@ import (
 	"github.com/clearlinux/clr-installer/utils"
 )
 
+var (
+	// partitionNumberExp matches the partition number ending a partition name
+	partitionNumberExp = regexp.MustCompile(`([0-9]+)$`)
+
+	// isterSettings are the top level settings the ister config has an
+	// equivalent for, the other ones are dropped by MarshalIsterConfig
+	isterSettings = map[string]bool{
+		"targetMedia":       true,
+		"block-devices":     true,
+		"bundles":           true,
+		"kernel":            true,
+		"users":             true,
+		"kernel-arguments":  true,
+		"networkInterfaces": true,
+		"httpsProxy":        true,
+		"swupdMirror":       true,
+		"hostname":          true,
+		"version":           true,
+		"post-install":      true,
+		"legacyBios":        true,
+	}
+
+	// isterDefaults are the settings JSONtoYAMLConfig always sets, only
+	// their default value survives the ister config
+	isterDefaults = map[string]string{
+		"keyboard":   keyboard.DefaultKeyboard,
+		"language":   language.DefaultLanguage,
+		"telemetry":  "false",
+		"autoUpdate": "true",
+	}
+
+	// isterPartitionSettings are the partition settings of the ister config
+	isterPartitionSettings = map[string]bool{
+		"name":       true,
+		"size":       true,
+		"type":       true,
+		"fstype":     true,
+		"mountpoint": true,
+		"options":    true,
+		"rm":         true,
+		"ro":         true,
+	}
+)
+
 type UintString struct {
 	Number uint
 }
@@ -78,10 +125,10 @@ type PartitionMountPoint struct {
 // User describes the user  details
 type User struct {
 	Username string      `json:"username"`
-	Key      string      `json:"key"`
-	UID      json.Number `json:"uid"`
+	Key      string      `json:"key,omitempty"`
+	UID      json.Number `json:"uid,omitempty"`
 	Sudo     bool        `json:"sudo"`
-	Password string      `json:"password"`
+	Password string      `json:"password,omitempty"`
 }
 
 // Network describes the network interface  details
@@ -235,16 +282,22 @@ func JSONtoYAMLConfig(cf string) (*SystemInstall, error) {
 		u.Login = string(curr.UID)
 		u.UserName = curr.Username
 		u.Admin = curr.Sudo
-		u.SSHKeys = append(u.SSHKeys, curr.Key)
+		// The key file path is relative to the ister config
+		if curr.Key != "" {
+			key := curr.Key
+			if !filepath.IsAbs(key) {
+				if key, err = filepath.Abs(filepath.Join(filepath.Dir(cf), key)); err != nil {
+					return nil, errors.Wrap(err)
+				}
+			}
+			u.SSHKeys = append(u.SSHKeys, key)
+		}
 		si.Users = append(si.Users, &u)
 	}
 
 	// Set KernelArguments
 	if ic.Cmdline != "" {
-		s := strings.Split(ic.Cmdline, " ")
-		ka := kernel.Arguments{}
-		ka.Add = s
-		si.KernelArguments = &ka
+		si.AddExtraKernelArguments(strings.Split(ic.Cmdline, " "))
 	}
 
 	// Set Hostname
@@ -314,29 +367,11 @@ func (si *SystemInstall) WriteYAMLConfig(cf string) (string, error) {
 		cf = strings.TrimSuffix(cf, filepath.Ext(cf)) + ".yaml"
 	}
 
-	info, err := os.Stat(cf)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// File does not exist, skip backup
-		} else {
-			return cf, errors.Wrap(err)
-		}
-	} else { // Make backup
-		mt := info.ModTime()
-		suffix := fmt.Sprintf("-%d-%02d-%02d-%02d%02d%02d",
-			mt.Year(), mt.Month(), mt.Day(),
-			mt.Hour(), mt.Minute(), mt.Second())
-		bf := strings.TrimSuffix(cf, filepath.Ext(cf)) + suffix + ".yaml"
-		err = os.Rename(cf, bf)
-		if err != nil {
-			return cf, errors.Wrap(err)
-		}
-		msg := fmt.Sprintf("Config file %s already exists. Making a backup: %s", cf, bf)
-		fmt.Println("WARNING: " + msg)
-		log.Warning(msg)
+	if err := backupConfigFile(cf); err != nil {
+		return cf, err
 	}
 
-	err = si.WriteFile(cf)
+	err := si.WriteFile(cf)
 	if err != nil {
 		return cf, errors.Wrap(err)
 	}
@@ -408,3 +443,340 @@ func (us *UintString) UnmarshalJSON(b []byte) error {
 
 	return nil
 }
+
+// WriteJSONConfig writes out the current model to an indented "ister" JSON
+// configuration file, the fields are in a fixed order so the output diffs cleanly.
+// If the config file ends in YAML, it renames it to JSON
+// If the file exists, it first makes a backup
+func (si *SystemInstall) WriteJSONConfig(cf string) (string, error) {
+	if ext := filepath.Ext(cf); ext == ".yaml" || ext == ".yml" {
+		cf = strings.TrimSuffix(cf, ext) + ".json"
+	}
+
+	b, err := si.MarshalIsterConfig()
+	if err != nil {
+		return cf, err
+	}
+
+	var out bytes.Buffer
+	if err = json.Indent(&out, b, "", "  "); err != nil {
+		return cf, errors.Wrap(err)
+	}
+	out.WriteString("\n")
+
+	if err = backupConfigFile(cf); err != nil {
+		return cf, err
+	}
+
+	if err = ioutil.WriteFile(cf, out.Bytes(), 0600); err != nil {
+		return cf, errors.Wrap(err)
+	}
+
+	msg := fmt.Sprint("Converted config file from YAML to JSON: " + cf)
+	fmt.Println(msg)
+	log.Info(msg)
+
+	return cf, nil
+}
+
+// backupConfigFile renames the existing config file cf after its modification time
+func backupConfigFile(cf string) error {
+	info, err := os.Stat(cf)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// File does not exist, skip backup
+			return nil
+		}
+		return errors.Wrap(err)
+	}
+
+	mt := info.ModTime()
+	suffix := fmt.Sprintf("-%d-%02d-%02d-%02d%02d%02d",
+		mt.Year(), mt.Month(), mt.Day(),
+		mt.Hour(), mt.Minute(), mt.Second())
+	bf := strings.TrimSuffix(cf, filepath.Ext(cf)) + suffix + filepath.Ext(cf)
+	if err = os.Rename(cf, bf); err != nil {
+		return errors.Wrap(err)
+	}
+
+	msg := fmt.Sprintf("Config file %s already exists. Making a backup: %s", cf, bf)
+	fmt.Println("WARNING: " + msg)
+	log.Warning(msg)
+
+	return nil
+}
+
+// MarshalJSON encodes a UintString, the latest version as "latest"
+func (us UintString) MarshalJSON() ([]byte, error) {
+	if us.Number == 0 {
+		return json.Marshal("latest")
+	}
+
+	return json.Marshal(us.Number)
+}
+
+// isterWarning reports a setting dropped from the ister config
+func isterWarning(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	fmt.Println("WARNING: " + msg)
+	log.Warning(msg)
+}
+
+// unsupportedSettings returns the sorted keys of the YAML encoding of value
+// which are not in supported
+func unsupportedSettings(value interface{}, supported map[string]bool) ([]string, map[string]interface{}, error) {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	fields := map[string]interface{}{}
+	if err = yaml.Unmarshal(data, &fields); err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	keys := []string{}
+	for key := range fields {
+		if !supported[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, fields, nil
+}
+
+// warnIsterDropped warns about every setting of the model the ister config
+// has no equivalent for, or only supports partially
+func (si *SystemInstall) warnIsterDropped() error {
+	keys, fields, err := unsupportedSettings(si, isterSettings)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if def, found := isterDefaults[key]; found && fmt.Sprint(fields[key]) == def {
+			continue
+		}
+		isterWarning("Skipping the %s setting, not supported in ister config", key)
+	}
+
+	for _, curr := range si.Users {
+		if _, err := strconv.ParseUint(curr.Login, 10, 32); err != nil && curr.Login != "" {
+			isterWarning("Skipping the login %q of user %q, ister only supports numeric uids", curr.Login, curr.UserName)
+		}
+
+		for idx, key := range curr.SSHKeys {
+			if idx > 0 || !user.IsSSHKeyFile(key) {
+				isterWarning("Skipping the SSH key %q of user %q, ister only supports one key file", key, curr.UserName)
+			}
+		}
+	}
+
+	if si.KernelArguments != nil && len(si.KernelArguments.Remove) > 0 {
+		isterWarning("Skipping the removed kernel arguments, not supported in ister config")
+	}
+
+	static := 0
+	for _, curr := range si.NetworkInterfaces {
+		if curr.DHCP || len(curr.Addrs) == 0 {
+			continue
+		}
+
+		if static++; static > 1 || len(curr.Addrs) > 1 {
+			isterWarning("Skipping the static addresses of %s, ister only supports one", curr.Name)
+		}
+	}
+
+	return nil
+}
+
+// MarshalIsterConfig returns the model as the "ister" JSON config read by
+// JSONtoYAMLConfig; the settings ister has no equivalent for are dropped
+// with a warning and the encrypted partitions are refused
+func (si *SystemInstall) MarshalIsterConfig() ([]byte, error) {
+	if err := si.warnIsterDropped(); err != nil {
+		return nil, err
+	}
+
+	ic := IsterConfig{
+		Version:    UintString{Number: si.Version},
+		Hostname:   si.Hostname,
+		LegacyBios: si.MediaOpts.LegacyBios,
+		HTTPSProxy: si.HTTPSProxy,
+		MirrorURL:  si.SwupdMirror,
+	}
+
+	if err := si.isterPartitions(&ic); err != nil {
+		return nil, err
+	}
+
+	if si.Kernel != nil && si.Kernel.Bundle != "" {
+		ic.Bundles = append(ic.Bundles, si.Kernel.Bundle)
+	}
+	ic.Bundles = append(ic.Bundles, si.Bundles...)
+
+	for _, curr := range si.Users {
+		u := &User{Username: curr.UserName, Sudo: curr.Admin, Password: curr.Password}
+		if _, err := strconv.ParseUint(curr.Login, 10, 32); err == nil {
+			u.UID = json.Number(curr.Login)
+		}
+		if len(curr.SSHKeys) > 0 {
+			u.Key = curr.SSHKeys[0]
+		}
+		ic.Users = append(ic.Users, u)
+	}
+
+	if si.KernelArguments != nil {
+		ic.Cmdline = strings.Join(si.KernelArguments.Add, " ")
+	}
+
+	for _, curr := range si.NetworkInterfaces {
+		if curr.DHCP || len(curr.Addrs) == 0 {
+			continue
+		}
+
+		ic.StaticIP = &Network{
+			Iface:   curr.Name,
+			Address: curr.Addrs[0].IP + "/" + curr.Addrs[0].NetMask,
+			Gateway: curr.Gateway,
+			DNS:     curr.DNSServer,
+		}
+		break
+	}
+
+	for _, curr := range si.PostInstall {
+		if curr.Chroot {
+			isterWarning("Skipping the chroot post install hook %q, not supported in ister config", curr.Cmd)
+			continue
+		}
+
+		if strings.HasPrefix(curr.Cmd, "/bin/bash -c ") {
+			ic.PostNonChrootShell = append(ic.PostNonChrootShell, strings.TrimPrefix(curr.Cmd, "/bin/bash -c "))
+		} else {
+			ic.PostNonChroot = append(ic.PostNonChroot, curr.Cmd)
+		}
+	}
+
+	b, err := json.Marshal(ic)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return b, nil
+}
+
+// isterPartitions sets the destination type and the partitions of ic from
+// the target medias, ordered by disk then partition number
+func (si *SystemInstall) isterPartitions(ic *IsterConfig) error {
+	alias := map[string]*StorageAlias{}
+	for _, curr := range si.StorageAlias {
+		alias["${"+curr.Name+"}"] = curr
+	}
+
+	for _, bd := range si.TargetMedias {
+		disk, destType := bd.Name, "physical"
+		if bd.Type == storage.BlockDeviceTypeLoop {
+			destType = "virtual"
+		}
+
+		if sa, found := alias[bd.Name]; found {
+			disk = strings.TrimPrefix(sa.File, "/dev/")
+			if disk == sa.File && !sa.DeviceFile {
+				destType = "virtual"
+			}
+		}
+
+		if ic.DestinationType != "" && ic.DestinationType != destType {
+			return errors.Errorf("ister config does not support both physical and virtual disks")
+		}
+		ic.DestinationType = destType
+
+		children := map[uint64]*storage.BlockDevice{}
+		numbers := []uint64{}
+
+		for _, ch := range bd.Children {
+			match := partitionNumberExp.FindString(ch.Name)
+			if match == "" {
+				return errors.Errorf("partition %q of disk %s has no number", ch.Name, disk)
+			}
+
+			number, err := strconv.ParseUint(match, 10, 64)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+
+			children[number] = ch
+			numbers = append(numbers, number)
+		}
+
+		sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+		for _, number := range numbers {
+			ch := children[number]
+
+			// ister would create the partition unencrypted
+			if ch.Type == storage.BlockDeviceTypeCrypt {
+				return errors.Errorf("partition %s is encrypted, not supported in ister config", ch.Name)
+			}
+
+			keys, _, err := unsupportedSettings(ch, isterPartitionSettings)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				isterWarning("Skipping the %s setting of partition %s, not supported in ister config", key, ch.Name)
+			}
+
+			ic.PartitionLayouts = append(ic.PartitionLayouts, &PartitionLayout{
+				Disk:      disk,
+				Partition: number,
+				Size:      isterSize(ch.Size),
+				Type:      isterPartitionType(ch),
+			})
+
+			if ch.FsType != "" {
+				ic.FilesystemTypes = append(ic.FilesystemTypes, &FilesystemType{
+					Disk: disk, Partition: number, Type: ch.FsType, Options: ch.Options,
+				})
+			}
+
+			if ch.MountPoint != "" {
+				ic.PartitionMountPoints = append(ic.PartitionMountPoints, &PartitionMountPoint{
+					Disk: disk, Partition: number, Mount: ch.MountPoint,
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+// isterSize returns the ister partition size of size bytes, 0 meaning the
+// rest of the disk
+func isterSize(size uint64) string {
+	if size == 0 {
+		return "rest"
+	}
+
+	for idx := len("KMGT"); idx > 0; idx-- {
+		unit := uint64(1) << (10 * uint(idx))
+		if size%unit == 0 {
+			return strconv.FormatUint(size/unit, 10) + string("KMGT"[idx-1])
+		}
+	}
+
+	return strconv.FormatUint(size, 10)
+}
+
+// isterPartitionType returns the ister partition type of bd
+func isterPartitionType(bd *storage.BlockDevice) string {
+	switch {
+	case bd.FsType == "vfat" && bd.MountPoint == "/boot":
+		return "EFI"
+	case bd.FsType == "swap":
+		return "swap"
+	}
+
+	return "linux"
+}
@@ -10,11 +10,20 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
+	"gopkg.in/yaml.v2"
+
 	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/kernel"
+	"github.com/clearlinux/clr-installer/language"
+	"github.com/clearlinux/clr-installer/services"
 	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/syscheck"
+	"github.com/clearlinux/clr-installer/telemetry"
+	"github.com/clearlinux/clr-installer/timezone"
 	"github.com/clearlinux/clr-installer/user"
 	"github.com/clearlinux/clr-installer/utils"
 )
@@ -663,6 +672,152 @@ func TestBackupFile(t *testing.T) {
 	}
 }
 
+func TestYAMLtoJSONConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-yaml-json-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	md, err := LoadFile(filepath.Join(testsDir, "real-example.yaml"), args.Args{})
+	if err != nil {
+		t.Fatalf("Failed to load the config: %v", err)
+	}
+
+	cf, err := md.WriteJSONConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil || filepath.Ext(cf) != ".json" {
+		t.Fatalf("Failed to write the JSON config %s: %v", cf, err)
+	}
+
+	first, err := ioutil.ReadFile(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(first), "\n  \"") {
+		t.Fatalf("The JSON config should be indented: %s", first)
+	}
+
+	// JSON -> YAML -> JSON, the JSON config is read back as an ister config
+	fromJSON, err := JSONtoYAMLConfig(cf)
+	if err != nil {
+		t.Fatalf("Failed to convert the JSON config: %v", err)
+	}
+
+	if fromJSON.Kernel == nil || fromJSON.Kernel.Bundle != md.Kernel.Bundle {
+		t.Fatalf("The kernel %+v should be kept", fromJSON.Kernel)
+	}
+
+	if strings.Join(fromJSON.Bundles, ",") != strings.Join(md.Bundles, ",") {
+		t.Fatalf("Expected the bundles %v, got %v", md.Bundles, fromJSON.Bundles)
+	}
+
+	if len(fromJSON.TargetMedias) != 1 || len(fromJSON.TargetMedias[0].Children) != 3 {
+		t.Fatalf("The target media should be kept: %+v", fromJSON.TargetMedias)
+	}
+
+	mounts := map[string]uint64{}
+	for _, ch := range fromJSON.TargetMedias[0].Children {
+		mounts[ch.FsType+":"+ch.MountPoint] = ch.Size
+	}
+
+	for _, ch := range md.TargetMedias[0].Children {
+		if size, found := mounts[ch.FsType+":"+ch.MountPoint]; !found || size != ch.Size {
+			t.Fatalf("The partition %s %s of size %d should be kept: %v", ch.FsType, ch.MountPoint, ch.Size, mounts)
+		}
+	}
+
+	yf := filepath.Join(dir, "roundtrip.yaml")
+	if _, err = fromJSON.WriteYAMLConfig(yf); err != nil {
+		t.Fatal(err)
+	}
+
+	if cf, err = fromJSON.WriteJSONConfig(yf); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := ioutil.ReadFile(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(first) != string(second) {
+		t.Fatalf("The JSON configs differ after a round trip:\n%s\n%s", first, second)
+	}
+}
+
+func TestIsterConfigRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-ister-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for _, curr := range []string{"valid-ister-full-physical.json", "valid-ister-full-virtual.json"} {
+		md, err := JSONtoYAMLConfig(filepath.Join(testsDir, curr))
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", curr, err)
+		}
+
+		first, err := md.MarshalIsterConfig()
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %v", curr, err)
+		}
+
+		cf := filepath.Join(dir, curr)
+		if err = ioutil.WriteFile(cf, first, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded, err := JSONtoYAMLConfig(cf)
+		if err != nil {
+			t.Fatalf("The ister config of %s should be readable: %v\n%s", curr, err, first)
+		}
+
+		second, err := reloaded.MarshalIsterConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(first) != string(second) {
+			t.Fatalf("The ister configs of %s differ after a round trip:\n%s\n%s", curr, first, second)
+		}
+
+		for _, field := range []string{`"DestinationType":`, `"PartitionLayout":`, `"Version":"latest"`,
+			`"Static_IP":`, `"cmdline":`, `"uid":1000`} {
+			if !strings.Contains(string(first), field) {
+				t.Fatalf("The ister config of %s should contain %s: %s", curr, field, first)
+			}
+		}
+	}
+}
+
+func TestIsterConfigUnsupported(t *testing.T) {
+	md, err := LoadFile(filepath.Join(testsDir, "real-example.yaml"), args.Args{})
+	if err != nil {
+		t.Fatalf("Failed to load the config: %v", err)
+	}
+
+	md.UserBundles = []string{"editors"}
+	md.Timezone = &timezone.TimeZone{Code: "Europe/Paris"}
+
+	keys, _, err := unsupportedSettings(md, isterSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"userBundles", "timezone"} {
+		if !utils.StringSliceContains(keys, key) {
+			t.Fatalf("The %s setting should be reported as unsupported: %v", key, keys)
+		}
+	}
+
+	md.TargetMedias[0].Children[0].Type = storage.BlockDeviceTypeCrypt
+	if _, err = md.MarshalIsterConfig(); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Fatalf("The encrypted partitions should be refused, got %v", err)
+	}
+}
+
 func TestWriteScrubModelTargetMedias(t *testing.T) {
 	si := &SystemInstall{}
 	yaml, err := si.WriteScrubModelTargetMedias()
@@ -839,3 +994,638 @@ func TestJSONUnmarshalIster(t *testing.T) {
 		t.Fatalf("Version 54321 should always be 54321, not %d", us.Version.Number)
 	}
 }
+
+func TestModuleSigEnforceArgument(t *testing.T) {
+	tests := []struct {
+		state string
+		arg   string
+		fail  bool
+	}{
+		{"", "", false},
+		{ModuleSigEnforceEnabled, "module.sig_enforce=1", false},
+		{ModuleSigEnforceDisabled, "module.sig_enforce=0", false},
+		{"invalid", "", true},
+	}
+
+	for _, curr := range tests {
+		si := &SystemInstall{ModuleSigEnforce: curr.state}
+
+		arg, err := si.ModuleSigEnforceArgument()
+		if curr.fail {
+			if err == nil {
+				t.Fatalf("ModuleSigEnforceArgument() should fail for state %q", curr.state)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("ModuleSigEnforceArgument() failed for state %q: %v", curr.state, err)
+		}
+
+		if arg != curr.arg {
+			t.Fatalf("ModuleSigEnforceArgument() returned %q for state %q, expected %q",
+				arg, curr.state, curr.arg)
+		}
+	}
+
+	si := &SystemInstall{ModuleSigEnforce: ModuleSigEnforceDisabled}
+	si.AddExtraKernelArguments([]string{"quiet", "module.sig_enforce=1"})
+
+	if err := si.AddModuleSigEnforceArgument(); err != nil {
+		t.Fatalf("AddModuleSigEnforceArgument() failed: %v", err)
+	}
+
+	if utils.StringSliceContains(si.KernelArguments.Add, "module.sig_enforce=1") ||
+		!utils.StringSliceContains(si.KernelArguments.Add, "module.sig_enforce=0") ||
+		!utils.StringSliceContains(si.KernelArguments.Add, "quiet") {
+		t.Fatalf("AddModuleSigEnforceArgument() produced unexpected arguments: %v", si.KernelArguments.Add)
+	}
+}
+
+func TestKernelArgumentsValidate(t *testing.T) {
+	tests := []struct {
+		arg   string
+		valid bool
+	}{
+		{"intel_iommu=on", true},
+		{"mitigations=off", true},
+		{"quiet", true},
+		{`dyndbg="file foo.c +p"`, false},
+		{`foo="bar`, false},
+		{"=on", false},
+		{"foo\tbar", false},
+	}
+
+	for _, curr := range tests {
+		si := &SystemInstall{KernelArguments: &kernel.Arguments{Add: []string{curr.arg}}}
+		err := si.KernelArguments.Validate()
+
+		if curr.valid && err != nil {
+			t.Fatalf("Kernel argument %q should be valid: %v", curr.arg, err)
+		} else if !curr.valid && err == nil {
+			t.Fatalf("Kernel argument %q should be invalid", curr.arg)
+		}
+	}
+
+	si := &SystemInstall{}
+	si.AddExtraKernelArguments([]string{"intel_iommu=on", "", "mitigations=off"})
+	si.RemoveKernelArguments([]string{"quiet"})
+
+	if len(si.KernelArguments.Add) != 2 {
+		t.Fatalf("Empty kernel arguments should be ignored: %v", si.KernelArguments.Add)
+	}
+
+	data, err := yaml.Marshal(si)
+	if err != nil {
+		t.Fatalf("Failed to marshal kernel arguments: %v", err)
+	}
+
+	loaded := &SystemInstall{}
+	if err = yaml.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("Failed to unmarshal kernel arguments: %v", err)
+	}
+
+	if loaded.KernelArguments == nil ||
+		strings.Join(loaded.KernelArguments.Add, " ") != "intel_iommu=on mitigations=off" ||
+		strings.Join(loaded.KernelArguments.Remove, " ") != "quiet" {
+		t.Fatalf("Kernel arguments did not round trip through YAML: %s", string(data))
+	}
+}
+
+func TestBuildPlan(t *testing.T) {
+	si := &SystemInstall{}
+	si.ClearInstallSelected()
+	si.MediaOpts.SwapFileSize = "64MiB"
+
+	bd := &storage.BlockDevice{Name: "sda", Type: storage.BlockDeviceTypeDisk, Size: 32212254720}
+	bd.AddChild(&storage.BlockDevice{Name: "sda1", FsType: "vfat", MountPoint: "/boot",
+		Type: storage.BlockDeviceTypePart, Size: 157286400, MakePartition: true, FormatPartition: true})
+	bd.AddChild(&storage.BlockDevice{Name: "sda2", FsType: "ext4", MountPoint: "/",
+		Type: storage.BlockDeviceTypePart, Size: 10737418240, MakePartition: true, FormatPartition: true})
+	bd.AddChild(&storage.BlockDevice{Name: "sda3", FsType: "ext4", MountPoint: "/home",
+		Type: storage.BlockDeviceTypePart, Size: 21317550080})
+	si.AddTargetMedia(bd)
+	si.InstallSelected[bd.Name] = storage.InstallTarget{Name: bd.Name}
+
+	plan := BuildPlan(si)
+
+	if len(plan.Targets) != 1 || plan.Targets[0].Name != "sda" {
+		t.Fatalf("Unexpected plan targets: %+v", plan.Targets)
+	}
+
+	expected := []storage.PlannedPartition{
+		{Name: "sda1", Disk: "sda", MountPoint: "/boot", FsType: "vfat", Size: 157286400, Create: true, Format: true},
+		{Name: "sda2", Disk: "sda", MountPoint: "/", FsType: "ext4", Size: 10737418240, Create: true, Format: true},
+		{Name: "sda3", Disk: "sda", MountPoint: "/home", FsType: "ext4", Size: 21317550080},
+	}
+
+	if len(plan.Partitions) != len(expected) {
+		t.Fatalf("Expected %d planned partitions, got %d", len(expected), len(plan.Partitions))
+	}
+
+	for i, curr := range expected {
+		if *plan.Partitions[i] != curr {
+			t.Fatalf("Planned partition %d: %+v doesn't match expected %+v", i, *plan.Partitions[i], curr)
+		}
+
+		if !utils.StringSliceContains(plan.TargetResults, plan.Partitions[i].String()) {
+			t.Fatalf("Dry run results %v are missing %q", plan.TargetResults, plan.Partitions[i].String())
+		}
+	}
+
+	if plan.PartitionsSize != 157286400+10737418240 {
+		t.Fatalf("Unexpected size of new partitions: %d", plan.PartitionsSize)
+	}
+
+	if plan.SwapFileSize != 67108864 {
+		t.Fatalf("Unexpected swapfile size: %d", plan.SwapFileSize)
+	}
+}
+
+func TestHostnameLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-hostname-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "hostname.yaml")
+
+	if err = ioutil.WriteFile(path, []byte("hostname: clear-host01\n"), 0644); err != nil {
+		t.Fatalf("Could not write config file: %v", err)
+	}
+
+	loaded, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("Valid hostname should load: %v", err)
+	}
+
+	if loaded.Hostname != "clear-host01" {
+		t.Fatalf("Hostname should be loaded, got: %q", loaded.Hostname)
+	}
+
+	data, err := yaml.Marshal(loaded)
+	if err != nil {
+		t.Fatalf("Failed to marshal hostname: %v", err)
+	}
+
+	if !strings.Contains(string(data), "hostname: clear-host01") {
+		t.Fatalf("Hostname did not round trip through YAML: %s", string(data))
+	}
+
+	for _, host := range []string{"-clear", "clear-", "clear_host", "clear.host"} {
+		if err = ioutil.WriteFile(path, []byte("hostname: "+host+"\n"), 0644); err != nil {
+			t.Fatalf("Could not write config file: %v", err)
+		}
+
+		if _, err = LoadFile(path, args.Args{}); err == nil {
+			t.Fatalf("Invalid hostname %q should be rejected", host)
+		}
+	}
+}
+
+func TestMarshalJSONConfig(t *testing.T) {
+	si := &SystemInstall{
+		Hostname:                   "clear-host01",
+		PreInstallValidationScript: "/usr/bin/policy",
+		CryptPass:                  "secret",
+	}
+
+	data, err := si.MarshalJSONConfig()
+	if err != nil {
+		t.Fatalf("Failed to marshal model as JSON: %v", err)
+	}
+
+	config := map[string]interface{}{}
+	if err = json.Unmarshal(data, &config); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", string(data), err)
+	}
+
+	if config["hostname"] != "clear-host01" {
+		t.Fatalf("Hostname should use the YAML key, got: %s", string(data))
+	}
+
+	if config["preInstallValidationScript"] != "/usr/bin/policy" {
+		t.Fatalf("Validation script should be included, got: %s", string(data))
+	}
+
+	if strings.Contains(string(data), "secret") {
+		t.Fatalf("Passphrase should never be exported: %s", string(data))
+	}
+}
+
+func TestTimezoneLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-timezone-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "timezone.yaml")
+
+	if err = ioutil.WriteFile(path, []byte("timezoneGeolocation: true\n"+
+		"timezoneGeolocationURL: https://geo.example.com/tz\n"), 0644); err != nil {
+		t.Fatalf("Could not write config file: %v", err)
+	}
+
+	loaded, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("Config should load: %v", err)
+	}
+
+	if !loaded.TimezoneGeolocation || !loaded.Timezone.IsDefaulted() {
+		t.Fatalf("Missing timezone should be defaulted: %+v", loaded.Timezone)
+	}
+
+	if loaded.TimezoneGeolocationURL != "https://geo.example.com/tz" {
+		t.Fatalf("The geolocation service should be configurable, got %q", loaded.TimezoneGeolocationURL)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("timezone: UTC\n"), 0644); err != nil {
+		t.Fatalf("Could not write config file: %v", err)
+	}
+
+	if loaded, err = LoadFile(path, args.Args{}); err != nil {
+		t.Fatalf("Config should load: %v", err)
+	}
+
+	if loaded.Timezone.Code != "UTC" || loaded.Timezone.IsDefaulted() {
+		t.Fatalf("Configured UTC timezone should not be defaulted: %+v", loaded.Timezone)
+	}
+}
+
+func TestValidateDeferredBundles(t *testing.T) {
+	si := &SystemInstall{
+		Bundles:         []string{"os-core-update", "editors"},
+		UserBundles:     []string{"dev-utils"},
+		DeferredBundles: []string{"c-basic", "python3-basic"},
+	}
+
+	if err := si.ValidateDeferredBundles(); err != nil {
+		t.Fatalf("Deferred bundles should be valid: %v", err)
+	}
+
+	invalid := [][]string{
+		{"c-basic", "c-basic"},
+		{"kernel-lts"},
+		{"os-core"},
+		{"editors"},
+		{"dev-utils"},
+		{"c-basic,python3-basic"},
+		{""},
+	}
+
+	for _, curr := range invalid {
+		si.DeferredBundles = curr
+		if err := si.ValidateDeferredBundles(); err == nil {
+			t.Fatalf("Deferred bundles %v should be invalid", curr)
+		}
+	}
+
+	si.DeferredBundles = []string{"c-basic"}
+	data, err := yaml.Marshal(si)
+	if err != nil {
+		t.Fatalf("Failed to marshal the model: %v", err)
+	}
+
+	if !strings.Contains(string(data), "deferredBundles: [c-basic]") {
+		t.Fatalf("Deferred bundles should be recorded in the config: %s", string(data))
+	}
+}
+
+func TestValidateISOLiveImage(t *testing.T) {
+	si := &SystemInstall{}
+
+	if err := si.ValidateISOLiveImage(); err != nil {
+		t.Fatalf("The default live image should be valid: %v", err)
+	}
+
+	si.ISOCompression = "zstd"
+	si.ISOBundles = []string{"os-core-update", "clr-installer"}
+	if err := si.ValidateISOLiveImage(); err != nil {
+		t.Fatalf("The live image should be valid: %v", err)
+	}
+
+	si.ISOCompression = "bzip2"
+	if err := si.ValidateISOLiveImage(); err == nil {
+		t.Fatal("mksquashfs does not support bzip2")
+	}
+
+	si.ISOCompression = ""
+	for _, curr := range [][]string{{"editors", "editors"}, {"kernel-lts"}, {""}, {"a b"}} {
+		si.ISOBundles = curr
+		if err := si.ValidateISOLiveImage(); err == nil {
+			t.Fatalf("ISO bundles %v should be invalid", curr)
+		}
+	}
+
+	si.ISOCompression = "xz"
+	si.ISOBundles = []string{"os-core-update"}
+	data, err := yaml.Marshal(si)
+	if err != nil {
+		t.Fatalf("Failed to marshal the model: %v", err)
+	}
+
+	for _, line := range []string{"isoCompression: xz", "isoBundles: [os-core-update]"} {
+		if !strings.Contains(string(data), line) {
+			t.Fatalf("%q should be recorded in the config: %s", line, string(data))
+		}
+	}
+}
+
+func TestBootloaderYAML(t *testing.T) {
+	si := &SystemInstall{}
+	if err := yaml.Unmarshal([]byte("bootloader: systemd-boot\n"), si); err != nil {
+		t.Fatalf("Failed to unmarshal the model: %v", err)
+	}
+
+	if si.MediaOpts.GetBootloader() != storage.BootloaderSystemdBoot {
+		t.Fatalf("Unexpected boot loader: %s", si.MediaOpts.GetBootloader())
+	}
+
+	data, err := yaml.Marshal(si)
+	if err != nil {
+		t.Fatalf("Failed to marshal the model: %v", err)
+	}
+
+	if !strings.Contains(string(data), "bootloader: systemd-boot") {
+		t.Fatalf("The boot loader should be kept in the config: %s", string(data))
+	}
+
+	if data, err = yaml.Marshal(&SystemInstall{}); err != nil || strings.Contains(string(data), "bootloader") {
+		t.Fatalf("The default boot loader should be omitted: %s", string(data))
+	}
+}
+
+func TestLoadFileSSHKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-sshkeys-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "sshkeys.yaml")
+
+	config := "users:\n- login: foobar\n  ssh-keys: [\"ssh-rsa xxxxxxxxxxxxxxxx\"]\n"
+	if err = ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("Could not write config file: %v", err)
+	}
+
+	if _, err = LoadFile(path, args.Args{}); err == nil || !strings.Contains(err.Error(), "Invalid SSH key") {
+		t.Fatalf("A malformed SSH key should fail to load: %v", err)
+	}
+}
+
+func TestLockRoot(t *testing.T) {
+	md, err := LoadFile(filepath.Join(testsDir, "basic-valid-descriptor.yaml"), args.Args{})
+	if err != nil {
+		t.Fatalf("Failed to load the test file: %v", err)
+	}
+	md.MediaOpts.SkipValidationSize = true
+	md.MediaOpts.SkipValidationAll = true
+
+	md.LockRoot = true
+	if err = md.Validate(); err == nil {
+		t.Fatal("Locking root without an admin user should fail")
+	}
+
+	md.AddUser(&user.User{Login: "root", Admin: true})
+	if err = md.Validate(); err == nil {
+		t.Fatal("Locking root with root as the only admin user should fail")
+	}
+
+	md.AddUser(&user.User{Login: "clrlinux", Admin: true})
+	if err = md.Validate(); err != nil {
+		t.Fatalf("Locking root with an admin user should be valid: %v", err)
+	}
+
+	data, err := yaml.Marshal(md)
+	if err != nil {
+		t.Fatalf("Failed to marshal the model: %v", err)
+	}
+
+	if !strings.Contains(string(data), "lockRoot: true") {
+		t.Fatalf("lockRoot should be recorded in the config: %s", string(data))
+	}
+}
+
+func TestApplyTelemetryChoice(t *testing.T) {
+	si := &SystemInstall{
+		Bundles:      []string{"os-core", telemetry.RequiredBundle},
+		UserBundles:  []string{telemetry.RequiredBundle},
+		MaskServices: []string{"telemd.socket"},
+	}
+
+	si.EnableTelemetry(true)
+	si.ApplyTelemetryChoice()
+
+	if !si.ContainsBundle(telemetry.RequiredBundle) || len(si.MaskServices) != 1 {
+		t.Fatalf("An enabled telemetry should be left untouched: %v %v", si.Bundles, si.MaskServices)
+	}
+
+	si.EnableTelemetry(false)
+	si.ApplyTelemetryChoice()
+
+	if si.ContainsBundle(telemetry.RequiredBundle) || si.ContainsUserBundle(telemetry.RequiredBundle) {
+		t.Fatalf("A disabled telemetry should not be installed: %v %v", si.Bundles, si.UserBundles)
+	}
+
+	if len(si.MaskServices) != len(telemetry.Units) || si.MaskServices[0] != "telemd.socket" {
+		t.Fatalf("Expected the telemetry units to be masked once, got %v", si.MaskServices)
+	}
+
+	if err := services.ValidateUnits("maskServices", si.MaskServices); err != nil {
+		t.Fatalf("The masked telemetry units should be valid: %v", err)
+	}
+
+	// Applying the choice again, i.e. from the recorded configuration, is a no-op
+	si.ApplyTelemetryChoice()
+	if len(si.MaskServices) != len(telemetry.Units) {
+		t.Fatalf("The telemetry units should not be duplicated, got %v", si.MaskServices)
+	}
+}
+
+func TestSwupdMaxParallel(t *testing.T) {
+	si := &SystemInstall{}
+
+	for _, curr := range []uint{0, 1, 16, MaxSwupdParallel} {
+		si.SwupdMaxParallel = curr
+		if err := si.ValidateSwupdMaxParallel(); err != nil {
+			t.Fatalf("%d parallel downloads should be valid: %v", curr, err)
+		}
+	}
+
+	si.SwupdMaxParallel = MaxSwupdParallel + 1
+	if err := si.ValidateSwupdMaxParallel(); err == nil {
+		t.Fatalf("%d parallel downloads should be invalid", si.SwupdMaxParallel)
+	}
+
+	si.SwupdMaxParallel = 16
+	data, err := yaml.Marshal(si)
+	if err != nil {
+		t.Fatalf("Failed to marshal the model: %v", err)
+	}
+
+	if !strings.Contains(string(data), "swupdMaxParallel: 16") {
+		t.Fatalf("swupdMaxParallel should be recorded in the config: %s", string(data))
+	}
+}
+
+func TestReadOnlyRoot(t *testing.T) {
+	md, err := LoadFile(filepath.Join(testsDir, "basic-valid-descriptor.yaml"), args.Args{})
+	if err != nil {
+		t.Fatalf("Failed to load the test file: %v", err)
+	}
+	md.MediaOpts.SkipValidationSize = true
+	md.MediaOpts.SkipValidationAll = true
+
+	md.MediaOpts.ReadOnlyRoot = true
+	md.AutoUpdate.SetValue(true)
+	if err = md.Validate(); err == nil {
+		t.Fatal("A read-only root with autoUpdate should fail")
+	}
+
+	md.AutoUpdate.SetValue(false)
+	if err = md.Validate(); err != nil {
+		t.Fatalf("A read-only root without autoUpdate should be valid: %v", err)
+	}
+}
+
+func TestLanguageWarnings(t *testing.T) {
+	savedState := secureBootState
+	secureBootState = func() string { return syscheck.SecureBootDisabled }
+	defer func() { secureBootState = savedState }()
+
+	si := &SystemInstall{Language: &language.Language{Code: "ja_JP.UTF-8"}}
+
+	warnings := si.LanguageWarnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "desktop-locales") {
+		t.Fatalf("Expected a desktop-locales warning, got %v", warnings)
+	}
+
+	plan := BuildPlan(si)
+	results := *plan.DryRun().TargetResults
+	if len(plan.Warnings) != 1 || len(results) == 0 || results[len(results)-1] != warnings[0] {
+		t.Fatalf("The language warning should be part of the plan: %v", results)
+	}
+
+	si.AddUserBundle("desktop-locales")
+	if warnings = si.LanguageWarnings(); len(warnings) != 0 {
+		t.Fatalf("No warning expected with the bundle, got %v", warnings)
+	}
+
+	si = &SystemInstall{Language: &language.Language{Code: language.DefaultLanguage}}
+	if warnings = si.LanguageWarnings(); len(warnings) != 0 {
+		t.Fatalf("No warning expected for %s, got %v", language.DefaultLanguage, warnings)
+	}
+}
+
+func TestSecureBootWarnings(t *testing.T) {
+	savedState := secureBootState
+	defer func() { secureBootState = savedState }()
+
+	si := &SystemInstall{}
+	for _, state := range []string{syscheck.SecureBootDisabled, syscheck.SecureBootUnsupported} {
+		if warnings := si.SecureBootWarnings(state); len(warnings) != 0 {
+			t.Fatalf("No warning expected with Secure Boot %s, got %v", state, warnings)
+		}
+	}
+
+	secureBootState = func() string { return syscheck.SecureBootEnabled }
+
+	plan := BuildPlan(si)
+	if len(plan.Warnings) != 1 || !strings.Contains(plan.Warnings[0], "Secure Boot is enabled") {
+		t.Fatalf("Expected a Secure Boot warning, got %v", plan.Warnings)
+	}
+
+	if !utils.StringSliceContains(plan.TargetResults, "Secure Boot: enabled") {
+		t.Fatalf("The Secure Boot state should be part of the plan: %v", plan.TargetResults)
+	}
+
+	dir, err := ioutil.TempDir("", "clr-installer-secure-boot-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	cert := filepath.Join(dir, "mok.der")
+	password := filepath.Join(dir, "mok.pass")
+	for _, file := range []string{cert, password} {
+		if err = ioutil.WriteFile(file, []byte("content\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Enrolling a MOK does not sign the boot loader
+	si.SecureBoot = &SecureBoot{MOKCert: cert, MOKPasswordFile: password}
+	warnings := si.SecureBootWarnings(syscheck.SecureBootEnabled)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "not signed") {
+		t.Fatalf("Expected an unsigned boot loader warning with a MOK enrollment, got %v", warnings)
+	}
+
+	if err = syscheck.ValidateMOK(cert, password); err != nil {
+		t.Fatalf("The MOK enrollment should be valid: %v", err)
+	}
+
+	for _, sb := range []SecureBoot{{MOKCert: cert}, {MOKPasswordFile: password},
+		{MOKCert: filepath.Join(dir, "missing"), MOKPasswordFile: password}} {
+		if err = syscheck.ValidateMOK(sb.MOKCert, sb.MOKPasswordFile); err == nil {
+			t.Fatalf("The MOK enrollment %+v should be invalid", sb)
+		}
+	}
+}
+
+func TestZswapArguments(t *testing.T) {
+	si := &SystemInstall{Zswap: &kernel.Zswap{Enabled: true, Compressor: "lz4"}}
+	si.AddExtraKernelArguments([]string{"quiet", "zswap.compressor=lzo"})
+
+	si.AddZswapArguments()
+
+	expected := []string{"quiet", "zswap.enabled=1", "zswap.compressor=lz4"}
+	if !reflect.DeepEqual(si.KernelArguments.Add, expected) {
+		t.Fatalf("Expected the kernel arguments %v, got %v", expected, si.KernelArguments.Add)
+	}
+
+	if warnings := si.ZswapWarnings(); len(warnings) != 0 {
+		t.Fatalf("No zswap warning expected without zram: %v", warnings)
+	}
+
+	si.AddExtraKernelArguments([]string{"zram.num_devices=1"})
+	if warnings := si.ZswapWarnings(); len(warnings) != 1 {
+		t.Fatalf("Expected a warning for zswap with a zram swap, got %v", warnings)
+	}
+
+	si.Zswap.Enabled = false
+	if warnings := si.ZswapWarnings(); len(warnings) != 0 {
+		t.Fatalf("No zswap warning expected with zswap disabled: %v", warnings)
+	}
+}
+
+func TestRootfsOnlyValidate(t *testing.T) {
+	path := filepath.Join(testsDir, "basic-valid-descriptor.yaml")
+	si, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("Failed to load a valid descriptor: %v", err)
+	}
+
+	si.RootfsOnly = true
+	if err = si.Validate(); err == nil {
+		t.Fatal("targetMedia should be refused when only installing the rootfs")
+	}
+
+	si.TargetMedias = nil
+	if err = si.Validate(); err != nil {
+		t.Fatalf("A rootfs only install should not require a target media: %v", err)
+	}
+
+	si.MakeISO = true
+	if err = si.Validate(); err == nil {
+		t.Fatal("iso should be refused when only installing the rootfs")
+	}
+
+	si.MakeISO = false
+	si.RootfsOnly = false
+	if err = si.Validate(); err == nil {
+		t.Fatal("A target media should be required unless only installing the rootfs")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+const (
+	// AddressFamilyAuto lets the resolver order decide, trying both families
+	AddressFamilyAuto = "auto"
+
+	// AddressFamilyIPv4 only connects to IPv4 addresses
+	AddressFamilyIPv4 = "ipv4"
+
+	// AddressFamilyIPv6 only connects to IPv6 addresses
+	AddressFamilyIPv6 = "ipv6"
+
+	// resolverNoAAAA is the glibc resolver option skipping the IPv6 lookups
+	resolverNoAAAA = "no-aaaa"
+)
+
+var (
+	// addressFamily is the address family the remote fetches connect with
+	addressFamily = AddressFamilyAuto
+
+	// familyNetworks maps the address families to their dial networks suffix
+	familyNetworks = map[string]string{
+		AddressFamilyAuto: "",
+		AddressFamilyIPv4: "4",
+		AddressFamilyIPv6: "6",
+	}
+
+	// dialTimeout and dialKeepAlive match the http.DefaultTransport dialer
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+)
+
+// SetAddressFamily sets the address family preference, auto, ipv4 or ipv6,
+// of the configuration and content fetches; an empty family selects auto
+func SetAddressFamily(family string) error {
+	family = strings.ToLower(family)
+	if family == "" {
+		family = AddressFamilyAuto
+	}
+
+	if _, found := familyNetworks[family]; !found {
+		return errors.Errorf("Invalid address family %q, must be %s, %s or %s", family,
+			AddressFamilyAuto, AddressFamilyIPv4, AddressFamilyIPv6)
+	}
+
+	if family != AddressFamilyAuto {
+		log.Info("Restricting the remote fetches to %s", family)
+	}
+
+	addressFamily = family
+
+	return setResolverOptions(family)
+}
+
+// setResolverOptions passes the address family to the environment of the
+// external tools, swupd has no option of its own so its glibc lookups are
+// restricted instead; glibc can skip the IPv6 addresses but has no
+// counterpart for the IPv4 ones
+func setResolverOptions(family string) error {
+	options := []string{}
+	for _, opt := range strings.Fields(os.Getenv("RES_OPTIONS")) {
+		if opt != resolverNoAAAA {
+			options = append(options, opt)
+		}
+	}
+
+	switch family {
+	case AddressFamilyIPv4:
+		options = append(options, resolverNoAAAA)
+	case AddressFamilyIPv6:
+		log.Warning("swupd can not be restricted to %s, it follows the host address configuration", family)
+	}
+
+	if len(options) == 0 {
+		return os.Unsetenv("RES_OPTIONS")
+	}
+
+	return os.Setenv("RES_OPTIONS", strings.Join(options, " "))
+}
+
+// GetAddressFamily returns the address family of the remote fetches
+func GetAddressFamily() string {
+	return addressFamily
+}
+
+// familyDialer restricts the tcp and udp connections to an address family
+type familyDialer struct {
+	net.Dialer
+	family string
+}
+
+// DialContext connects to address with the network of the dialer family,
+// i.e. tcp becomes tcp6 for ipv6; other networks are left untouched
+func (fd *familyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network == "tcp" || network == "udp" {
+		network += familyNetworks[fd.family]
+	}
+
+	return fd.Dialer.DialContext(ctx, network, address)
+}
+
+// newFamilyDialer returns the dialer of the address family
+func newFamilyDialer(family string) *familyDialer {
+	return &familyDialer{
+		Dialer: net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: dialKeepAlive,
+		},
+		family: family,
+	}
+}
+
+// NewTransport returns a http transport using the environment proxy and
+// connecting with the configured address family
+func NewTransport() *http.Transport {
+	return &http.Transport{
+		Proxy:       http.ProxyFromEnvironment,
+		DialContext: newFamilyDialer(addressFamily).DialContext,
+	}
+}
+
+// curlFamilyArgs returns the curl options of the configured address family
+func curlFamilyArgs() []string {
+	switch addressFamily {
+	case AddressFamilyIPv4:
+		return []string{"--ipv4"}
+	case AddressFamilyIPv6:
+		return []string{"--ipv6"}
+	}
+
+	return []string{}
+}
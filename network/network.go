@@ -6,6 +6,8 @@ package network
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -755,8 +757,9 @@ func CheckURL(url string) error {
 		"/dev/null",
 		"-s",
 		"-f",
-		url,
 	}
+	args = append(args, curlFamilyArgs()...)
+	args = append(args, url)
 
 	if err := cmd.Run(nil, args...); err != nil {
 		log.Debug("curl failed : %q", err)
@@ -766,6 +769,86 @@ func CheckURL(url string) error {
 	return nil
 }
 
+// CheckURLHead tests if the given URL exists with a HEAD request, the content
+// is not downloaded
+func CheckURLHead(url string) error {
+	args := []string{
+		"/usr/bin/timeout",
+		"--kill-after=10s",
+		"10s",
+		"/usr/bin/curl",
+		"--no-sessionid",
+		"--head",
+		"-o",
+		"/dev/null",
+		"-s",
+		"-f",
+	}
+	args = append(args, curlFamilyArgs()...)
+	args = append(args, url)
+
+	if err := cmd.Run(nil, args...); err != nil {
+		log.Debug("curl --head failed : %q", err)
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// URLContentLength returns the Content-Length the server reports for the
+// given URL with a HEAD request, the content is not downloaded
+func URLContentLength(url string) (uint64, error) {
+	args := []string{
+		"/usr/bin/timeout",
+		"--kill-after=10s",
+		"10s",
+		"/usr/bin/curl",
+		"--no-sessionid",
+		"--head",
+		"-L",
+		"-s",
+		"-f",
+	}
+	args = append(args, curlFamilyArgs()...)
+	args = append(args, url)
+
+	w := bytes.NewBuffer(nil)
+	if err := cmd.Run(w, args...); err != nil {
+		log.Debug("curl --head failed : %q", err)
+		return 0, errors.Wrap(err)
+	}
+
+	return parseContentLength(w.String())
+}
+
+// parseContentLength returns the Content-Length of the headers, the last one
+// when redirects were followed
+func parseContentLength(headers string) (uint64, error) {
+	found := false
+	var length uint64
+
+	for _, line := range strings.Split(headers, "\n") {
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 || !strings.EqualFold(strings.TrimSpace(fields[0]), "Content-Length") {
+			continue
+		}
+
+		value, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			return 0, errors.Errorf("Invalid Content-Length %q", strings.TrimSpace(fields[1]))
+		}
+
+		found = true
+		length = value
+	}
+
+	if !found {
+		return 0, errors.Errorf("No Content-Length returned")
+	}
+
+	return length, nil
+}
+
 // FetchRemoteConfigFile given an config url fetches it from the network. This function
 // currently supports only http/https protocol. After success return the local file path.
 func FetchRemoteConfigFile(url string) (string, error) {
@@ -790,8 +873,9 @@ func FetchRemoteConfigFile(url string) (string, error) {
 		out.Name(),
 		"-s",
 		"-f",
-		url,
 	}
+	args = append(args, curlFamilyArgs()...)
+	args = append(args, url)
 
 	if err := cmd.Run(nil, args...); err != nil {
 		log.Debug("FetchRemoteConfigFile failed : %q", err)
@@ -802,6 +886,27 @@ func FetchRemoteConfigFile(url string) (string, error) {
 	return out.Name(), nil
 }
 
+// VerifyConfigFileSHA256 checks the SHA-256 digest of the config file, as it
+// was downloaded, matches the expected hex encoded digest
+func VerifyConfigFileSHA256(file string, expected string) error {
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	if decoded, err := hex.DecodeString(expected); err != nil || len(decoded) != sha256.Size {
+		return errors.Errorf("Invalid SHA-256 digest %q", expected)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	sum := sha256.Sum256(content)
+	if digest := hex.EncodeToString(sum[:]); digest != expected {
+		return errors.Errorf("Configuration file SHA-256 mismatch: expected %s, got %s", expected, digest)
+	}
+
+	return nil
+}
+
 // DownloadInstallerMessage pulls down a message from a URL
 // Intended for getting a message to display before or after
 // the installation process
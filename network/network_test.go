@@ -5,9 +5,17 @@
 package network
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/clearlinux/clr-installer/utils"
@@ -355,3 +363,182 @@ func TestGoodDownload(t *testing.T) {
 		t.Fatalf("Good Clear Linux HTTPS URL failed: %s", err)
 	}
 }
+
+func TestVerifyConfigFileSHA256(t *testing.T) {
+	config := []byte("{\"hostname\": \"clr\"}\n")
+	tampered := []byte("{\"hostname\": \"pwn\"}\n")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tampered.json" {
+			_, _ = w.Write(tampered)
+			return
+		}
+		_, _ = w.Write(config)
+	}))
+	defer ts.Close()
+
+	sum := sha256.Sum256(config)
+	digest := hex.EncodeToString(sum[:])
+
+	file, err := FetchRemoteConfigFile(ts.URL + "/good.json")
+	if err != nil {
+		t.Fatalf("Could not fetch the config file: %v", err)
+	}
+	defer func() { _ = os.Remove(file) }()
+
+	if err = VerifyConfigFileSHA256(file, digest); err != nil {
+		t.Fatalf("The good config file should be verified: %v", err)
+	}
+
+	if err = VerifyConfigFileSHA256(file, strings.ToUpper(digest)); err != nil {
+		t.Fatalf("The digest should be case insensitive: %v", err)
+	}
+
+	file, err = FetchRemoteConfigFile(ts.URL + "/tampered.json")
+	if err != nil {
+		t.Fatalf("Could not fetch the config file: %v", err)
+	}
+	defer func() { _ = os.Remove(file) }()
+
+	if err = VerifyConfigFileSHA256(file, digest); err == nil {
+		t.Fatal("The tampered config file should fail the verification")
+	}
+
+	if err = VerifyConfigFileSHA256(file, "abc"); err == nil {
+		t.Fatal("An invalid digest should be refused")
+	}
+}
+
+func TestAddressFamily(t *testing.T) {
+	defer func() {
+		addressFamily = AddressFamilyAuto
+		_ = os.Unsetenv("RES_OPTIONS")
+	}()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	tests := []struct {
+		family  string
+		network string
+		args    []string
+		dials   bool
+	}{
+		{"", "tcp", []string{}, true},
+		{"auto", "tcp", []string{}, true},
+		{"IPv4", "tcp4", []string{"--ipv4"}, true},
+		{"ipv6", "tcp6", []string{"--ipv6"}, false},
+	}
+
+	for _, curr := range tests {
+		if err = SetAddressFamily(curr.family); err != nil {
+			t.Fatalf("Address family %q should be valid: %v", curr.family, err)
+		}
+
+		dialer := newFamilyDialer(addressFamily)
+		if network := "tcp" + familyNetworks[dialer.family]; network != curr.network {
+			t.Fatalf("Expected %s dials for %q, got %s", curr.network, curr.family, network)
+		}
+
+		if args := curlFamilyArgs(); !reflect.DeepEqual(args, curr.args) {
+			t.Fatalf("Expected curl options %q for %q, got %q", curr.args, curr.family, args)
+		}
+
+		// swupd gets the IPv4 restriction through the resolver options
+		if noAAAA := os.Getenv("RES_OPTIONS") == resolverNoAAAA; noAAAA != (curr.network == "tcp4") {
+			t.Fatalf("Unexpected resolver options for %q: %q", curr.family, os.Getenv("RES_OPTIONS"))
+		}
+
+		// An IPv6 dialer must refuse the IPv4 listener
+		conn, err := NewTransport().DialContext(context.Background(), "tcp", listener.Addr().String())
+		if curr.dials != (err == nil) {
+			t.Fatalf("Unexpected dial result for %q: %v", curr.family, err)
+		}
+
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}
+
+	if err = SetAddressFamily("ipx"); err == nil {
+		t.Fatal("Address family ipx should be invalid")
+	}
+}
+
+func TestResolved(t *testing.T) {
+	valid := &Resolved{
+		DNS:        []string{"9.9.9.9", "2620:fe::fe", "1.1.1.1#cloudflare-dns.com"},
+		DNSOverTLS: true,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Resolved configuration should be valid: %v", err)
+	}
+
+	for _, dns := range []string{"", "dns.quad9.net", "9.9.9", "1.1.1.1#", "1.1.1.1#bad_name"} {
+		if err := (&Resolved{DNS: []string{dns}}).Validate(); err == nil {
+			t.Fatalf("DNS server %q should be invalid", dns)
+		}
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-resolved-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	dropInFile := filepath.Join(rootDir, ResolvedDropInDir, ResolvedDropInFile)
+
+	var unset *Resolved
+	for _, curr := range []*Resolved{unset, {}} {
+		if err = curr.WriteDropIn(rootDir); err != nil {
+			t.Fatalf("An unset configuration should be skipped: %v", err)
+		}
+
+		if _, err = os.Stat(dropInFile); !os.IsNotExist(err) {
+			t.Fatalf("No drop-in should be written for an unset configuration")
+		}
+	}
+
+	if err = valid.WriteDropIn(rootDir); err != nil {
+		t.Fatalf("Could not write the resolved drop-in: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(dropInFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[Resolve]\nDNS=9.9.9.9 2620:fe::fe 1.1.1.1#cloudflare-dns.com\nDNSOverTLS=yes\n"
+	if string(content) != expected {
+		t.Fatalf("Expected the drop-in %q, got %q", expected, string(content))
+	}
+}
+
+func TestURLContentLength(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/pack.tar", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Length", "4096")
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{"/pack.tar", "/redirect"} {
+		length, err := URLContentLength(ts.URL + path)
+		if err != nil || length != 4096 {
+			t.Fatalf("Expected the content length 4096 for %s, got %d: %v", path, length, err)
+		}
+	}
+
+	if _, err := parseContentLength("HTTP/1.1 200 OK\r\nContent-Length: bad\r\n"); err == nil {
+		t.Fatal("An invalid Content-Length should fail")
+	}
+
+	if _, err := parseContentLength("HTTP/1.1 200 OK\r\n"); err == nil {
+		t.Fatal("A missing Content-Length should fail")
+	}
+}
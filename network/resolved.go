@@ -0,0 +1,100 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// ResolvedDropInDir is the directory of the systemd-resolved configuration drop-ins
+	ResolvedDropInDir = "/etc/systemd/resolved.conf.d"
+
+	// ResolvedDropInFile is the name of the drop-in written by the installer
+	ResolvedDropInFile = "clr-installer.conf"
+)
+
+// Resolved is the systemd-resolved configuration of the target system, the
+// DNS servers are used in addition to the ones provided by DHCP
+type Resolved struct {
+	DNS        []string `yaml:"dns,omitempty,flow"`
+	DNSOverTLS bool     `yaml:"dnsOverTLS,omitempty,flow"`
+}
+
+// splitDNSServer splits a DNS server entry into its address and the optional
+// server name, i.e. 1.1.1.1#cloudflare-dns.com, used to authenticate it over TLS
+func splitDNSServer(server string) (string, string) {
+	fields := strings.SplitN(server, "#", 2)
+	if len(fields) == 1 {
+		return fields[0], ""
+	}
+
+	return fields[0], fields[1]
+}
+
+// Validate checks the DNS servers are IPv4 or IPv6 addresses
+func (r *Resolved) Validate() error {
+	for _, server := range r.DNS {
+		addr, name := splitDNSServer(server)
+
+		if net.ParseIP(addr) == nil {
+			return errors.ValidationErrorf("Invalid resolved DNS server %q, must be an IP address", server)
+		}
+
+		if strings.Contains(server, "#") {
+			if msg := IsValidDomainName(name); msg != "" {
+				return errors.ValidationErrorf("Invalid resolved DNS server name %q: %s", name, msg)
+			}
+		}
+	}
+
+	return nil
+}
+
+// dropIn returns the content of the resolved.conf drop-in
+func (r *Resolved) dropIn() string {
+	content := "[Resolve]\n"
+
+	if len(r.DNS) > 0 {
+		content = content + fmt.Sprintf("DNS=%s\n", strings.Join(r.DNS, " "))
+	}
+
+	if r.DNSOverTLS {
+		content = content + "DNSOverTLS=yes\n"
+	}
+
+	return content
+}
+
+// WriteDropIn writes the resolved.conf drop-in to the target rootDir, leaving
+// the resolved.conf provided by the OS untouched; nothing is written when
+// neither DNS servers nor DNS over TLS are configured
+func (r *Resolved) WriteDropIn(rootDir string) error {
+	if r == nil || (len(r.DNS) == 0 && !r.DNSOverTLS) {
+		return nil
+	}
+
+	dropInDir := filepath.Join(rootDir, ResolvedDropInDir)
+	if err := utils.MkdirAll(dropInDir, 0755); err != nil {
+		return err
+	}
+
+	dropInFile := filepath.Join(dropInDir, ResolvedDropInFile)
+	log.Info("Writing the systemd-resolved configuration %s", dropInFile)
+
+	if err := ioutil.WriteFile(dropInFile, []byte(r.dropIn()), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
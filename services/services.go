@@ -0,0 +1,111 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+var (
+	// unitNameExp matches a systemd unit name, templates included, with its type suffix
+	unitNameExp = regexp.MustCompile(`^[0-9A-Za-z:_.\\-]+(@[0-9A-Za-z:_.\\-]*)?` +
+		`\.(service|socket|device|mount|automount|swap|target|path|timer|slice|scope)$`)
+
+	// DefaultTargets are the systemd targets the target system may boot into by default
+	DefaultTargets = []string{"multi-user", "graphical", "rescue", "emergency"}
+)
+
+const (
+	// targetSuffix is the type suffix of the systemd target units
+	targetSuffix = ".target"
+)
+
+// ValidateUnits checks every entry of the list named kind is a unit name,
+// listed only once
+func ValidateUnits(kind string, units []string) error {
+	seen := map[string]bool{}
+
+	for _, unit := range units {
+		if !unitNameExp.MatchString(unit) {
+			return errors.ValidationErrorf("Invalid %s unit name %q", kind, unit)
+		}
+
+		if seen[unit] {
+			return errors.ValidationErrorf("Unit %s is listed more than once in %s", unit, kind)
+		}
+		seen[unit] = true
+	}
+
+	return nil
+}
+
+// systemctl runs the systemctl operation on the units of the target rootDir
+func systemctl(rootDir string, operation string, units []string) error {
+	if len(units) == 0 {
+		return nil
+	}
+
+	log.Info("Running systemctl %s on: %s", operation, strings.Join(units, ", "))
+
+	args := append([]string{
+		"systemctl",
+		fmt.Sprintf("--root=%s", rootDir),
+		operation,
+	}, units...)
+
+	if err := cmd.RunAndLog(args...); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// Enable enables the units in the target rootDir
+func Enable(rootDir string, units []string) error {
+	return systemctl(rootDir, "enable", units)
+}
+
+// Disable disables the units in the target rootDir
+func Disable(rootDir string, units []string) error {
+	return systemctl(rootDir, "disable", units)
+}
+
+// Mask masks the units in the target rootDir
+func Mask(rootDir string, units []string) error {
+	return systemctl(rootDir, "mask", units)
+}
+
+// ValidateDefaultTarget checks target is one of the known DefaultTargets, with
+// or without its .target suffix; an empty target keeps the system default
+func ValidateDefaultTarget(target string) error {
+	if target == "" {
+		return nil
+	}
+
+	name := strings.TrimSuffix(target, targetSuffix)
+	for _, curr := range DefaultTargets {
+		if curr == name {
+			return nil
+		}
+	}
+
+	return errors.ValidationErrorf("Invalid defaultTarget %q, valid values are: %s",
+		target, strings.Join(DefaultTargets, ", "))
+}
+
+// SetDefault sets the target the rootDir system boots into by default
+func SetDefault(rootDir string, target string) error {
+	if target == "" {
+		return nil
+	}
+
+	return systemctl(rootDir, "set-default", []string{strings.TrimSuffix(target, targetSuffix) + targetSuffix})
+}
@@ -0,0 +1,53 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package services
+
+import (
+	"testing"
+)
+
+func TestValidateUnits(t *testing.T) {
+	valid := []string{
+		"swupd-update.timer",
+		"swupd-update.service",
+		"getty@tty1.service",
+		"serial-getty@.service",
+		"var-lib-docker.mount",
+		"sshd.socket",
+	}
+
+	if err := ValidateUnits("maskServices", valid); err != nil {
+		t.Fatalf("Units should be valid: %v", err)
+	}
+
+	invalid := [][]string{
+		{""},
+		{"swupd-update"},
+		{"swupd-update.timer swupd-update.service"},
+		{"../swupd-update.timer"},
+		{"swupd-update.unknown"},
+		{"sshd.socket", "sshd.socket"},
+	}
+
+	for _, curr := range invalid {
+		if err := ValidateUnits("disableServices", curr); err == nil {
+			t.Fatalf("Units %q should be invalid", curr)
+		}
+	}
+}
+
+func TestValidateDefaultTarget(t *testing.T) {
+	for _, target := range []string{"", "multi-user", "graphical", "graphical.target", "rescue.target"} {
+		if err := ValidateDefaultTarget(target); err != nil {
+			t.Fatalf("Default target %q should be valid: %v", target, err)
+		}
+	}
+
+	for _, target := range []string{"graphical.service", "desktop", "multi-user.target.target", ".target"} {
+		if err := ValidateDefaultTarget(target); err == nil {
+			t.Fatalf("Default target %q should be invalid", target)
+		}
+	}
+}
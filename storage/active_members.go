@@ -0,0 +1,83 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// ActiveMemberWarning specifies the warning message for a target used by an
+	// active RAID array or LVM volume group
+	ActiveMemberWarning = "WARNING: Selected media is a member of the active %s which will be destroyed."
+)
+
+// isArrayMember returns true if bd is a RAID array member or a LVM physical volume
+func (bd *BlockDevice) isArrayMember() bool {
+	return bd.FsType == "linux_raid_member" || bd.FsType == BlockDeviceTypeLVM2GroupString
+}
+
+// ActiveMembers returns the names of the assembled RAID arrays and active
+// logical volumes built on top of bd or of its partitions, bd must be a
+// detected device and not one declared by a configuration file
+func (bd *BlockDevice) ActiveMembers() []string {
+	found := map[string]bool{}
+
+	for _, curr := range append([]*BlockDevice{bd}, bd.FindAllChildren()...) {
+		if !curr.isArrayMember() {
+			continue
+		}
+
+		for _, ch := range curr.Children {
+			found[ch.Name] = true
+		}
+	}
+
+	members := []string{}
+	for name := range found {
+		members = append(members, name)
+	}
+	sort.Strings(members)
+
+	return members
+}
+
+// HasActiveMemberTargets returns true if any of the targets is used by an
+// active RAID array or LVM volume group, erasing it then requires the
+// explicit confirmation of ForceDestructive
+func HasActiveMemberTargets(targets map[string]InstallTarget) bool {
+	for _, target := range targets {
+		if len(target.ActiveMembers) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// activeMemberWarning returns the dry run warning of the target, empty if the
+// target is not used by an active RAID array or LVM volume group
+func (target InstallTarget) activeMemberWarning() string {
+	if len(target.ActiveMembers) == 0 {
+		return ""
+	}
+
+	return target.Name + ": " + utils.Locale.Get(ActiveMemberWarning, strings.Join(target.ActiveMembers, ", "))
+}
+
+// checkActiveMembers refuses to modify a target used by an active RAID array
+// or LVM volume group unless forceDestructive is set
+func (target InstallTarget) checkActiveMembers(forceDestructive bool) error {
+	if len(target.ActiveMembers) == 0 || forceDestructive {
+		return nil
+	}
+
+	return errors.Errorf("Target %s is a member of the active %s; use --force-destructive to erase it",
+		target.Name, strings.Join(target.ActiveMembers, ", "))
+}
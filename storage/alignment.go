@@ -0,0 +1,107 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// sector4K is the sector size of the 4K native and 512e (advanced format) disks
+	sector4K = 4096
+
+	// mibAlignUnit is the partition boundary used on the 4K sector disks,
+	// a multiple of any sector size
+	mibAlignUnit = 1024 * 1024
+)
+
+// Uses4KSectors returns true if the disk has 4K physical or logical sectors,
+// i.e. a 4K native (4Kn) or 512e disk, as reported by lsblk phy-sec and log-sec
+func (bd *BlockDevice) Uses4KSectors() bool {
+	return bd.PhysicalSector >= sector4K || bd.LogicalSector >= sector4K
+}
+
+// sectorSize returns the largest of the physical and logical sector sizes
+func (bd *BlockDevice) sectorSize() uint64 {
+	if bd.PhysicalSector > bd.LogicalSector {
+		return bd.PhysicalSector
+	}
+
+	return bd.LogicalSector
+}
+
+// partedUnit returns the parted unit of the partition positions; the MB
+// boundaries are not sector aligned on the 4K sector disks, MiB ones are
+func (bd *BlockDevice) partedUnit() string {
+	if bd.Uses4KSectors() {
+		return "MiB"
+	}
+
+	return "MB"
+}
+
+// getStartEnd returns the parted start and end positions of a partition
+// aligned for the sector size of the disk
+func (bd *BlockDevice) getStartEnd(start uint64, end uint64) string {
+	if bd.Uses4KSectors() {
+		return getStartEndMiB(start, end)
+	}
+
+	return getStartEndMB(start, end)
+}
+
+// getStartEndMiB is getStartEndMB for the 4K sector disks; the start is
+// rounded up and the end down to a MiB boundary. parted does not move the
+// XiB positions, which are exactly on a MiB hence on a sector boundary.
+func getStartEndMiB(start uint64, end uint64) string {
+	startMiB := (start + mibAlignUnit - 1) / mibAlignUnit
+	if startMiB < 1 {
+		// The first MiB holds the partition table
+		startMiB = 1
+	}
+
+	strStart := fmt.Sprintf("%dMiB", startMiB)
+
+	strEnd := fmt.Sprintf("%dMiB", end/mibAlignUnit)
+	if end < 1 {
+		strEnd = "100%"
+	}
+
+	return strStart + " " + strEnd
+}
+
+// misalignedPartitions returns a warning for each new partition of the 4K
+// sector disk whose size is not a multiple of the sector size, such sizes
+// are rounded to a MiB boundary
+func (bd *BlockDevice) misalignedPartitions() []string {
+	var results []string
+
+	if !bd.Uses4KSectors() {
+		return results
+	}
+
+	sector := bd.sectorSize()
+
+	for _, ch := range bd.Children {
+		if !ch.MakePartition || ch.Size == 0 || ch.Size%sector == 0 {
+			continue
+		}
+
+		name := ch.Name
+		if name == "" {
+			name = ch.MountPoint
+		}
+
+		warning := utils.Locale.Get("Partition %s size is not a multiple of the %d bytes sectors of %s, rounding it",
+			name, sector, bd.Name)
+		log.Warning("WritePartitionTable: %s", warning)
+		results = append(results, warning)
+	}
+
+	return results
+}
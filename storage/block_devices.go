@@ -33,17 +33,39 @@ type BlockDevice struct {
 	MountPoint      string             // where the device is mounted
 	Label           string             // label for the filesystem; set with mkfs
 	PartitionLabel  string             // label for the partition; set with cgdisk/parted/gparted
+	PartitionType   string             // partition type GUID
 	Size            uint64             // size of the device
+	LogicalSector   uint64             // logical sector size of the device
+	PhysicalSector  uint64             // physical sector size of the device
 	Type            BlockDeviceType    // device type
 	State           BlockDeviceState   // device state (running, live etc)
 	ReadOnly        bool               // read-only device
 	RemovableDevice bool               // removable device
+	Rotational      bool               // rotational device, i.e. not a SSD
 	Children        []*BlockDevice     // children devices/partitions
 	UserDefined     bool               // was this value set by user?
 	MakePartition   bool               // Do we need to make a new partition?
 	FormatPartition bool               // Do we need to format the partition?
+	CreateOnly      bool               // Create the partition but leave it empty for later use
 	LabeledAdvanced bool               // Does this partition have a valid Advanced Label?
+	ReuseESP        bool               // Is this an existing EFI System Partition used as /boot as is?
+	ReusePartition  bool               // Is this an existing partition formatted in place?
+	Attributes      []string           // GPT partition attributes to set, i.e. no-automount
+	FreeSpace       bool               // Add the partition to the free space of the disk, keeping the others
+	PassphraseFile  string             // File holding the own passphrase of an encrypted partition
+	Order           int                // Explicit partition creation order, overrides the name order when set
 	Options         string             // arbitrary mkfs.* options
+	MountOptions    string             // fstab mount options, overrides the defaults
+	MountMode       string             // octal mode of the mount point directory
+	MountOwner      string             // numeric uid[:gid] owning the mount point directory
+	BtrfsProfile    string             // btrfs RAID profile of a multi-device root
+	BtrfsMember     bool               // Is this partition a member of the btrfs root?
+	btrfsMembers    []*BlockDevice     // member partitions of a multi-device btrfs
+	XfsReflink      string             // xfs reflink feature, true or false; empty keeps the mkfs default
+	XfsCrc          string             // xfs crc (v5 format) feature, true or false; empty keeps the mkfs default
+	XfsQuota        string             // comma separated xfs quota mount options; uquota, gquota or pquota
+	luksMapped      bool               // was the LUKS volume formatted and opened?
+	splitBoot       bool               // does the disk hold a split boot layout?
 	available       bool               // was it mounted the moment we loaded?
 	partition       uint64             // Assigned partition for media - can't set until after mkpart
 	PartTable       []*PartedPartition // Existing Disk partition table from parted
@@ -95,6 +117,9 @@ const (
 	// BlockDeviceTypeLoop identifies a BlockDevice as a loop device (created with losetup)
 	BlockDeviceTypeLoop
 
+	// BlockDeviceTypeMultipath identifies a BlockDevice as a device-mapper multipath device
+	BlockDeviceTypeMultipath
+
 	// BlockDeviceTypeUnknown identifies a BlockDevice as unknown
 	BlockDeviceTypeUnknown
 
@@ -170,6 +195,13 @@ const (
 
 	// RequiredBundleLVM the bundle needed if lvm partitions are used other than root
 	RequiredBundleLVM = "storage-utils"
+
+	// mapperDevDir is the directory of the device-mapper devices
+	mapperDevDir = "/dev/mapper/"
+
+	// mapperPartSuffix separates the partition number of the device-mapper
+	// devices partitions, as named by the kpartx rules of multipath-tools
+	mapperPartSuffix = "-part"
 )
 
 var (
@@ -188,6 +220,7 @@ var (
 		BlockDeviceTypePart:       "part",
 		BlockDeviceTypeCrypt:      "crypt",
 		BlockDeviceTypeLoop:       "loop",
+		BlockDeviceTypeMultipath:  "mpath",
 		BlockDeviceTypeRom:        "rom",
 		BlockDeviceTypeLVM2Group:  "LVM2_member",
 		BlockDeviceTypeLVM2Volume: "lvm",
@@ -203,8 +236,14 @@ var (
 		"/dev/loop":   "p",
 		"/dev/nvme":   "p",
 		"/dev/mmcblk": "p",
+		"/dev/nbd":    "p",
+		mapperDevDir:  mapperPartSuffix,
 	}
 
+	// devNameDigitExp matches the device names ending with a digit, the kernel
+	// separates their partition number with a "p", i.e. nvme0n2p1 or md0p1
+	devNameDigitExp = regexp.MustCompile(`[0-9]$`)
+
 	bootSizeDefault     = uint64(512 * (1024 * 1024))
 	SwapFileSizeDefault = uint64(64 * (1024 * 1024))
 
@@ -221,6 +260,10 @@ func getAliasSuffix(file string) string {
 		}
 	}
 
+	if devNameDigitExp.MatchString(file) {
+		return "p"
+	}
+
 	return ""
 }
 
@@ -312,7 +355,9 @@ func (bd BlockDevice) GetMappedDeviceFile() string {
 // String is suitable for the /etc/fstab
 func (bd BlockDevice) GetDeviceID() string {
 	if bd.Label != "" {
-		return "LABEL=" + bd.Label
+		// Use the label mkfs actually wrote
+		label, _ := bd.getFsLabel()
+		return "LABEL=" + label
 	}
 
 	if bd.UUID != "" {
@@ -379,15 +424,34 @@ func (bd *BlockDevice) Clone() *BlockDevice {
 		MountPoint:      bd.MountPoint,
 		Label:           bd.Label,
 		PartitionLabel:  bd.PartitionLabel,
+		PartitionType:   bd.PartitionType,
 		Size:            bd.Size,
+		LogicalSector:   bd.LogicalSector,
+		PhysicalSector:  bd.PhysicalSector,
 		Type:            bd.Type,
 		State:           bd.State,
 		ReadOnly:        bd.ReadOnly,
 		RemovableDevice: bd.RemovableDevice,
+		Rotational:      bd.Rotational,
 		UserDefined:     bd.UserDefined,
 		MakePartition:   bd.MakePartition,
 		FormatPartition: bd.FormatPartition,
+		CreateOnly:      bd.CreateOnly,
+		MountOptions:    bd.MountOptions,
+		MountMode:       bd.MountMode,
+		MountOwner:      bd.MountOwner,
+		BtrfsProfile:    bd.BtrfsProfile,
+		BtrfsMember:     bd.BtrfsMember,
+		XfsReflink:      bd.XfsReflink,
+		XfsCrc:          bd.XfsCrc,
+		XfsQuota:        bd.XfsQuota,
 		LabeledAdvanced: bd.LabeledAdvanced,
+		ReuseESP:        bd.ReuseESP,
+		ReusePartition:  bd.ReusePartition,
+		Attributes:      append([]string{}, bd.Attributes...),
+		FreeSpace:       bd.FreeSpace,
+		PassphraseFile:  bd.PassphraseFile,
+		Order:           bd.Order,
 		available:       bd.available,
 		partition:       bd.partition,
 		PartTable:       bd.PartTable,
@@ -456,18 +520,35 @@ func (bd *BlockDevice) RemoveChild(child *BlockDevice) {
 	}
 }
 
+// isMapperDevice returns true if bd is a device-mapper device, i.e. multipath
+func (bd *BlockDevice) isMapperDevice() bool {
+	return bd.Type == BlockDeviceTypeMultipath || strings.HasPrefix(bd.Path, mapperDevDir)
+}
+
 func (bd *BlockDevice) getBasePartitionName() string {
 	partPrefix := ""
 
-	if bd.Type == BlockDeviceTypeLoop ||
+	if bd.isMapperDevice() {
+		partPrefix = mapperPartSuffix
+	} else if bd.Type == BlockDeviceTypeLoop ||
 		strings.Contains(bd.Name, "nvme") ||
-		strings.Contains(bd.Name, "mmcblk") {
+		strings.Contains(bd.Name, "mmcblk") ||
+		strings.HasPrefix(bd.Name, "nbd") ||
+		devNameDigitExp.MatchString(bd.Name) {
 		partPrefix = "p"
 	}
 
 	return fmt.Sprintf("%s%s", bd.Name, partPrefix)
 }
 
+// setPartitionPath sets the device file of the partition child of a
+// device-mapper bd, its partitions are not listed in /dev but /dev/mapper
+func (bd *BlockDevice) setPartitionPath(child *BlockDevice) {
+	if bd.isMapperDevice() && !strings.HasSuffix(child.Name, "?") {
+		child.Path = filepath.Join(mapperDevDir, child.Name)
+	}
+}
+
 // AddChild adds a partition to a disk block device
 func (bd *BlockDevice) AddChild(child *BlockDevice) {
 	if bd.Children == nil {
@@ -482,6 +563,7 @@ func (bd *BlockDevice) AddChild(child *BlockDevice) {
 		} else {
 			child.Name = fmt.Sprintf("%s%d", bd.getBasePartitionName(), child.partition)
 		}
+		bd.setPartitionPath(child)
 	}
 	log.Debug("AddChild: child.Name is %q", child.Name)
 }
@@ -612,6 +694,83 @@ func RescanBlockDevices(userDefined []*BlockDevice) ([]*BlockDevice, error) {
 	return ListAvailableBlockDevices(userDefined)
 }
 
+// findRescannedDevice looks for bd in the rescanned list of block devices, devices
+// are matched by serial so a renamed disk is still found, devices without a
+// serial number (i.e. loop devices) are matched by name
+func findRescannedDevice(bd *BlockDevice, rescanned []*BlockDevice) *BlockDevice {
+	for _, curr := range rescanned {
+		if bd.Serial != "" && curr.Serial == bd.Serial {
+			return curr
+		}
+
+		if bd.Serial == "" && curr.Name == bd.Name {
+			return curr
+		}
+	}
+
+	return nil
+}
+
+// MergeRescannedSelection updates the install selection and target medias after
+// a rescan, selections for disks still present are preserved (following a
+// possible device rename) and only the ones for disks which disappeared are dropped
+func MergeRescannedSelection(selected map[string]InstallTarget, targets []*BlockDevice,
+	previous []*BlockDevice, rescanned []*BlockDevice) (map[string]InstallTarget, []*BlockDevice) {
+	// The selection only knows about names, use the previous scan to find the serial
+	lookup := func(name string) *BlockDevice {
+		for _, curr := range previous {
+			if curr.Name == name {
+				return curr
+			}
+		}
+
+		return &BlockDevice{Name: name}
+	}
+
+	mergedSelected := map[string]InstallTarget{}
+	for name, target := range selected {
+		bd := findRescannedDevice(lookup(name), rescanned)
+		if bd == nil {
+			log.Info("Rescan: Dropping selection of disappeared media %s", name)
+			continue
+		}
+
+		if bd.Name != name {
+			log.Info("Rescan: Selected media %s was renamed to %s", name, bd.Name)
+			target.Name = bd.Name
+		}
+
+		mergedSelected[bd.Name] = target
+	}
+
+	var mergedTargets []*BlockDevice
+	for _, tm := range targets {
+		ref := lookup(tm.Name)
+		if ref.Serial == "" && tm.Serial != "" {
+			ref = tm
+		}
+
+		bd := findRescannedDevice(ref, rescanned)
+		if bd == nil {
+			log.Info("Rescan: Dropping target media %s", tm.Name)
+			continue
+		}
+
+		if tm.Name != bd.Name {
+			for _, ch := range tm.FindAllChildren() {
+				if strings.HasPrefix(ch.Name, tm.Name) {
+					ch.Name = bd.Name + strings.TrimPrefix(ch.Name, tm.Name)
+				}
+			}
+			tm.Name = bd.Name
+		}
+
+		mergedTargets = append(mergedTargets, tm)
+	}
+
+	return mergedSelected, mergedTargets
+}
+
 // ListAvailableBlockDevices Lists only available block devices
 // where available means block devices not mounted or not in use by the host system
 // userDefined will be inserted in the resulting list rather the loaded ones
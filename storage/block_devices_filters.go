@@ -4,6 +4,20 @@
 
 package storage
 
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+const (
+	// excludeRegexPrefix marks an exclude pattern as a regular expression rather than a glob
+	excludeRegexPrefix = "re:"
+)
+
 // BlockDevFilterFunc is a type for all filter functions
 type BlockDevFilterFunc func(*BlockDevice) bool
 
@@ -72,3 +86,51 @@ func FindAllBlockDevices(bd *BlockDevice, filterfunc BlockDevFilterFunc) []*Bloc
 
 	return result
 }
+
+// matchExcludePattern returns true if value matches the glob, or the regular
+// expression when prefixed with "re:", pattern
+func matchExcludePattern(pattern string, value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+
+	if strings.HasPrefix(pattern, excludeRegexPrefix) {
+		return regexp.MatchString(strings.TrimPrefix(pattern, excludeRegexPrefix), value)
+	}
+
+	return filepath.Match(pattern, value)
+}
+
+// ValidateExcludeDevices checks all of the exclude device patterns are valid
+func ValidateExcludeDevices(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := matchExcludePattern(pattern, "validate"); err != nil {
+			return errors.ValidationErrorf("Invalid excludeDevices pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// ExcludeDevicesFilter returns a filter function which rejects the block
+// devices whose name or serial matches any of the patterns
+func ExcludeDevicesFilter(patterns []string) BlockDevFilterFunc {
+	return func(bd *BlockDevice) bool {
+		for _, pattern := range patterns {
+			for _, value := range []string{bd.Name, bd.Serial} {
+				match, err := matchExcludePattern(pattern, value)
+				if err != nil {
+					log.Warning("Invalid excludeDevices pattern %q: %v", pattern, err)
+					continue
+				}
+
+				if match {
+					log.Debug("Excluding device %s matching pattern %q", bd.Name, pattern)
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+}
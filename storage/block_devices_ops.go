@@ -6,6 +6,7 @@ package storage
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
@@ -14,6 +15,7 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/errors"
@@ -24,12 +26,32 @@ import (
 
 // MediaOpts group the set of media related options
 type MediaOpts struct {
-	LegacyBios         bool   `yaml:"legacyBios,omitempty,flow"`
-	SkipValidationSize bool   `yaml:"skipValidationSize,omitempty,flow"`
-	SkipValidationAll  bool   `yaml:"skipValidationAll,omitempty,flow"`
-	SwapFileSize       string `yaml:"swapFileSize,omitempty,flow"`
-	SwapFileSet        bool   `yaml:"-"`
-	ForceDestructive   bool   `yaml:"-"`
+	LegacyBios            bool     `yaml:"legacyBios,omitempty,flow"`
+	Bootloader            string   `yaml:"bootloader,omitempty,flow"`
+	PartitionTable        string   `yaml:"partitionTable,omitempty,flow"`
+	SkipValidationSize    bool     `yaml:"skipValidationSize,omitempty,flow"`
+	SkipValidationAll     bool     `yaml:"skipValidationAll,omitempty,flow"`
+	SwapFileSize          string   `yaml:"swapFileSize,omitempty,flow"`
+	NoSwap                bool     `yaml:"noSwap,omitempty,flow"`
+	PrimaryBoot           string   `yaml:"primaryBoot,omitempty,flow"`
+	HibernationSupport    bool     `yaml:"hibernation,omitempty,flow"`
+	PersistentSwapKey     bool     `yaml:"persistentSwapKey,omitempty,flow"`
+	DeterministicGUIDs    bool     `yaml:"deterministicGUIDs,omitempty,flow"`
+	GUIDSeed              string   `yaml:"guidSeed,omitempty,flow"`
+	ExcludeDevices        []string `yaml:"excludeDevices,omitempty,flow"`
+	ExpandLVMRoot         bool     `yaml:"expandLvmRoot,omitempty,flow"`
+	SmartMountDefaults    bool     `yaml:"smartMountDefaults,omitempty,flow"`
+	LabelPolicy           string   `yaml:"labelPolicy,omitempty,flow"`
+	AllowMixedSectorSizes bool     `yaml:"allowMixedSectorSizes,omitempty,flow"`
+	ReadOnlyRoot          bool     `yaml:"readOnlyRoot,omitempty,flow"`
+	AtimeMode             string   `yaml:"atimeMode,omitempty,flow"`
+	Trim                  string   `yaml:"trim,omitempty,flow"`
+	SplitBoot             bool     `yaml:"splitBoot,omitempty,flow"`
+	GrowRoot              bool     `yaml:"growRoot,omitempty,flow"`
+	DeviceNodeTimeout     uint     `yaml:"deviceNodeTimeout,omitempty,flow"`
+	SwapFileSet           bool     `yaml:"-"`
+	ForceDestructive      bool     `yaml:"-"`
+	ForceUnmount          bool     `yaml:"-"`
 }
 
 // DryRunType to hold results of dryrun from calling WritePartitionTable
@@ -62,26 +84,44 @@ var (
 		"ext2":  {commonMakeFsCommand, []string{"-v", "-F"}, commonMakePartCommand},
 		"ext3":  {commonMakeFsCommand, []string{"-v", "-F"}, commonMakePartCommand},
 		"ext4":  {commonMakeFsCommand, []string{"-v", "-F", "-b", "4096"}, commonMakePartCommand},
-		"btrfs": {commonMakeFsCommand, []string{"-f"}, commonMakePartCommand},
-		"xfs":   {commonMakeFsCommand, []string{"-f"}, commonMakePartCommand},
+		"btrfs": {btrfsMakeFsCommand, []string{"-f"}, commonMakePartCommand},
+		"xfs":   {xfsMakeFsCommand, []string{"-f"}, commonMakePartCommand},
 		"f2fs":  {commonMakeFsCommand, []string{"-f"}, commonMakePartCommand},
 		"swap":  {swapMakeFsCommand, []string{}, swapMakePartCommand},
 		"vfat":  {commonMakeFsCommand, []string{"-F32"}, vfatMakePartCommand},
+		// nilfs2 and jfs are data only file systems, clr-boot-manager can
+		// not boot from them so they are refused for / and /boot
+		"nilfs2": {commonMakeFsCommand, []string{"-f"}, commonMakePartCommand},
+		"jfs":    {commonMakeFsCommand, []string{"-q"}, commonMakePartCommand},
 	}
 
 	guidMap = map[string]string{
 		"/":     "4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709",
 		"/home": "933AC7E1-2EB4-4F13-B844-0E14E2AEF915",
 		"/srv":  "3B8F8425-20E0-4F3B-907F-1A25A76F98E8",
+		"/var":  "4D21B016-B534-45C2-A9FB-5C16E091FD2D",
 		"swap":  "0657FD6D-A4AB-43C4-84E5-0933C84B4F4F",
 		"efi":   "C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+		"data":  "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
 	}
 
+	// standardMounts are discovered and mounted by systemd-gpt-auto-generator
+	// based on the partition type GUID, so they don't need a fstab entry.
+	// /var is only auto mounted if the partition unique GUID matches the
+	// machine id, which is not known at install time.
+	standardMounts = []string{"/", "/home", "/srv", "/boot"}
+
 	mountedPoints   []string
 	mountedEncrypts []string
 
 	minBootSize = uint64(100) * (1000 * 1000) // 100MB recommend for 4-5 kernels
 
+	// maxPartitionLabelLength is the length of the GPT partition name
+	maxPartitionLabelLength = 36
+
+	minGUIDSeedLength = 8
+	maxGUIDSeedLength = 256
+
 	minSwapSize = uint64(32) * (1024 * 1024)       // 32MiB recommend smallest for memory crunch times
 	maxSwapSize = uint64(8) * (1024 * 1024 * 1024) // 8GiB recommend maximum for memory crunch times
 
@@ -137,18 +177,27 @@ func setImpactOnOtherDisks(value bool) {
 	hasUnplannedDestructiveChanges = value
 }
 
-func getBlockDevicesLsblkJSON(opts ...string) ([]*BlockDevice, error) {
+// lsblkJSON returns the complete lsblk JSON description of the block devices
+func lsblkJSON(opts ...string) ([]byte, error) {
 	w := bytes.NewBuffer(nil)
 	args := []string{lsblkBinary, "--exclude", "1,2,11", "-J", "-b", "-O"}
 
 	args = append(args, opts...)
 
-	err := cmd.Run(w, args...)
+	if err := cmd.Run(w, args...); err != nil {
+		return nil, err
+	}
+
+	return w.Bytes(), nil
+}
+
+func getBlockDevicesLsblkJSON(opts ...string) ([]*BlockDevice, error) {
+	out, err := lsblkJSON(opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	bds, err := parseBlockDevicesDescriptor(w.Bytes())
+	bds, err := parseBlockDevicesDescriptor(out)
 	if err == nil {
 		return bds, nil
 	}
@@ -156,30 +205,68 @@ func getBlockDevicesLsblkJSON(opts ...string) ([]*BlockDevice, error) {
 	return []*BlockDevice{}, nil
 }
 
+// LogBlockDevicesSnapshot logs the complete lsblk output with the debug log
+// level, describing the actual state of the disks at the stage of the install;
+// nothing but what lsblk itself reports is logged
+func LogBlockDevicesSnapshot(stage string) {
+	if !log.IsLevelEnabled(log.LogLevelDebug) {
+		return
+	}
+
+	out, err := lsblkJSON()
+	if err != nil {
+		log.Debug("Could not capture the block devices snapshot %s: %v", stage, err)
+		return
+	}
+
+	log.Debug("Block devices snapshot %s:\n%s", stage, strings.TrimSpace(string(out)))
+}
+
 // MakeFs runs mkfs.* commands for a BlockDevice definition
 func (bd *BlockDevice) MakeFs() error {
+	return bd.MakeFsWithProgress(nil)
+}
+
+// MakeFsWithProgress makes the file system as MakeFs does, calling partial with
+// the completion percentage when the mkfs tool reports it, see MakeFsReportsProgress
+func (bd *BlockDevice) MakeFsWithProgress(partial func(percent int)) error {
 	if bd.Type == BlockDeviceTypeDisk {
 		return errors.Errorf("Trying to run MakeFs() against a disk, partition required")
 	}
 
+	// The members of a multi-device btrfs are formatted along with the root
+	if bd.BtrfsMember {
+		log.Debug("Skipping btrfs RAID member %s, formatted with the root", bd.Name)
+		return nil
+	}
+
 	if op, ok := bdOps[bd.FsType]; ok {
 		if cmd, err := op.makeFsCommand(bd, op.makeFsArgs); err == nil {
-			return makeFs(bd, cmd)
+			return makeFs(bd, cmd, partial)
 		}
 	}
 
 	return errors.Errorf("MakeFs() not implemented for filesystem: %s", bd.FsType)
 }
 
-func makeFs(bd *BlockDevice, args []string) error {
+// makeFsArgs completes the mkfs command with the user options and the devices
+func makeFsArgs(bd *BlockDevice, args []string) []string {
 	if bd.Options != "" {
 		args = append(args, strings.Split(bd.Options, " ")...)
 	}
 
-	args = append(args, bd.GetMappedDeviceFile())
+	return append(args, bd.makeFsDevices()...)
+}
 
-	err := cmd.RunAndLog(args...)
+func makeFs(bd *BlockDevice, args []string, partial func(percent int)) error {
+	w := &mkfsProgressWriter{partial: partial}
+	err := cmd.Run(w, makeFsArgs(bd, args)...)
+	log.Debug("%s", w.output.String())
 	if err != nil {
+		if errors.IsNoSpaceMessage(w.output.String()) {
+			return errors.InsufficientSpaceErrorf("Not enough space to create the %s file system on %s",
+				bd.FsType, bd.GetDeviceFile())
+		}
 		return errors.Wrap(err)
 	}
 
@@ -251,36 +338,71 @@ func (bd *BlockDevice) getGUID() string {
 		return guidMap["efi"]
 	}
 
+	// Empty partitions are tagged as generic Linux data
+	if bd.CreateOnly {
+		return guidMap["data"]
+	}
+
 	return ""
 }
 
 func (bd *BlockDevice) isStandardMount() bool {
-	standard := false
+	return utils.StringSliceContains(standardMounts, bd.MountPoint)
+}
 
-	for mnt := range guidMap {
-		// LVM do not have GUID Types
-		if bd.MountPoint == mnt {
-			standard = true
-		}
+// mountDepth returns the number of path elements of the mount point,
+// the root and devices without a mount point have depth 0
+func (bd *BlockDevice) mountDepth() int {
+	mnt := filepath.Clean(bd.MountPoint)
+	if bd.MountPoint == "" || mnt == "/" {
+		return 0
 	}
 
-	if bd.MountPoint == "/boot" {
-		standard = true
-	}
+	return strings.Count(mnt, "/")
+}
+
+// SortByMountPoint sorts bds so parent mount points come before the mount
+// points nested under them (i.e. / before /var before /var/log), the
+// relative order of devices with the same depth is kept
+func SortByMountPoint(bds []*BlockDevice) []*BlockDevice {
+	sort.SliceStable(bds, func(i, j int) bool {
+		return bds[i].mountDepth() < bds[j].mountDepth()
+	})
 
-	return standard
+	return bds
 }
 
 // Mount will mount a block devices bd considering its mount point and the
-// root directory
-func (bd *BlockDevice) Mount(root string) error {
+// root directory, with the atime mode of the installed system
+func (bd *BlockDevice) Mount(root string, mediaOpts MediaOpts) error {
 	if bd.Type == BlockDeviceTypeDisk {
 		return errors.Errorf("Trying to run mountFs() against a disk, partition required")
 	}
 
 	targetPath := filepath.Join(root, bd.MountPoint)
 
-	return mountFs(bd.GetMappedDeviceFile(), targetPath, bd.FsType, syscall.MS_RELATIME)
+	options := bd.MountOptions
+	if options == "" {
+		options = "defaults"
+	}
+
+	flags, data, err := parseMountOptions(atimeMountOptions(options, mediaOpts))
+	if err != nil {
+		return err
+	}
+
+	// The installer writes to the target, a read-only mount only applies once installed
+	flags &^= syscall.MS_RDONLY
+
+	if err = makeMountPoint(targetPath, bd.mountPointMode()); err != nil {
+		return err
+	}
+
+	if err = mountFs(bd.GetMappedDeviceFile(), targetPath, bd.FsType, flags, data); err != nil {
+		return err
+	}
+
+	return bd.setMountPointOwnership(targetPath)
 }
 
 // When you specify a start (or end) position to the parted mkpart command,
@@ -306,15 +428,57 @@ func getStartEndMB(start uint64, end uint64) string {
 	return strStart + " " + strEnd
 }
 
-// WritePartitionLabel make a device a 'gpt' partition type
+const (
+	// partitionAlignUnit is the step used to move the start or end of a
+	// partition when parted fails to create it; matches the M unit used
+	partitionAlignUnit = 1000 * 1000
+
+	// partitionStartRetries is the number of times the start is moved ahead
+	partitionStartRetries = 3
+
+	// partitionEndRetries is the number of times the end of the maximally
+	// sized partition is trimmed once the start retries are exhausted
+	partitionEndRetries = 3
+)
+
+// retryStartEnd computes the start and end of the next attempt to create a
+// partition after attempt failed ones; the start is first nudged ahead, then
+// for the max-fill partition the end, or diskEnd when it fills up to the end
+// of the disk, is trimmed down. Returns false when no retry is left.
+func retryStartEnd(start, end, diskEnd uint64, maxFill bool, attempt int) (uint64, uint64, bool) {
+	if attempt <= partitionStartRetries {
+		start = start + partitionAlignUnit
+		log.Info("WritePartitionTable: retry %d, moving start to %d", attempt, start)
+		return start, end, true
+	}
+
+	if !maxFill || attempt > partitionStartRetries+partitionEndRetries {
+		return start, end, false
+	}
+
+	if end < 1 {
+		end = diskEnd
+	}
+
+	if end < start+2*partitionAlignUnit {
+		return start, end, false
+	}
+
+	end = end - partitionAlignUnit
+	log.Info("WritePartitionTable: retry %d, trimming end to %d", attempt, end)
+
+	return start, end, true
+}
+
+// WritePartitionLabel make a device a ptType, 'gpt' or 'msdos', partition type
 // Only call when we are wiping and reusing the entire disk
-func (bd *BlockDevice) writePartitionLabel(wholeDisk bool) error {
+func (bd *BlockDevice) writePartitionLabel(wholeDisk bool, ptType string) error {
 	if !wholeDisk {
 		log.Debug("WritePartitionTable: partial disk, skipping mklabel for %s", bd.Name)
 		return nil
 	}
 
-	if bd.Type != BlockDeviceTypeDisk && bd.Type != BlockDeviceTypeLoop {
+	if bd.Type != BlockDeviceTypeDisk && bd.Type != BlockDeviceTypeLoop && bd.Type != BlockDeviceTypeMultipath {
 		return errors.Errorf("Type is partition, disk required")
 	}
 
@@ -326,7 +490,7 @@ func (bd *BlockDevice) writePartitionLabel(wholeDisk bool) error {
 		"-s",
 		bd.GetDeviceFile(),
 		"mklabel",
-		"gpt",
+		ptType,
 	}
 
 	err := cmd.RunAndLog(args...)
@@ -373,6 +537,92 @@ func (bd *BlockDevice) setPartitionGUIDs(guids map[int]string) error {
 	return nil
 }
 
+const (
+	// LabelPolicyTruncate truncates the over-length labels with a warning
+	LabelPolicyTruncate = "truncate"
+
+	// LabelPolicyError refuses the over-length labels during validation
+	LabelPolicyError = "error"
+)
+
+// ValidateLabelPolicy checks policy is a known file system label policy, an
+// empty policy defaults to LabelPolicyTruncate
+func ValidateLabelPolicy(policy string) error {
+	switch policy {
+	case "", LabelPolicyTruncate, LabelPolicyError:
+		return nil
+	}
+
+	return errors.ValidationErrorf("Invalid labelPolicy value %q, expected %q or %q",
+		policy, LabelPolicyTruncate, LabelPolicyError)
+}
+
+// ValidateGUIDSeed checks the seed used to derive deterministic partition GUIDs
+func ValidateGUIDSeed(seed string) error {
+	if len(seed) < minGUIDSeedLength || len(seed) > maxGUIDSeedLength {
+		return errors.ValidationErrorf("GUID seed must have between %d and %d characters",
+			minGUIDSeedLength, maxGUIDSeedLength)
+	}
+
+	for _, r := range seed {
+		if r <= ' ' || r > '~' {
+			return errors.ValidationErrorf("GUID seed must only have printable ASCII characters")
+		}
+	}
+
+	return nil
+}
+
+// deterministicGUID derives a partition unique GUID from the seed, the position
+// of the disk in the target medias and the partition number
+func deterministicGUID(seed string, disk int, partition uint64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", seed, disk, partition)))
+
+	// Format as a RFC 4122 version 4 variant 1 GUID
+	sum[6] = (sum[6] & 0x0f) | 0x40
+	sum[8] = (sum[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%X-%X-%X-%X-%X", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// deterministicGUIDs returns the partition unique GUIDs, indexed by partition
+// number, to be assigned to the new partitions of the disk
+func (bd *BlockDevice) deterministicGUIDs(seed string, disk int) map[int]string {
+	guids := map[int]string{}
+
+	for _, curr := range bd.Children {
+		if !curr.MakePartition {
+			continue
+		}
+
+		guids[int(curr.partition)] = deterministicGUID(seed, disk, curr.partition)
+	}
+
+	return guids
+}
+
+// setPartitionUniqueGUIDs uses sgdisk to set deterministic unique GUIDs
+// to the new partitions of the disk
+func (bd *BlockDevice) setPartitionUniqueGUIDs(seed string, disk int) error {
+	guids := bd.deterministicGUIDs(seed, disk)
+
+	log.Info("Setting deterministic unique GUIDs for device: %s", bd.GetDeviceFile())
+
+	for idx, guid := range guids {
+		args := []string{
+			"sgdisk",
+			bd.GetDeviceFile(),
+			fmt.Sprintf("--partition-guid=%d:%s", idx, guid),
+		}
+
+		if err := cmd.RunAndLog(args...); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
 func partitionUsingParted(bd *BlockDevice, dryRun *DryRunType, wholeDisk bool) error {
 	var start uint64
 	maxFound := false
@@ -380,10 +630,21 @@ func partitionUsingParted(bd *BlockDevice, dryRun *DryRunType, wholeDisk bool) e
 	// Initialize the partition list before we add new ones
 	currentPartitions := bd.getPartitionList()
 
+	if bd.Uses4KSectors() {
+		log.Debug("WritePartitionTable: %s has %d bytes sectors, aligning on MiB", bd.Name, bd.sectorSize())
+	}
+
+	warnings := bd.misalignedPartitions()
+	if dryRun != nil {
+		*dryRun.TargetResults = append(*dryRun.TargetResults, warnings...)
+	}
+
 	// Make the needed new partitions
 	for _, curr := range bd.Children {
 		if dryRun != nil {
-			if curr.MakePartition {
+			if curr.MakePartition && curr.FreeSpace && !wholeDisk {
+				*dryRun.TargetResults = append(*dryRun.TargetResults, bd.freeSpacePartitionResult(curr))
+			} else if curr.MakePartition {
 				size, _ := HumanReadableSizeXiBWithPrecision(curr.Size, 1)
 				*dryRun.TargetResults = append(*dryRun.TargetResults, fmt.Sprintf("%s: %s [%s]",
 					bd.Name, utils.Locale.Get(AddPartitionInfo), size))
@@ -397,7 +658,7 @@ func partitionUsingParted(bd *BlockDevice, dryRun *DryRunType, wholeDisk bool) e
 			"-a",
 			"optimal",
 			bd.GetDeviceFile(),
-			"unit", "MB",
+			"unit", bd.partedUnit(),
 			"--script",
 			"--",
 		}
@@ -408,22 +669,32 @@ func partitionUsingParted(bd *BlockDevice, dryRun *DryRunType, wholeDisk bool) e
 		}
 
 		var mkPart string
+		var err error
 
-		op, found := bdOps[curr.FsType]
-		if !found {
-			return errors.Errorf("No makePartCommand() implementation for: %s",
-				curr.FsType)
+		if curr.CreateOnly {
+			mkPart = createOnlyMakePartCommand(curr)
+		} else {
+			op, found := bdOps[curr.FsType]
+			if !found {
+				return errors.Errorf("No makePartCommand() implementation for: %s",
+					curr.FsType)
+			}
+
+			if mkPart, err = op.makePartCommand(curr); err != nil {
+				return err
+			}
 		}
 
-		mkPart, err := op.makePartCommand(curr)
-		if err != nil {
-			return err
+		if bd.partitionTable() == PartitionTableMSDOS {
+			mkPart = msdosMakePartCommand(mkPart)
 		}
 
 		size := uint64(curr.Size)
 		end := start + size
+		diskEnd := bd.Size
 		if !wholeDisk {
 			start, end = bd.getPartitionStartEnd(curr.partition)
+			diskEnd = end
 		} else {
 			log.Debug("WritePartitionTable: WholeDisk mode")
 		}
@@ -437,24 +708,22 @@ func partitionUsingParted(bd *BlockDevice, dryRun *DryRunType, wholeDisk bool) e
 			end = 0
 		}
 
-		retries := 3
-		for {
-			mkPartCmd := mkPart + " " + getStartEndMB(start, end)
+		for attempt := 1; ; attempt++ {
+			mkPartCmd := mkPart + " " + bd.getStartEnd(start, end)
 			log.Debug("WritePartitionTable: mkPartCmd: " + mkPartCmd)
 
 			args := append(baseArgs, mkPartCmd)
 
-			err = cmd.RunAndLog(args...)
-
-			if err == nil || retries == 0 {
+			if err = cmd.RunAndLog(args...); err == nil {
 				break
 			}
 
-			// Move the start position ahead one MB in an attempt
-			// to find a working optimal partition entry
-			start = start + (1000 * 1000)
-
-			retries--
+			// Move the start ahead, or trim the end of the max-fill
+			// partition, in an attempt to find a working optimal entry
+			var retry bool
+			if start, end, retry = retryStartEnd(start, end, diskEnd, size < 1, attempt); !retry {
+				break
+			}
 		}
 		if err != nil {
 			return errors.Wrap(err)
@@ -463,7 +732,25 @@ func partitionUsingParted(bd *BlockDevice, dryRun *DryRunType, wholeDisk bool) e
 		// Get the new list of partitions
 		newPartitions := bd.getPartitionList()
 		// The current partition is new one added
-		curr.SetPartitionNumber(findNewPartition(currentPartitions, newPartitions).Number)
+		newPartition := findNewPartition(currentPartitions, newPartitions)
+		curr.SetPartitionNumber(newPartition.Number)
+
+		// The free space partition names were predicted from the free
+		// partition numbers, and the ordered ones from their creation
+		// order, parted may have assigned another one
+		if curr.FreeSpace || bd.hasPartitionOrder() {
+			if newPartition.Number == 0 {
+				return errors.Errorf("Could not find the new partition of %s on %s", curr.MountPoint, bd.Name)
+			}
+			curr.Name = fmt.Sprintf("%s%d", bd.getBasePartitionName(), newPartition.Number)
+			curr.Path = ""
+			bd.setPartitionPath(curr)
+		}
+
+		if curr.PartitionLabel != "" && newPartition.Name != curr.PartitionLabel {
+			return errors.Errorf("Partition %s was named %q instead of %q", curr.Name,
+				newPartition.Name, curr.PartitionLabel)
+		}
 
 		start = end
 		currentPartitions = newPartitions
@@ -958,7 +1245,7 @@ func removePart(bd *BlockDevice, forceDestructive bool, disk string, dryRun *Dry
 	}
 
 	partParentFinder := func(b *BlockDevice) bool {
-		if b.Type == BlockDeviceTypeDisk || b.Type == BlockDeviceTypeLoop ||
+		if b.Type == BlockDeviceTypeDisk || b.Type == BlockDeviceTypeLoop || b.Type == BlockDeviceTypeMultipath ||
 			b.isRaidType() || b.Type == BlockDeviceTypeLVM2Volume {
 			for _, ch := range b.Children {
 				if ch.Name == bd.Name {
@@ -1038,6 +1325,7 @@ func preScanRaidMembers(bds []*BlockDevice) []*BlockDevice {
 // WritePartitionTable writes the defined partitions to the actual block device
 func (bd *BlockDevice) WritePartitionTable(wholeDisk bool, forceDestructive bool, dryRun *DryRunType) error {
 	if bd.Type != BlockDeviceTypeDisk && bd.Type != BlockDeviceTypeLoop && bd.Type != BlockDeviceTypeLVM2Volume &&
+		bd.Type != BlockDeviceTypeMultipath &&
 		bd.Type != BlockDeviceTypeRAID0 && bd.Type != BlockDeviceTypeRAID1 && bd.Type != BlockDeviceTypeRAID4 &&
 		bd.Type != BlockDeviceTypeRAID5 && bd.Type != BlockDeviceTypeRAID6 && bd.Type != BlockDeviceTypeRAID10 {
 		return errors.Errorf("Type is partition, disk required")
@@ -1091,7 +1379,7 @@ func (bd *BlockDevice) WritePartitionTable(wholeDisk bool, forceDestructive bool
 		}
 	} else {
 		//write the partition label
-		if err := bd.writePartitionLabel(wholeDisk); err != nil {
+		if err := bd.writePartitionLabel(wholeDisk, bd.partitionTable()); err != nil {
 			return err
 		}
 
@@ -1100,13 +1388,16 @@ func (bd *BlockDevice) WritePartitionTable(wholeDisk bool, forceDestructive bool
 		log.Info(mesg)
 	}
 
-	// Sort the partitions by name before writing the partition table
+	// Sort the partitions by name, or their explicit order, before writing the partition table
 	log.Debug("Partitions before sorting:")
 	for _, part := range bd.Children {
 		part.logDetails()
 	}
 
-	sort.Sort(ByBDName(bd.Children))
+	bd.sortPartitions()
+	if wholeDisk {
+		bd.renamePartitions()
+	}
 
 	log.Debug("Partitions after sorting:")
 	for _, part := range bd.Children {
@@ -1120,14 +1411,17 @@ func (bd *BlockDevice) WritePartitionTable(wholeDisk bool, forceDestructive bool
 		return err
 	}
 
-	if dryRun == nil {
+	if dryRun == nil && bd.partitionTable() == PartitionTableMSDOS {
+		log.Debug("WritePartitionTable: msdos partition table, skipping the GUIDs of %s", bd.Name)
+		prg.Success()
+	} else if dryRun == nil {
 		guids := map[int]string{}
 
 		// Now that all new partitions are created,
 		// and we know their assigned numbers ...
 		for _, curr := range bd.Children {
 			var guid string
-			guid = curr.getGUID()
+			guid = bd.partitionGUID(curr)
 			if guid == "" {
 				log.Warning("Could not determine the guid for: %s", curr.Name)
 				continue
@@ -1143,6 +1437,10 @@ func (bd *BlockDevice) WritePartitionTable(wholeDisk bool, forceDestructive bool
 			return err
 		}
 
+		if err = bd.setPartitionAttributes(); err != nil {
+			return err
+		}
+
 		prg.Success()
 	} else {
 		if partChanges := getPlannedPartitionChanges(bd); len(partChanges) > 0 {
@@ -1153,6 +1451,24 @@ func (bd *BlockDevice) WritePartitionTable(wholeDisk bool, forceDestructive bool
 	return nil
 }
 
+// CheckTargetMediasPresent returns an error if the device file of any target
+// media is missing, i.e. a second disk unplugged since it was selected
+func CheckTargetMediasPresent(medias []*BlockDevice) error {
+	missing := []string{}
+
+	for _, bd := range medias {
+		if found, _ := utils.FileExists(bd.GetDeviceFile()); !found {
+			missing = append(missing, bd.GetDeviceFile())
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.Errorf("Target media no longer present: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
 // PrepareInstallationMedia updates all of the installation medias to ensure
 // installation can proceed. Media is only updated if dryRun is passed 'nil,
 // otherwise a high level description, in the locale, is set in the passed
@@ -1174,10 +1490,22 @@ func PrepareInstallationMedia(targets map[string]InstallTarget,
 				*dryRun.TargetResults = append(*dryRun.TargetResults,
 					target.Name+": "+utils.Locale.Get(MediaToBeUsed))
 			}
+
+			if warning := target.activeMemberWarning(); warning != "" {
+				*dryRun.TargetResults = append(*dryRun.TargetResults, warning)
+			}
+		} else if err := target.checkActiveMembers(mediaOpts.ForceDestructive); err != nil {
+			return err
 		}
 
-		for _, curr := range medias {
+		for idx, curr := range medias {
 			if target.Name == curr.Name {
+				// The whole disk is relabeled with the requested table type
+				if target.WholeDisk {
+					curr.PtType = mediaOpts.GetPartitionTable()
+				}
+				curr.splitBoot = mediaOpts.SplitBoot
+
 				if err := curr.WritePartitionTable(target.WholeDisk, mediaOpts.ForceDestructive, dryRun); err != nil {
 					if dryRun != nil {
 						*dryRun.TargetResults = append(*dryRun.TargetResults, FailedPartitionWarning)
@@ -1185,6 +1513,12 @@ func PrepareInstallationMedia(targets map[string]InstallTarget,
 						return err
 					}
 				}
+
+				if dryRun == nil && mediaOpts.DeterministicGUIDs {
+					if err := curr.setPartitionUniqueGUIDs(mediaOpts.GUIDSeed, idx); err != nil {
+						return err
+					}
+				}
 			}
 		}
 	}
@@ -1234,8 +1568,8 @@ func (bd *BlockDevice) getPartitionList() []*PartedPartition {
 	partTable := bytes.NewBuffer(nil)
 	devFile := bd.GetDeviceFile()
 
-	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop, BlockDeviceTypeLVM2Volume},
-		int(bd.Type)) {
+	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop, BlockDeviceTypeMultipath,
+		BlockDeviceTypeLVM2Volume}, int(bd.Type)) {
 		log.Warning("getPartitionList() called on non-disk %q", devFile)
 		return partitionList
 	}
@@ -1257,36 +1591,7 @@ func (bd *BlockDevice) getPartitionList() []*PartedPartition {
 		return partitionList
 	}
 
-	for _, line := range strings.Split(partTable.String(), ";\n") {
-		partition := &PartedPartition{}
-
-		fields := strings.Split(line, ":")
-		if len(fields) == 7 {
-			partition.Number, err = strconv.ParseUint(fields[0], 10, 64)
-			if err != nil {
-				log.Warning("getPartitionList: Failed to parse partition number from: %s", line)
-			}
-			partition.Start, err = strconv.ParseUint(strings.TrimRight(fields[1], "B"), 10, 64)
-			if err != nil {
-				log.Warning("getPartitionList: Failed to parse start position from: %s", line)
-			}
-			partition.End, err = strconv.ParseUint(strings.TrimRight(fields[2], "B"), 10, 64)
-			if err != nil {
-				log.Warning("getPartitionList: Failed to parse end position from: %s", line)
-			}
-			partition.Size, err = strconv.ParseUint(strings.TrimRight(fields[3], "B"), 10, 64)
-			if err != nil {
-				log.Warning("getPartitionList: Failed to parse partition size from: %s", line)
-			}
-			partition.FileSystem = fields[4]
-			partition.Name = fields[5]
-			partition.Flags = fields[6]
-
-			partitionList = append(partitionList, partition)
-		}
-	}
-
-	return partitionList
+	return parsePartitionTable(partTable)
 }
 
 func findNewPartition(currentPartitions, newPartitions []*PartedPartition) *PartedPartition {
@@ -1322,7 +1627,7 @@ func (bd *BlockDevice) getPartitionTable() *bytes.Buffer {
 	partTable := bytes.NewBuffer(nil)
 	devFile := bd.GetDeviceFile()
 
-	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop}, int(bd.Type)) {
+	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop, BlockDeviceTypeMultipath}, int(bd.Type)) {
 		log.Warning("getPartitionTable() called on non-disk %q", devFile)
 		return partTable
 	}
@@ -1353,7 +1658,7 @@ func (bd *BlockDevice) getPartitionStartEnd(partNumber uint64) (uint64, uint64)
 	var start, end uint64
 	devFile := bd.GetDeviceFile()
 
-	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop}, int(bd.Type)) {
+	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop, BlockDeviceTypeMultipath}, int(bd.Type)) {
 		log.Warning("getPartitionStartEnd() called on non-disk %q", devFile)
 		return start, end
 	}
@@ -1375,7 +1680,7 @@ func (bd *BlockDevice) LargestContiguousFreeSpace(minSize uint64) (uint64, uint6
 	var start, end, size uint64
 	devFile := bd.GetDeviceFile()
 
-	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop}, int(bd.Type)) {
+	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop, BlockDeviceTypeMultipath}, int(bd.Type)) {
 		log.Warning("LargestContiguousFreeSpace() called on non-disk %q", devFile)
 		return start, end
 	}
@@ -1401,19 +1706,17 @@ func (bd *BlockDevice) AddFromFreePartition(parted *PartedPartition, child *Bloc
 	var partitionList []*PartedPartition
 	devFile := bd.GetDeviceFile()
 
-	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop}, int(bd.Type)) {
+	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop, BlockDeviceTypeMultipath}, int(bd.Type)) {
 		log.Warning("AddFromFreePartition() called on non-disk %q", devFile)
 		return
 	}
 
-	const (
-		maxPartitions = 127
-	)
+	maxPartitions := bd.maxPartitions()
 
 	found := false
 	next = 1
 
-	for !found && next < maxPartitions {
+	for !found && next <= maxPartitions {
 		present := false
 		for _, partition := range bd.PartTable {
 			if partition.Number == next {
@@ -1428,7 +1731,7 @@ func (bd *BlockDevice) AddFromFreePartition(parted *PartedPartition, child *Bloc
 		}
 	}
 
-	if next >= maxPartitions {
+	if next > maxPartitions {
 		log.Warning("AddFromFreePartition() could not add new partition: %v", child)
 		return
 	}
@@ -1505,38 +1808,59 @@ func (bd *BlockDevice) consolidateFree() {
 
 // Populate the current partition table for a disk device
 func (bd *BlockDevice) setPartitionTable(partTable *bytes.Buffer) {
-	var partitionList []*PartedPartition
 	devFile := bd.GetDeviceFile()
 
-	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop}, int(bd.Type)) {
+	if !utils.IntSliceContains([]int{BlockDeviceTypeDisk, BlockDeviceTypeLoop, BlockDeviceTypeMultipath}, int(bd.Type)) {
 		log.Warning("setPartitionTable() called on non-disk %q", devFile)
 		return
 	}
 
+	output := partTable.String()
+	bd.PartTable = parsePartitionTable(partTable)
+
+	// parted lists at least the free space of a disk, even an empty one
+	if len(bd.PartTable) == 0 && (bd.Size > 0 || len(bd.Children) > 0) {
+		log.Warning("setPartitionTable() found neither partitions nor free space for %q in the parted output %q",
+			devFile, output)
+	}
+}
+
+// partedHeaders are the unit lines "parted --machine" prints before the disk line
+var partedHeaders = []string{"BYT", "CHS", "CYL"}
+
+// parsePartitionTable parses the "parted --machine print free" output, the unit
+// header, disk line and blank lines are skipped
+func parsePartitionTable(partTable *bytes.Buffer) []*PartedPartition {
+	var partitionList []*PartedPartition
 	var err error
 
-	for _, line := range strings.Split(partTable.String(), ";\n") {
+	for _, line := range strings.Split(partTable.String(), "\n") {
+		line = strings.TrimSuffix(strings.TrimSpace(line), ";")
+		if line == "" || utils.StringSliceContains(partedHeaders, line) || strings.HasPrefix(line, "/") {
+			continue
+		}
+
 		partition := &PartedPartition{}
 
-		log.Debug("setPartitionTable() line is %q", line)
+		log.Debug("parsePartitionTable() line is %q", line)
 
 		fields := strings.Split(line, ":")
 		if len(fields) == 7 {
 			partition.Number, err = strconv.ParseUint(fields[0], 10, 64)
 			if err != nil {
-				log.Warning("setPartitionTable: Failed to parse partition number from: %s", line)
+				log.Warning("parsePartitionTable: Failed to parse partition number from: %s", line)
 			}
 			partition.Start, err = strconv.ParseUint(strings.TrimRight(fields[1], "B"), 10, 64)
 			if err != nil {
-				log.Warning("setPartitionTable: Failed to parse start position from: %s", line)
+				log.Warning("parsePartitionTable: Failed to parse start position from: %s", line)
 			}
 			partition.End, err = strconv.ParseUint(strings.TrimRight(fields[2], "B"), 10, 64)
 			if err != nil {
-				log.Warning("setPartitionTable: Failed to parse end position from: %s", line)
+				log.Warning("parsePartitionTable: Failed to parse end position from: %s", line)
 			}
 			partition.Size, err = strconv.ParseUint(strings.TrimRight(fields[3], "B"), 10, 64)
 			if err != nil {
-				log.Warning("setPartitionTable: Failed to parse partition size from: %s", line)
+				log.Warning("parsePartitionTable: Failed to parse partition size from: %s", line)
 			}
 			partition.FileSystem = fields[4]
 			partition.Name = fields[5]
@@ -1550,23 +1874,38 @@ func (bd *BlockDevice) setPartitionTable(partTable *bytes.Buffer) {
 			partition.Number = 0 // We use 0 to special case as a free partition
 			partition.Start, err = strconv.ParseUint(strings.TrimRight(fields[1], "B"), 10, 64)
 			if err != nil {
-				log.Warning("setPartitionTable: Failed to parse start position from: %s", line)
+				log.Warning("parsePartitionTable: Failed to parse start position from: %s", line)
 			}
 			partition.End, err = strconv.ParseUint(strings.TrimRight(fields[2], "B"), 10, 64)
 			if err != nil {
-				log.Warning("setPartitionTable: Failed to parse end position from: %s", line)
+				log.Warning("parsePartitionTable: Failed to parse end position from: %s", line)
 			}
 			partition.Size, err = strconv.ParseUint(strings.TrimRight(fields[3], "B"), 10, 64)
 			if err != nil {
-				log.Warning("setPartitionTable: Failed to parse partition size from: %s", line)
+				log.Warning("parsePartitionTable: Failed to parse partition size from: %s", line)
 			}
 			partition.FileSystem = fields[4]
 
 			partitionList = append(partitionList, partition)
+			continue
 		}
+
+		log.Debug("parsePartitionTable: Skipping unexpected parted line: %q", line)
 	}
 
-	bd.PartTable = partitionList
+	return partitionList
+}
+
+// getFsLabel returns the label as written by mkfs, that is truncated to the
+// MaxLabelLength of the file system; true is returned if it was truncated
+func (bd *BlockDevice) getFsLabel() (string, bool) {
+	maxLen := MaxLabelLength(bd.FsType)
+
+	if len(bd.Label) > maxLen {
+		return bd.Label[0:maxLen], true
+	}
+
+	return bd.Label, false
 }
 
 func getMakeFsLabel(bd *BlockDevice) []string {
@@ -1574,8 +1913,6 @@ func getMakeFsLabel(bd *BlockDevice) []string {
 	labelArg := "-L"
 
 	if bd.Label != "" {
-		maxLen := MaxLabelLength(bd.FsType)
-
 		if bd.FsType == "vfat" {
 			labelArg = "-n"
 		}
@@ -1584,14 +1921,13 @@ func getMakeFsLabel(bd *BlockDevice) []string {
 			labelArg = "-l"
 		}
 
-		if len(bd.Label) > maxLen {
-			shortLabel := string(bd.Label[0:(maxLen - 1)])
-			log.Warning("Truncating file system label '%s' to %d character label '%s'",
-				bd.FsType, maxLen, shortLabel)
-			bd.Label = shortLabel
+		fsLabel, truncated := bd.getFsLabel()
+		if truncated {
+			log.Warning("Truncating %s file system label '%s' to %d character label '%s'",
+				bd.FsType, bd.Label, len(fsLabel), fsLabel)
 		}
 
-		label = append(label, labelArg, bd.Label)
+		label = append(label, labelArg, fsLabel)
 	}
 
 	return label
@@ -1612,15 +1948,41 @@ func commonMakeFsCommand(bd *BlockDevice, args []string) ([]string, error) {
 	return cmd, nil
 }
 
+// partitionName returns the GPT partition name of bd, the configured
+// PartitionLabel if any or the name the partition type uses by default
+func partitionName(bd *BlockDevice, defaultName string) string {
+	if bd.PartitionLabel != "" {
+		return bd.PartitionLabel
+	}
+
+	return defaultName
+}
+
 func commonMakePartCommand(bd *BlockDevice) (string, error) {
 	args := []string{
 		"mkpart",
-		bd.MountPoint,
+		partitionName(bd, bd.MountPoint),
 	}
 
 	return strings.Join(args, " "), nil
 }
 
+// createOnlyMakePartCommand names the empty partition after its label
+func createOnlyMakePartCommand(bd *BlockDevice) string {
+	partName := bd.Label
+	if partName == "" {
+		partName = "data"
+	}
+	partName = partitionName(bd, partName)
+
+	args := []string{
+		"mkpart",
+		partName,
+	}
+
+	return strings.Join(args, " ")
+}
+
 func makeEncryptedSwap(bd *BlockDevice) error {
 	args := []string{
 		"wipefs",
@@ -1637,7 +1999,7 @@ func makeEncryptedSwap(bd *BlockDevice) error {
 		"-L",
 		filepath.Base(bd.GetMappedDeviceFile()),
 		bd.GetDeviceFile(),
-		"1M",
+		fmt.Sprintf("%dk", encryptedSwapMarkerSize/1024),
 	}
 
 	err = cmd.RunAndLog(args...)
@@ -1653,7 +2015,7 @@ func swapMakeFsCommand(bd *BlockDevice, args []string) ([]string, error) {
 		"mkswap",
 	}
 
-	if bd.FsType == "swap" && bd.Type == BlockDeviceTypeCrypt {
+	if bd.FsType == "swap" && bd.Type == BlockDeviceTypeCrypt && !bd.luksMapped {
 		// Fake the standard command, and call the special function
 		cmd = []string{
 			"/bin/true",
@@ -1674,7 +2036,7 @@ func swapMakeFsCommand(bd *BlockDevice, args []string) ([]string, error) {
 }
 
 func swapMakePartCommand(bd *BlockDevice) (string, error) {
-	partName := "linux-swap"
+	partName := partitionName(bd, "linux-swap")
 
 	if bd.FsType == "swap" && bd.Type == BlockDeviceTypeCrypt {
 		mapped := fmt.Sprintf("eswap-%s", bd.Name)
@@ -1693,30 +2055,21 @@ func swapMakePartCommand(bd *BlockDevice) (string, error) {
 func vfatMakePartCommand(bd *BlockDevice) (string, error) {
 	args := []string{
 		"mkpart",
-		"EFI",
+		partitionName(bd, "EFI"),
 		"fat32",
 	}
 
 	return strings.Join(args, " "), nil
 }
 
-// MakeImage create an image file considering the total block device size
-func MakeImage(bd *BlockDevice, file string) error {
+// MakeImage create an image file of the format considering the total block device size
+func MakeImage(bd *BlockDevice, file string, format string) error {
 	size, err := bd.DiskSize()
 	if err != nil {
 		return errors.Wrap(err)
 	}
 
-	args := []string{
-		"qemu-img",
-		"create",
-		"-f",
-		"raw",
-		file,
-		fmt.Sprintf("%d", size),
-	}
-
-	err = cmd.RunAndLog(args...)
+	err = cmd.RunAndLog(makeImageArgs(file, format, size)...)
 	if err != nil {
 		return errors.Wrap(err)
 	}
@@ -1760,8 +2113,50 @@ func DetachLoopDevice(file string) {
 	_ = cmd.RunAndLog(args...)
 }
 
+// smartMountOptions are the curated mount options per file system used with
+// SmartMountDefaults, for rotational and non rotational (SSD) devices
+var smartMountOptions = map[string]struct{ rotational, ssd string }{
+	"btrfs": {rotational: "defaults,space_cache=v2", ssd: "defaults,ssd,space_cache=v2"},
+	"xfs":   {rotational: "defaults,inode64", ssd: "defaults,inode64"},
+	"ext4":  {rotational: "defaults", ssd: "defaults,noatime"},
+	"f2fs":  {rotational: "defaults", ssd: "defaults,noatime"},
+}
+
+// getMountOptions returns the fstab mount options of bd; explicit MountOptions
+// take precedence over the SmartMountDefaults chosen from the file system and
+// the device rotation
+func getMountOptions(bd *BlockDevice, rotational bool, mediaOpts MediaOpts) string {
+	if bd.MountOptions != "" {
+		return bd.MountOptions
+	}
+
+	if !mediaOpts.SmartMountDefaults {
+		return "defaults"
+	}
+
+	options, found := smartMountOptions[bd.FsType]
+	if !found {
+		return "defaults"
+	}
+
+	if rotational {
+		return options.rotational
+	}
+
+	return options.ssd
+}
+
+// fsckPass returns the fstab fsck pass of bd, the root is checked first
+func fsckPass(bd *BlockDevice) string {
+	if bd.MountPoint == "/" {
+		return "1"
+	}
+
+	return "2"
+}
+
 // GenerateTabFiles creates the /etc mounting files if needed
-func GenerateTabFiles(rootDir string, medias []*BlockDevice) error {
+func GenerateTabFiles(rootDir string, medias []*BlockDevice, mediaOpts MediaOpts) error {
 	var crypttab []string
 	var fstab []string
 	var errFound bool
@@ -1769,29 +2164,87 @@ func GenerateTabFiles(rootDir string, medias []*BlockDevice) error {
 	// First create a list of all children we need to check
 	var childrenToCheck []*BlockDevice
 
+	// New partitions do not know the rotation nor the sector size of their disk
+	rotational := map[*BlockDevice]bool{}
+	logicalSector := map[*BlockDevice]uint64{}
+
+	// systemd-gpt-auto-generator only mounts the partitions of the root's disk
+	disk := map[*BlockDevice]*BlockDevice{}
+	var rootDisk *BlockDevice
+
 	for _, curr := range medias {
-		childrenToCheck = append(childrenToCheck, curr.FindAllChildren()...)
+		for _, ch := range curr.FindAllChildren() {
+			disk[ch] = curr
+			if ch.MountPoint == "/" {
+				rootDisk = curr
+			}
+			rotational[ch] = curr.Rotational || ch.Rotational
+			logicalSector[ch] = curr.LogicalSector
+			if ch.LogicalSector > logicalSector[ch] {
+				logicalSector[ch] = ch.LogicalSector
+			}
+			childrenToCheck = append(childrenToCheck, ch)
+		}
 	}
 
+	// Nested mount points (i.e. /var/log) must be listed after their parent (i.e. /var)
+	childrenToCheck = SortByMountPoint(childrenToCheck)
+
 	for _, ch := range childrenToCheck {
 		// Handle Encrypted partitions
 		var ctab []string
 		var ftab []string
 
+		// Empty partitions are neither formatted nor mounted
+		if ch.CreateOnly {
+			continue
+		}
+
+		options := xfsMountOptions(ch, atimeMountOptions(getMountOptions(ch, rotational[ch], mediaOpts), mediaOpts))
+		options = discardMountOptions(ch, options, rotational[ch], mediaOpts)
+
+		// The read-only root needs a fstab entry, auto mounted it is writable
+		readOnlyRoot := mediaOpts.ReadOnlyRoot && ch.MountPoint == "/"
+		if readOnlyRoot {
+			options = readOnlyMountOptions(options)
+		}
+
+		autoMounted := ch.isStandardMount() && (rootDisk == nil || disk[ch] == rootDisk)
+
+		// Auto mounted the partitions would get the defaults, without the
+		// mount options, atime mode nor discard
+		forceEntry := options != "defaults" && ch.MountPoint != ""
+
 		if ch.Type == BlockDeviceTypeCrypt {
-			if ch.FsType == "swap" {
+			if ch.FsType == "swap" && mediaOpts.PersistentSwapKey {
+				// No key file, systemd-cryptsetup tries the passphrase cached
+				// when unlocking the root before asking for it
+				ctab = append(ctab, filepath.Base(ch.MappedName), ch.GetDeviceID(),
+					"none", "luks")
+
+				ftab = append(ftab, ch.GetMappedDeviceFile(), "none",
+					"swap", "defaults", "0", "0")
+			} else if ch.FsType == "swap" {
 				ctab = append(ctab, filepath.Base(ch.MappedName), ch.GetDeviceID(),
-					"/dev/urandom",
-					fmt.Sprintf("swap,offset=2048,cipher=%s,size=%d",
-						EncryptCipher, EncryptKeySize))
+					"/dev/urandom", encryptedSwapOptions(logicalSector[ch]))
 
 				ftab = append(ftab, ch.GetMappedDeviceFile(), "none",
 					"swap", "defaults", "0", "0")
 			} else {
-				if !ch.isStandardMount() {
+				if ch.PassphraseFile != "" && ch.MountPoint != "/" {
+					// Listed even when auto mounted, its own passphrase is
+					// asked at boot
+					ctab = append(ctab, filepath.Base(ch.MappedName), ch.GetDeviceID(), "none")
+					ftab = append(ftab, ch.GetMappedDeviceFile(), ch.MountPoint,
+						ch.FsType, options, "0", fsckPass(ch))
+				} else if !autoMounted {
 					ctab = append(ctab, filepath.Base(ch.MappedName), ch.GetDeviceID())
 					ftab = append(ftab, ch.GetMappedDeviceFile(), ch.MountPoint,
-						ch.FsType, "defaults", "0", "2")
+						ch.FsType, options, "0", fsckPass(ch))
+				} else if readOnlyRoot || (forceEntry && ch.MountPoint == "/") {
+					// The root is unlocked by the initrd, only its mount is listed
+					ftab = append(ftab, ch.GetMappedDeviceFile(), ch.MountPoint,
+						ch.FsType, options, "0", fsckPass(ch))
 				}
 			}
 		} else if ch.Type == BlockDeviceTypeLVM2Volume {
@@ -1800,12 +2253,21 @@ func GenerateTabFiles(rootDir string, medias []*BlockDevice) error {
 					"swap", "defaults", "0", "0")
 			} else {
 				ftab = append(ftab, ch.GetDeviceID(), ch.MountPoint,
-					ch.FsType, "defaults", "0", "2")
-			}
+					ch.FsType, options, "0", fsckPass(ch))
+			}
+		} else if ch.BtrfsProfile != "" {
+			// The root is auto mounted but the members of a multi-device btrfs
+			// are only assembled once scanned, the fstab entry lets systemd wait
+			// for the btrfs udev rules to report all of them ready
+			ftab = append(ftab, ch.GetDeviceID(), ch.MountPoint,
+				ch.FsType, options, "0", "0")
 		} else {
-			if !ch.isStandardMount() && ch.MountPoint != "" {
+			// Auto mounted partitions need a fstab entry to get the quotas
+			// or the mount options
+			if (!autoMounted || len(ch.xfsQuotas()) > 0 || readOnlyRoot || forceEntry) &&
+				ch.MountPoint != "" {
 				ftab = append(ftab, ch.GetDeviceID(), ch.MountPoint,
-					ch.FsType, "defaults", "0", "2")
+					ch.FsType, options, "0", fsckPass(ch))
 			}
 		}
 
@@ -1869,6 +2331,9 @@ type InstallTarget struct {
 	Advanced  bool   // Was this disk configured via advanced mode?
 	FreeStart uint64 // Starting position of free space
 	FreeEnd   uint64 // Ending position of free space
+	ESP       string // Existing EFI System Partition which may be reused as /boot
+
+	ActiveMembers []string // Active RAID arrays or logical volumes using the disk
 }
 
 const (
@@ -1909,20 +2374,24 @@ func sortInstallTargets(targets []InstallTarget) []InstallTarget {
 }
 
 // FindSafeInstallTargets creates an order list of possible installation targets
-// Only disk with gpt partition are safe to use
+// Only disk with the requested partition table, gpt by default, are safe to use
 // There must be at least 3 free partition in the table (gpt can have 127)
 // There must be at least minSize free space on the disk
-func FindSafeInstallTargets(rootSize uint64, medias []*BlockDevice) []InstallTarget {
+// A msdos partition table is only used for whole disk installs
+func FindSafeInstallTargets(rootSize uint64, medias []*BlockDevice, mediaOpts MediaOpts) []InstallTarget {
 	var installTargets []InstallTarget
+	ptType := mediaOpts.GetPartitionTable()
 
 	// Add the default boot and swap to the passed root size
 	minSize := rootSize + bootSizeDefault
 	minSizeStr, _ := HumanReadableSizeXiBWithPrecision(minSize, 1)
 
-	FilterBlockDevices(medias,
-		// Firstly, we filter out non-gpt partitions
+	medias = FilterBlockDevices(medias,
+		// Never consider the explicitly excluded devices
+		ExcludeDevicesFilter(mediaOpts.ExcludeDevices),
+		// Firstly, we filter out the partition tables of another type
 		func(curr *BlockDevice) bool {
-			if curr.PtType != "gpt" && curr.PtType != "" {
+			if curr.PtType != "" && curr.partitionTable() != ptType {
 				log.Debug("FindSafeInstallTargets: ignoring disk %s with partition table type %s",
 					curr.Name, curr.PtType)
 				return false
@@ -1960,12 +2429,27 @@ func FindSafeInstallTargets(rootSize uint64, medias []*BlockDevice) []InstallTar
 			continue
 		}
 
+		if ptType == PartitionTableMSDOS {
+			log.Debug("FindSafeInstallTargets: ignoring partitioned disk %s, msdos requires a whole disk",
+				curr.Name)
+			continue
+		}
+
 		// Fourthly, we want to select Block Devices whose
 		// largest contingous space satisfies the minSize required for installation
 		if start, end := curr.LargestContiguousFreeSpace(minSize); start != 0 && end != 0 {
-			installTargets = append(installTargets,
-				InstallTarget{Name: curr.Name, Friendly: curr.Model,
-					Removable: curr.RemovableDevice, FreeStart: start, FreeEnd: end})
+			target := InstallTarget{Name: curr.Name, Friendly: curr.Model,
+				Removable: curr.RemovableDevice, FreeStart: start, FreeEnd: end}
+
+			// An existing EFI System Partition, i.e. a dual boot with
+			// Windows, may be reused as /boot if explicitly selected
+			if esp := curr.FindESP(); esp != nil && !mediaOpts.LegacyBoot() {
+				target.ESP = esp.Name
+				log.Debug("FindSafeInstallTargets: found EFI System Partition %s on disk %s",
+					esp.Name, curr.Name)
+			}
+
+			installTargets = append(installTargets, target)
 			log.Debug("FindSafeInstallTargets: Room on disk %s: %d to %d", curr.Name, start, end)
 			continue
 		}
@@ -1979,7 +2463,7 @@ func FindSafeInstallTargets(rootSize uint64, medias []*BlockDevice) []InstallTar
 
 // FindAllInstallTargets creates an order list of all possible installation targets
 // There must be at least minSize free space on the disk
-func FindAllInstallTargets(rootSize uint64, medias []*BlockDevice) []InstallTarget {
+func FindAllInstallTargets(rootSize uint64, medias []*BlockDevice, mediaOpts MediaOpts) []InstallTarget {
 	var installTargets []InstallTarget
 
 	// Add the default boot and swap to the passed root size
@@ -1987,11 +2471,18 @@ func FindAllInstallTargets(rootSize uint64, medias []*BlockDevice) []InstallTarg
 
 	// All Disk are possible destructive installs
 	FilterBlockDevices(medias,
+		// Never consider the explicitly excluded devices
+		ExcludeDevicesFilter(mediaOpts.ExcludeDevices),
 		func(curr *BlockDevice) bool {
 			if curr.Size >= minSize {
 				target := InstallTarget{Name: curr.Name, Friendly: curr.Model,
 					WholeDisk: true, Removable: curr.RemovableDevice, EraseDisk: true,
-					FreeStart: 0, FreeEnd: curr.Size}
+					FreeStart: 0, FreeEnd: curr.Size, ActiveMembers: curr.ActiveMembers()}
+
+				if len(target.ActiveMembers) > 0 {
+					log.Warning("FindAllInstallTargets: disk %s is a member of the active %s", curr.Name,
+						strings.Join(target.ActiveMembers, ", "))
+				}
 
 				installTargets = append(installTargets, target)
 				log.Debug("FindAllInstallTargets: found whole disk %s", curr.Name)
@@ -2008,6 +2499,12 @@ func FindAllInstallTargets(rootSize uint64, medias []*BlockDevice) []InstallTarg
 	return sortInstallTargets(installTargets)
 }
 
+// holdsFileSystem returns true if the existing partition was scanned with a
+// file system, so possibly holding data; a swap holds none
+func (bd *BlockDevice) holdsFileSystem() bool {
+	return !bd.MakePartition && bd.UUID != "" && bd.FsType != "" && bd.FsTypeNotSwap()
+}
+
 // FindAdvancedInstallTargets creates a list of advanced installation targets
 // We use Partition Labels to tag and convey which partitions should be used
 // for an advanced installations.
@@ -2020,13 +2517,17 @@ func FindAllInstallTargets(rootSize uint64, medias []*BlockDevice) []InstallTarg
 //		included in the install like /srv, /home, ...
 //
 // Appending "_E" to the label marks it for encryption; not valid for CLR_BOOT
-// Appending "_F" to the label marks it for formatting (newfs)
+// Appending "_F" to the label marks it for formatting (newfs); it is the only
+// trigger, the partitions without it are mounted with their existing file system
 func hasAdvancedInstallTarget(medias []*BlockDevice) bool {
 	clrFound := false
 
 	for _, bd := range medias {
 		label := bd.PartitionLabel
 
+		// File system used when the partition has none and is formatted
+		defaultFs := ""
+
 		if label != "" {
 			log.Debug("FindAdvancedInstallTargets: Found partition %s with name %s", bd.Name, label)
 		}
@@ -2062,37 +2563,19 @@ func hasAdvancedInstallTarget(medias []*BlockDevice) bool {
 				}
 				log.Debug("FindAdvancedInstallTargets: Boot is %s", bd.Name)
 				bd.LabeledAdvanced = true
-				if bd.FsType == "" {
-					log.Debug("FindAdvancedInstallTargets: No FsType set for %s, defaulting to %s",
-						bd.Name, defaultBootFsType)
-					bd.FsType = defaultBootFsType
-					log.Debug("FindAdvancedInstallTargets: Forcing Format partition %s enabled", bd.Name)
-					bd.FormatPartition = true
-				}
+				defaultFs = defaultBootFsType
 				clrFound = true
 				bd.MountPoint = "/boot"
 			case "root":
 				log.Debug("FindAdvancedInstallTargets: Root is %s", bd.Name)
 				bd.LabeledAdvanced = true
-				if bd.FsType == "" {
-					log.Debug("FindAdvancedInstallTargets: No FsType set for %s, defaulting to %s",
-						bd.Name, defaultFsType)
-					bd.FsType = defaultFsType
-					log.Debug("FindAdvancedInstallTargets: Forcing Format partition %s enabled", bd.Name)
-					bd.FormatPartition = true
-				}
+				defaultFs = defaultFsType
 				clrFound = true
 				bd.MountPoint = "/"
 			case "swap":
 				log.Debug("FindAdvancedInstallTargets: Swap on %s", bd.Name)
 				bd.LabeledAdvanced = true
-				if bd.FsType == "" {
-					log.Debug("FindAdvancedInstallTargets: No FsType set for %s, defaulting to %s",
-						bd.Name, "swap")
-					bd.FsType = "swap"
-					log.Debug("FindAdvancedInstallTargets: Forcing Format partition %s enabled", bd.Name)
-					bd.FormatPartition = true
-				}
+				defaultFs = "swap"
 				clrFound = true
 			case "mnt":
 				mntParts := strings.Split(label, "MNT_")
@@ -2103,13 +2586,7 @@ func hasAdvancedInstallTarget(medias []*BlockDevice) bool {
 
 						bd.MountPoint = path
 						bd.LabeledAdvanced = true
-						if bd.FsType == "" {
-							log.Debug("FindAdvancedInstallTargets: No FsType set for %s, defaulting to %s",
-								bd.Name, defaultFsType)
-							bd.FsType = defaultFsType
-							log.Debug("FindAdvancedInstallTargets: Forcing Format partition %s enabled", bd.Name)
-							bd.FormatPartition = true
-						}
+						defaultFs = defaultFsType
 						clrFound = true
 					}
 				}
@@ -2120,6 +2597,19 @@ func hasAdvancedInstallTarget(medias []*BlockDevice) bool {
 			}
 		}
 
+		// Only the _F suffix formats a partition, an existing file system is
+		// reused as is and a partition without one is refused by the validation
+		if bd.FsType == "" && defaultFs != "" {
+			if bd.FormatPartition {
+				log.Debug("FindAdvancedInstallTargets: No FsType set for %s, defaulting to %s",
+					bd.Name, defaultFs)
+				bd.FsType = defaultFs
+			} else {
+				log.Warning("FindAdvancedInstallTargets: %s has no file system and is not marked for formatting",
+					bd.Name)
+			}
+		}
+
 		if len(bd.Children) > 0 {
 			log.Debug("FindAdvancedInstallTargets: %s partition has children %d, pushing recurse ...",
 				bd.Name, len(bd.Children))
@@ -2131,10 +2621,10 @@ func hasAdvancedInstallTarget(medias []*BlockDevice) bool {
 	return clrFound
 }
 
-func FindAdvancedInstallTargets(medias []*BlockDevice) []*BlockDevice {
+func FindAdvancedInstallTargets(medias []*BlockDevice, mediaOpts MediaOpts) []*BlockDevice {
 	var targetMedias []*BlockDevice
 
-	for _, curr := range medias {
+	for _, curr := range FilterBlockDevices(medias, ExcludeDevicesFilter(mediaOpts.ExcludeDevices)) {
 		var installBlockDevice *BlockDevice
 		installBlockDevice = curr.Clone()
 
@@ -2262,12 +2752,21 @@ func logMissingPartition(label string) string {
 	return logPartitionWarning(nil, "Missing %s partition", label)
 }
 
-// Helper to validatePartitions for validating boot minimum size etc
+// Helper to validatePartitions for validating boot minimum size etc, with
+// splitBoot /boot is the XBOOTLDR partition and /boot/efi the ESP
 func validateBoot(found *bool, bd *BlockDevice, mediaOpts MediaOpts, bootLabel string) []string {
 	var results []string
 
+	if mediaOpts.SplitBoot && bd.MountPoint == ESPMountPoint {
+		return validateSplitESP(found, bd, mediaOpts)
+	}
+
 	if bd.MountPoint == "/boot" {
-		if *found {
+		if mediaOpts.SplitBoot {
+			bootLabel = bootLabel + " (XBOOTLDR)"
+		}
+
+		if *found && mediaOpts.PrimaryBoot == "" {
 			results = append(results, logPartitionWarning(bd, "Found multiple %s partitions", bootLabel))
 		} else {
 			*found = true
@@ -2275,7 +2774,12 @@ func validateBoot(found *bool, bd *BlockDevice, mediaOpts MediaOpts, bootLabel s
 				results = append(results, logPartitionMustBeWarning(bd, bootLabel, "vfat"))
 			}
 		}
-		if bd.Size == 0 {
+		if bd.ReuseESP && mediaOpts.SplitBoot {
+			// The reused ESP would be the XBOOTLDR, not the boot loader partition
+			results = append(results, logPartitionWarning(bd, "%s can not reuse an ESP with splitBoot", bootLabel))
+		} else if bd.ReuseESP {
+			results = append(results, validateReuseESP(bd, mediaOpts, bootLabel)...)
+		} else if bd.Size == 0 {
 			log.Warning("validatePartitions: Skipping %s size check due to zero size", bootLabel)
 		} else if mediaOpts.SkipValidationSize {
 			log.Warning("validatePartitions: Skipping %s size check due to skipSize", bootLabel)
@@ -2319,14 +2823,25 @@ func validateRoot(found *bool, bd *BlockDevice,
 }
 
 // Helper to validatePartitions for validating Swap minimum size etc
-func validateSwap(found *bool, bd *BlockDevice, skipSize bool, swapLabel string) []string {
+func validateSwap(found *bool, bd *BlockDevice, skipSize bool, hibernate bool, persistentKey bool,
+	swapLabel string) []string {
 	var results []string
 
 	*found = true
+	if hibernate && bd.Type == BlockDeviceTypeCrypt && !persistentKey {
+		results = append(results, logPartitionWarning(bd,
+			"%s encrypted with a random key can not be used for hibernation", swapLabel))
+	}
+
 	if bd.Size == 0 {
 		log.Warning("validatePartitions: Skipping swap size check due to zero size")
 	} else if skipSize {
 		log.Warning("validatePartitions: Skipping swap size check due to skipSize")
+	} else if hibernate {
+		// Hibernation stores the whole memory content in the swap
+		if memSize := physicalMemory(); bd.Size < memSize {
+			results = append(results, logPartitionSizeWarning(bd, memSize, swapLabel))
+		}
 	} else {
 		if bd.Size < minSwapSize {
 			results = append(results, logPartitionSizeWarning(bd, minSwapSize, swapLabel))
@@ -2344,7 +2859,7 @@ func validateSwap(found *bool, bd *BlockDevice, skipSize bool, swapLabel string)
 func validateBootLegacy(rootBlockDevice *BlockDevice, rootLabel, bootLabel string, mediaOpts MediaOpts) []string {
 	var results []string
 
-	if mediaOpts.LegacyBios {
+	if mediaOpts.LegacyBoot() {
 		if rootBlockDevice != nil {
 			if !(rootBlockDevice.isExtFsType()) {
 				// xfs currently not supported due to partition table of MBR requirement
@@ -2393,7 +2908,7 @@ func validateVarPartition(rootBlockDevice *BlockDevice, skipSize bool, varSize u
 
 // Helper to validatePartitions for validating Swap minimum size etc
 func validateSwapFile(swapFileSize string, rootBlockDevice *BlockDevice,
-	skipSize bool, varFound bool, varSize uint64) []string {
+	skipSize bool, hibernate bool, varFound bool, varSize uint64) []string {
 	var results []string
 	var checkSwapSize uint64
 	var err error
@@ -2430,7 +2945,15 @@ func validateSwapFile(swapFileSize string, rootBlockDevice *BlockDevice,
 			}
 		}
 
-		if !skipSize {
+		if !skipSize && hibernate {
+			// Hibernation stores the whole memory content in the swap
+			if memSize := physicalMemory(); checkSwapSize < memSize {
+				size, _ := HumanReadableSizeXiBWithPrecision(memSize, 1)
+				results = append(results, logPartitionMustBeWarning(nil,
+					fmt.Sprintf("swapfile (%s)", checkSizeString),
+					fmt.Sprintf(">= RAM (%s)", size)))
+			}
+		} else if !skipSize {
 			if checkSwapSize < minSwapSize {
 				size, _ := HumanReadableSizeXiBWithPrecision(minSwapSize, 1)
 				results = append(results, logPartitionMustBeWarning(nil,
@@ -2461,6 +2984,134 @@ func validateSwapFile(swapFileSize string, rootBlockDevice *BlockDevice,
 	return results
 }
 
+// validatePartitionLabel checks the GPT partition name fits the 36 UTF-16
+// characters of the GPT entry and can be passed as a single parted argument
+func validatePartitionLabel(bd *BlockDevice) []string {
+	results := []string{}
+
+	if utf8.RuneCountInString(bd.PartitionLabel) > maxPartitionLabelLength {
+		results = append(results, utils.Locale.Get("Partition label %s of %s is longer than %d characters",
+			bd.PartitionLabel, bd.Name, maxPartitionLabelLength))
+	}
+
+	if strings.ContainsAny(bd.PartitionLabel, " \t'\":;") {
+		results = append(results, utils.Locale.Get("Partition label %s of %s can not contain spaces, quotes, ':' or ';'",
+			bd.PartitionLabel, bd.Name))
+	}
+
+	return results
+}
+
+// validatePersistentSwapKey checks the swap to encrypt with a persistent LUKS
+// key is encrypted and the root passphrase, it is unlocked with, exists
+func validatePersistentSwapKey(children []*BlockDevice, root *BlockDevice, swapLabel string) []string {
+	results := []string{}
+
+	encryptedSwap := false
+	for _, ch := range children {
+		encryptedSwap = encryptedSwap || (ch.FsType == "swap" && ch.Type == BlockDeviceTypeCrypt)
+	}
+
+	if !encryptedSwap {
+		results = append(results, utils.Locale.Get("A persistent swap key requires an encrypted %s partition",
+			swapLabel))
+	}
+
+	if root == nil || root.Type != BlockDeviceTypeCrypt {
+		results = append(results,
+			utils.Locale.Get("A persistent swap key requires an encrypted root partition to share its passphrase"))
+	}
+
+	return results
+}
+
+// validateNoSwap refuses the options requiring a swap when swap is disabled
+func validateNoSwap(mediaOpts MediaOpts) []string {
+	results := []string{}
+
+	if mediaOpts.HibernationSupport {
+		results = append(results, utils.Locale.Get("Hibernation requires a swap partition or a swapfile"))
+	}
+
+	if mediaOpts.SwapFileSet {
+		if size, err := ParseVolumeSize(mediaOpts.SwapFileSize); err != nil || size > 0 {
+			results = append(results, utils.Locale.Get("A swapfile can not be created when swap is disabled"))
+		}
+	}
+
+	return results
+}
+
+// validateDiskCapacity refuses the disks whose declared partitions do not fit,
+// the max-fill partition of size 0 requires some free space to be left; it
+// runs before any destructive action as parted would otherwise fail midway
+func validateDiskCapacity(medias []*BlockDevice) []string {
+	results := []string{}
+
+	for _, bd := range medias {
+		if bd.Size == 0 {
+			continue
+		}
+
+		var total uint64
+		maxFill := false
+		newPartition := false
+
+		for _, ch := range bd.Children {
+			newPartition = newPartition || ch.MakePartition
+			if ch.MakePartition && ch.Size == 0 {
+				maxFill = true
+			}
+			total += ch.Size
+		}
+
+		if !newPartition || total < bd.Size || (total == bd.Size && !maxFill) {
+			continue
+		}
+
+		totalSize, _ := HumanReadableSizeXiBWithPrecision(total, 1)
+		diskSize, _ := HumanReadableSizeXiBWithPrecision(bd.Size, 1)
+
+		var mesg string
+		if total == bd.Size {
+			mesg = utils.Locale.Get("Partitions total %s leaves no room for the max-fill partition on %s (%s)",
+				totalSize, bd.Name, diskSize)
+		} else {
+			mesg = utils.Locale.Get("Partitions total %s exceeds disk %s (%s)", totalSize, bd.Name, diskSize)
+		}
+		log.Warning("validatePartitions: %s", mesg)
+		results = append(results, mesg)
+	}
+
+	return results
+}
+
+// validateSectorSizes refuses multi-disk layouts mixing disks of different
+// logical or physical sector sizes, i.e. 512e and 4Kn disks; disks of
+// unknown sector sizes are not checked
+func validateSectorSizes(medias []*BlockDevice) []string {
+	var first *BlockDevice
+
+	for _, curr := range medias {
+		if curr.LogicalSector == 0 || curr.PhysicalSector == 0 {
+			continue
+		}
+
+		if first == nil {
+			first = curr
+			continue
+		}
+
+		if curr.LogicalSector != first.LogicalSector || curr.PhysicalSector != first.PhysicalSector {
+			return []string{utils.Locale.Get("Sector size of %s (%d/%d) does not match %s (%d/%d)",
+				curr.Name, curr.LogicalSector, curr.PhysicalSector,
+				first.Name, first.LogicalSector, first.PhysicalSector)}
+		}
+	}
+
+	return nil
+}
+
 // validatePartitions returns an array of validation error strings
 func validatePartitions(rootSize uint64, medias []*BlockDevice, mediaOpts MediaOpts, advancedMode bool) []string {
 	results := []string{}
@@ -2468,20 +3119,23 @@ func validatePartitions(rootSize uint64, medias []*BlockDevice, mediaOpts MediaO
 	bootLabel := "/boot"
 	swapLabel := "[swap]"
 	varLabel := "/var"
+	varLogLabel := "/var/log"
 
 	if advancedMode {
 		rootLabel = "CLR_ROOT"
 		bootLabel = "CLR_BOOT"
 		swapLabel = "CLR_SWAP"
 		varLabel = "CLR_MNT_/var"
+		varLogLabel = "CLR_MNT_/var/log"
 	}
 
 	bootFound := false
+	espFound := false
 	swapFound := false
 	rootFound := false
 	varFound := false
 	var varSize uint64
-	var rootBlockDevice *BlockDevice
+	var rootBlockDevice, varBlockDevice *BlockDevice
 
 	// If we are validating without media, special case results
 	if medias == nil || len(medias) == 0 {
@@ -2489,6 +3143,19 @@ func validatePartitions(rootSize uint64, medias []*BlockDevice, mediaOpts MediaO
 		return results
 	}
 
+	if !mediaOpts.AllowMixedSectorSizes {
+		results = append(results, validateSectorSizes(medias)...)
+	}
+
+	if !mediaOpts.SkipValidationSize {
+		results = append(results, validateDiskCapacity(medias)...)
+	}
+
+	results = append(results, validateBtrfsRaid(medias)...)
+	results = append(results, validateXfs(medias)...)
+	results = append(results, validatePartitionOrder(medias)...)
+	results = append(results, validateSplitBoot(medias, mediaOpts)...)
+
 	// First create a list of all children we need to check
 	var childrenToCheck []*BlockDevice
 
@@ -2496,8 +3163,41 @@ func validatePartitions(rootSize uint64, medias []*BlockDevice, mediaOpts MediaO
 		childrenToCheck = append(childrenToCheck, curr.FindAllChildren()...)
 	}
 
+	results = append(results, validateMountPointConflicts(childrenToCheck, mediaOpts)...)
+
 	for _, ch := range childrenToCheck {
-		if ch.MountPoint == "/boot" || (advancedMode && ch.Label == bootLabel) {
+		if ch.MakePartition && ch.PartitionLabel != "" {
+			results = append(results, validatePartitionLabel(ch)...)
+		}
+		if ch.ReusePartition {
+			results = append(results, validateReusePartition(ch)...)
+		}
+		if ch.CreateOnly {
+			if ch.MountPoint != "" || ch.Type == BlockDeviceTypeCrypt {
+				results = append(results,
+					utils.Locale.Get("Create only partition %s can not be mounted nor encrypted", ch.Name))
+			}
+			continue
+		}
+		if ch.FormatPartition && mediaOpts.LabelPolicy == LabelPolicyError {
+			if fsLabel, truncated := ch.getFsLabel(); truncated {
+				results = append(results,
+					utils.Locale.Get("Label %s of %s is longer than %d characters", ch.Label, ch.Name, len(fsLabel)))
+			}
+		}
+		if strings.ContainsAny(ch.MountOptions, " \t") {
+			results = append(results,
+				utils.Locale.Get("Mount options of %s can not contain spaces", ch.Name))
+		} else if ch.MountOptions != "" {
+			if _, _, err := parseMountOptions(ch.MountOptions); err != nil {
+				results = append(results,
+					utils.Locale.Get("Invalid mount options of %s: %s", ch.Name, err.Error()))
+			}
+		}
+		results = append(results, validateMountOwnership(ch)...)
+		if mediaOpts.SplitBoot && ch.MountPoint == ESPMountPoint {
+			results = append(results, validateBoot(&espFound, ch, mediaOpts, bootLabel)...)
+		} else if ch.MountPoint == "/boot" || (advancedMode && ch.Label == bootLabel) {
 			results = append(results, validateBoot(&bootFound, ch, mediaOpts, bootLabel)...)
 		}
 		if ch.MountPoint == "/" || (advancedMode && ch.Label == rootLabel) {
@@ -2507,11 +3207,23 @@ func validatePartitions(rootSize uint64, medias []*BlockDevice, mediaOpts MediaO
 			results = append(results, newResults...)
 		}
 		if ch.FsType == "swap" || (advancedMode && ch.Label == swapLabel) {
-			results = append(results, validateSwap(&swapFound, ch, mediaOpts.SkipValidationSize, swapLabel)...)
+			if mediaOpts.NoSwap {
+				results = append(results,
+					utils.Locale.Get("Swap partition %s can not be used when swap is disabled", ch.Name))
+				continue
+			}
+			results = append(results, validateSwap(&swapFound, ch, mediaOpts.SkipValidationSize,
+				mediaOpts.HibernationSupport, mediaOpts.PersistentSwapKey, swapLabel)...)
 		}
 		if ch.MountPoint == "/var" || (advancedMode && ch.Label == varLabel) {
 			varFound = true
 			varSize = ch.Size
+			varBlockDevice = ch
+		}
+		if ch.MountPoint == "/var/log" || (advancedMode && ch.Label == varLogLabel) {
+			// Unlike /var, swupd does not store content under /var/log
+			// so a dedicated partition has no size requirements
+			log.Info("validatePartitions: Using independent /var/log partition %s", ch.Name)
 		}
 	}
 
@@ -2528,10 +3240,32 @@ func validatePartitions(rootSize uint64, medias []*BlockDevice, mediaOpts MediaO
 			mediaOpts.SkipValidationSize, varSize)...)
 	}
 
+	if mediaOpts.PersistentSwapKey {
+		results = append(results, validatePersistentSwapKey(childrenToCheck, rootBlockDevice, swapLabel)...)
+	}
+
+	if mediaOpts.NoSwap {
+		results = append(results, validateNoSwap(mediaOpts)...)
+		return results
+	}
+
 	// If no swap partition found or the swapfile size was manually set
 	if !swapFound || mediaOpts.SwapFileSet {
 		results = append(results, validateSwapFile(mediaOpts.SwapFileSize, rootBlockDevice,
-			mediaOpts.SkipValidationSize, varFound, varSize)...)
+			mediaOpts.SkipValidationSize, mediaOpts.HibernationSupport, varFound, varSize)...)
+	}
+
+	// The swapfile is created on /var if it is a separate partition, see SwapFilePartition
+	if size, err := ParseVolumeSize(mediaOpts.SwapFileSize); err == nil && size > 0 {
+		swapFileDevice := rootBlockDevice
+		if varBlockDevice != nil {
+			swapFileDevice = varBlockDevice
+		}
+
+		if swapFileDevice != nil && swapFileDevice.FsType == "btrfs" {
+			results = append(results,
+				utils.Locale.Get("Swapfiles are not supported on btrfs, use a swap partition instead"))
+		}
 	}
 
 	return results
@@ -2560,6 +3294,11 @@ func validateAdvancedPartitions(rootSize uint64, medias []*BlockDevice, mediaOpt
 	valResults := validatePartitions(rootSize, medias, mediaOpts, advancedMode)
 	results = append(results, valResults...)
 
+	if mediaOpts.GetPartitionTable() == PartitionTableMSDOS {
+		results = append(results,
+			utils.Locale.Get("Advanced partitioning is not supported with a msdos partition table"))
+	}
+
 	// First create a list of all children we need to check
 	var childrenToCheck []*BlockDevice
 
@@ -2572,6 +3311,11 @@ func validateAdvancedPartitions(rootSize uint64, medias []*BlockDevice, mediaOpt
 		label := ch.PartitionLabel
 		labelUpper := ""
 
+		if ch.LabeledAdvanced && ch.FsType == "" && !ch.FormatPartition {
+			results = append(results,
+				utils.Locale.Get("Partition %s has no file system, append _F to its label to format it", ch.Name))
+		}
+
 		if label != "" {
 			log.Debug("validateAdvancedPartitions: Found partition %s with name %s", ch.Name, label)
 		}
@@ -2711,7 +3455,7 @@ func GetAdvancedPartitions(medias []*BlockDevice) []string {
 			}
 		}
 		if strings.HasPrefix(ch.PartitionLabel, "CLR_SWAP") &&
-			len(validateSwap(&found, ch, false, "CLR_SWAP")) == 0 {
+			len(validateSwap(&found, ch, false, false, false, "CLR_SWAP")) == 0 {
 			if found {
 				ch.FsType = "swap"
 				results = append(results, formatter(ch))
@@ -2731,6 +3475,18 @@ func GetAdvancedPartitions(medias []*BlockDevice) []string {
 	return results
 }
 
+// IsSecondaryBoot returns true if bd is a /boot partition other than the one
+// designated by MediaOpts.PrimaryBoot; secondary /boot partitions are formatted
+// but neither boot flagged nor mounted
+func IsSecondaryBoot(bd *BlockDevice, mediaOpts MediaOpts) bool {
+	if bd.MountPoint != "/boot" || mediaOpts.PrimaryBoot == "" {
+		return false
+	}
+
+	primary := mediaOpts.PrimaryBoot
+	return bd.Name != primary && bd.Label != primary && bd.PartitionLabel != primary
+}
+
 // setBootPartition is a helper function to PrepareInstallationMedia
 // Looks through all of the installation media to determine which
 // partition will be the one from which the install boots
@@ -2753,13 +3509,20 @@ func setBootPartition(medias []*BlockDevice, mediaOpts MediaOpts, dryRun *DryRun
 	}
 
 	style := bootStyleDefault
-	var bootParent, bootBlockDevice, rootParent, rootBlockDevice *BlockDevice
+	var bootParent, bootBlockDevice, rootParent, rootBlockDevice, espParent, espBlockDevice *BlockDevice
+	secondaryBoots := []string{}
 
 	// Check if there is a bootable partition
 	// Clear Linux OS only supports booting from a top level
 	// block device; RAID, LVM, encryption, etc are not supported
 	for _, bd := range medias {
 		for _, curr := range bd.Children {
+			// Secondary /boot partitions are formatted but never flagged
+			if IsSecondaryBoot(curr, mediaOpts) {
+				secondaryBoots = append(secondaryBoots, curr.Name)
+				continue
+			}
+
 			// We have the standard /boot partition
 			if curr.MountPoint == "/boot" {
 				if bootBlockDevice != nil {
@@ -2769,6 +3532,12 @@ func setBootPartition(medias []*BlockDevice, mediaOpts MediaOpts, dryRun *DryRun
 				bootBlockDevice = curr
 			}
 
+			// The EFI System Partition of a split boot layout
+			if mediaOpts.SplitBoot && curr.MountPoint == ESPMountPoint {
+				espParent = bd
+				espBlockDevice = curr
+			}
+
 			if curr.MountPoint == "/" {
 				if rootBlockDevice != nil {
 					return errors.Errorf(logFormatError("Found multiple %s partition names", curr.MountPoint))
@@ -2779,8 +3548,23 @@ func setBootPartition(medias []*BlockDevice, mediaOpts MediaOpts, dryRun *DryRun
 		}
 	}
 
+	if mediaOpts.PrimaryBoot != "" {
+		if bootBlockDevice == nil {
+			return errors.Errorf(logFormatError("Primary %s partition %s not found", "/boot", mediaOpts.PrimaryBoot))
+		}
+
+		if len(secondaryBoots) > 0 {
+			mesg := utils.Locale.Get("Using %s as the primary %s partition, not flagging: %s",
+				bootBlockDevice.Name, "/boot", strings.Join(secondaryBoots, ", "))
+			log.Info(mesg)
+			if dryRun != nil {
+				*dryRun.TargetResults = append(*dryRun.TargetResults, mesg)
+			}
+		}
+	}
+
 	// In case we don't have a viable boot partition
-	if bootBlockDevice == nil && !mediaOpts.LegacyBios {
+	if bootBlockDevice == nil && !mediaOpts.LegacyBoot() {
 		log.Error("No /boot and not in legacy mode!")
 		return errors.Errorf(logFormatError("Found invalid %s partition name", "!BOOT"))
 	}
@@ -2790,8 +3574,16 @@ func setBootPartition(medias []*BlockDevice, mediaOpts MediaOpts, dryRun *DryRun
 		return errors.Errorf(logFormatError("Found invalid %s partition name", "!BOOT/!ROOT"))
 	}
 
-	// If legacyBios mode
-	if mediaOpts.LegacyBios {
+	// The firmware boots from the ESP, the /boot XBOOTLDR partition keeps its type
+	if espBlockDevice != nil && bootBlockDevice != nil {
+		log.Info("setBootPartition: split boot, %s is the XBOOTLDR of the %s ESP",
+			bootBlockDevice.Name, espBlockDevice.Name)
+		bootParent = espParent
+		bootBlockDevice = espBlockDevice
+	}
+
+	// If the boot loader boots in legacy BIOS mode
+	if mediaOpts.LegacyBoot() {
 		style = bootStyleLegacy
 
 		// If legacyBios mode and we do not have a boot, use root
@@ -2826,6 +3618,17 @@ func setBootPartition(medias []*BlockDevice, mediaOpts MediaOpts, dryRun *DryRun
 		}
 	}
 
+	// The msdos tables mark the active partition, legacy_boot is a GPT attribute
+	if bootParent.partitionTable() == PartitionTableMSDOS {
+		style = bootStyleDefault
+	}
+
+	// A reused EFI System Partition is already flagged, its table entry is left as is
+	if bootBlockDevice.ReuseESP {
+		log.Info("setBootPartition: %s is an existing EFI System Partition, not flagging", bootBlockDevice.Name)
+		return nil
+	}
+
 	if dryRun == nil {
 		var prg progress.Progress
 		mesg := utils.Locale.Get("Setting boot partition: %s",
@@ -2852,50 +3655,8 @@ func setBootPartition(medias []*BlockDevice, mediaOpts MediaOpts, dryRun *DryRun
 func getPlannedPartitionChanges(media *BlockDevice) []string {
 	results := []string{}
 
-	// First create a list of all children we need to check
-	var childrenToCheck []*BlockDevice
-
-	if len(media.Children) > 0 {
-		childrenToCheck = append(childrenToCheck, media.FindAllChildren()...)
-	}
-
-	for _, ch := range childrenToCheck {
-		if ch.FormatPartition {
-			partName := ch.Name
-			if partName == "" {
-				partName = ch.GetNewPartitionName(ch.partition)
-			}
-
-			part := fmt.Sprintf("%s: %s", partName,
-				utils.Locale.Get(FormatPartitionInfo, ch.FsType))
-
-			if ch.MountPoint != "" {
-				part = part + fmt.Sprintf(" [%s]", ch.MountPoint)
-			}
-
-			if ch.Type == BlockDeviceTypeCrypt {
-				part = part + " " + utils.Locale.Get("Encrypted")
-			}
-
-			results = append(results, part)
-		} else if ch.MountPoint != "" || !ch.FsTypeNotSwap() {
-			partName := ch.Name
-			if partName == "" {
-				partName = ch.GetNewPartitionName(ch.partition)
-			}
-			part := fmt.Sprintf("%s: %s", partName, utils.Locale.Get(UsePartitionInfo))
-
-			if ch.MountPoint != "" {
-				part = part + fmt.Sprintf(" [%s]", ch.MountPoint)
-			} else if ch.FsType != "" {
-				part = part + fmt.Sprintf(" (%s)", ch.FsType)
-			}
-
-			if ch.Type == BlockDeviceTypeCrypt {
-				part = part + " " + utils.Locale.Get("Encrypted")
-			}
-			results = append(results, part)
-		}
+	for _, part := range getPlannedPartitions(media) {
+		results = append(results, part.String())
 	}
 
 	return results
@@ -2905,28 +3666,5 @@ func getPlannedPartitionChanges(media *BlockDevice) []string {
 // disk and partition planned changes to advise the user before start
 func GetPlannedMediaChanges(targets map[string]InstallTarget, medias []*BlockDevice,
 	mediaOpts MediaOpts) *DryRunType {
-	dryRun := &DryRunType{&[]string{}, &[]string{}}
-
-	if len(targets) != len(medias) {
-		log.Warning("The number of install targets (%d) != media devices (%d)",
-			len(targets), len(medias))
-
-		for _, target := range targets {
-			log.Warning("Install Target: %+v", target)
-		}
-		for _, curr := range medias {
-			log.Warning("Media Device: %+v", curr)
-		}
-	}
-
-	if err := PrepareInstallationMedia(targets, medias, mediaOpts, dryRun); err != nil {
-		log.Warning("PrepareInstallationMedia: %+v", err)
-	}
-
-	if mediaOpts.SwapFileSize != "" {
-		*dryRun.TargetResults = append(*dryRun.TargetResults,
-			fmt.Sprintf("%s (%s)", SwapfileName, mediaOpts.SwapFileSize))
-	}
-
-	return dryRun
+	return NewInstallPlan(targets, medias, mediaOpts).DryRun()
 }
@@ -0,0 +1,69 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"github.com/clearlinux/clr-installer/errors"
+)
+
+const (
+	// BootloaderCBM lets clr-boot-manager install systemd-boot on UEFI
+	// systems or syslinux in legacy BIOS mode; it is the default
+	BootloaderCBM = "clr-boot-manager"
+
+	// BootloaderSystemdBoot installs systemd-boot, managed by clr-boot-manager,
+	// on UEFI systems only
+	BootloaderSystemdBoot = "systemd-boot"
+
+	// BootloaderGRUB installs GRUB, not supported yet
+	BootloaderGRUB = "grub"
+
+	// RequiredBundleBootloader is the bundle providing the supported boot loaders
+	RequiredBundleBootloader = "bootloader"
+)
+
+// GetBootloader returns the selected boot loader, BootloaderCBM if none is set
+func (mo MediaOpts) GetBootloader() string {
+	if mo.Bootloader == "" {
+		return BootloaderCBM
+	}
+
+	return mo.Bootloader
+}
+
+// LegacyBoot returns true if the selected boot loader boots in legacy BIOS mode
+func (mo MediaOpts) LegacyBoot() bool {
+	return mo.LegacyBios && mo.GetBootloader() == BootloaderCBM
+}
+
+// ValidateBootloader checks the selected boot loader is supported and
+// compatible with the legacy BIOS mode
+func ValidateBootloader(mediaOpts MediaOpts) error {
+	switch mediaOpts.GetBootloader() {
+	case BootloaderCBM:
+		return nil
+	case BootloaderSystemdBoot:
+		if mediaOpts.LegacyBios {
+			return errors.ValidationErrorf("Bootloader %q does not support legacyBios", BootloaderSystemdBoot)
+		}
+		return nil
+	case BootloaderGRUB:
+		return errors.ValidationErrorf("Bootloader %q is not supported yet", BootloaderGRUB)
+	}
+
+	return errors.ValidationErrorf("Invalid bootloader value %q, expected %q, %q or %q", mediaOpts.Bootloader,
+		BootloaderCBM, BootloaderSystemdBoot, BootloaderGRUB)
+}
+
+// BootloaderBundle returns the extra bundle providing a non-default boot loader,
+// the default clr-boot-manager is already part of the base install
+func BootloaderBundle(bootloader string) string {
+	switch bootloader {
+	case BootloaderSystemdBoot:
+		return RequiredBundleBootloader
+	}
+
+	return ""
+}
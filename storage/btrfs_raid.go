@@ -0,0 +1,219 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"strings"
+
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+var (
+	// btrfsMinDevices is the number of devices required by each btrfs RAID profile
+	btrfsMinDevices = map[string]int{
+		"raid1":  2,
+		"raid10": 4,
+	}
+
+	// btrfsSizeTolerance is the percentage of size difference allowed between
+	// the members of a btrfs RAID, the smallest one limits the usable space
+	btrfsSizeTolerance = uint64(10)
+)
+
+// LinkBtrfsMembers attaches the btrfsMember partitions to the multi-device
+// btrfs root so MakeFs creates the file system on all of them at once
+func LinkBtrfsMembers(medias []*BlockDevice) {
+	var root *BlockDevice
+	members := []*BlockDevice{}
+
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			if ch.BtrfsMember {
+				members = append(members, ch)
+			} else if ch.BtrfsProfile != "" {
+				root = ch
+			}
+		}
+	}
+
+	if root != nil {
+		root.btrfsMembers = members
+	}
+}
+
+// BtrfsKernelArguments returns the rootflags kernel argument listing the
+// devices of the multi-device btrfs root, the kernel mounts the root before
+// udev scans its members; only the last rootflags argument is honored so the
+// device options of all of the members are joined in a single one
+func BtrfsKernelArguments(medias []*BlockDevice) []string {
+	var root *BlockDevice
+	members := []*BlockDevice{}
+
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			if ch.BtrfsMember {
+				members = append(members, ch)
+			} else if ch.BtrfsProfile != "" {
+				root = ch
+			}
+		}
+	}
+
+	if root == nil || len(members) == 0 {
+		return nil
+	}
+
+	devices := []string{"device=" + root.GetDeviceFile()}
+	for _, curr := range members {
+		devices = append(devices, "device="+curr.GetDeviceFile())
+	}
+
+	return []string{"rootflags=" + strings.Join(devices, ",")}
+}
+
+// btrfsMakeFsCommand adds the data and metadata RAID profile of a
+// multi-device btrfs to the common mkfs command
+func btrfsMakeFsCommand(bd *BlockDevice, args []string) ([]string, error) {
+	cmd, err := commonMakeFsCommand(bd, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if bd.BtrfsProfile != "" {
+		cmd = append(cmd, "-d", bd.BtrfsProfile, "-m", bd.BtrfsProfile)
+	}
+
+	return cmd, nil
+}
+
+// makeFsDevices returns the device files the file system of bd is created on,
+// the members of a multi-device btrfs follow its first device
+func (bd *BlockDevice) makeFsDevices() []string {
+	devices := []string{bd.GetMappedDeviceFile()}
+
+	for _, curr := range bd.btrfsMembers {
+		devices = append(devices, curr.GetMappedDeviceFile())
+	}
+
+	return devices
+}
+
+// validateBtrfsRaid checks the multi-device btrfs root; the profile must be
+// supported, set on the btrfs root only, and the members must be on distinct
+// disks with a size similar to the root partition
+func validateBtrfsRaid(medias []*BlockDevice) []string {
+	results := []string{}
+
+	var root *BlockDevice
+	members := []*BlockDevice{}
+	disks := map[*BlockDevice]*BlockDevice{}
+
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			if ch.BtrfsProfile == "" && !ch.BtrfsMember {
+				continue
+			}
+
+			if ch.BtrfsProfile != "" && ch.BtrfsMember {
+				results = append(results,
+					utils.Locale.Get("Partition %s can not be both a btrfs root and a member", ch.Name))
+				continue
+			}
+
+			if ch.FsType != "btrfs" || ch.Type == BlockDeviceTypeCrypt {
+				results = append(results,
+					utils.Locale.Get("Partition %s of the btrfs RAID must be an unencrypted btrfs", ch.Name))
+				continue
+			}
+
+			if ch.BtrfsMember {
+				if ch.MountPoint != "" {
+					results = append(results,
+						utils.Locale.Get("btrfs RAID member %s can not have a mount point", ch.Name))
+				}
+				members = append(members, ch)
+			} else if ch.MountPoint != "/" {
+				results = append(results,
+					utils.Locale.Get("btrfs RAID is only supported for the / (root) partition"))
+			} else {
+				root = ch
+			}
+
+			for _, disk := range disks {
+				if disk == curr {
+					results = append(results,
+						utils.Locale.Get("btrfs RAID partitions must be on distinct disks, %s is used twice", curr.Name))
+					break
+				}
+			}
+			disks[ch] = curr
+		}
+	}
+
+	if root == nil {
+		if len(members) > 0 {
+			results = append(results,
+				utils.Locale.Get("btrfs RAID members require a / (root) partition with a btrfsProfile"))
+		}
+		return results
+	}
+
+	minDevices, found := btrfsMinDevices[root.BtrfsProfile]
+	if !found {
+		results = append(results, utils.Locale.Get("Invalid btrfsProfile %q, expected raid1 or raid10",
+			root.BtrfsProfile))
+		return results
+	}
+
+	if len(members)+1 < minDevices {
+		results = append(results, utils.Locale.Get("btrfs %s requires at least %d devices, %d found",
+			root.BtrfsProfile, minDevices, len(members)+1))
+	}
+
+	rootSize := btrfsMemberSize(disks[root], root)
+
+	for _, curr := range members {
+		size := btrfsMemberSize(disks[curr], curr)
+		if size == 0 || rootSize == 0 {
+			continue
+		}
+
+		diff := size - rootSize
+		if size < rootSize {
+			diff = rootSize - size
+		}
+
+		if diff*100 > rootSize*btrfsSizeTolerance {
+			memberSize, _ := HumanReadableSizeXiBWithPrecision(size, 1)
+			expected, _ := HumanReadableSizeXiBWithPrecision(rootSize, 1)
+			mesg := utils.Locale.Get("btrfs RAID member %s (%s) must be within %d%% of the root size (%s)",
+				curr.Name, memberSize, btrfsSizeTolerance, expected)
+			log.Warning("validatePartitions: %s", mesg)
+			results = append(results, mesg)
+		}
+	}
+
+	return results
+}
+
+// btrfsMemberSize returns the declared size of the btrfs RAID partition, a
+// partition of size 0 fills the free space of its disk
+func btrfsMemberSize(disk *BlockDevice, bd *BlockDevice) uint64 {
+	if bd.Size != 0 {
+		return bd.Size
+	}
+
+	var used uint64
+	for _, ch := range disk.Children {
+		used += ch.Size
+	}
+
+	if used >= disk.Size {
+		return 0
+	}
+
+	return disk.Size - used
+}
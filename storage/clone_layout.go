@@ -0,0 +1,183 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"math"
+	"path/filepath"
+	"sort"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+var (
+	// cloneFillSlack is the free space below which the reference disk is
+	// considered full, its root partition is then cloned as max-fill
+	cloneFillSlack = uint64(16 * 1024 * 1024)
+
+	// cloneLabelMountPoints are the mount points of the file system labels
+	// used by the installer default layout
+	cloneLabelMountPoints = map[string]string{
+		"boot": "/boot",
+		"root": "/",
+	}
+)
+
+// FindBlockDevice returns the disk of devices named name, either by its
+// device name or its device file
+func FindBlockDevice(devices []*BlockDevice, name string) *BlockDevice {
+	for _, curr := range devices {
+		if curr.Name == name || curr.GetDeviceFile() == name {
+			return curr
+		}
+	}
+
+	return nil
+}
+
+// CloneLayout returns the target disk with a new partition layout
+// reproducing the partitions of the source disk, the mount points are read
+// from the mounted partitions, the CLR_ partition labels or the installer
+// default file system labels. The / partition is resized proportionally when
+// the disks differ in size, and is max-fill when it fills the source disk.
+func CloneLayout(source *BlockDevice, target *BlockDevice) (*BlockDevice, error) {
+	if source.Type != BlockDeviceTypeDisk || target.Type != BlockDeviceTypeDisk {
+		return nil, errors.Errorf("Can only clone the layout of a disk onto a disk")
+	}
+
+	if len(source.Children) == 0 {
+		return nil, errors.Errorf("Disk %s has no partition to clone", source.Name)
+	}
+
+	parts := make([]*BlockDevice, len(source.Children))
+	copy(parts, source.Children)
+	sort.SliceStable(parts, func(i, j int) bool {
+		return parts[i].GetPartitionNumber() < parts[j].GetPartitionNumber()
+	})
+
+	layout := &BlockDevice{
+		Name:            target.Name,
+		Path:            target.Path,
+		Model:           target.Model,
+		MajorMinor:      target.MajorMinor,
+		Serial:          target.Serial,
+		Size:            target.Size,
+		LogicalSector:   target.LogicalSector,
+		PhysicalSector:  target.PhysicalSector,
+		Type:            target.Type,
+		State:           target.State,
+		RemovableDevice: target.RemovableDevice,
+		Rotational:      target.Rotational,
+		Children:        []*BlockDevice{},
+	}
+
+	var used uint64
+	var root *BlockDevice
+
+	for i, curr := range parts {
+		part, err := clonePartition(curr)
+		if err != nil {
+			return nil, err
+		}
+
+		part.Name = layout.GetNewPartitionName(uint64(i + 1))
+		if part.MountPoint == "/" {
+			root = part
+		}
+
+		used += curr.Size
+		layout.Children = append(layout.Children, part)
+	}
+
+	if root == nil {
+		return nil, errors.Errorf("Could not find the / (root) partition of %s", source.Name)
+	}
+
+	root.Size = cloneRootSize(root.Size, used, source.Size, target.Size)
+
+	log.Debug("CloneLayout: %s cloned onto %s with %d partitions", source.Name, target.Name, len(layout.Children))
+
+	return layout, nil
+}
+
+// clonePartition returns a new partition of the same size, file system and
+// mount point as part; an encrypted partition clones its mapped file system
+func clonePartition(part *BlockDevice) (*BlockDevice, error) {
+	if part.Type != BlockDeviceTypePart {
+		return nil, errors.Errorf("Cloning the %s partition %s is not supported", part.Type, part.Name)
+	}
+
+	fs := part
+	encrypted := false
+
+	if len(part.Children) > 0 {
+		if len(part.Children) > 1 || part.Children[0].Type != BlockDeviceTypeCrypt {
+			return nil, errors.Errorf("Cloning the partition %s holding %s devices is not supported",
+				part.Name, part.Children[0].Type)
+		}
+		fs = part.Children[0]
+		encrypted = true
+	}
+
+	clone := &BlockDevice{
+		Type:            BlockDeviceTypePart,
+		FsType:          fs.FsType,
+		Label:           fs.Label,
+		PartitionLabel:  part.PartitionLabel,
+		Size:            part.Size,
+		MakePartition:   true,
+		FormatPartition: true,
+	}
+
+	if encrypted {
+		clone.Type = BlockDeviceTypeCrypt
+	}
+
+	// lsblk reports the active swap as mounted on [SWAP]
+	if filepath.IsAbs(fs.MountPoint) {
+		clone.MountPoint = fs.MountPoint
+	} else if mountPoint, found := cloneLabelMountPoints[fs.Label]; found {
+		clone.MountPoint = mountPoint
+	}
+
+	if clone.MountPoint == "" {
+		// The clone is formatted, a label without _F still gets a file system
+		adv := &BlockDevice{Name: part.Name, PartitionLabel: part.PartitionLabel, FsType: clone.FsType,
+			FormatPartition: true}
+		if hasAdvancedInstallTarget([]*BlockDevice{adv}) {
+			clone.MountPoint = adv.MountPoint
+			clone.FsType = adv.FsType
+		}
+	}
+
+	// Partitions of an unknown use are kept empty for later use
+	if clone.MountPoint == "" && clone.FsType != "swap" {
+		log.Warning("CloneLayout: No mount point found for %s, creating it empty", part.Name)
+		clone.Type = BlockDeviceTypePart
+		clone.FsType = ""
+		clone.CreateOnly = true
+		clone.FormatPartition = false
+	}
+
+	return clone, nil
+}
+
+// cloneRootSize returns the size of the cloned root partition, max-fill when
+// the source disk is fully used, otherwise scaled to the target disk size
+func cloneRootSize(rootSize, used, sourceSize, targetSize uint64) uint64 {
+	if used+cloneFillSlack >= sourceSize {
+		return 0
+	}
+
+	if sourceSize == targetSize || sourceSize == 0 {
+		return rootSize
+	}
+
+	scaled := uint64(math.Floor(float64(rootSize) * float64(targetSize) / float64(sourceSize)))
+
+	// Keep the partition boundaries aligned
+	return scaled - scaled%partitionAlignUnit
+}
@@ -0,0 +1,84 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"time"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// DefaultDeviceNodeTimeout is the number of seconds to wait for the
+	// partition device nodes when MediaOpts.DeviceNodeTimeout is not set
+	DefaultDeviceNodeTimeout = 5
+)
+
+var (
+	// deviceNodeRetryInterval is the delay between two checks of the
+	// partition device nodes, replaceable for testing
+	deviceNodeRetryInterval = time.Second
+
+	// deviceNodeExists checks a partition device node exists, replaceable
+	// for testing
+	deviceNodeExists = utils.FileExists
+)
+
+// partitionDeviceFiles returns the device files of the partitions of the
+// target medias, the ones udev creates once the partition table is reread
+func partitionDeviceFiles(medias []*BlockDevice) []string {
+	files := []string{}
+
+	for _, curr := range medias {
+		if curr.Type != BlockDeviceTypeDisk && curr.Type != BlockDeviceTypeLoop &&
+			curr.Type != BlockDeviceTypeMultipath {
+			continue
+		}
+
+		for _, ch := range curr.Children {
+			if ch.Type != BlockDeviceTypePart && ch.Type != BlockDeviceTypeCrypt {
+				continue
+			}
+
+			files = append(files, ch.GetDeviceFile())
+		}
+	}
+
+	return files
+}
+
+// WaitForDeviceNodes waits up to timeout seconds, DefaultDeviceNodeTimeout if
+// 0, for the device nodes of the partitions of the target medias to appear
+func WaitForDeviceNodes(medias []*BlockDevice, timeout uint) error {
+	if timeout == 0 {
+		timeout = DefaultDeviceNodeTimeout
+	}
+
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+
+	for _, file := range partitionDeviceFiles(medias) {
+		for {
+			found, err := deviceNodeExists(file)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+
+			if found {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				return errors.Errorf("Partition device %s never appeared after %d seconds", file, timeout)
+			}
+
+			log.Debug("Waiting for the partition device %s", file)
+			time.Sleep(deviceNodeRetryInterval)
+		}
+	}
+
+	return nil
+}
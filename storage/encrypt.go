@@ -7,6 +7,7 @@ package storage
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -41,11 +42,39 @@ const (
 	EncryptCipher = "aes-xts-plain64"
 	// EncryptKeySize use for LUKS encryption
 	EncryptKeySize = 512
+
+	// encryptedSwapMarkerSize is the size of the ext2 marker labeling the
+	// random key swap partition, the swap starts after it
+	encryptedSwapMarkerSize = 1024 * 1024
+
+	// cryptOffsetUnit is the unit, in bytes, of the crypttab offset option
+	cryptOffsetUnit = 512
 )
 
-// EncryptionRequiresPassphrase checks all partition to see if encryption was enabled
+// encryptedSwapOptions returns the crypttab options of the random key swap of
+// a device with logicalSector bytes sectors; the offset skipping the marker is
+// aligned on the sector size, which is also the dm-crypt sector size of the 4Kn disks
+func encryptedSwapOptions(logicalSector uint64) string {
+	if logicalSector < cryptOffsetUnit {
+		logicalSector = cryptOffsetUnit
+	}
+
+	markerSize := ((encryptedSwapMarkerSize + logicalSector - 1) / logicalSector) * logicalSector
+
+	options := fmt.Sprintf("swap,offset=%d,cipher=%s,size=%d", markerSize/cryptOffsetUnit,
+		EncryptCipher, EncryptKeySize)
+
+	if logicalSector > cryptOffsetUnit {
+		options = options + fmt.Sprintf(",sector-size=%d", logicalSector)
+	}
+
+	return options
+}
+
+// EncryptionRequiresPassphrase checks all partition to see if encryption was
+// enabled, the partitions with their own passphrase file don't need the shared one
 func (bd *BlockDevice) EncryptionRequiresPassphrase(isAdvanced bool) bool {
-	enabled := (bd.Type == BlockDeviceTypeCrypt && bd.FsType != "swap")
+	enabled := (bd.Type == BlockDeviceTypeCrypt && bd.FsType != "swap" && bd.PassphraseFile == "")
 
 	for _, ch := range bd.Children {
 		if len(ch.Children) > 0 {
@@ -54,13 +83,82 @@ func (bd *BlockDevice) EncryptionRequiresPassphrase(isAdvanced bool) bool {
 			enabled = enabled ||
 				(ch.Type == BlockDeviceTypeCrypt &&
 					ch.LabeledAdvanced == isAdvanced &&
-					ch.FsTypeNotSwap())
+					ch.FsTypeNotSwap() &&
+					ch.PassphraseFile == "")
 		}
 	}
 
 	return enabled
 }
 
+// Passphrase returns the passphrase of the encrypted partition, read from its
+// own passphrase file if any, otherwise the shared passphrase
+func (bd *BlockDevice) Passphrase(shared string) (string, error) {
+	if bd.PassphraseFile == "" {
+		if shared == "" {
+			return "", errors.Errorf("No passphrase for the encrypted partition %s", bd.Name)
+		}
+		return shared, nil
+	}
+
+	content, err := ioutil.ReadFile(bd.PassphraseFile)
+	if err != nil {
+		return "", errors.Errorf("Could not read the passphrase file of %s: %v", bd.Name, err)
+	}
+
+	passphrase := strings.TrimSpace(string(content))
+	if ok, msg := IsValidPassphrase(passphrase); !ok {
+		return "", errors.Errorf("Invalid passphrase in %s for %s: %s", bd.PassphraseFile, bd.Name, msg)
+	}
+
+	return passphrase, nil
+}
+
+// ValidatePassphraseFiles checks the partitions passphrase files are set on
+// encrypted partitions and hold a valid passphrase
+func ValidatePassphraseFiles(medias []*BlockDevice) error {
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			if ch.PassphraseFile == "" {
+				continue
+			}
+
+			if ch.Type != BlockDeviceTypeCrypt {
+				return errors.ValidationErrorf("Partition %s has a passphrase file but is not encrypted", ch.Name)
+			}
+
+			if _, err := ch.Passphrase(""); err != nil {
+				return errors.ValidationErrorf("%v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidatePassphrases makes sure every encrypted partition formatted with
+// LUKS has a passphrase, either its own or the shared one
+func ValidatePassphrases(medias []*BlockDevice, mediaOpts MediaOpts, shared string) error {
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			if ch.Type != BlockDeviceTypeCrypt || ch.CreateOnly {
+				continue
+			}
+
+			// The swap uses a random key unless it persists for hibernation
+			if !ch.FsTypeNotSwap() && !mediaOpts.PersistentSwapKey {
+				continue
+			}
+
+			if _, err := ch.Passphrase(shared); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // MapEncrypted uses cryptsetup to format (initialize) and open (map) the
 // physical partion to an encrypted partition
 func (bd *BlockDevice) MapEncrypted(passphrase string) error {
@@ -109,6 +207,7 @@ func (bd *BlockDevice) MapEncrypted(passphrase string) error {
 	mountedEncrypts = append(mountedEncrypts, mapped)
 
 	bd.MappedName = filepath.Join("mapper", mapped)
+	bd.luksMapped = true
 
 	return nil
 }
@@ -134,9 +233,11 @@ func unMapEncrypted(mapped string) error {
 func (bd *BlockDevice) getMappedName() (string, error) {
 	var mapped string
 
-	// Special case for mapping 'root'
+	// Special case for mapping 'root' and the swap, which has no mount point
 	if bd.MountPoint == "/" {
 		mapped = "root"
+	} else if bd.FsType == "swap" {
+		mapped = "swap"
 	} else {
 		// make the mapped device all lower case
 		// drop the leading '/'
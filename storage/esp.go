@@ -0,0 +1,124 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+var (
+	// minESPFreeSize is the free space an existing EFI System Partition
+	// requires to hold the loader and a few kernels
+	minESPFreeSize = uint64(64) * (1024 * 1024)
+
+	// espFreeSpace returns the free space of an existing EFI System Partition,
+	// replaceable for testing
+	espFreeSpace = readESPFreeSpace
+)
+
+// IsESP returns true if the partition is an existing EFI System Partition,
+// identified by its partition type GUID
+func (bd *BlockDevice) IsESP() bool {
+	return bd.Type == BlockDeviceTypePart && bd.FsType == "vfat" &&
+		strings.EqualFold(bd.PartitionType, guidMap["efi"])
+}
+
+// FindESP returns the first existing EFI System Partition of the disk, nil
+// if it has none
+func (bd *BlockDevice) FindESP() *BlockDevice {
+	for _, ch := range bd.Children {
+		if ch.IsESP() {
+			return ch
+		}
+	}
+
+	return nil
+}
+
+// UseExistingESP mounts the existing EFI System Partition name of disk as
+// /boot; the partition is neither recreated nor formatted, the loader
+// entries are written alongside the ones of the other operating systems
+func UseExistingESP(disk *BlockDevice, name string) error {
+	for _, ch := range disk.Children {
+		if ch.Name != name {
+			continue
+		}
+
+		if !ch.IsESP() {
+			return errors.Errorf("%s is not an EFI System Partition", name)
+		}
+
+		ch.MountPoint = "/boot"
+		ch.ReuseESP = true
+		ch.MakePartition = false
+		ch.FormatPartition = false
+		ch.UserDefined = true
+
+		log.Info("Using the existing EFI System Partition %s as /boot", name)
+
+		return nil
+	}
+
+	return errors.Errorf("EFI System Partition %s not found on %s", name, disk.Name)
+}
+
+// validateReuseESP checks a reused EFI System Partition is a /boot booting in
+// UEFI mode with enough free space for the loader and the kernels
+func validateReuseESP(bd *BlockDevice, mediaOpts MediaOpts, bootLabel string) []string {
+	var results []string
+
+	if bd.MountPoint != "/boot" {
+		results = append(results, logPartitionMustBeWarning(bd, "reuseEsp", bootLabel))
+	}
+
+	if mediaOpts.LegacyBoot() {
+		results = append(results, logPartitionWarning(bd, "%s can not be reused in legacy BIOS mode", bd.Name))
+	}
+
+	if mediaOpts.SkipValidationSize {
+		log.Warning("validatePartitions: Skipping %s free space check due to skipSize", bootLabel)
+		return results
+	}
+
+	free, err := espFreeSpace(bd)
+	if err != nil {
+		log.Warning("validatePartitions: Could not read the %s free space: %v", bd.Name, err)
+		return append(results, logPartitionWarning(bd, "Could not read the free space of %s", bd.Name))
+	}
+
+	if free < minESPFreeSize {
+		results = append(results, logPartitionSizeWarning(bd, minESPFreeSize, bootLabel+" free space"))
+	}
+
+	return results
+}
+
+// readESPFreeSpace mounts the partition read only in a temporary directory
+// to read its free space
+func readESPFreeSpace(bd *BlockDevice) (uint64, error) {
+	dir, err := ioutil.TempDir("", "clr-installer-esp-")
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	defer func() { _ = os.Remove(dir) }()
+
+	if err = syscall.Mount(bd.GetDeviceFile(), dir, "vfat", syscall.MS_RDONLY, ""); err != nil {
+		return 0, errors.Errorf("mount %s %s: %v", bd.GetDeviceFile(), dir, err)
+	}
+	defer func() { _ = umountRetry(dir) }()
+
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(dir, &stat); err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
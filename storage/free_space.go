@@ -0,0 +1,104 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// FreeSpacePartitionInfo specifies the message for a partition added to
+	// the free space of a media
+	FreeSpacePartitionInfo = "Add new partition in free space %s - %s"
+)
+
+// FreeSpacePartitions returns the partitions of the disk to be added to its
+// free space
+func (bd *BlockDevice) FreeSpacePartitions() []*BlockDevice {
+	results := []*BlockDevice{}
+
+	for _, ch := range bd.Children {
+		if ch.FreeSpace {
+			results = append(results, ch)
+		}
+	}
+
+	return results
+}
+
+// freeSpaceAt returns the free space region of the partition table starting at start
+func (bd *BlockDevice) freeSpaceAt(start uint64) *PartedPartition {
+	for _, part := range bd.PartTable {
+		if part.Number == 0 && part.FileSystem == "free" && part.Start == start {
+			return part.Clone()
+		}
+	}
+
+	return nil
+}
+
+// AddFreeSpacePartitions adds the freeSpace partitions of the configured disk
+// to the largest free space regions of the scanned disk, i.e. as listed with
+// its partition table; the existing partitions are neither removed nor formatted.
+// A partition without size fills its free space region. The other partitions
+// of the configured disk must be reused ones, see ReuseExistingPartitions.
+func AddFreeSpacePartitions(scanned *BlockDevice, configured *BlockDevice) error {
+	parts := configured.FreeSpacePartitions()
+
+	if len(parts)+len(configured.ReusedPartitions()) != len(configured.Children) {
+		return errors.ValidationErrorf("Disk %s mixes freeSpace or reuse and new partitions", configured.Name)
+	}
+
+	for _, part := range parts {
+		minSize := part.Size
+		if minSize < 1 {
+			minSize = 1
+		}
+
+		start, end := scanned.LargestContiguousFreeSpace(minSize)
+		free := scanned.freeSpaceAt(start)
+		if (start == 0 && end == 0) || free == nil {
+			size, _ := HumanReadableSizeXiBWithPrecision(part.Size, 1)
+			return errors.InsufficientSpaceErrorf("No free space of %s left on %s for %s",
+				size, scanned.Name, part.MountPoint)
+		}
+
+		if part.Size == 0 {
+			part.Size = free.Size
+		}
+
+		part.Name = ""
+		part.Type = BlockDeviceTypePart
+		part.MakePartition = true
+		part.FormatPartition = !part.CreateOnly
+		part.UserDefined = true
+
+		scanned.AddFromFreePartition(free, part)
+		if part.partition == 0 {
+			return errors.Errorf("No partition number left on %s for %s", scanned.Name, part.MountPoint)
+		}
+
+		log.Info("Adding %s as %s in the free space %d-%d of %s", part.MountPoint, part.Name,
+			start, end, scanned.Name)
+	}
+
+	return nil
+}
+
+// freeSpacePartitionResult returns the dry run result of a partition added
+// to the free space, with the region it consumes
+func (bd *BlockDevice) freeSpacePartitionResult(part *BlockDevice) string {
+	start, end := bd.getPartitionStartEnd(part.partition)
+	startStr, _ := HumanReadableSizeXiBWithPrecision(start, 1)
+	endStr, _ := HumanReadableSizeXiBWithPrecision(end+1, 1)
+	size, _ := HumanReadableSizeXiBWithPrecision(part.Size, 1)
+
+	return fmt.Sprintf("%s: %s [%s]", bd.Name,
+		utils.Locale.Get(FreeSpacePartitionInfo, startStr, endStr), size)
+}
@@ -0,0 +1,106 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+// gptAttributes maps the supported GPT partition attribute names to their bit
+var gptAttributes = map[string]int{
+	"required":     0,
+	"no-block-io":  1,
+	"legacy-boot":  2,
+	"read-only":    60,
+	"shadow-copy":  61,
+	"hidden":       62,
+	"no-automount": 63,
+}
+
+// gptAttributeNames returns the sorted supported GPT attribute names
+func gptAttributeNames() []string {
+	names := []string{}
+
+	for name := range gptAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ValidatePartitionAttributes checks the GPT attributes of the partitions are
+// known and only used with a GPT partition table
+func ValidatePartitionAttributes(medias []*BlockDevice, mediaOpts MediaOpts) error {
+	for _, curr := range medias {
+		for _, ch := range curr.Children {
+			if len(ch.Attributes) == 0 {
+				continue
+			}
+
+			if mediaOpts.GetPartitionTable() != PartitionTableGPT {
+				return errors.ValidationErrorf("Partition %s has attributes, only supported on GPT partitions",
+					ch.Name)
+			}
+
+			for _, attr := range ch.Attributes {
+				if _, ok := gptAttributes[attr]; !ok {
+					return errors.ValidationErrorf("Invalid attribute %q for partition %s, expected one of: %s",
+						attr, ch.Name, strings.Join(gptAttributeNames(), ", "))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// partitionAttributesArgs returns the sgdisk commands setting the GPT
+// attributes of the partitions of the disk
+func (bd *BlockDevice) partitionAttributesArgs() [][]string {
+	results := [][]string{}
+
+	for _, curr := range bd.Children {
+		for _, attr := range curr.Attributes {
+			bit, ok := gptAttributes[attr]
+			if !ok {
+				continue
+			}
+
+			results = append(results, []string{
+				"sgdisk",
+				bd.GetDeviceFile(),
+				fmt.Sprintf("--attributes=%d:set:%d", curr.partition, bit),
+			})
+		}
+	}
+
+	return results
+}
+
+// setPartitionAttributes uses sgdisk to set the GPT attributes of the
+// partitions, once their type codes are set
+func (bd *BlockDevice) setPartitionAttributes() error {
+	args := bd.partitionAttributesArgs()
+	if len(args) < 1 {
+		return nil
+	}
+
+	log.Info("Setting partition attributes for device: %s", bd.GetDeviceFile())
+
+	for _, curr := range args {
+		if err := cmd.RunAndLog(curr...); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
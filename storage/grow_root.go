@@ -0,0 +1,189 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// GrowRootUnit is the first boot unit growing the root partition and
+	// its file system to the end of the disk
+	GrowRootUnit = "clr-installer-grow-root.service"
+
+	// growRootWantedBy is the target pulling GrowRootUnit at boot
+	growRootWantedBy = "multi-user.target"
+
+	// RequiredBundleGrowRoot is the bundle shipping growpart
+	RequiredBundleGrowRoot = "storage-utils"
+
+	// growPartPath is where RequiredBundleGrowRoot installs growpart
+	growPartPath = "/usr/bin/growpart"
+)
+
+// growRootResizeCommands maps the root file system types which can be grown
+// online, while mounted, to the command growing them to their partition
+var growRootResizeCommands = map[string]string{
+	"ext3":  "resize2fs \"$(findmnt -no SOURCE /)\"",
+	"ext4":  "resize2fs \"$(findmnt -no SOURCE /)\"",
+	"xfs":   "xfs_growfs /",
+	"btrfs": "btrfs filesystem resize max /",
+}
+
+// findGrowRoot returns the root partition and the disk holding it, the root
+// on a logical volume or a RAID array is not a child of the disk
+func findGrowRoot(medias []*BlockDevice) (*BlockDevice, *BlockDevice) {
+	for _, curr := range medias {
+		for _, ch := range curr.Children {
+			if ch.MountPoint == "/" {
+				return curr, ch
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// lastPartition returns the partition created last on the disk, the one
+// ending the partition table
+func (bd *BlockDevice) lastPartition() *BlockDevice {
+	if len(bd.Children) == 0 {
+		return nil
+	}
+
+	sorted := &BlockDevice{Children: append([]*BlockDevice{}, bd.Children...)}
+	sorted.sortPartitions()
+
+	return sorted.Children[len(sorted.Children)-1]
+}
+
+// ValidateGrowRoot checks the root file system is on the last partition of
+// its disk, so it can be grown to the end of the disk, and can be grown online
+func ValidateGrowRoot(medias []*BlockDevice, mediaOpts MediaOpts) error {
+	if !mediaOpts.GrowRoot {
+		return nil
+	}
+
+	if mediaOpts.ExpandLVMRoot || mediaOpts.ReadOnlyRoot {
+		return errors.ValidationErrorf("growRoot can not be used with expandLvmRoot nor readOnlyRoot")
+	}
+
+	disk, root := findGrowRoot(medias)
+	if root == nil || root.Type != BlockDeviceTypePart {
+		return errors.ValidationErrorf("growRoot requires the root file system on a partition of a disk")
+	}
+
+	if _, ok := growRootResizeCommands[root.FsType]; !ok {
+		return errors.ValidationErrorf("growRoot does not support the %s root file system, it must be one of: %s",
+			root.FsType, strings.Join(growRootFsTypes(), ", "))
+	}
+
+	if last := disk.lastPartition(); last != root {
+		return errors.ValidationErrorf("growRoot requires the root partition %s to be the last one of %s",
+			root.Name, disk.Name)
+	}
+
+	return nil
+}
+
+// growRootFsTypes returns the sorted root file system types growRoot supports
+func growRootFsTypes() []string {
+	fsTypes := []string{}
+
+	for fsType := range growRootResizeCommands {
+		fsTypes = append(fsTypes, fsType)
+	}
+	sort.Strings(fsTypes)
+
+	return fsTypes
+}
+
+// growRootCommands returns the shell commands growing the partition holding
+// the root file system to the end of its disk, then the file system itself
+func growRootCommands(fsType string) []string {
+	return []string{
+		// growpart exits with 1 when the partition can not be grown any further
+		"root=\"$(findmnt -no SOURCE /)\"; growpart \"/dev/$(lsblk -no PKNAME \"$root\")\" " +
+			"\"$(cat /sys/class/block/${root##*/}/partition)\" || [ $? -eq 1 ]",
+		growRootResizeCommands[fsType],
+	}
+}
+
+// growRootUnit returns the systemd unit running the growRootCommands once
+// on first boot, the unit disables itself when done
+func growRootUnit(fsType string) string {
+	// systemd expands both specifiers and variables in command lines
+	escape := strings.NewReplacer("%", "%%", "$", "$$")
+
+	unit := []string{
+		"[Unit]",
+		"Description=Grow the root partition and file system to the end of the disk",
+		"After=local-fs.target",
+		"",
+		"[Service]",
+		"Type=oneshot",
+	}
+
+	for _, command := range growRootCommands(fsType) {
+		unit = append(unit, "ExecStart=/usr/bin/sh -c '"+escape.Replace(command)+"'")
+	}
+
+	unit = append(unit,
+		"ExecStartPost=/usr/bin/systemctl disable "+GrowRootUnit,
+		"",
+		"[Install]",
+		"WantedBy="+growRootWantedBy,
+		"")
+
+	return strings.Join(unit, "\n")
+}
+
+// InstallGrowRootUnit writes and enables, in the target rootDir, the first
+// boot unit growing the root partition of medias and its file system; the
+// target must provide growpart
+func InstallGrowRootUnit(rootDir string, medias []*BlockDevice) error {
+	_, root := findGrowRoot(medias)
+	if root == nil {
+		return errors.Errorf("Growing the root requires the root file system on a partition of a disk")
+	}
+
+	if _, err := os.Stat(filepath.Join(rootDir, growPartPath)); err != nil {
+		return errors.Errorf("Growing the root requires %s, bundle %s did not install it: %v",
+			growPartPath, RequiredBundleGrowRoot, err)
+	}
+
+	return writeGrowRootUnit(rootDir, root.FsType)
+}
+
+func writeGrowRootUnit(rootDir string, fsType string) error {
+	unitDir := filepath.Join(rootDir, "etc", "systemd", "system")
+	wantsDir := filepath.Join(unitDir, growRootWantedBy+".wants")
+	unitFile := filepath.Join(unitDir, GrowRootUnit)
+
+	if err := utils.MkdirAll(wantsDir, 0755); err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Info("Enabling first boot growth of the %s root file system", fsType)
+
+	if err := ioutil.WriteFile(unitFile, []byte(growRootUnit(fsType)), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	link := filepath.Join(wantsDir, GrowRootUnit)
+	if err := os.Symlink(filepath.Join("/etc/systemd/system", GrowRootUnit), link); err != nil && !os.IsExist(err) {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+const (
+	// ImageFormatRaw is the sparse raw image format, attached with losetup
+	ImageFormatRaw = "raw"
+
+	// ImageFormatQcow2 is the qcow2 image format, attached with qemu-nbd
+	ImageFormatQcow2 = "qcow2"
+
+	// maxNBDDevices is the number of nbd devices created by the nbd module
+	maxNBDDevices = 16
+
+	// maxNBDPartitions is the number of partitions of each nbd device
+	maxNBDPartitions = 16
+)
+
+var (
+	// sysBlockDir is where the kernel lists the block devices, replaceable for testing
+	sysBlockDir = "/sys/block"
+
+	// lookPath finds the image tools, replaceable for testing
+	lookPath = exec.LookPath
+
+	// imageFormatTools are the tools required to create and attach an image
+	imageFormatTools = map[string][]string{
+		ImageFormatRaw:   {"qemu-img", "losetup"},
+		ImageFormatQcow2: {"qemu-img", "qemu-nbd", "modprobe"},
+	}
+)
+
+// ImageFormat returns the format of the image file: the explicit format if
+// set, qcow2 for a .qcow2 file and raw otherwise
+func ImageFormat(file string, format string) string {
+	if format != "" {
+		return format
+	}
+
+	if strings.EqualFold(filepath.Ext(file), "."+ImageFormatQcow2) {
+		return ImageFormatQcow2
+	}
+
+	return ImageFormatRaw
+}
+
+// ValidateImageFormat checks format is a supported image format
+func ValidateImageFormat(format string) error {
+	if _, ok := imageFormatTools[format]; !ok {
+		return errors.ValidationErrorf("Invalid image format %q, expected %q or %q",
+			format, ImageFormatRaw, ImageFormatQcow2)
+	}
+
+	return nil
+}
+
+// CheckImageFormatTools checks the format is supported and its tools are
+// installed, before any image is created
+func CheckImageFormatTools(format string) error {
+	if err := ValidateImageFormat(format); err != nil {
+		return err
+	}
+
+	for _, tool := range imageFormatTools[format] {
+		if _, err := lookPath(tool); err != nil {
+			return errors.Errorf("%s is required to create a %s image: %v", tool, format, err)
+		}
+	}
+
+	return nil
+}
+
+// makeImageArgs returns the qemu-img command creating the image file; the
+// image is never preallocated so a raw image stays sparse
+func makeImageArgs(file string, format string, size uint64) []string {
+	return []string{
+		"qemu-img",
+		"create",
+		"-f",
+		format,
+		"-o",
+		"preallocation=off",
+		file,
+		fmt.Sprintf("%d", size),
+	}
+}
+
+// isNBDDevice returns true if file is a network block device
+func isNBDDevice(file string) bool {
+	return strings.HasPrefix(filepath.Base(file), "nbd")
+}
+
+// findFreeNBDDevice returns the first network block device not connected,
+// the connected ones have a pid file
+func findFreeNBDDevice() (string, error) {
+	for i := 0; i < maxNBDDevices; i++ {
+		name := fmt.Sprintf("nbd%d", i)
+
+		if _, err := os.Stat(filepath.Join(sysBlockDir, name)); err != nil {
+			break
+		}
+
+		if _, err := os.Stat(filepath.Join(sysBlockDir, name, "pid")); os.IsNotExist(err) {
+			return filepath.Join("/dev", name), nil
+		}
+	}
+
+	return "", errors.Errorf("Could not find a free nbd device")
+}
+
+// SetupNBDDevice connects the qcow2 image file to a network block device
+// and returns the device path
+func SetupNBDDevice(file string) (string, error) {
+	if err := cmd.RunAndLog("modprobe", "nbd", fmt.Sprintf("nbds_max=%d", maxNBDDevices),
+		fmt.Sprintf("max_part=%d", maxNBDPartitions)); err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	dev, err := findFreeNBDDevice()
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{
+		"qemu-nbd",
+		fmt.Sprintf("--connect=%s", dev),
+		fmt.Sprintf("--format=%s", ImageFormatQcow2),
+		file,
+	}
+
+	if err = cmd.RunAndLog(args...); err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	return dev, nil
+}
+
+// AttachImage attaches the image file, with a loop device for a raw image or
+// a network block device for a qcow2 one, and returns the device path
+func AttachImage(file string, format string) (string, error) {
+	if format == ImageFormatQcow2 {
+		return SetupNBDDevice(file)
+	}
+
+	return SetupLoopDevice(file)
+}
+
+// DetachImage detaches the loop or network block device of an image
+func DetachImage(file string) {
+	if !isNBDDevice(file) {
+		DetachLoopDevice(file)
+		return
+	}
+
+	if err := cmd.RunAndLog("qemu-nbd", "--disconnect", file); err != nil {
+		log.Warning("Failed to disconnect %s: %v", file, err)
+	}
+}
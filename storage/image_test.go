@@ -0,0 +1,96 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImageFormat(t *testing.T) {
+	tests := []struct {
+		file     string
+		format   string
+		expected string
+	}{
+		{"clear.img", "", ImageFormatRaw},
+		{"clear.qcow2", "", ImageFormatQcow2},
+		{"clear.QCOW2", "", ImageFormatQcow2},
+		{"clear.img", ImageFormatQcow2, ImageFormatQcow2},
+		{"clear.qcow2", ImageFormatRaw, ImageFormatRaw},
+	}
+
+	for _, curr := range tests {
+		if format := ImageFormat(curr.file, curr.format); format != curr.expected {
+			t.Fatalf("Image %s with format %q: got %q, want %q", curr.file, curr.format, format, curr.expected)
+		}
+	}
+
+	if err := ValidateImageFormat("vmdk"); err == nil {
+		t.Fatal("The vmdk format should be invalid")
+	}
+
+	defer func(orig func(string) (string, error)) { lookPath = orig }(lookPath)
+
+	lookPath = func(file string) (string, error) {
+		if file == "qemu-nbd" {
+			return "", fmt.Errorf("not found")
+		}
+		return filepath.Join("/usr/bin", file), nil
+	}
+
+	if err := CheckImageFormatTools(ImageFormatRaw); err != nil {
+		t.Fatalf("The raw format should be supported: %v", err)
+	}
+
+	if err := CheckImageFormatTools(ImageFormatQcow2); err == nil {
+		t.Fatal("The qcow2 format requires qemu-nbd")
+	}
+
+	args := strings.Join(makeImageArgs("clear.img", ImageFormatRaw, 1024), " ")
+	if args != "qemu-img create -f raw -o preallocation=off clear.img 1024" {
+		t.Fatalf("Unexpected qemu-img command %q", args)
+	}
+}
+
+func TestFindFreeNBDDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-nbd-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	defer func(orig string) { sysBlockDir = orig }(sysBlockDir)
+	sysBlockDir = dir
+
+	if _, err = findFreeNBDDevice(); err == nil {
+		t.Fatal("No nbd device should be found without the nbd module")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = os.MkdirAll(filepath.Join(dir, fmt.Sprintf("nbd%d", i)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nbd0 is connected
+	if err = ioutil.WriteFile(filepath.Join(dir, "nbd0", "pid"), []byte("42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dev, err := findFreeNBDDevice()
+	if err != nil || dev != "/dev/nbd1" {
+		t.Fatalf("Expected /dev/nbd1, got %q: %v", dev, err)
+	}
+
+	bd := &BlockDevice{Name: "nbd1", Type: BlockDeviceTypeDisk}
+	if name := bd.GetNewPartitionName(2); name != "nbd1p2" {
+		t.Fatalf("Unexpected nbd partition name %q", name)
+	}
+}
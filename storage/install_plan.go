@@ -0,0 +1,174 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// PlannedPartition describes the changes planned for a single partition
+type PlannedPartition struct {
+	Name       string // partition name, predicted for new partitions
+	Disk       string // name of the target media holding the partition
+	MountPoint string // where the partition will be mounted
+	FsType     string // file system type
+	Size       uint64 // size of the partition in bytes
+	Create     bool   // will a new partition be created?
+	Format     bool   // will a new file system be created?
+	Encrypted  bool   // will the partition be encrypted?
+	Erased     string // existing file system erased by the format, if any
+}
+
+// InstallPlan is a structured representation of the changes the installation
+// will perform on the target medias, the dry run strings are derived from it
+type InstallPlan struct {
+	Targets              []InstallTarget     // install targets sorted by name
+	Partitions           []*PlannedPartition // partition and file system operations
+	PartitionsSize       uint64              // size of the new partitions in bytes
+	SwapFileSize         uint64              // size of the swapfile in bytes, 0 if none
+	TargetResults        []string            // what will be changed during the installation
+	UnPlannedDestructive []string            // changes impacting media other than the targets
+	Warnings             []string            // non blocking configuration issues
+}
+
+// String returns the localized description of the planned partition change
+func (pp *PlannedPartition) String() string {
+	var part string
+
+	if pp.Format {
+		part = fmt.Sprintf("%s: %s", pp.Name, utils.Locale.Get(FormatPartitionInfo, pp.FsType))
+
+		if pp.MountPoint != "" {
+			part = part + fmt.Sprintf(" [%s]", pp.MountPoint)
+		}
+
+		if pp.Erased != "" {
+			part = part + " " + utils.Locale.Get("The existing %s file system will be erased.", pp.Erased)
+		}
+	} else {
+		part = fmt.Sprintf("%s: %s", pp.Name, utils.Locale.Get(UsePartitionInfo))
+
+		if pp.MountPoint != "" {
+			part = part + fmt.Sprintf(" [%s]", pp.MountPoint)
+		} else if pp.FsType != "" {
+			part = part + fmt.Sprintf(" (%s)", pp.FsType)
+		}
+	}
+
+	if pp.Encrypted {
+		part = part + " " + utils.Locale.Get("Encrypted")
+	}
+
+	return part
+}
+
+// getPlannedPartitions returns the partitions of media which will be
+// formatted or used by the installation
+func getPlannedPartitions(media *BlockDevice) []*PlannedPartition {
+	results := []*PlannedPartition{}
+
+	for _, ch := range media.FindAllChildren() {
+		if !ch.FormatPartition && ch.MountPoint == "" && ch.FsTypeNotSwap() {
+			continue
+		}
+
+		partName := ch.Name
+		if partName == "" {
+			partName = ch.GetNewPartitionName(ch.partition)
+		}
+
+		erased := ""
+		if ch.FormatPartition && ch.holdsFileSystem() {
+			erased = ch.FsType
+		}
+
+		results = append(results, &PlannedPartition{
+			Name:       partName,
+			Disk:       media.Name,
+			MountPoint: ch.MountPoint,
+			FsType:     ch.FsType,
+			Size:       ch.Size,
+			Create:     ch.MakePartition,
+			Format:     ch.FormatPartition,
+			Encrypted:  ch.Type == BlockDeviceTypeCrypt,
+			Erased:     erased,
+		})
+	}
+
+	return results
+}
+
+// NewInstallPlan builds the installation plan for the targets and medias
+func NewInstallPlan(targets map[string]InstallTarget, medias []*BlockDevice, mediaOpts MediaOpts) *InstallPlan {
+	plan := &InstallPlan{}
+	dryRun := &DryRunType{&[]string{}, &[]string{}}
+
+	if len(targets) != len(medias) {
+		log.Warning("The number of install targets (%d) != media devices (%d)",
+			len(targets), len(medias))
+
+		for _, target := range targets {
+			log.Warning("Install Target: %+v", target)
+		}
+		for _, curr := range medias {
+			log.Warning("Media Device: %+v", curr)
+		}
+	}
+
+	if err := PrepareInstallationMedia(targets, medias, mediaOpts, dryRun); err != nil {
+		log.Warning("PrepareInstallationMedia: %+v", err)
+	}
+
+	for _, target := range targets {
+		plan.Targets = append(plan.Targets, target)
+	}
+	sort.Slice(plan.Targets, func(i, j int) bool {
+		return plan.Targets[i].Name < plan.Targets[j].Name
+	})
+
+	for _, curr := range medias {
+		if _, ok := targets[curr.Name]; !ok {
+			continue
+		}
+
+		for _, part := range getPlannedPartitions(curr) {
+			plan.Partitions = append(plan.Partitions, part)
+			if part.Create {
+				plan.PartitionsSize += part.Size
+			}
+		}
+	}
+
+	if mediaOpts.NoSwap {
+		*dryRun.TargetResults = append(*dryRun.TargetResults, utils.Locale.Get("No swap configured"))
+	} else if mediaOpts.SwapFileSize != "" {
+		size, err := ParseVolumeSize(mediaOpts.SwapFileSize)
+		if err != nil {
+			log.Warning("Could not parse the swapfile size %q: %v", mediaOpts.SwapFileSize, err)
+		}
+		plan.SwapFileSize = size
+
+		*dryRun.TargetResults = append(*dryRun.TargetResults,
+			fmt.Sprintf("%s (%s)", SwapfileName, mediaOpts.SwapFileSize))
+	}
+
+	plan.TargetResults = *dryRun.TargetResults
+	plan.UnPlannedDestructive = *dryRun.UnPlannedDestructiveResults
+
+	return plan
+}
+
+// DryRun returns the plan as the dry run results displayed to the user
+func (plan *InstallPlan) DryRun() *DryRunType {
+	targetResults := append([]string{}, plan.TargetResults...)
+	targetResults = append(targetResults, plan.Warnings...)
+	unPlannedDestructive := append([]string{}, plan.UnPlannedDestructive...)
+
+	return &DryRunType{&targetResults, &unPlannedDestructive}
+}
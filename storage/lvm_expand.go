@@ -0,0 +1,125 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// LVMExpandUnit is the first boot unit expanding the LVM root volume
+	LVMExpandUnit = "clr-installer-lvm-expand.service"
+
+	// lvmExpandWantedBy is the target pulling LVMExpandUnit at boot
+	lvmExpandWantedBy = "local-fs.target"
+)
+
+// lvmVolumeNames returns the volume group and logical volume names of the
+// logical volume bd
+func lvmVolumeNames(bd *BlockDevice) (string, string, error) {
+	w := bytes.NewBuffer(nil)
+	err := cmd.Run(w, "lvs", "--noheadings", "-o", "vg_name,lv_name", bd.GetMappedDeviceFile())
+	if err != nil {
+		return "", "", errors.Wrap(err)
+	}
+
+	return parseLVSNames(w.String())
+}
+
+// parseLVSNames parses the "lvs --noheadings -o vg_name,lv_name" output
+func parseLVSNames(output string) (string, string, error) {
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return "", "", errors.Errorf("Could not parse the logical volume names: %q", strings.TrimSpace(output))
+	}
+
+	return fields[0], fields[1], nil
+}
+
+// lvmExpandCommands returns the shell commands growing every physical volume
+// of the volume group vg and then, when free extents exist, the logical
+// volume lv and its file system
+func lvmExpandCommands(vg string, lv string) []string {
+	return []string{
+		fmt.Sprintf("pvs --noheadings -o pv_name -S vg_name=%s | xargs -r -n1 pvresize", vg),
+		fmt.Sprintf("if [ \"$(vgs --noheadings -o vg_free_count %s)\" -gt 0 ]; then "+
+			"lvextend -r -l +100%%FREE /dev/%s/%s; fi", vg, vg, lv),
+	}
+}
+
+// lvmExpandUnit returns the systemd unit running the lvmExpandCommands once
+// on first boot, the unit disables itself when done
+func lvmExpandUnit(vg string, lv string) string {
+	// systemd expands both specifiers and variables in command lines
+	escape := strings.NewReplacer("%", "%%", "$", "$$")
+
+	unit := []string{
+		"[Unit]",
+		"Description=Expand the LVM root volume to the available disk space",
+		"DefaultDependencies=no",
+		"After=local-fs-pre.target",
+		"Before=" + lvmExpandWantedBy,
+		"",
+		"[Service]",
+		"Type=oneshot",
+	}
+
+	for _, command := range lvmExpandCommands(vg, lv) {
+		unit = append(unit, "ExecStart=/usr/bin/sh -c '"+escape.Replace(command)+"'")
+	}
+
+	unit = append(unit,
+		"ExecStartPost=/usr/bin/systemctl disable "+LVMExpandUnit,
+		"",
+		"[Install]",
+		"WantedBy="+lvmExpandWantedBy,
+		"")
+
+	return strings.Join(unit, "\n")
+}
+
+// InstallLVMExpandUnit writes and enables, in the target rootDir, the first
+// boot unit expanding the root logical volume lvRoot to the free disk space
+func InstallLVMExpandUnit(rootDir string, lvRoot *BlockDevice) error {
+	vg, lv, err := lvmVolumeNames(lvRoot)
+	if err != nil {
+		return err
+	}
+
+	return writeLVMExpandUnit(rootDir, vg, lv)
+}
+
+func writeLVMExpandUnit(rootDir string, vg string, lv string) error {
+	unitDir := filepath.Join(rootDir, "etc", "systemd", "system")
+	wantsDir := filepath.Join(unitDir, lvmExpandWantedBy+".wants")
+	unitFile := filepath.Join(unitDir, LVMExpandUnit)
+
+	if err := utils.MkdirAll(wantsDir, 0755); err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Info("Enabling first boot expansion of the LVM root %s/%s", vg, lv)
+
+	if err := ioutil.WriteFile(unitFile, []byte(lvmExpandUnit(vg, lv)), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	link := filepath.Join(wantsDir, LVMExpandUnit)
+	if err := os.Symlink(filepath.Join("/etc/systemd/system", LVMExpandUnit), link); err != nil && !os.IsExist(err) {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
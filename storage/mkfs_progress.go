@@ -0,0 +1,93 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+var (
+	// mkfsProgressExp matches the numeric progress of mke2fs, i.e. 12/128
+	mkfsProgressExp = regexp.MustCompile(`^(\d+)/(\d+)$`)
+
+	// progressFsTypes are the file systems whose mkfs tool reports its progress
+	progressFsTypes = []string{"ext2", "ext3", "ext4"}
+)
+
+// MakeFsReportsProgress returns true if MakeFsWithProgress reports the
+// completion of the file system creation
+func (bd *BlockDevice) MakeFsReportsProgress() bool {
+	return !bd.BtrfsMember && utils.StringSliceContains(progressFsTypes, bd.FsType)
+}
+
+// mkfsProgressWriter keeps the mkfs output and forwards its numeric progress;
+// mke2fs rewrites the progress in place with backspaces instead of new lines,
+// each of its phases (discard, inode tables, journal) counting from 0
+type mkfsProgressWriter struct {
+	output  bytes.Buffer
+	pending string
+	percent int
+	partial func(percent int)
+}
+
+func isMkfsProgressSeparator(r rune) bool {
+	return r == '\b' || unicode.IsSpace(r)
+}
+
+// Write stores p and reports the completed progress fields
+func (w *mkfsProgressWriter) Write(p []byte) (int, error) {
+	w.output.Write(p)
+
+	if w.partial == nil {
+		return len(p), nil
+	}
+
+	data := w.pending + string(p)
+	fields := strings.FieldsFunc(data, isMkfsProgressSeparator)
+
+	// The last field may be completed by the next write unless a separator follows it
+	w.pending = ""
+	if last, _ := utf8.DecodeLastRuneInString(data); len(fields) > 0 && !isMkfsProgressSeparator(last) {
+		w.pending = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+
+	for _, field := range fields {
+		w.report(field)
+	}
+
+	return len(p), nil
+}
+
+// report forwards the percentage of a progress field, i.e. 12/128
+func (w *mkfsProgressWriter) report(field string) {
+	match := mkfsProgressExp.FindStringSubmatch(field)
+	if match == nil {
+		return
+	}
+
+	done, err := strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	total, err := strconv.ParseUint(match[2], 10, 64)
+	if err != nil || total == 0 || done > total {
+		return
+	}
+
+	percent := int(done * 100 / total)
+	if percent != w.percent {
+		w.percent = percent
+		w.partial(percent)
+	}
+}
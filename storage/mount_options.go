@@ -0,0 +1,158 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"strings"
+	"syscall"
+
+	"github.com/clearlinux/clr-installer/errors"
+)
+
+// mountFlag is a generic mount option translated into a mount(2) flag, the
+// options of a same group conflict with each other
+type mountFlag struct {
+	group string
+	flag  uintptr
+}
+
+const (
+	// msLazyTime is MS_LAZYTIME, missing from the syscall package
+	msLazyTime = 1 << 25
+
+	// AtimeRelatime updates the access time relative to the modify or change
+	// time, the mount(8) default
+	AtimeRelatime = "relatime"
+
+	// AtimeNoatime never updates the access time
+	AtimeNoatime = "noatime"
+
+	// AtimeStrictatime always updates the access time
+	AtimeStrictatime = "strictatime"
+)
+
+var (
+	mountFlags = map[string]mountFlag{
+		"ro":          {"ro", syscall.MS_RDONLY},
+		"rw":          {"ro", 0},
+		"atime":       {"atime", 0},
+		"noatime":     {"atime", syscall.MS_NOATIME},
+		"relatime":    {"atime", syscall.MS_RELATIME},
+		"norelatime":  {"atime", 0},
+		"strictatime": {"atime", syscall.MS_STRICTATIME},
+		"diratime":    {"diratime", 0},
+		"nodiratime":  {"diratime", syscall.MS_NODIRATIME},
+		"lazytime":    {"lazytime", msLazyTime},
+		"nolazytime":  {"lazytime", 0},
+		"suid":        {"suid", 0},
+		"nosuid":      {"suid", syscall.MS_NOSUID},
+		"dev":         {"dev", 0},
+		"nodev":       {"dev", syscall.MS_NODEV},
+		"exec":        {"exec", 0},
+		"noexec":      {"exec", syscall.MS_NOEXEC},
+		"async":       {"sync", 0},
+		"sync":        {"sync", syscall.MS_SYNCHRONOUS},
+		"dirsync":     {"dirsync", syscall.MS_DIRSYNC},
+		"auto":        {"auto", 0},
+		"noauto":      {"auto", 0},
+		"user":        {"user", 0},
+		"nouser":      {"user", 0},
+	}
+
+	// fstabOnlyOptions are the options only used by mount(8) or systemd
+	fstabOnlyOptions = []string{"defaults", "nofail", "users", "owner", "group", "_netdev"}
+
+	// fstabOnlyPrefixes are the prefixes of the options only used by systemd
+	fstabOnlyPrefixes = []string{"x-", "comment="}
+)
+
+// ValidateAtimeMode checks mode is a known atime mode, an empty mode
+// defaults to AtimeRelatime
+func ValidateAtimeMode(mode string) error {
+	switch mode {
+	case "", AtimeRelatime, AtimeNoatime, AtimeStrictatime:
+		return nil
+	}
+
+	return errors.ValidationErrorf("Invalid atimeMode value %q, expected %q, %q or %q",
+		mode, AtimeRelatime, AtimeNoatime, AtimeStrictatime)
+}
+
+// atimeModeSet returns true if mediaOpts changes the default atime mode, the
+// auto mounted partitions then need a fstab entry to keep it after install
+func atimeModeSet(mediaOpts MediaOpts) bool {
+	return mediaOpts.AtimeMode != "" && mediaOpts.AtimeMode != AtimeRelatime
+}
+
+// atimeMountOptions returns the mount options with the atime mode of mediaOpts
+// added unless they already have an atime option; the same options are used
+// by the install time mounts and the fstab so both get the same atime behavior
+func atimeMountOptions(options string, mediaOpts MediaOpts) string {
+	// relatime is the default of both mount(8) and parseMountOptions
+	if !atimeModeSet(mediaOpts) {
+		return options
+	}
+
+	for _, opt := range strings.Split(options, ",") {
+		if mf, found := mountFlags[opt]; found && mf.group == "atime" {
+			return options
+		}
+	}
+
+	return options + "," + mediaOpts.AtimeMode
+}
+
+// isFstabOnlyOption returns true if opt does not apply to the mount(2) call
+func isFstabOnlyOption(opt string) bool {
+	for _, curr := range fstabOnlyOptions {
+		if opt == curr {
+			return true
+		}
+	}
+
+	for _, prefix := range fstabOnlyPrefixes {
+		if strings.HasPrefix(opt, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseMountOptions splits the comma separated fstab options into the mount(2)
+// flags and the file system specific data; relatime is used unless an atime
+// option is given, as mount(8) does
+func parseMountOptions(options string) (uintptr, string, error) {
+	var flags uintptr
+	data := []string{}
+	groups := map[string]string{}
+
+	for _, opt := range strings.Split(options, ",") {
+		if opt == "" {
+			return 0, "", errors.Errorf("Empty mount option in %q", options)
+		}
+
+		if mf, found := mountFlags[opt]; found {
+			if prev, conflict := groups[mf.group]; conflict && prev != opt {
+				return 0, "", errors.Errorf("Mount options %q and %q conflict", prev, opt)
+			}
+			groups[mf.group] = opt
+			flags |= mf.flag
+			continue
+		}
+
+		if isFstabOnlyOption(opt) {
+			continue
+		}
+
+		data = append(data, opt)
+	}
+
+	if _, found := groups["atime"]; !found {
+		flags |= syscall.MS_RELATIME
+	}
+
+	return flags, strings.Join(data, ","), nil
+}
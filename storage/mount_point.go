@@ -0,0 +1,188 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// DefaultMountPointMode is the mode of the mount point directories created
+	// for the partitions declaring no mount mode
+	DefaultMountPointMode os.FileMode = 0755
+)
+
+var (
+	// mountOwnerExp matches a numeric owner, uid or uid:gid, the account names
+	// of the target can not be resolved before its content is installed
+	mountOwnerExp = regexp.MustCompile(`^[0-9]+(:[0-9]+)?$`)
+)
+
+// parseMountMode returns the permissions of the octal mount mode
+func parseMountMode(mode string) (os.FileMode, error) {
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, errors.Errorf("Invalid mount mode %q, must be an octal value such as 0755", mode)
+	}
+
+	return os.FileMode(value), nil
+}
+
+// parseMountOwner returns the uid and gid of the uid:gid mount owner, the
+// gid is -1, i.e. unchanged, when not set
+func parseMountOwner(owner string) (int, int, error) {
+	if !mountOwnerExp.MatchString(owner) {
+		return -1, -1, errors.Errorf("Invalid mount owner %q, must be a numeric uid or uid:gid", owner)
+	}
+
+	ids := strings.SplitN(owner, ":", 2)
+	uid, err := strconv.Atoi(ids[0])
+	if err != nil {
+		return -1, -1, errors.Wrap(err)
+	}
+
+	gid := -1
+	if len(ids) == 2 {
+		if gid, err = strconv.Atoi(ids[1]); err != nil {
+			return -1, -1, errors.Wrap(err)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// mountPointMode returns the mode of the mount point of bd
+func (bd *BlockDevice) mountPointMode() os.FileMode {
+	if bd.MountMode == "" {
+		return DefaultMountPointMode
+	}
+
+	mode, err := parseMountMode(bd.MountMode)
+	if err != nil {
+		log.Warning("Using the default mode of the %s mount point: %v", bd.Name, err)
+		return DefaultMountPointMode
+	}
+
+	return mode
+}
+
+// makeMountPoint creates the missing mount point directory path with mode,
+// its missing parent directories with DefaultMountPointMode; an existing
+// directory, i.e. the root directory, is left untouched
+func makeMountPoint(path string, mode os.FileMode) error {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), DefaultMountPointMode); err != nil {
+		return errors.Errorf("mkdir %s: %v", filepath.Dir(path), err)
+	}
+
+	if err := os.Mkdir(path, mode); err != nil {
+		return errors.Errorf("mkdir %s: %v", path, err)
+	}
+
+	// The mode is not restricted by the umask
+	if err := os.Chmod(path, mode); err != nil {
+		return errors.Errorf("chmod %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// setMountPointOwnership applies the declared mode and owner to the root
+// directory of the file system mounted at path
+func (bd *BlockDevice) setMountPointOwnership(path string) error {
+	if bd.MountMode != "" {
+		if err := os.Chmod(path, bd.mountPointMode()); err != nil {
+			return errors.Errorf("chmod %s: %v", path, err)
+		}
+	}
+
+	if bd.MountOwner != "" {
+		uid, gid, err := parseMountOwner(bd.MountOwner)
+		if err != nil {
+			return err
+		}
+
+		if err = os.Chown(path, uid, gid); err != nil {
+			return errors.Errorf("chown %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// validateMountOwnership checks the mount mode and owner of bd are well formed
+// and set on a mounted file system supporting them
+func validateMountOwnership(bd *BlockDevice) []string {
+	results := []string{}
+
+	if bd.MountMode == "" && bd.MountOwner == "" {
+		return results
+	}
+
+	if bd.MountPoint == "" || bd.FsType == "vfat" || bd.FsType == "swap" {
+		results = append(results,
+			utils.Locale.Get("Mount mode and owner of %s require a mount point and a POSIX file system", bd.Name))
+		return results
+	}
+
+	if bd.MountMode != "" {
+		if _, err := parseMountMode(bd.MountMode); err != nil {
+			results = append(results, utils.Locale.Get("Invalid mount mode of %s: %s", bd.Name, err.Error()))
+		}
+	}
+
+	if bd.MountOwner != "" {
+		if _, _, err := parseMountOwner(bd.MountOwner); err != nil {
+			results = append(results, utils.Locale.Get("Invalid mount owner of %s: %s", bd.Name, err.Error()))
+		}
+	}
+
+	return results
+}
+
+// validateMountPointConflicts checks no two partitions of the target medias
+// share a mount point, compared once cleaned so /home and /home/ conflict;
+// the secondary /boot partitions are not mounted and are left out
+func validateMountPointConflicts(children []*BlockDevice, mediaOpts MediaOpts) []string {
+	var results []string
+	mounted := map[string]*BlockDevice{}
+
+	for _, ch := range children {
+		if ch.MountPoint == "" || IsSecondaryBoot(ch, mediaOpts) {
+			continue
+		}
+
+		mountPoint := filepath.Clean(ch.MountPoint)
+		if other, found := mounted[mountPoint]; found {
+			results = append(results, logPartitionWarning(ch, "Mount point %s is used by both %s and %s",
+				mountPoint, other.partitionName(), ch.partitionName()))
+			continue
+		}
+
+		mounted[mountPoint] = ch
+	}
+
+	return results
+}
+
+// partitionName returns the name of the partition, predicted for new ones
+func (bd *BlockDevice) partitionName() string {
+	if bd.Name != "" {
+		return bd.Name
+	}
+
+	return bd.GetNewPartitionName(bd.partition)
+}
@@ -24,13 +24,32 @@ type blockDeviceYAMLMarshal struct {
 	Serial          string         `yaml:"serial,omitempty"`
 	MountPoint      string         `yaml:"mountpoint,omitempty"`
 	Label           string         `yaml:"label,omitempty"`
+	PartitionLabel  string         `yaml:"partitionLabel,omitempty"`
 	Size            string         `yaml:"size,omitempty"`
+	LogicalSector   uint64         `yaml:"logSec,omitempty"`
+	PhysicalSector  uint64         `yaml:"phySec,omitempty"`
 	ReadOnly        string         `yaml:"ro,omitempty"`
 	RemovableDevice string         `yaml:"rm,omitempty"`
+	Rotational      string         `yaml:"rota,omitempty"`
 	Type            string         `yaml:"type,omitempty"`
 	State           string         `yaml:"state,omitempty"`
 	Children        []*BlockDevice `yaml:"children,omitempty"`
 	Options         string         `yaml:"options,omitempty"`
+	CreateOnly      bool           `yaml:"createOnly,omitempty"`
+	ReuseESP        bool           `yaml:"reuseEsp,omitempty"`
+	ReusePartition  bool           `yaml:"reuse,omitempty"`
+	Attributes      []string       `yaml:"attributes,omitempty,flow"`
+	FreeSpace       bool           `yaml:"freeSpace,omitempty"`
+	PassphraseFile  string         `yaml:"passphraseFile,omitempty"`
+	Order           int            `yaml:"order,omitempty"`
+	MountOptions    string         `yaml:"mountOptions,omitempty"`
+	MountMode       string         `yaml:"mountMode,omitempty"`
+	MountOwner      string         `yaml:"mountOwner,omitempty"`
+	BtrfsProfile    string         `yaml:"btrfsProfile,omitempty"`
+	BtrfsMember     bool           `yaml:"btrfsMember,omitempty"`
+	XfsReflink      string         `yaml:"xfsReflink,omitempty"`
+	XfsCrc          string         `yaml:"xfsCrc,omitempty"`
+	XfsQuota        string         `yaml:"xfsQuota,omitempty"`
 }
 
 // UnmarshalJSON decodes a BlockDevice, targeted to integrate with json
@@ -91,6 +110,14 @@ func (bd *BlockDevice) UnmarshalJSON(b []byte) error {
 			}
 
 			bd.Size = size
+		case "log-sec":
+			if bd.LogicalSector, err = getNextByteToken(dec, "log-sec"); err != nil {
+				return err
+			}
+		case "phy-sec":
+			if bd.PhysicalSector, err = getNextByteToken(dec, "phy-sec"); err != nil {
+				return err
+			}
 		case "pttype":
 			var pttype string
 
@@ -171,6 +198,14 @@ func (bd *BlockDevice) UnmarshalJSON(b []byte) error {
 			}
 
 			bd.PartitionLabel = label
+		case "parttype":
+			var partType string
+
+			if partType, err = getNextStrToken(dec, "parttype"); err != nil {
+				return err
+			}
+
+			bd.PartitionType = partType
 		case "ro":
 			if bd.ReadOnly, err = getNextBoolToken(dec, "ro"); err != nil {
 				return err
@@ -179,6 +214,10 @@ func (bd *BlockDevice) UnmarshalJSON(b []byte) error {
 			if bd.RemovableDevice, err = getNextBoolToken(dec, "rm"); err != nil {
 				return err
 			}
+		case "rota":
+			if bd.Rotational, err = getNextBoolToken(dec, "rota"); err != nil {
+				return err
+			}
 		case "children":
 			bd.Children = []*BlockDevice{}
 			if err := dec.Decode(&bd.Children); err != nil {
@@ -285,13 +324,36 @@ func (bd *BlockDevice) MarshalYAML() (interface{}, error) {
 	bdm.Serial = bd.Serial
 	bdm.MountPoint = bd.MountPoint
 	bdm.Label = bd.Label
+	bdm.PartitionLabel = bd.PartitionLabel
 	bdm.Size = strconv.FormatUint(bd.Size, 10)
+	bdm.LogicalSector = bd.LogicalSector
+	bdm.PhysicalSector = bd.PhysicalSector
 	bdm.ReadOnly = strconv.FormatBool(bd.ReadOnly)
 	bdm.RemovableDevice = strconv.FormatBool(bd.RemovableDevice)
 	bdm.Type = bd.Type.String()
 	bdm.State = bd.State.String()
 	bdm.Children = bd.Children
 	bdm.Options = bd.Options
+	bdm.CreateOnly = bd.CreateOnly
+	bdm.ReuseESP = bd.ReuseESP
+	bdm.ReusePartition = bd.ReusePartition
+	bdm.Attributes = bd.Attributes
+	bdm.FreeSpace = bd.FreeSpace
+	bdm.PassphraseFile = bd.PassphraseFile
+	bdm.Order = bd.Order
+	bdm.MountOptions = bd.MountOptions
+	bdm.MountMode = bd.MountMode
+	bdm.MountOwner = bd.MountOwner
+	bdm.BtrfsProfile = bd.BtrfsProfile
+	bdm.BtrfsMember = bd.BtrfsMember
+	bdm.XfsReflink = bd.XfsReflink
+	bdm.XfsCrc = bd.XfsCrc
+	bdm.XfsQuota = bd.XfsQuota
+
+	// Only flag rotational devices, most install targets are not
+	if bd.Rotational {
+		bdm.Rotational = strconv.FormatBool(bd.Rotational)
+	}
 
 	return bdm, nil
 }
@@ -314,8 +376,26 @@ func (bd *BlockDevice) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	bd.Serial = unmarshBlockDevice.Serial
 	bd.MountPoint = unmarshBlockDevice.MountPoint
 	bd.Label = unmarshBlockDevice.Label
+	bd.PartitionLabel = unmarshBlockDevice.PartitionLabel
 	bd.Children = unmarshBlockDevice.Children
 	bd.Options = unmarshBlockDevice.Options
+	bd.CreateOnly = unmarshBlockDevice.CreateOnly
+	bd.ReuseESP = unmarshBlockDevice.ReuseESP
+	bd.ReusePartition = unmarshBlockDevice.ReusePartition
+	bd.Attributes = unmarshBlockDevice.Attributes
+	bd.FreeSpace = unmarshBlockDevice.FreeSpace
+	bd.PassphraseFile = unmarshBlockDevice.PassphraseFile
+	bd.Order = unmarshBlockDevice.Order
+	bd.MountOptions = unmarshBlockDevice.MountOptions
+	bd.MountMode = unmarshBlockDevice.MountMode
+	bd.MountOwner = unmarshBlockDevice.MountOwner
+	bd.BtrfsProfile = unmarshBlockDevice.BtrfsProfile
+	bd.BtrfsMember = unmarshBlockDevice.BtrfsMember
+	bd.XfsReflink = unmarshBlockDevice.XfsReflink
+	bd.XfsCrc = unmarshBlockDevice.XfsCrc
+	bd.XfsQuota = unmarshBlockDevice.XfsQuota
+	bd.LogicalSector = unmarshBlockDevice.LogicalSector
+	bd.PhysicalSector = unmarshBlockDevice.PhysicalSector
 	// Convert String to Uint64
 	if unmarshBlockDevice.Size != "" {
 		uSize, err := ParseVolumeSize(unmarshBlockDevice.Size)
@@ -335,8 +415,11 @@ func (bd *BlockDevice) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 		bd.Type = iType
 		if iType != BlockDeviceTypeDisk {
-			bd.MakePartition = true
-			bd.FormatPartition = true
+			// a reused EFI System Partition is neither created nor formatted,
+			// a reused partition is formatted in place
+			bd.MakePartition = !bd.ReuseESP && !bd.ReusePartition
+			// create only partitions are left empty for later use
+			bd.FormatPartition = !bd.CreateOnly && !bd.ReuseESP
 		}
 	}
 
@@ -367,5 +450,14 @@ func (bd *BlockDevice) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		bd.RemovableDevice = bRemovableDevice
 	}
 
+	// Map the Rotational bool
+	if unmarshBlockDevice.Rotational != "" {
+		bRotational, err := strconv.ParseBool(unmarshBlockDevice.Rotational)
+		if err != nil {
+			return err
+		}
+		bd.Rotational = bRotational
+	}
+
 	return nil
 }
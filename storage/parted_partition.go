@@ -7,13 +7,43 @@ package storage
 // PartedPartition hold partition information
 // Number 0 and FileSystem "free" are free spaces
 type PartedPartition struct {
-	Number     uint64 // partition number 0 indicates free space
-	Start      uint64 // starting byte location
-	End        uint64 // ending byte location
-	Size       uint64 // size in bytes
-	FileSystem string // file system Type
-	Name       string // partition name
-	Flags      string // flags for partition
+	Number     uint64 `yaml:"number"`               // partition number 0 indicates free space
+	Start      uint64 `yaml:"start"`                // starting byte location
+	End        uint64 `yaml:"end"`                  // ending byte location
+	Size       uint64 `yaml:"size"`                 // size in bytes
+	FileSystem string `yaml:"filesystem,omitempty"` // file system Type
+	Name       string `yaml:"name,omitempty"`       // partition name
+	Flags      string `yaml:"flags,omitempty"`      // flags for partition
+}
+
+// DiskLayout records the partition table of a disk
+type DiskLayout struct {
+	Disk       string             `yaml:"disk"`
+	Partitions []*PartedPartition `yaml:"partitions,omitempty"`
+}
+
+var (
+	// readPartitionTable reads the partition table of a disk, replaceable for testing
+	readPartitionTable = (*BlockDevice).getPartitionTable
+)
+
+// CapturePartitionLayouts returns the current partition table of each of the
+// target medias; it must be called before any change is made to the medias
+func CapturePartitionLayouts(targets map[string]InstallTarget, medias []*BlockDevice) []*DiskLayout {
+	layouts := []*DiskLayout{}
+
+	for _, curr := range medias {
+		if _, found := targets[curr.Name]; !found {
+			continue
+		}
+
+		layouts = append(layouts, &DiskLayout{
+			Disk:       curr.Name,
+			Partitions: parsePartitionTable(readPartitionTable(curr)),
+		})
+	}
+
+	return layouts
 }
 
 // Clone creates a copies a PartedPartition
@@ -103,3 +133,36 @@ func NewStandardPartitions(disk *BlockDevice) {
 		FormatPartition: true,
 	})
 }
+
+// AddHomeStandardPartition will add to disk a new standard /home partition,
+// used to place /home on a separate disk from /
+func AddHomeStandardPartition(disk *BlockDevice, homeSize uint64) {
+	freePart := disk.findFree(homeSize)
+	disk.AddFromFreePartition(freePart, &BlockDevice{
+		Size:            homeSize,
+		Type:            BlockDeviceTypePart,
+		FsType:          "ext4",
+		MountPoint:      "/home",
+		Label:           "home",
+		UserDefined:     true,
+		MakePartition:   true,
+		FormatPartition: true,
+	})
+}
+
+// NewHomePartitions will add to disk a single /home partition filling the
+// whole disk
+func NewHomePartitions(disk *BlockDevice) {
+	disk.Children = nil
+	newFreePart := &PartedPartition{
+		Number:     0,
+		Start:      0,
+		End:        disk.Size,
+		Size:       disk.Size,
+		FileSystem: "free",
+	}
+	disk.PartTable = nil
+	disk.PartTable = append(disk.PartTable, newFreePart)
+
+	AddHomeStandardPartition(disk, disk.Size)
+}
@@ -0,0 +1,102 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// ByBDOrder implements sort.Interface for []*BlockDevice based on the Order
+// field, the partitions without an order are kept after the ordered ones
+type ByBDOrder []*BlockDevice
+
+func (a ByBDOrder) Len() int      { return len(a) }
+func (a ByBDOrder) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+func (a ByBDOrder) Less(i, j int) bool {
+	if a[i].Order == 0 || a[j].Order == 0 {
+		return a[i].Order != 0 && a[j].Order == 0
+	}
+
+	return a[i].Order < a[j].Order
+}
+
+// hasPartitionOrder returns true if any partition of bd has an explicit order
+func (bd *BlockDevice) hasPartitionOrder() bool {
+	for _, ch := range bd.Children {
+		if ch.Order != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortPartitions sorts the partitions of bd in their creation order: by name,
+// unless any partition has an explicit order which then takes precedence
+func (bd *BlockDevice) sortPartitions() {
+	sort.Sort(ByBDName(bd.Children))
+
+	if bd.hasPartitionOrder() {
+		sort.Stable(ByBDOrder(bd.Children))
+	}
+}
+
+// renamePartitions renames the new partitions of a whole disk after their
+// creation order, parted numbers them as they are created so an explicit
+// order may not match the partition names
+func (bd *BlockDevice) renamePartitions() {
+	if !bd.hasPartitionOrder() {
+		return
+	}
+
+	number := uint64(0)
+	for _, ch := range bd.Children {
+		if !ch.MakePartition {
+			continue
+		}
+
+		number++
+		ch.SetPartitionNumber(number)
+		ch.Name = fmt.Sprintf("%s%d", bd.getBasePartitionName(), number)
+		ch.Path = ""
+		bd.setPartitionPath(ch)
+	}
+}
+
+// validatePartitionOrder checks the partition orders are positive and unique
+// on each disk
+func validatePartitionOrder(medias []*BlockDevice) []string {
+	results := []string{}
+
+	for _, curr := range medias {
+		orders := map[int]string{}
+
+		for _, ch := range curr.Children {
+			if ch.Order == 0 {
+				continue
+			}
+
+			if ch.Order < 0 {
+				results = append(results,
+					utils.Locale.Get("Invalid order %d for %s, must be a positive number", ch.Order, ch.Name))
+				continue
+			}
+
+			if other, found := orders[ch.Order]; found {
+				results = append(results,
+					utils.Locale.Get("Partitions %s and %s have the same order %d", other, ch.Name, ch.Order))
+				continue
+			}
+
+			orders[ch.Order] = ch.Name
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,140 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// PartitionTableGPT writes a GUID partition table; it is the default
+	PartitionTableGPT = "gpt"
+
+	// PartitionTableMSDOS writes a MBR (msdos) partition table, for the
+	// legacy BIOS systems unable to boot from a GPT disk
+	PartitionTableMSDOS = "msdos"
+
+	// maxGPTPartitions is the number of partitions of a gpt disk
+	maxGPTPartitions = 127
+
+	// maxMSDOSPartitions is the number of primary partitions of a msdos disk
+	maxMSDOSPartitions = 4
+)
+
+// GetPartitionTable returns the partition table type written to the whole
+// disk targets, PartitionTableGPT if none is set
+func (mo MediaOpts) GetPartitionTable() string {
+	if mo.PartitionTable == "" {
+		return PartitionTableGPT
+	}
+
+	return mo.PartitionTable
+}
+
+// partitionTable returns the partition table type of the disk, gpt when it
+// has none; lsblk reports the msdos tables as dos
+func (bd *BlockDevice) partitionTable() string {
+	switch bd.PtType {
+	case "":
+		return PartitionTableGPT
+	case "dos":
+		return PartitionTableMSDOS
+	}
+
+	return bd.PtType
+}
+
+// maxPartitions returns the number of partitions the disk table can hold
+func (bd *BlockDevice) maxPartitions() uint64 {
+	if bd.partitionTable() == PartitionTableMSDOS {
+		return maxMSDOSPartitions
+	}
+
+	return maxGPTPartitions
+}
+
+// msdosMakePartCommand replaces the GPT partition name of the mkpart command
+// by the primary type, msdos partitions have no name
+func msdosMakePartCommand(mkPart string) string {
+	fields := strings.Fields(mkPart)
+	if len(fields) > 1 {
+		fields[1] = "primary"
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// ValidatePartitionTable checks the partition table type is known and, for a
+// msdos table, that the medias are whole disk installs of at most 4 primary
+// partitions, booting in legacy BIOS mode, without any advanced, LVM or RAID
+// layout nor the GPT only partition labels and GUIDs
+func ValidatePartitionTable(medias []*BlockDevice, mediaOpts MediaOpts) error {
+	switch mediaOpts.GetPartitionTable() {
+	case PartitionTableGPT:
+		return nil
+	case PartitionTableMSDOS:
+	default:
+		return errors.ValidationErrorf("Invalid partitionTable value %q, expected %q or %q",
+			mediaOpts.PartitionTable, PartitionTableGPT, PartitionTableMSDOS)
+	}
+
+	if !mediaOpts.LegacyBoot() {
+		return errors.ValidationErrorf("partitionTable %q requires legacyBios", PartitionTableMSDOS)
+	}
+
+	if mediaOpts.DeterministicGUIDs {
+		return errors.ValidationErrorf("partitionTable %q does not support deterministicGUIDs",
+			PartitionTableMSDOS)
+	}
+
+	if results := validateMSDOSMedias(medias); len(results) > 0 {
+		return errors.ValidationErrorf(strings.Join(results, ", "))
+	}
+
+	return nil
+}
+
+// validateMSDOSMedias returns the layouts a msdos partition table can not hold
+func validateMSDOSMedias(medias []*BlockDevice) []string {
+	results := []string{}
+
+	for _, curr := range medias {
+		if curr.Type != BlockDeviceTypeDisk && curr.Type != BlockDeviceTypeLoop {
+			results = append(results,
+				utils.Locale.Get("%s is not a disk, a msdos partition table requires a whole disk", curr.Name))
+			continue
+		}
+
+		if len(curr.Children) > maxMSDOSPartitions {
+			results = append(results,
+				utils.Locale.Get("%s has %d partitions, a msdos partition table holds at most %d",
+					curr.Name, len(curr.Children), maxMSDOSPartitions))
+		}
+
+		for _, ch := range curr.Children {
+			if ch.LabeledAdvanced || !ch.MakePartition {
+				results = append(results,
+					utils.Locale.Get("Partition %s is not created, a msdos partition table requires a whole disk install",
+						ch.Name))
+			}
+
+			if ch.PartitionLabel != "" {
+				results = append(results,
+					utils.Locale.Get("Partition %s has a partitionLabel, msdos partitions have no name", ch.Name))
+			}
+
+			if ch.BtrfsMember || ch.FsType == "linux_raid_member" || ch.FsType == BlockDeviceTypeLVM2GroupString {
+				results = append(results,
+					utils.Locale.Get("Partition %s is a RAID or LVM member, not supported with a msdos partition table",
+						ch.Name))
+			}
+		}
+	}
+
+	return results
+}
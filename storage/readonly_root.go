@@ -0,0 +1,121 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// VarOverlayUnit is the unit mounting a writable overlay on /var when
+	// the root is read-only
+	VarOverlayUnit = "clr-installer-var-overlay.service"
+
+	// varOverlayWantedBy is the target pulling VarOverlayUnit at boot
+	varOverlayWantedBy = "local-fs.target"
+
+	// varOverlayDir holds the upper and work directories of the /var
+	// overlay, /run is a tmpfs so the changes are lost on reboot
+	varOverlayDir = "/run/clr-installer/var-overlay"
+)
+
+// readOnlyMountOptions returns the mount options with rw replaced by ro
+func readOnlyMountOptions(options string) string {
+	result := []string{}
+
+	for _, opt := range strings.Split(options, ",") {
+		if opt == "rw" || opt == "ro" || opt == "defaults" || opt == "" {
+			continue
+		}
+		result = append(result, opt)
+	}
+
+	return strings.Join(append([]string{"ro"}, result...), ",")
+}
+
+// ValidateReadOnlyRoot checks the layout of a read-only root: the /var overlay
+// would hide a separate /var partition and the root file system can not be
+// expanded once mounted read-only
+func ValidateReadOnlyRoot(medias []*BlockDevice, mediaOpts MediaOpts) error {
+	if !mediaOpts.ReadOnlyRoot {
+		return nil
+	}
+
+	if mediaOpts.ExpandLVMRoot {
+		return errors.ValidationErrorf("readOnlyRoot can not be used with expandLvmRoot")
+	}
+
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			if ch.MountPoint == "/var" || strings.HasPrefix(ch.MountPoint, "/var/") {
+				return errors.ValidationErrorf("readOnlyRoot can not be used with the %s partition %s, "+
+					"/var is a tmpfs overlay", ch.MountPoint, ch.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// varOverlayUnit returns the systemd unit mounting a tmpfs backed overlay on
+// /var, early enough for the journal and the tmpfiles to use it
+func varOverlayUnit() string {
+	upper := filepath.Join(varOverlayDir, "upper")
+	work := filepath.Join(varOverlayDir, "work")
+
+	unit := []string{
+		"[Unit]",
+		"Description=Writable tmpfs overlay on /var for the read-only root",
+		"DefaultDependencies=no",
+		"After=local-fs-pre.target systemd-remount-fs.service",
+		"Before=" + varOverlayWantedBy + " systemd-tmpfiles-setup.service systemd-journal-flush.service",
+		"ConditionPathIsReadWrite=!/var",
+		"",
+		"[Service]",
+		"Type=oneshot",
+		"RemainAfterExit=yes",
+		"ExecStart=/usr/bin/mkdir -p " + upper + " " + work,
+		"ExecStart=/usr/bin/mount -t overlay overlay -o lowerdir=/var,upperdir=" + upper +
+			",workdir=" + work + " /var",
+		"",
+		"[Install]",
+		"WantedBy=" + varOverlayWantedBy,
+		"",
+	}
+
+	return strings.Join(unit, "\n")
+}
+
+// InstallReadOnlyRootUnits writes and enables, in the target rootDir, the
+// unit mounting a writable overlay on /var
+func InstallReadOnlyRootUnits(rootDir string) error {
+	unitDir := filepath.Join(rootDir, "etc", "systemd", "system")
+	wantsDir := filepath.Join(unitDir, varOverlayWantedBy+".wants")
+	unitFile := filepath.Join(unitDir, VarOverlayUnit)
+
+	if err := utils.MkdirAll(wantsDir, 0755); err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Info("Enabling the /var overlay of the read-only root")
+
+	if err := ioutil.WriteFile(unitFile, []byte(varOverlayUnit()), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	link := filepath.Join(wantsDir, VarOverlayUnit)
+	if err := os.Symlink(filepath.Join("/etc/systemd/system", VarOverlayUnit), link); err != nil && !os.IsExist(err) {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// ReusedPartitions returns the partitions of the disk referencing an existing
+// partition to be formatted in place
+func (bd *BlockDevice) ReusedPartitions() []*BlockDevice {
+	results := []*BlockDevice{}
+
+	for _, ch := range bd.Children {
+		if ch.ReusePartition {
+			results = append(results, ch)
+		}
+	}
+
+	return results
+}
+
+// findReusedPartition returns the partition of the scanned disk referenced by
+// the configured one, by name, i.e. sdb3, or by file system UUID
+func (bd *BlockDevice) findReusedPartition(configured *BlockDevice) *BlockDevice {
+	for _, ch := range bd.Children {
+		if configured.Name != "" && ch.Name == configured.Name {
+			return ch
+		}
+
+		if configured.UUID != "" && strings.EqualFold(ch.UUID, configured.UUID) {
+			return ch
+		}
+	}
+
+	return nil
+}
+
+// ReuseExistingPartitions formats the existing partitions of the scanned disk
+// referenced by the reuse partitions of the configured disk in place; their
+// number and position are kept and the partition table is not rewritten
+func ReuseExistingPartitions(scanned *BlockDevice, configured *BlockDevice) error {
+	for _, part := range configured.ReusedPartitions() {
+		if part.Name == "" && part.UUID == "" {
+			return errors.ValidationErrorf("Reused partition of %s requires a name or uuid", configured.Name)
+		}
+
+		existing := scanned.findReusedPartition(part)
+		if existing == nil {
+			ref := part.Name
+			if ref == "" {
+				ref = "UUID=" + part.UUID
+			}
+			return errors.ValidationErrorf("Reused partition %s not found on %s", ref, scanned.Name)
+		}
+
+		if existing.Type != BlockDeviceTypePart {
+			return errors.ValidationErrorf("Reused partition %s is not a plain partition", existing.Name)
+		}
+
+		existing.FsType = part.FsType
+		existing.MountPoint = part.MountPoint
+		existing.Label = part.Label
+		existing.Options = part.Options
+		existing.MountOptions = part.MountOptions
+		existing.MountMode = part.MountMode
+		existing.MountOwner = part.MountOwner
+		existing.ReusePartition = true
+		existing.MakePartition = false
+		existing.FormatPartition = true
+		existing.UserDefined = true
+
+		log.Info("Formatting the existing partition %s as %s in place", existing.Name, existing.MountPoint)
+	}
+
+	return nil
+}
+
+// validateReusePartition checks a reused partition is only formatted, it is
+// neither created in the free space nor left empty
+func validateReusePartition(bd *BlockDevice) []string {
+	var results []string
+
+	if bd.FreeSpace || bd.CreateOnly || bd.ReuseESP {
+		results = append(results,
+			utils.Locale.Get("Reused partition %s can not set freeSpace, createOnly nor reuseEsp", bd.Name))
+	}
+
+	if bd.FsType == "" {
+		results = append(results, utils.Locale.Get("Reused partition %s requires a file system type", bd.Name))
+	}
+
+	return results
+}
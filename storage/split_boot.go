@@ -0,0 +1,158 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"path/filepath"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// ESPMountPoint is the mount point of the EFI System Partition of a split
+	// boot layout, the kernels are stored on the XBOOTLDR partition at /boot
+	ESPMountPoint = "/boot/efi"
+
+	// xbootldrGUID is the partition type GUID of the Extended Boot Loader
+	// partition defined by the Boot Loader Specification
+	xbootldrGUID = "BC13C2FF-59E6-4262-A352-B275FD6F7172"
+
+	// systemdBootImage is the systemd-boot EFI binary shipped in the target
+	systemdBootImage = "/usr/lib/systemd/boot/efi/systemd-bootx64.efi"
+)
+
+var (
+	// minSplitESPSize is the size of the EFI System Partition of a split boot
+	// layout, it only holds the boot loader
+	minSplitESPSize = uint64(64) * (1024 * 1024)
+)
+
+// findSplitESP returns the EFI System Partition mounted at /boot/efi, nil if
+// the disk has none
+func (bd *BlockDevice) findSplitESP() *BlockDevice {
+	for _, ch := range bd.Children {
+		if ch.MountPoint == ESPMountPoint {
+			return ch
+		}
+	}
+
+	return nil
+}
+
+// partitionGUID returns the partition type GUID of the partition ch of the
+// disk bd; with splitBoot the /boot/efi partition is the ESP and the /boot
+// partition the XBOOTLDR
+func (bd *BlockDevice) partitionGUID(ch *BlockDevice) string {
+	if bd.splitBoot && ch.MountPoint == ESPMountPoint && ch.FsType == "vfat" {
+		return guidMap["efi"]
+	}
+
+	if bd.splitBoot && ch.MountPoint == "/boot" && bd.findSplitESP() != nil {
+		return xbootldrGUID
+	}
+
+	return ch.getGUID()
+}
+
+// validateSplitESP checks the EFI System Partition of a split boot layout is
+// a single unencrypted vfat partition large enough for the boot loader
+func validateSplitESP(found *bool, esp *BlockDevice, mediaOpts MediaOpts) []string {
+	results := []string{}
+	espLabel := ESPMountPoint + " (ESP)"
+
+	if *found {
+		results = append(results, logPartitionWarning(esp, "Found multiple %s partitions", espLabel))
+	}
+	*found = true
+
+	if !mediaOpts.SkipValidationAll && esp.FsType != "vfat" {
+		results = append(results, logPartitionMustBeWarning(esp, espLabel, "vfat"))
+	}
+
+	if esp.Type == BlockDeviceTypeCrypt {
+		results = append(results, logPartitionWarning(esp, "%s can not be encrypted", espLabel))
+	}
+
+	if esp.Size == 0 {
+		log.Warning("validatePartitions: Skipping %s size check due to zero size", espLabel)
+	} else if mediaOpts.SkipValidationSize {
+		log.Warning("validatePartitions: Skipping %s size check due to skipSize", espLabel)
+	} else if esp.Size < minSplitESPSize {
+		results = append(results, logPartitionSizeWarning(esp, minSplitESPSize, espLabel))
+	}
+
+	return results
+}
+
+// validateSplitBoot checks the split boot layout: an EFI System Partition at
+// /boot/efi on the same disk as the XBOOTLDR partition at /boot, validateBoot
+// checks each of them; without splitBoot /boot/efi is a regular partition
+func validateSplitBoot(medias []*BlockDevice, mediaOpts MediaOpts) []string {
+	results := []string{}
+
+	if !mediaOpts.SplitBoot {
+		return results
+	}
+
+	found := false
+	for _, curr := range medias {
+		esp := curr.findSplitESP()
+		if esp == nil {
+			continue
+		}
+		found = true
+
+		hasBoot := false
+		for _, ch := range curr.Children {
+			hasBoot = hasBoot || ch.MountPoint == "/boot"
+		}
+
+		// The Boot Loader Specification requires both partitions on the same disk
+		if !hasBoot {
+			results = append(results,
+				logPartitionWarning(esp, "splitBoot requires a /boot (XBOOTLDR) partition on %s", curr.Name))
+		}
+	}
+
+	if !found {
+		results = append(results, logMissingPartition(ESPMountPoint+" (ESP)"))
+	}
+
+	if mediaOpts.LegacyBoot() {
+		results = append(results, logPartitionWarning(nil, "splitBoot is not supported with legacyBios"))
+	}
+
+	if mediaOpts.GetPartitionTable() == PartitionTableMSDOS {
+		results = append(results, logPartitionWarning(nil, "splitBoot requires a %s partition table",
+			PartitionTableGPT))
+	}
+
+	return results
+}
+
+// InstallSplitBootESP installs the systemd-boot binary of the target on the
+// ESP mounted at /boot/efi; clr-boot-manager keeps the kernels and their boot
+// entries on the XBOOTLDR partition at /boot, where systemd-boot finds them
+func InstallSplitBootESP(rootDir string) error {
+	src := filepath.Join(rootDir, systemdBootImage)
+	espDir := filepath.Join(rootDir, ESPMountPoint)
+
+	// The firmware boots the fallback path, no EFI variable is needed
+	for _, dest := range []string{"EFI/systemd/systemd-bootx64.efi", "EFI/BOOT/BOOTX64.EFI"} {
+		target := filepath.Join(espDir, dest)
+
+		if err := utils.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return errors.Wrap(err)
+		}
+
+		if err := utils.CopyFile(src, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
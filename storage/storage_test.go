@@ -6,14 +6,23 @@ package storage
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"sort"
+	"strings"
+	"syscall"
 	"testing"
 	"text/template"
 	"time"
 
+	"gopkg.in/yaml.v2"
+
+	"github.com/clearlinux/clr-installer/errors"
 	"github.com/clearlinux/clr-installer/progress"
 	"github.com/clearlinux/clr-installer/utils"
 )
@@ -67,7 +76,7 @@ func TestGetDeviceFile(t *testing.T) {
 }
 
 func TestSupportedFileSystem(t *testing.T) {
-	expected := []string{"btrfs", "ext2", "ext3", "ext4", "swap", "vfat", "xfs", "f2fs"}
+	expected := []string{"btrfs", "ext2", "ext3", "ext4", "swap", "vfat", "xfs", "f2fs", "nilfs2", "jfs"}
 	supported := []string{}
 	tot := 0
 
@@ -91,6 +100,37 @@ func TestSupportedFileSystem(t *testing.T) {
 	if tot != len(expected) {
 		t.Fatal("supported file system list don't match the expected")
 	}
+
+	for fsType, expected := range map[string]string{
+		"nilfs2": "mkfs.nilfs2 -L data -f",
+		"jfs":    "mkfs.jfs -L data -q",
+	} {
+		bd := &BlockDevice{Name: "sda4", FsType: fsType, Label: "data", MountPoint: "/mnt/data"}
+		command, err := bdOps[fsType].makeFsCommand(bd, bdOps[fsType].makeFsArgs)
+		if err != nil || strings.Join(command, " ") != expected {
+			t.Fatalf("Expected the %s make fs command %q, got %q: %v", fsType, expected, command, err)
+		}
+
+		// Data only file systems, clr-boot-manager can't boot from them
+		found := false
+		if _, results := validateRoot(&found, bd, 0, true, "/"); len(results) == 0 {
+			t.Fatalf("%s should be refused for /", fsType)
+		}
+
+		found = false
+		bd.MountPoint = "/boot"
+		if results := validateBoot(&found, bd, MediaOpts{SkipValidationSize: true}, "/boot"); len(results) == 0 {
+			t.Fatalf("%s should be refused for /boot", fsType)
+		}
+
+		// An existing partition is reused, mounted without being formatted
+		reused := &BlockDevice{Name: "sda5", Type: BlockDeviceTypePart, FsType: fsType,
+			PartitionLabel: "CLR_MNT_/srv/legacy"}
+		if !hasAdvancedInstallTarget([]*BlockDevice{reused}) || reused.FormatPartition ||
+			reused.FsType != fsType || reused.MountPoint != "/srv/legacy" {
+			t.Fatalf("The %s partition should be mounted as is: %+v", fsType, reused)
+		}
+	}
 }
 
 func TestFailListBlockDevices(t *testing.T) {
@@ -210,6 +250,55 @@ func TestSizeUnits(t *testing.T) {
 	}
 }
 
+func TestSizeUnitsXiB(t *testing.T) {
+	templateStr := `{
+    "blockdevices": [
+        {
+           {{.Value}}
+        }
+    ]
+}`
+
+	tests := []struct {
+		size  uint64
+		Value string
+	}{
+		{512, `"size": "512b"`},
+		{1000, `"size": "1KB"`},
+		{1500, `"size": "1.5kb"`},
+		{1024, `"size": "1KiB"`},
+		{1000000, `"size": "1MB"`},
+		{1048576, `"size": "1MiB"`},
+		{4000000000, `"size": "4GB"`},
+		{4294967296, `"size": "4GiB"`},
+		{4294967296, `"size": "4G"`},
+		{1500000000000, `"size": "1.5TB"`},
+		{1649267441664, `"size": "1.5TiB"`},
+		{1000000000000000, `"size": "1PB"`},
+		{1125899906842624, `"size": "1PiB"`},
+	}
+
+	tmpl, err := template.New("").Parse(templateStr)
+	if err != nil {
+		t.Fatalf("Failed to parse template: %s", err)
+	}
+
+	for _, curr := range tests {
+		w := bytes.NewBuffer(nil)
+
+		err = tmpl.Execute(w, curr)
+		if err != nil {
+			t.Fatalf("Failed to execute template: %s", err)
+		}
+
+		bd, _ := parseBlockDevicesDescriptor(w.Bytes())
+		if bd[0].Size != curr.size {
+			t.Fatalf("Parsed size of %s: %d doesn't match the expected size: %d",
+				curr.Value, bd[0].Size, curr.size)
+		}
+	}
+}
+
 func TestListBlockDevices(t *testing.T) {
 	if !utils.IsRoot() {
 		t.Log("Not running as 'root', not using Loopback device")
@@ -419,9 +508,11 @@ func TestNullRemovable(t *testing.T) {
 	}
 }
 
-func TestRAID(t *testing.T) {
-	//nolint: lll // WONTFIX
-	lsblkOutput := `{
+// raidLsblkOutput lists two disks whose partitions are the members of the
+// assembled RAID array md127
+//
+//nolint:lll // WONTFIX
+var raidLsblkOutput = `{
    "blockdevices": [
       {"name":"sdb", "kname":"sdb", "path":"/dev/sdb", "maj:min":"8:16", "fsavail":null, "fssize":null, "fstype":null, "fsused":null, "fsuse%":null, "mountpoint":null, "label":null, "pttype":"gpt", "parttype":null, "partlabel":null, "ra":1024, "ro":false, "rm":false, "hotplug":false, "size":1000204886016, "state":"running", "owner":"root", "group":"disk", "mode":"brw-rw----", "alignment":0, "min-io":4096, "opt-io":0, "phy-sec":4096, "log-sec":512, "rota":false, "sched":"bfq", "rq-size":1024, "type":"disk", "disc-aln":0, "disc-gran":4096, "disc-max":2147450880, "disc-zero":false, "wsame":0, "wwn":"0x500a0751e1eda080", "rand":true, "pkname":null, "hctl":"7:0:0:0", "tran":"sata", "subsystems":"block:scsi:pci", "rev":"023 ", "vendor":"ATA     ", "zoned":"none",
          "children": [
@@ -444,10 +535,83 @@ func TestRAID(t *testing.T) {
    ]
 }`
 
-	_, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+func TestRAID(t *testing.T) {
+	bds, err := parseBlockDevicesDescriptor([]byte(raidLsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parser block device descriptor: %s", err)
+	}
+
+	// The RAID members are 512e disks
+	for _, bd := range bds {
+		if bd.LogicalSector != 512 || bd.PhysicalSector != 4096 || !bd.Uses4KSectors() {
+			t.Fatalf("Expected 512/4096 sectors for %s, got %d/%d", bd.Name, bd.LogicalSector, bd.PhysicalSector)
+		}
+
+		for _, ch := range bd.Children {
+			if ch.LogicalSector != 512 || ch.PhysicalSector != 4096 {
+				t.Fatalf("Expected 512/4096 sectors for %s, got %d/%d", ch.Name, ch.LogicalSector,
+					ch.PhysicalSector)
+			}
+		}
+	}
+}
+
+func TestActiveMemberTargets(t *testing.T) {
+	bds, err := parseBlockDevicesDescriptor([]byte(raidLsblkOutput))
 	if err != nil {
 		t.Fatalf("Could not parser block device descriptor: %s", err)
 	}
+
+	targets := FindAllInstallTargets(0, bds, MediaOpts{})
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 install targets, got %d", len(targets))
+	}
+
+	selected := map[string]InstallTarget{}
+	for _, target := range targets {
+		if !reflect.DeepEqual(target.ActiveMembers, []string{"md127"}) {
+			t.Fatalf("Expected %s to be a member of md127, got %v", target.Name, target.ActiveMembers)
+		}
+
+		warning := target.activeMemberWarning()
+		if !strings.HasPrefix(warning, target.Name+": ") || !strings.Contains(warning, "md127") {
+			t.Fatalf("Unexpected active member warning for %s: %q", target.Name, warning)
+		}
+
+		if err = target.checkActiveMembers(false); err == nil {
+			t.Fatalf("Erasing the active member %s should require force destructive", target.Name)
+		}
+
+		if err = target.checkActiveMembers(true); err != nil {
+			t.Fatalf("Erasing the active member %s should be allowed when forced: %v", target.Name, err)
+		}
+
+		selected[target.Name] = target
+	}
+
+	if !HasActiveMemberTargets(selected) {
+		t.Fatal("The RAID members should be reported as active member targets")
+	}
+
+	// The partitions are not touched before the membership is confirmed
+	if err = PrepareInstallationMedia(selected, bds, MediaOpts{}, nil); err == nil {
+		t.Fatal("PrepareInstallationMedia should refuse the unconfirmed active members")
+	}
+
+	// Inactive members, i.e. not assembled arrays, are not reported
+	for _, bd := range bds {
+		for _, ch := range bd.Children {
+			ch.Children = nil
+		}
+
+		if members := bd.ActiveMembers(); len(members) != 0 {
+			t.Fatalf("Expected no active member for %s, got %v", bd.Name, members)
+		}
+	}
+
+	if HasActiveMemberTargets(map[string]InstallTarget{"sda": {Name: "sda"}}) {
+		t.Fatal("A target without members should not be reported")
+	}
 }
 
 func TestWritePartition(t *testing.T) {
@@ -465,7 +629,7 @@ func TestWritePartition(t *testing.T) {
 	children := make([]*BlockDevice, 0)
 	bd := &BlockDevice{Name: "", Size: 1288490188, Type: BlockDeviceTypeLoop, Children: children}
 
-	if err = MakeImage(bd, imageFile); err != nil {
+	if err = MakeImage(bd, imageFile, ImageFormatRaw); err != nil {
 		t.Fatalf("Could not make image file: %s", err)
 	}
 
@@ -583,7 +747,7 @@ func TestWritePartition(t *testing.T) {
 		}
 		bds := []*BlockDevice{bd}
 
-		found := FindAdvancedInstallTargets(bds)
+		found := FindAdvancedInstallTargets(bds, MediaOpts{})
 		if len(found) == 0 {
 			t.Fatalf("Should have found any advanced targets %+v", found)
 		}
@@ -806,11 +970,59 @@ func TestWriteConfigFiles(t *testing.T) {
 		_ = os.RemoveAll(rootDir)
 	}()
 
-	if err := GenerateTabFiles(rootDir, bds); err != nil {
+	if err := GenerateTabFiles(rootDir, bds, MediaOpts{}); err != nil {
 		t.Fatalf("Failed to create directories to write config file: %v\n", err)
 	}
 }
 
+func TestWriteVarFstab(t *testing.T) {
+	//nolint: lll // WONTFIX
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name": "sdg", "maj:min": "8:96", "rm": "0", "size": "64G", "rw": "0", "type": "disk", "mountpoint": null,
+         "children": [
+            {"name": "sdg1", "maj:min": "8:97", "rm": "0", "fstype": "vfat", "label": "boot", "size": "512M", "rw": "0", "type": "part", "mountpoint": "/boot"},
+            {"name": "sdg2", "maj:min": "8:98", "rm": "0", "fstype": "ext4", "label": "root", "size": "20G", "rw": "0", "type": "part", "mountpoint": "/"},
+            {"name": "sdg3", "maj:min": "8:99", "rm": "0", "fstype": "ext4", "label": "var", "size": "30G", "rw": "0", "type": "part", "mountpoint": "/var"},
+            {"name": "sdg4", "maj:min": "8:100", "rm": "0", "fstype": "ext4", "label": "home", "size": "10G", "rw": "0", "type": "part", "mountpoint": "/home"}
+         ]
+      }
+   ]
+}`
+
+	bds, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parser block device descriptor: %s", err)
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, bds, MediaOpts{}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	if fstab := strings.TrimSpace(string(content)); fstab != "LABEL=var /var ext4 defaults 0 2" {
+		t.Fatalf("Unexpected fstab content: %q", fstab)
+	}
+
+	varPart := bds[0].Children[2]
+	if guid := varPart.getGUID(); guid != "4D21B016-B534-45C2-A9FB-5C16E091FD2D" {
+		t.Fatalf("Unexpected /var partition type GUID: %s", guid)
+	}
+}
+
 func TestInstallTargets(t *testing.T) {
 	getPartAllFreeOutput := `
 BYT;
@@ -900,6 +1112,141 @@ BYT;
 		t.Fatalf("Should NOT have found %d free in getPartNotEnoughFree3Output", twentyGig)
 	}
 	t.Logf("getPartNotEnoughFree3Output: start: %d, end: %d", start, end)
+
+	// The leading blank line, unit header and disk line are skipped, the
+	// same entries are parsed from quirky CRLF and unterminated output
+	fixtures := []struct {
+		name    string
+		output  string
+		entries int
+	}{
+		{"getPartAllFreeOutput", getPartAllFreeOutput, 1},
+		{"getPartSomeFreeOutput", getPartSomeFreeOutput, 4},
+		{"getPartNotEnoughFreeOutput", getPartNotEnoughFreeOutput, 6},
+		{"getPartNotEnoughFree2Output", getPartNotEnoughFree2Output, 7},
+		{"getPartNotEnoughFree3Output", getPartNotEnoughFree3Output, 5},
+	}
+
+	for _, fixture := range fixtures {
+		variants := []string{
+			fixture.output,
+			strings.ReplaceAll(fixture.output, "\n", "\r\n"),
+			strings.TrimSpace(fixture.output),
+			strings.ReplaceAll(fixture.output, ";\n", ";\n\n"),
+		}
+
+		for _, variant := range variants {
+			bd.setPartitionTable(bytes.NewBufferString(variant))
+			if len(bd.PartTable) != fixture.entries {
+				t.Fatalf("Expected %d entries in %s, got %d from %q", fixture.entries, fixture.name,
+					len(bd.PartTable), variant)
+			}
+
+			free := bd.PartTable[len(bd.PartTable)-1]
+			if free.Number != 0 || free.FileSystem != "free" || free.Size == 0 {
+				t.Fatalf("Expected the %s free space last, got %+v", fixture.name, free)
+			}
+		}
+	}
+
+	bd.setPartitionTable(bytes.NewBufferString("\nBYT;\nWarning: unexpected geometry\n"))
+	if len(bd.PartTable) != 0 {
+		t.Fatalf("Expected no entries from an output without partitions, got %+v", bd.PartTable)
+	}
+}
+
+func TestAddFreeSpacePartitions(t *testing.T) {
+	getPartSomeFreeOutput := `
+BYT;
+/dev/sdc:2000398934016B:scsi:512:4096:gpt:ATA ST2000DM001-1ER1:;
+1:17408B:150000127B:149982720B:fat32:EFI:boot, esp;
+2:150000128B:2198000127B:2048000000B:linux-swap(v1):linux-swap:;
+3:2198000128B:1907729000447B:1905531000320B:ext4:/:;
+1:1907729000448B:2000398917119B:92669916672B:free;
+`
+	newScanned := func() *BlockDevice {
+		bd := &BlockDevice{Name: "sdc", Type: BlockDeviceTypeDisk, Size: 2000398934016}
+		for i := 1; i <= 3; i++ {
+			bd.AddChild(&BlockDevice{Name: fmt.Sprintf("sdc%d", i), Type: BlockDeviceTypePart})
+		}
+		bd.setPartitionTable(bytes.NewBuffer([]byte(getPartSomeFreeOutput)))
+		return bd
+	}
+
+	var configured BlockDevice
+	config := `{name: sdc, type: disk, children: [
+	  {freeSpace: true, type: part, fstype: ext4, mountpoint: /data, size: 40G},
+	  {freeSpace: true, type: part, fstype: xfs, mountpoint: /srv}]}`
+	if err := yaml.Unmarshal([]byte(config), &configured); err != nil {
+		t.Fatalf("Failed to parse the disk: %v", err)
+	}
+
+	scanned := newScanned()
+	if err := AddFreeSpacePartitions(scanned, &configured); err != nil {
+		t.Fatalf("Failed to add the free space partitions: %v", err)
+	}
+
+	if len(scanned.Children) != 5 {
+		t.Fatalf("Expected the 3 existing and 2 new partitions, got %d", len(scanned.Children))
+	}
+
+	for _, ch := range scanned.Children[:3] {
+		if ch.MakePartition || ch.FormatPartition {
+			t.Fatalf("Existing partition %s should be kept", ch.Name)
+		}
+	}
+
+	data, srv := scanned.Children[3], scanned.Children[4]
+	if data.Name != "sdc4" || data.GetPartitionNumber() != 4 || !data.MakePartition || !data.FormatPartition {
+		t.Fatalf("Unexpected /data partition %+v", data)
+	}
+
+	if srv.Name != "sdc5" || srv.GetPartitionNumber() != 5 {
+		t.Fatalf("Unexpected /srv partition %+v", srv)
+	}
+
+	// /data starts the free space, /srv fills what is left of it
+	freeStart, freeEnd := uint64(1907729000448), uint64(2000398917119)
+
+	start, end := scanned.getPartitionStartEnd(4)
+	if start != freeStart || end != freeStart+data.Size-1 {
+		t.Fatalf("Unexpected /data region %d-%d", start, end)
+	}
+
+	start, end = scanned.getPartitionStartEnd(5)
+	if start != freeStart+data.Size || end != freeEnd || srv.Size != freeEnd-start+1 {
+		t.Fatalf("Unexpected /srv region %d-%d of size %d", start, end, srv.Size)
+	}
+
+	if free := scanned.findFree(1); free != nil {
+		t.Fatalf("No free space should be left: %+v", free)
+	}
+
+	result := scanned.freeSpacePartitionResult(data)
+	if !strings.Contains(result, "1.7TiB - 1.8TiB") || !strings.Contains(result, "[40GiB]") {
+		t.Fatalf("Unexpected free space dry run result %q", result)
+	}
+
+	// The free space is too small for a 100GiB partition
+	var large BlockDevice
+	if err := yaml.Unmarshal([]byte("{name: sdc, children: [{freeSpace: true, mountpoint: /data, size: 100G}]}"),
+		&large); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddFreeSpacePartitions(newScanned(), &large); !errors.IsInsufficientSpaceError(err) {
+		t.Fatalf("Expected an insufficient space error, got %v", err)
+	}
+
+	var mixed BlockDevice
+	if err := yaml.Unmarshal([]byte("{name: sdc, children: [{freeSpace: true, mountpoint: /data}, {name: sdc3}]}"),
+		&mixed); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddFreeSpacePartitions(newScanned(), &mixed); err == nil {
+		t.Fatal("Mixing free space and existing partitions should fail")
+	}
 }
 
 func TestAddPartititions(t *testing.T) {
@@ -914,6 +1261,28 @@ func TestAddPartititions(t *testing.T) {
 	AddRootStandardPartition(bd, rootSize)
 }
 
+func TestHomePartitions(t *testing.T) {
+	bd := &BlockDevice{Name: "sdb", Size: MinimumServerInstallSize}
+
+	NewHomePartitions(bd)
+	if len(bd.Children) != 1 {
+		t.Fatalf("Expected a single /home partition, got %d", len(bd.Children))
+	}
+
+	home := bd.Children[0]
+	if home.MountPoint != "/home" || home.FsType != "ext4" || home.Size != bd.Size || !home.MakePartition {
+		t.Fatalf("Unexpected /home partition: %+v", home)
+	}
+
+	if err := CheckTargetMediasPresent([]*BlockDevice{{Name: "null", Path: "/dev/null"}}); err != nil {
+		t.Fatalf("/dev/null should be present: %v", err)
+	}
+
+	if err := CheckTargetMediasPresent([]*BlockDevice{{Name: "null"}, {Name: "clr-installer-missing"}}); err == nil {
+		t.Fatal("Missing media should have been reported")
+	}
+}
+
 // nolint: lll // WONTFIX
 var lsblkOutput = `{
    "blockdevices": [
@@ -1255,18 +1624,136 @@ func TestAdvancedPartitionValidation(t *testing.T) {
 
 	resetWith("sdc")
 	t.Logf("targets: %v", targets)
-	advTargets := FindAdvancedInstallTargets(targets)
+	advTargets := FindAdvancedInstallTargets(targets, MediaOpts{})
 	t.Logf("advTargets: %v", advTargets)
 	if !HasAdvancedSwap(advTargets) {
 		t.Fatalf("HasAdvancedSwap should be true for device %q", "sdc")
 	}
 	resetWith("sdd")
-	advTargets = FindAdvancedInstallTargets(targets)
+	advTargets = FindAdvancedInstallTargets(targets, MediaOpts{})
 	if HasAdvancedSwap(advTargets) {
 		t.Fatalf("HasAdvancedSwap should be false for device %q", "sdd")
 	}
 }
 
+func TestAdvancedFormatLabel(t *testing.T) {
+	disk := &BlockDevice{Name: "sdh", Type: BlockDeviceTypeDisk, Size: 64 << 30, Children: []*BlockDevice{
+		{Name: "sdh1", Type: BlockDeviceTypePart, FsType: "vfat", PartitionLabel: "CLR_BOOT", Size: 512 << 20},
+		{Name: "sdh2", Type: BlockDeviceTypePart, FsType: "ext4", PartitionLabel: "CLR_ROOT", Size: 20 << 30},
+		{Name: "sdh3", Type: BlockDeviceTypePart, PartitionLabel: "CLR_SWAP_F", Size: 1 << 30},
+		{Name: "sdh4", Type: BlockDeviceTypePart, PartitionLabel: "CLR_MNT_/srv", Size: 20 << 30},
+	}}
+
+	targets := FindAdvancedInstallTargets([]*BlockDevice{disk}, MediaOpts{})
+	if len(targets) != 1 {
+		t.Fatalf("Expected one advanced target, got %d", len(targets))
+	}
+
+	tests := []struct {
+		fsType string
+		format bool
+	}{
+		{"vfat", false},
+		{"ext4", false},
+		{"swap", true},
+		{"", false},
+	}
+
+	for i, curr := range tests {
+		ch := targets[0].Children[i]
+		if ch.FsType != curr.fsType || ch.FormatPartition != curr.format {
+			t.Fatalf("Expected %s with fstype %q and format %v, got %q and %v", ch.Name,
+				curr.fsType, curr.format, ch.FsType, ch.FormatPartition)
+		}
+	}
+
+	// The pre-formatted root is kept, the empty /srv must be marked for formatting
+	found := false
+	for _, result := range ServerValidateAdvancedPartitions(targets, MediaOpts{SkipValidationSize: true}) {
+		if strings.Contains(result, "sdh2") {
+			t.Fatalf("The pre-formatted root should be valid: %s", result)
+		}
+		found = found || strings.Contains(result, "sdh4")
+	}
+
+	if !found {
+		t.Fatalf("The unformatted sdh4 without _F should be refused")
+	}
+}
+
+func TestAdvancedFormatDataLoss(t *testing.T) {
+	newDisk := func(rootLabel string) *BlockDevice {
+		return &BlockDevice{Name: "sdk", Type: BlockDeviceTypeDisk, Size: 64 << 30, Children: []*BlockDevice{
+			{Name: "sdk1", Type: BlockDeviceTypePart, FsType: "vfat", PartitionLabel: "CLR_BOOT",
+				UUID: "1A2B-3C4D", Size: 512 << 20},
+			{Name: "sdk2", Type: BlockDeviceTypePart, FsType: "ext4", PartitionLabel: rootLabel,
+				UUID: "6a1f0d3e-5b4c-4e1a-9d2f-0c8b7a6e5d41", Size: 20 << 30},
+		}}
+	}
+
+	opts := MediaOpts{SkipValidationSize: true}
+
+	targets := FindAdvancedInstallTargets([]*BlockDevice{newDisk("CLR_ROOT")}, opts)
+	if len(targets) != 1 {
+		t.Fatalf("Expected one advanced target, got %d", len(targets))
+	}
+
+	root := targets[0].Children[1]
+	if !root.holdsFileSystem() {
+		t.Fatalf("The scanned ext4 root should hold a file system")
+	}
+
+	// A data-bearing CLR_ROOT without _F is reused, never erased
+	if root.FormatPartition {
+		t.Fatal("The data-bearing sdk2 should not be formatted without _F")
+	}
+
+	plan := NewInstallPlan(map[string]InstallTarget{"sdk": {Name: "sdk", Advanced: true}}, targets, opts)
+	for _, result := range plan.TargetResults {
+		if strings.Contains(result, "erased") {
+			t.Fatalf("The reused root should not be erased: %s", result)
+		}
+	}
+
+	// A CLR_ROOT without a file system can only be formatted with _F
+	blank := newDisk("CLR_ROOT")
+	blank.Children[1].FsType = ""
+	blank.Children[1].UUID = ""
+
+	targets = FindAdvancedInstallTargets([]*BlockDevice{blank}, opts)
+
+	found := false
+	for _, result := range ServerValidateAdvancedPartitions(targets, opts) {
+		found = found || strings.Contains(result, "Partition sdk2 has no file system, append _F to its label")
+	}
+
+	if !found {
+		t.Fatal("The root without a file system nor _F should be refused")
+	}
+
+	targets = FindAdvancedInstallTargets([]*BlockDevice{newDisk("CLR_ROOT_F")}, opts)
+	if !targets[0].Children[1].FormatPartition {
+		t.Fatal("The root labeled with _F should be formatted")
+	}
+
+	for _, result := range ServerValidateAdvancedPartitions(targets, opts) {
+		if strings.Contains(result, "sdk2") {
+			t.Fatalf("The root labeled with _F should be valid: %s", result)
+		}
+	}
+
+	plan = NewInstallPlan(map[string]InstallTarget{"sdk": {Name: "sdk", Advanced: true}}, targets, opts)
+	found = false
+	for _, result := range plan.TargetResults {
+		found = found || (strings.Contains(result, "sdk2") &&
+			strings.Contains(result, "The existing ext4 file system will be erased."))
+	}
+
+	if !found {
+		t.Fatalf("The dry run should report the erased file system: %v", plan.TargetResults)
+	}
+}
+
 func TestHumanReadableSize(t *testing.T) {
 	tests := []struct {
 		size      uint64
@@ -1428,3 +1915,3174 @@ func TestHumanReadableSizeXiB(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteVarLogFstab(t *testing.T) {
+	//nolint: lll // WONTFIX
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name": "sdf", "maj:min": "8:80", "rm": "0", "size": "64G", "rw": "0", "type": "disk", "mountpoint": null,
+         "children": [
+            {"name": "sdf1", "maj:min": "8:81", "rm": "0", "fstype": "vfat", "label": "boot", "size": "512M", "rw": "0", "type": "part", "mountpoint": "/boot"},
+            {"name": "sdf2", "maj:min": "8:82", "rm": "0", "fstype": "ext4", "label": "log", "size": "4G", "rw": "0", "type": "part", "mountpoint": "/var/log"},
+            {"name": "sdf3", "maj:min": "8:83", "rm": "0", "fstype": "ext4", "label": "root", "size": "20G", "rw": "0", "type": "part", "mountpoint": "/"},
+            {"name": "sdf4", "maj:min": "8:84", "rm": "0", "fstype": "xfs", "label": "var", "size": "30G", "rw": "0", "type": "part", "mountpoint": "/var"}
+         ]
+      }
+   ]
+}`
+
+	bds, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parser block device descriptor: %s", err)
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, bds, MediaOpts{}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 fstab entries, got: %q", lines)
+	}
+
+	if fields := strings.Fields(lines[0]); fields[1] != "/var" || fields[2] != "xfs" {
+		t.Fatalf("Expected /var as first fstab entry, got: %q", lines[0])
+	}
+
+	fields := strings.Fields(lines[1])
+	if fields[1] != "/var/log" || fields[2] != "ext4" || fields[5] != "2" {
+		t.Fatalf("Invalid /var/log fstab entry: %q", lines[1])
+	}
+
+	if guid := bds[0].Children[1].getGUID(); guid != "" {
+		t.Fatalf("/var/log should use the default partition type GUID, got: %s", guid)
+	}
+}
+
+func TestMultipleBootPartitions(t *testing.T) {
+	newDisk := func(name string, root bool) *BlockDevice {
+		disk := &BlockDevice{Name: name, Type: BlockDeviceTypeDisk}
+		disk.AddChild(&BlockDevice{Name: name + "1", FsType: "vfat", MountPoint: "/boot",
+			Type: BlockDeviceTypePart, partition: 1})
+		if root {
+			disk.AddChild(&BlockDevice{Name: name + "2", FsType: "ext4", MountPoint: "/",
+				Type: BlockDeviceTypePart, partition: 2})
+		}
+		return disk
+	}
+
+	medias := []*BlockDevice{newDisk("sda", true), newDisk("sdb", false)}
+
+	dryRun := &DryRunType{&[]string{}, &[]string{}}
+	if err := setBootPartition(medias, MediaOpts{}, dryRun); err == nil {
+		t.Fatal("Multiple /boot partitions without a primary should fail")
+	}
+
+	mediaOpts := MediaOpts{PrimaryBoot: "sdb1"}
+	dryRun = &DryRunType{&[]string{}, &[]string{}}
+	if err := setBootPartition(medias, mediaOpts, dryRun); err != nil {
+		t.Fatalf("Multiple /boot partitions with a primary should not fail: %v", err)
+	}
+
+	expected := "Using sdb1 as the primary /boot partition, not flagging: sda1"
+	if !utils.StringSliceContains(*dryRun.TargetResults, expected) {
+		t.Fatalf("Expected %q in the dry run results: %v", expected, *dryRun.TargetResults)
+	}
+
+	if IsSecondaryBoot(medias[1].Children[0], mediaOpts) {
+		t.Fatal("sdb1 is the primary /boot partition")
+	}
+
+	if !IsSecondaryBoot(medias[0].Children[0], mediaOpts) {
+		t.Fatal("sda1 should be a secondary /boot partition")
+	}
+
+	results := validatePartitions(0, medias, mediaOpts, false)
+	for _, curr := range results {
+		if strings.Contains(curr, "multiple") {
+			t.Fatalf("Multiple /boot partitions with a primary should validate: %v", results)
+		}
+	}
+
+	dryRun = &DryRunType{&[]string{}, &[]string{}}
+	if err := setBootPartition(medias, MediaOpts{PrimaryBoot: "sdc1"}, dryRun); err == nil {
+		t.Fatal("A missing primary /boot partition should fail")
+	}
+}
+
+func TestMergeRescannedSelection(t *testing.T) {
+	previous := []*BlockDevice{
+		{Name: "sda", Serial: "DISK-A"},
+		{Name: "sdb", Serial: "DISK-B"},
+		{Name: "loop0"},
+	}
+
+	// sdb was unplugged, a USB stick took sda's name and sda became sdc
+	rescanned := []*BlockDevice{
+		{Name: "sda", Serial: "USB-STICK"},
+		{Name: "sdc", Serial: "DISK-A"},
+		{Name: "loop0"},
+	}
+
+	selected := map[string]InstallTarget{
+		"sda":   {Name: "sda", Friendly: "Disk A", Advanced: true},
+		"sdb":   {Name: "sdb", Friendly: "Disk B"},
+		"loop0": {Name: "loop0"},
+	}
+
+	diskA := &BlockDevice{Name: "sda", Serial: "DISK-A",
+		Children: []*BlockDevice{{Name: "sda1", MountPoint: "/"}}}
+	targets := []*BlockDevice{diskA, {Name: "sdb", Serial: "DISK-B"}}
+
+	mergedSelected, mergedTargets := MergeRescannedSelection(selected, targets, previous, rescanned)
+
+	if len(mergedSelected) != 2 {
+		t.Fatalf("Expected 2 selections, got: %+v", mergedSelected)
+	}
+
+	if target, ok := mergedSelected["sdc"]; !ok || target.Name != "sdc" || !target.Advanced {
+		t.Fatalf("Selection of renamed disk should be preserved: %+v", mergedSelected)
+	}
+
+	if _, ok := mergedSelected["loop0"]; !ok {
+		t.Fatal("Selection of a device without serial should be matched by name")
+	}
+
+	if _, ok := mergedSelected["sdb"]; ok {
+		t.Fatal("Selection of a disappeared disk should be dropped")
+	}
+
+	if len(mergedTargets) != 1 || mergedTargets[0] != diskA || diskA.Name != "sdc" {
+		t.Fatalf("Only the configured target media still present should be kept: %+v", mergedTargets)
+	}
+
+	if diskA.Children[0].Name != "sdc1" {
+		t.Fatalf("Partitions of a renamed disk should be renamed, got: %s", diskA.Children[0].Name)
+	}
+}
+
+func TestHibernationSwap(t *testing.T) {
+	savedPhysicalMemory := physicalMemory
+	defer func() { physicalMemory = savedPhysicalMemory }()
+
+	physicalMemory = func() uint64 { return uint64(4) * 1024 * 1024 * 1024 }
+
+	newMedia := func(swapSize uint64) []*BlockDevice {
+		disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+		disk.AddChild(&BlockDevice{Name: "sda1", FsType: "vfat", MountPoint: "/boot",
+			Type: BlockDeviceTypePart, Size: 157286400})
+		disk.AddChild(&BlockDevice{Name: "sda2", FsType: "swap", UUID: "1234-abcd",
+			Type: BlockDeviceTypePart, Size: swapSize})
+		disk.AddChild(&BlockDevice{Name: "sda3", FsType: "ext4", MountPoint: "/",
+			Type: BlockDeviceTypePart, Size: uint64(20) * 1024 * 1024 * 1024})
+		return []*BlockDevice{disk}
+	}
+
+	small := newMedia(uint64(2) * 1024 * 1024 * 1024)
+	if results := ServerValidatePartitions(small, MediaOpts{}); len(results) > 0 {
+		t.Fatalf("Swap should be valid without hibernation: %v", results)
+	}
+
+	if results := ServerValidatePartitions(small, MediaOpts{HibernationSupport: true}); len(results) == 0 {
+		t.Fatal("Swap smaller than the physical memory should fail with hibernation")
+	}
+
+	root := small[0].Children[2]
+	if results := validateSwapFile("1GiB", root, false, false, false, 0); len(results) > 0 {
+		t.Fatalf("Swapfile should be valid without hibernation: %v", results)
+	}
+
+	if results := validateSwapFile("1GiB", root, false, true, false, 0); len(results) == 0 {
+		t.Fatal("Swapfile smaller than the physical memory should fail with hibernation")
+	}
+
+	large := newMedia(uint64(4) * 1024 * 1024 * 1024)
+	if results := ServerValidatePartitions(large, MediaOpts{HibernationSupport: true}); len(results) > 0 {
+		t.Fatalf("Swap as large as the physical memory should be valid with hibernation: %v", results)
+	}
+
+	args, err := ResumeKernelArguments("", large, false)
+	if err != nil {
+		t.Fatalf("ResumeKernelArguments() failed: %v", err)
+	}
+
+	if len(args) != 1 || args[0] != "resume=UUID=1234-abcd" {
+		t.Fatalf("Unexpected resume kernel arguments: %v", args)
+	}
+
+	//nolint: lll // WONTFIX
+	filefragOutput := `Filesystem type is: ef53
+File size of /var/swapfile is 4294967296 (1048576 blocks of 4096 bytes)
+ ext:     logical_offset:        physical_offset: length:   expected: flags:
+   0:        0..   30719:      34816..     65535:  30720:
+   1:    30720..   63487:      67584..    100351:  32768:      65536:
+`
+	offset, err := parseResumeOffset(filefragOutput, 4096)
+	if err != nil || offset != 34816 {
+		t.Fatalf("Unexpected resume offset %d: %v", offset, err)
+	}
+}
+
+func TestDeterministicGUIDs(t *testing.T) {
+	newDisk := func() *BlockDevice {
+		disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+		for i := uint64(1); i <= 3; i++ {
+			disk.AddChild(&BlockDevice{Name: fmt.Sprintf("sda%d", i), Type: BlockDeviceTypePart,
+				MakePartition: i != 3, partition: i})
+		}
+		return disk
+	}
+
+	seed := "release-31000"
+	if err := ValidateGUIDSeed(seed); err != nil {
+		t.Fatalf("Seed %q should be valid: %v", seed, err)
+	}
+
+	for _, invalid := range []string{"", "short", "has a space"} {
+		if err := ValidateGUIDSeed(invalid); err == nil {
+			t.Fatalf("Seed %q should be invalid", invalid)
+		}
+	}
+
+	first := newDisk().deterministicGUIDs(seed, 0)
+	second := newDisk().deterministicGUIDs(seed, 0)
+
+	if len(first) != 2 {
+		t.Fatalf("Only new partitions should get a GUID: %v", first)
+	}
+
+	guidExp := regexp.MustCompile(`^[0-9A-F]{8}-[0-9A-F]{4}-4[0-9A-F]{3}-[89AB][0-9A-F]{3}-[0-9A-F]{12}$`)
+	for idx, guid := range first {
+		if second[idx] != guid {
+			t.Fatalf("Partition %d GUID %s doesn't match %s for the same seed", idx, guid, second[idx])
+		}
+
+		if !guidExp.MatchString(guid) {
+			t.Fatalf("Partition %d GUID %s is not a valid GUID", idx, guid)
+		}
+	}
+
+	if first[1] == first[2] {
+		t.Fatal("Partitions should get different GUIDs")
+	}
+
+	if other := newDisk().deterministicGUIDs(seed, 1); other[1] == first[1] {
+		t.Fatal("Partitions on different disks should get different GUIDs")
+	}
+
+	if other := newDisk().deterministicGUIDs("release-31010", 0); other[1] == first[1] {
+		t.Fatal("Different seeds should produce different GUIDs")
+	}
+}
+
+func TestPartitionAttributes(t *testing.T) {
+	var part BlockDevice
+	config := "{name: sda2, type: part, mountpoint: /data, attributes: [no-automount, hidden]}"
+	if err := yaml.Unmarshal([]byte(config), &part); err != nil {
+		t.Fatalf("Failed to parse the partition: %v", err)
+	}
+
+	if len(part.Attributes) != 2 || part.Attributes[0] != "no-automount" {
+		t.Fatalf("Unexpected attributes %v", part.Attributes)
+	}
+
+	disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+	disk.AddChild(&BlockDevice{Name: "sda1", Type: BlockDeviceTypePart, partition: 1})
+	part.partition = 2
+	disk.AddChild(&part)
+
+	medias := []*BlockDevice{disk}
+	if err := ValidatePartitionAttributes(medias, MediaOpts{}); err != nil {
+		t.Fatalf("The attributes should be valid: %v", err)
+	}
+
+	expected := [][]string{
+		{"sgdisk", "/dev/sda", "--attributes=2:set:63"},
+		{"sgdisk", "/dev/sda", "--attributes=2:set:62"},
+	}
+
+	args := disk.partitionAttributesArgs()
+	if len(args) != len(expected) {
+		t.Fatalf("Expected %d sgdisk commands, got %v", len(expected), args)
+	}
+
+	for i := range expected {
+		if strings.Join(args[i], " ") != strings.Join(expected[i], " ") {
+			t.Fatalf("Expected sgdisk command %v, got %v", expected[i], args[i])
+		}
+	}
+
+	if clone := disk.Clone(); len(clone.Children[1].Attributes) != 2 {
+		t.Fatalf("The attributes should be cloned: %v", clone.Children[1].Attributes)
+	}
+
+	if err := ValidatePartitionAttributes(medias, MediaOpts{LegacyBios: true,
+		PartitionTable: PartitionTableMSDOS}); err == nil {
+		t.Fatal("The attributes should be rejected on a msdos partition table")
+	}
+
+	part.Attributes = []string{"automount"}
+	if err := ValidatePartitionAttributes(medias, MediaOpts{}); err == nil {
+		t.Fatal("The unknown attribute should be rejected")
+	}
+
+	part.Attributes = nil
+	if args = disk.partitionAttributesArgs(); len(args) != 0 {
+		t.Fatalf("No sgdisk command expected without attributes, got %v", args)
+	}
+}
+
+func TestExcludeDevices(t *testing.T) {
+	//nolint: lll // WONTFIX
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name": "sda", "maj:min": "8:0", "rm": "0", "size": "223.6G", "ro": "0", "type": "disk", "serial": "SSD-0001", "mountpoint": null},
+      {"name": "sdb", "maj:min": "8:16", "rm": "1", "size": "28.7G", "ro": "0", "type": "disk", "serial": "USB-STICK-42", "mountpoint": null},
+      {"name": "nvme0n1", "maj:min": "259:0", "rm": "0", "size": "476.9G", "ro": "0", "type": "disk", "serial": "NVME-0001", "mountpoint": null},
+      {"name": "nvme1n1", "maj:min": "259:1", "rm": "0", "size": "476.9G", "ro": "0", "type": "disk", "serial": "NVME-0002", "mountpoint": null}
+   ]
+}`
+
+	bds, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parser block device descriptor: %s", err)
+	}
+
+	mediaOpts := MediaOpts{ExcludeDevices: []string{"USB-*", "re:^nvme1n[0-9]+$"}}
+	if err := ValidateExcludeDevices(mediaOpts.ExcludeDevices); err != nil {
+		t.Fatalf("Exclude patterns should be valid: %v", err)
+	}
+
+	for _, pattern := range []string{"sd[a", "re:nvme(0"} {
+		if err := ValidateExcludeDevices([]string{pattern}); err == nil {
+			t.Fatalf("Exclude pattern %q should be invalid", pattern)
+		}
+	}
+
+	rootSize := uint64(4294967296)
+	expected := []string{"nvme0n1", "sda"}
+
+	for _, targets := range [][]InstallTarget{
+		FindSafeInstallTargets(rootSize, bds, mediaOpts),
+		FindAllInstallTargets(rootSize, bds, mediaOpts),
+	} {
+		names := []string{}
+		for _, target := range targets {
+			names = append(names, target.Name)
+		}
+		sort.Strings(names)
+
+		if strings.Join(names, " ") != strings.Join(expected, " ") {
+			t.Fatalf("Expected install targets %v, got: %v", expected, names)
+		}
+	}
+
+	if targets := FindAllInstallTargets(rootSize, bds, MediaOpts{}); len(targets) != len(bds) {
+		t.Fatalf("Without exclusions all %d disks should be targets, got: %+v", len(bds), targets)
+	}
+}
+
+func TestCreateOnlyPartition(t *testing.T) {
+	config := `
+- name: sdh
+  size: "64G"
+  type: disk
+  children:
+  - name: sdh1
+    fstype: vfat
+    mountpoint: /boot
+    size: "512M"
+    type: part
+  - name: sdh2
+    fstype: ext4
+    mountpoint: /
+    size: "20G"
+    type: part
+  - name: sdh3
+    label: spare
+    size: "10G"
+    type: part
+    createOnly: true
+`
+
+	var bds []*BlockDevice
+	if err := yaml.Unmarshal([]byte(config), &bds); err != nil {
+		t.Fatalf("Could not unmarshal block devices: %v", err)
+	}
+
+	spare := bds[0].Children[2]
+	if !spare.CreateOnly || !spare.MakePartition || spare.FormatPartition {
+		t.Fatalf("Create only partition should be created but not formatted: %+v", spare)
+	}
+
+	if mkPart := createOnlyMakePartCommand(spare); mkPart != "mkpart spare" {
+		t.Fatalf("Unexpected create only mkpart command: %q", mkPart)
+	}
+
+	if guid := spare.getGUID(); guid != guidMap["data"] {
+		t.Fatalf("Unexpected create only partition type GUID: %s", guid)
+	}
+
+	if results := validatePartitions(0, bds, MediaOpts{SkipValidationSize: true}, false); len(results) > 0 {
+		t.Fatalf("Create only partition should be valid: %v", results)
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, bds, MediaOpts{}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(rootDir, "etc", "fstab")); !os.IsNotExist(err) {
+		t.Fatal("Create only partition should not have a fstab entry")
+	}
+
+	data, err := yaml.Marshal(bds)
+	if err != nil {
+		t.Fatalf("Failed to marshal block devices: %v", err)
+	}
+
+	if !strings.Contains(string(data), "createOnly: true") {
+		t.Fatalf("createOnly did not round trip through YAML: %s", string(data))
+	}
+
+	spare.MountPoint = "/spare"
+	if results := validatePartitions(0, bds, MediaOpts{SkipValidationSize: true}, false); len(results) == 0 {
+		t.Fatal("Create only partition with a mount point should be invalid")
+	}
+}
+
+func TestRetryStartEnd(t *testing.T) {
+	diskEnd := uint64(8 * 1000 * 1000 * 1000)
+	start := uint64(500 * 1000 * 1000)
+	end := uint64(0)
+
+	// the start is nudged ahead first, leaving the end alone
+	for attempt := 1; attempt <= partitionStartRetries; attempt++ {
+		var ok bool
+		if start, end, ok = retryStartEnd(start, end, diskEnd, true, attempt); !ok {
+			t.Fatalf("Attempt %d should be retried", attempt)
+		}
+
+		if start != uint64(500+attempt)*1000*1000 || end != 0 {
+			t.Fatalf("Attempt %d: unexpected start %d end %d", attempt, start, end)
+		}
+	}
+
+	// a fixed size partition is not retried any further
+	if _, _, ok := retryStartEnd(start, end, diskEnd, false, partitionStartRetries+1); ok {
+		t.Fatal("Fixed size partition should not trim its end")
+	}
+
+	// the max-fill partition end is then trimmed down from the end of the disk
+	for attempt := partitionStartRetries + 1; attempt <= partitionStartRetries+partitionEndRetries; attempt++ {
+		var ok bool
+		prevStart := start
+		if start, end, ok = retryStartEnd(start, end, diskEnd, true, attempt); !ok {
+			t.Fatalf("Attempt %d should be retried", attempt)
+		}
+
+		trims := uint64(attempt - partitionStartRetries)
+		if start != prevStart || end != diskEnd-trims*partitionAlignUnit {
+			t.Fatalf("Attempt %d: unexpected start %d end %d", attempt, start, end)
+		}
+	}
+
+	if getStartEndMB(start, end) != "503M 7997M" {
+		t.Fatalf("Unexpected parted start/end: %s", getStartEndMB(start, end))
+	}
+
+	// the retries are bounded
+	if _, _, ok := retryStartEnd(start, end, diskEnd, true, partitionStartRetries+partitionEndRetries+1); ok {
+		t.Fatal("Retries should be bounded")
+	}
+
+	// a too large explicit end is trimmed as well
+	tooLarge := diskEnd + 512*1000
+	if _, end, ok := retryStartEnd(start, tooLarge, diskEnd, true, partitionStartRetries+1); !ok || end != tooLarge-partitionAlignUnit {
+		t.Fatalf("Too large end should be trimmed, got %d", end)
+	}
+
+	// the end is never trimmed past the start
+	if _, _, ok := retryStartEnd(start, start+partitionAlignUnit, diskEnd, true, partitionStartRetries+1); ok {
+		t.Fatal("End should not be trimmed past the start")
+	}
+}
+
+func TestLVMExpandUnit(t *testing.T) {
+	vg, lv, err := parseLVSNames("  clearvg root\n")
+	if err != nil || vg != "clearvg" || lv != "root" {
+		t.Fatalf("Unexpected lvs names %q %q: %v", vg, lv, err)
+	}
+
+	if _, _, err = parseLVSNames(""); err == nil {
+		t.Fatal("Empty lvs output should fail")
+	}
+
+	commands := lvmExpandCommands(vg, lv)
+	expected := []string{
+		"pvs --noheadings -o pv_name -S vg_name=clearvg | xargs -r -n1 pvresize",
+		"if [ \"$(vgs --noheadings -o vg_free_count clearvg)\" -gt 0 ]; then " +
+			"lvextend -r -l +100%FREE /dev/clearvg/root; fi",
+	}
+
+	if len(commands) != len(expected) {
+		t.Fatalf("Unexpected expansion commands: %v", commands)
+	}
+
+	for i := range expected {
+		if commands[i] != expected[i] {
+			t.Fatalf("Expansion command %d: got %q, want %q", i, commands[i], expected[i])
+		}
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-lvm-expand")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = writeLVMExpandUnit(rootDir, vg, lv); err != nil {
+		t.Fatalf("Failed to write the LVM expansion unit: %v", err)
+	}
+
+	unitDir := filepath.Join(rootDir, "etc", "systemd", "system")
+	content, err := ioutil.ReadFile(filepath.Join(unitDir, LVMExpandUnit))
+	if err != nil {
+		t.Fatalf("LVM expansion unit not written: %v", err)
+	}
+
+	unit := string(content)
+	for _, line := range []string{
+		"Type=oneshot",
+		"ExecStart=/usr/bin/sh -c 'pvs --noheadings -o pv_name -S vg_name=clearvg | xargs -r -n1 pvresize'",
+		"ExecStart=/usr/bin/sh -c 'if [ \"$$(vgs --noheadings -o vg_free_count clearvg)\" -gt 0 ]; then " +
+			"lvextend -r -l +100%%FREE /dev/clearvg/root; fi'",
+		"ExecStartPost=/usr/bin/systemctl disable " + LVMExpandUnit,
+		"WantedBy=local-fs.target",
+	} {
+		if !strings.Contains(unit, line+"\n") {
+			t.Fatalf("LVM expansion unit is missing %q:\n%s", line, unit)
+		}
+	}
+
+	link, err := os.Readlink(filepath.Join(unitDir, "local-fs.target.wants", LVMExpandUnit))
+	if err != nil || link != "/etc/systemd/system/"+LVMExpandUnit {
+		t.Fatalf("LVM expansion unit not enabled: %q %v", link, err)
+	}
+
+	// Writing twice must not fail on the existing link
+	if err = writeLVMExpandUnit(rootDir, vg, lv); err != nil {
+		t.Fatalf("Failed to rewrite the LVM expansion unit: %v", err)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	for _, mode := range []string{"", TrimTimer, TrimDiscard, TrimBoth} {
+		if err := ValidateTrim(mode); err != nil {
+			t.Fatalf("Trim %q should be valid: %v", mode, err)
+		}
+	}
+
+	if err := ValidateTrim("always"); err == nil {
+		t.Fatal("Trim \"always\" should be invalid")
+	}
+
+	ssd := &BlockDevice{Name: "nvme0n1"}
+	hdd := &BlockDevice{Name: "sda", Rotational: true}
+
+	timers := []struct {
+		medias   []*BlockDevice
+		mode     string
+		expected bool
+	}{
+		{[]*BlockDevice{ssd}, TrimTimer, true},
+		{[]*BlockDevice{ssd}, TrimBoth, true},
+		{[]*BlockDevice{ssd}, TrimDiscard, false},
+		{[]*BlockDevice{ssd}, "", false},
+		{[]*BlockDevice{hdd}, TrimTimer, false},
+		{[]*BlockDevice{hdd, ssd}, TrimTimer, true},
+	}
+
+	for _, curr := range timers {
+		if enabled := TrimTimerEnabled(curr.medias, MediaOpts{Trim: curr.mode}); enabled != curr.expected {
+			t.Fatalf("Trim %q of %d medias: timer enabled %t, want %t", curr.mode, len(curr.medias),
+				enabled, curr.expected)
+		}
+	}
+
+	options := []struct {
+		fsType     string
+		rotational bool
+		mode       string
+		options    string
+		expected   string
+	}{
+		{"ext4", false, TrimDiscard, "defaults", "defaults,discard"},
+		{"xfs", false, TrimBoth, "defaults,inode64", "defaults,inode64,discard"},
+		{"ext4", true, TrimDiscard, "defaults", "defaults"},
+		{"ext4", false, TrimTimer, "defaults", "defaults"},
+		{"swap", false, TrimDiscard, "defaults", "defaults"},
+		{"ext4", false, TrimDiscard, "defaults,nodiscard", "defaults,nodiscard"},
+	}
+
+	for _, curr := range options {
+		bd := &BlockDevice{Name: "sda1", FsType: curr.fsType}
+
+		result := discardMountOptions(bd, curr.options, curr.rotational, MediaOpts{Trim: curr.mode})
+		if result != curr.expected {
+			t.Fatalf("%s (rotational: %t, trim: %q): got %q, want %q", curr.fsType, curr.rotational,
+				curr.mode, result, curr.expected)
+		}
+	}
+
+	// The auto mounted root gets a fstab entry to keep the discard option
+	root := &BlockDevice{Name: "sda2", Type: BlockDeviceTypePart, FsType: "ext4", Label: "root",
+		MountPoint: "/"}
+	disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{root}}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	if err = GenerateTabFiles(rootDir, []*BlockDevice{disk}, MediaOpts{Trim: TrimDiscard}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	if !strings.Contains(string(content), "LABEL=root / ext4 defaults,discard 0 1\n") {
+		t.Fatalf("Unexpected fstab content: %q", string(content))
+	}
+}
+
+func TestSmartMountDefaults(t *testing.T) {
+	smart := MediaOpts{SmartMountDefaults: true}
+
+	tests := []struct {
+		fsType     string
+		rotational bool
+		mediaOpts  MediaOpts
+		options    string
+		expected   string
+	}{
+		{"btrfs", false, smart, "", "defaults,ssd,space_cache=v2"},
+		{"btrfs", true, smart, "", "defaults,space_cache=v2"},
+		{"xfs", false, smart, "", "defaults,inode64"},
+		{"xfs", true, smart, "", "defaults,inode64"},
+		{"ext4", false, smart, "", "defaults,noatime"},
+		{"ext4", true, smart, "", "defaults"},
+		{"vfat", false, smart, "", "defaults"},
+		{"btrfs", false, MediaOpts{}, "", "defaults"},
+		{"btrfs", false, smart, "compress=zstd", "compress=zstd"},
+		{"xfs", true, MediaOpts{}, "noatime", "noatime"},
+	}
+
+	for _, curr := range tests {
+		bd := &BlockDevice{FsType: curr.fsType, MountOptions: curr.options}
+
+		if options := getMountOptions(bd, curr.rotational, curr.mediaOpts); options != curr.expected {
+			t.Fatalf("%s (rotational: %t, smart: %t, options: %q): got %q, want %q", curr.fsType,
+				curr.rotational, curr.mediaOpts.SmartMountDefaults, curr.options, options, curr.expected)
+		}
+	}
+
+	//nolint: lll // WONTFIX
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name": "sdf", "maj:min": "8:80", "rm": "0", "rota": "1", "size": "64G", "rw": "0", "type": "disk", "mountpoint": null,
+         "children": [
+            {"name": "sdf1", "maj:min": "8:81", "rm": "0", "fstype": "xfs", "label": "var", "size": "30G", "rw": "0", "type": "part", "mountpoint": "/var"}
+         ]
+      },
+      {"name": "nvme0n1", "maj:min": "259:0", "rm": "0", "rota": "0", "size": "64G", "rw": "0", "type": "disk", "mountpoint": null,
+         "children": [
+            {"name": "nvme0n1p1", "maj:min": "259:1", "rm": "0", "fstype": "btrfs", "label": "data", "size": "30G", "rw": "0", "type": "part", "mountpoint": "/data"}
+         ]
+      }
+   ]
+}`
+
+	bds, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parser block device descriptor: %s", err)
+	}
+
+	if !bds[0].Rotational || bds[1].Rotational {
+		t.Fatalf("Unexpected device rotation: %t %t", bds[0].Rotational, bds[1].Rotational)
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, bds, smart); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	expected := "LABEL=var /var xfs defaults,inode64 0 2\n" +
+		"LABEL=data /data btrfs defaults,ssd,space_cache=v2 0 2\n"
+	if !strings.HasSuffix(string(content), expected) {
+		t.Fatalf("Unexpected fstab content: %q", string(content))
+	}
+
+	data, err := yaml.Marshal(bds[0])
+	if err != nil {
+		t.Fatalf("Failed to marshal block device: %v", err)
+	}
+
+	if !strings.Contains(string(data), "rota: \"true\"") {
+		t.Fatalf("Rotation did not round trip through YAML: %s", string(data))
+	}
+}
+
+func TestSmartMountRootFstab(t *testing.T) {
+	root := &BlockDevice{
+		Name:       "nvme0n1p2",
+		Type:       BlockDeviceTypePart,
+		FsType:     "xfs",
+		Label:      "root",
+		MountPoint: "/",
+	}
+	home := &BlockDevice{
+		Name:       "nvme0n1p3",
+		Type:       BlockDeviceTypePart,
+		FsType:     "ext4",
+		Label:      "home",
+		MountPoint: "/home",
+		Rotational: true,
+	}
+	bds := []*BlockDevice{{Name: "nvme0n1", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{root, home}}}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, bds, MediaOpts{SmartMountDefaults: true}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	// The auto mounted root needs an entry for its smart options, not /home
+	// which keeps the defaults
+	expected := "LABEL=root / xfs defaults,inode64 0 1\n"
+	if string(content) != expected {
+		t.Fatalf("Unexpected fstab content: %q, want %q", string(content), expected)
+	}
+}
+
+func TestSeparateHomeDiskFstab(t *testing.T) {
+	root := &BlockDevice{
+		Name:       "nvme0n1p2",
+		Type:       BlockDeviceTypePart,
+		FsType:     "ext4",
+		Label:      "root",
+		MountPoint: "/",
+	}
+	srv := &BlockDevice{
+		Name:       "nvme0n1p3",
+		Type:       BlockDeviceTypePart,
+		FsType:     "ext4",
+		Label:      "srv",
+		MountPoint: "/srv",
+	}
+	home := &BlockDevice{
+		Name:       "sda1",
+		Type:       BlockDeviceTypePart,
+		FsType:     "ext4",
+		Label:      "home",
+		MountPoint: "/home",
+	}
+	bds := []*BlockDevice{
+		{Name: "nvme0n1", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{root, srv}},
+		{Name: "sda", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{home}},
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, bds, MediaOpts{}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	// Only the /home of the second disk is not auto mounted
+	expected := "LABEL=home /home ext4 defaults 0 2\n"
+	if string(content) != expected {
+		t.Fatalf("Unexpected fstab content: %q, want %q", string(content), expected)
+	}
+}
+
+func TestTruncatedLabelFstab(t *testing.T) {
+	efi := &BlockDevice{
+		Name:            "sda2",
+		Type:            BlockDeviceTypePart,
+		FsType:          "vfat",
+		Label:           "EFI_SYSTEM_PART",
+		MountPoint:      "/efi",
+		MakePartition:   true,
+		FormatPartition: true,
+	}
+	bds := []*BlockDevice{{Name: "sda", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{efi}}}
+
+	mkfs, err := commonMakeFsCommand(efi, []string{"-F32"})
+	if err != nil {
+		t.Fatalf("Failed to build mkfs command: %v", err)
+	}
+
+	if len(mkfs) < 3 || mkfs[1] != "-n" || mkfs[2] != "EFI_SYSTEM_" {
+		t.Fatalf("Label should be truncated to %d characters: %v", MaxLabelLength("vfat"), mkfs)
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, bds, MediaOpts{}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	if !strings.Contains(string(content), "LABEL="+mkfs[2]+" /efi vfat defaults 0 2\n") {
+		t.Fatalf("fstab should use the truncated label %q: %q", mkfs[2], string(content))
+	}
+
+	opts := MediaOpts{SkipValidationSize: true}
+	if results := validatePartitions(0, bds, opts, false); utils.StringSliceContains(results,
+		"Label EFI_SYSTEM_PART of sda2 is longer than 11 characters") {
+		t.Fatalf("Truncate policy should accept the long label: %v", results)
+	}
+
+	opts.LabelPolicy = LabelPolicyError
+	if results := validatePartitions(0, bds, opts, false); !utils.StringSliceContains(results,
+		"Label EFI_SYSTEM_PART of sda2 is longer than 11 characters") {
+		t.Fatalf("Error policy should refuse the long label: %v", results)
+	}
+
+	if err = ValidateLabelPolicy("ignore"); err == nil {
+		t.Fatal("Unknown label policy should be invalid")
+	}
+}
+
+func TestSectorSizes(t *testing.T) {
+	//nolint: lll // WONTFIX
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name": "sda", "maj:min": "8:0", "rm": "0", "size": "64G", "log-sec": 512, "phy-sec": 4096, "rw": "0", "type": "disk", "mountpoint": null},
+      {"name": "sdb", "maj:min": "8:16", "rm": "0", "size": "64G", "log-sec": "512", "phy-sec": "4096", "rw": "0", "type": "disk", "mountpoint": null},
+      {"name": "sdc", "maj:min": "8:32", "rm": "0", "size": "64G", "log-sec": 4096, "phy-sec": 4096, "rw": "0", "type": "disk", "mountpoint": null}
+   ]
+}`
+
+	bds, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parser block device descriptor: %s", err)
+	}
+
+	if bds[1].LogicalSector != 512 || bds[1].PhysicalSector != 4096 {
+		t.Fatalf("Unexpected sector sizes: %d/%d", bds[1].LogicalSector, bds[1].PhysicalSector)
+	}
+
+	if results := validateSectorSizes(bds[0:2]); len(results) > 0 {
+		t.Fatalf("Matching sector sizes should be valid: %v", results)
+	}
+
+	mixed := []*BlockDevice{bds[0], bds[2]}
+	results := validateSectorSizes(mixed)
+	if len(results) != 1 || results[0] != "Sector size of sdc (4096/4096) does not match sda (512/4096)" {
+		t.Fatalf("Mixed sector sizes should be refused: %v", results)
+	}
+
+	if results = validatePartitions(0, mixed, MediaOpts{}, false); !utils.StringSliceContains(results,
+		"Sector size of sdc (4096/4096) does not match sda (512/4096)") {
+		t.Fatalf("Mixed sector sizes should fail the validation: %v", results)
+	}
+
+	allowMixed := MediaOpts{AllowMixedSectorSizes: true}
+	if results = validatePartitions(0, mixed, allowMixed, false); utils.StringSliceContains(results,
+		"Sector size of sdc (4096/4096) does not match sda (512/4096)") {
+		t.Fatalf("Mixed sector sizes should be allowed when overridden: %v", results)
+	}
+
+	// Unknown sector sizes, i.e. images, are not checked
+	image := &BlockDevice{Name: "loop0", Type: BlockDeviceTypeLoop}
+	if results = validateSectorSizes([]*BlockDevice{bds[0], image}); len(results) > 0 {
+		t.Fatalf("Unknown sector sizes should not be checked: %v", results)
+	}
+}
+
+func TestCapturePartitionLayouts(t *testing.T) {
+	partedOutput := `BYT;
+/dev/sda:8000000000B:scsi:512:512:gpt:ATA QEMU HARDDISK:;
+1:17408B:1048575B:1031168B:free;
+1:1048576B:149946367B:148897792B:fat32:EFI:boot, esp;
+2:149946368B:7799308287B:7649361920B:ext4:/:;
+1:7799308288B:7999983103B:200674816B:free;
+`
+
+	saved := readPartitionTable
+	defer func() { readPartitionTable = saved }()
+
+	read := []string{}
+	readPartitionTable = func(bd *BlockDevice) *bytes.Buffer {
+		read = append(read, bd.Name)
+		return bytes.NewBuffer([]byte(partedOutput))
+	}
+
+	medias := []*BlockDevice{
+		{Name: "sda", Type: BlockDeviceTypeDisk},
+		{Name: "sdb", Type: BlockDeviceTypeDisk},
+	}
+	targets := map[string]InstallTarget{"sda": {Name: "sda", WholeDisk: true}}
+
+	layouts := CapturePartitionLayouts(targets, medias)
+	if len(read) != 1 || read[0] != "sda" {
+		t.Fatalf("Only the target media should be read: %v", read)
+	}
+
+	if len(layouts) != 1 || layouts[0].Disk != "sda" {
+		t.Fatalf("Expected the layout of sda: %+v", layouts)
+	}
+
+	parts := layouts[0].Partitions
+	if len(parts) != 4 {
+		t.Fatalf("Expected 4 entries in the layout, got %d", len(parts))
+	}
+
+	root := parts[2]
+	if root.Number != 2 || root.Start != 149946368 || root.End != 7799308287 ||
+		root.Size != 7649361920 || root.FileSystem != "ext4" || root.Name != "/" {
+		t.Fatalf("Unexpected root partition: %+v", root)
+	}
+
+	if parts[3].Number != 0 || parts[3].Size != 200674816 {
+		t.Fatalf("Unexpected free space: %+v", parts[3])
+	}
+
+	out, err := yaml.Marshal(layouts)
+	if err != nil {
+		t.Fatalf("Could not marshal the layouts: %v", err)
+	}
+
+	if !strings.Contains(string(out), "filesystem: fat32") || !strings.Contains(string(out), "flags: boot, esp") {
+		t.Fatalf("Unexpected layout report: %s", out)
+	}
+}
+
+func TestCreateSwapFile(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "clr-installer-swapfile-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	if err = utils.MkdirAll(filepath.Join(rootDir, "var"), 0755); err != nil {
+		t.Fatalf("Could not create var dir: %v", err)
+	}
+
+	disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+	disk.AddChild(&BlockDevice{Name: "sda1", FsType: "ext4", MountPoint: "/",
+		Type: BlockDeviceTypePart, Size: uint64(20) * 1024 * 1024 * 1024})
+	disk.AddChild(&BlockDevice{Name: "sda2", FsType: "xfs", MountPoint: "/var",
+		Type: BlockDeviceTypePart, Size: uint64(20) * 1024 * 1024 * 1024})
+
+	partition := SwapFilePartition([]*BlockDevice{disk})
+	if partition == nil || partition.Name != "sda2" {
+		t.Fatalf("The swapfile should be on the /var partition: %+v", partition)
+	}
+
+	if err = CreateSwapFile(rootDir, partition, 4*1024*1024+123); err != nil {
+		t.Fatalf("CreateSwapFile() failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(rootDir, SwapfileName))
+	if err != nil {
+		t.Fatalf("The swapfile was not created: %v", err)
+	}
+
+	if info.Size() != 4*1024*1024 || info.Mode().Perm() != 0600 {
+		t.Fatalf("Unexpected swapfile size %d or mode %v", info.Size(), info.Mode().Perm())
+	}
+
+	// The generated fstab must not drop the swapfile entry
+	if err = GenerateTabFiles(rootDir, []*BlockDevice{disk}, MediaOpts{}); err != nil {
+		t.Fatalf("GenerateTabFiles() failed: %v", err)
+	}
+
+	if err = AddSwapFileFstab(rootDir, partition); err != nil {
+		t.Fatalf("AddSwapFileFstab() failed: %v", err)
+	}
+
+	fstab, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Could not read fstab: %v", err)
+	}
+
+	if !strings.HasSuffix(string(fstab), "/var/swapfile none swap defaults 0 0\n") {
+		t.Fatalf("Unexpected fstab: %q", fstab)
+	}
+
+	data := &BlockDevice{Name: "sdb1", FsType: "ext4", MountPoint: "/data"}
+	if SwapFilePath(data) != "/data/swapfile" {
+		t.Fatalf("Unexpected swapfile path: %s", SwapFilePath(data))
+	}
+
+	btrfs := &BlockDevice{Name: "sdb1", FsType: "btrfs", MountPoint: "/"}
+	if err = CreateSwapFile(rootDir, btrfs, 4*1024*1024); err == nil {
+		t.Fatal("Swapfiles on btrfs should be refused")
+	}
+
+	disk.Children[1].FsType = "btrfs"
+	results := validatePartitions(0, []*BlockDevice{disk}, MediaOpts{SwapFileSize: "64MiB"}, false)
+	if !utils.StringSliceContains(results, "Swapfiles are not supported on btrfs, use a swap partition instead") {
+		t.Fatalf("The swapfile on btrfs should fail the validation: %v", results)
+	}
+}
+
+func TestBootloader(t *testing.T) {
+	valid := []MediaOpts{
+		{},
+		{LegacyBios: true},
+		{Bootloader: BootloaderCBM, LegacyBios: true},
+		{Bootloader: BootloaderSystemdBoot},
+	}
+
+	for _, curr := range valid {
+		if err := ValidateBootloader(curr); err != nil {
+			t.Fatalf("Boot loader %+v should be valid: %v", curr, err)
+		}
+	}
+
+	invalid := []MediaOpts{
+		{Bootloader: BootloaderSystemdBoot, LegacyBios: true},
+		{Bootloader: BootloaderGRUB},
+		{Bootloader: "lilo"},
+	}
+
+	for _, curr := range invalid {
+		if err := ValidateBootloader(curr); err == nil {
+			t.Fatalf("Boot loader %+v should be invalid", curr)
+		}
+	}
+
+	if err := ValidateBootloader(MediaOpts{Bootloader: BootloaderGRUB}); !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("GRUB should be reported as unsupported: %v", err)
+	}
+
+	if (MediaOpts{}).GetBootloader() != BootloaderCBM {
+		t.Fatal("The default boot loader should be clr-boot-manager")
+	}
+
+	disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+	disk.AddChild(&BlockDevice{Name: "sda1", FsType: "ext4", MountPoint: "/",
+		Type: BlockDeviceTypePart, partition: 1})
+	medias := []*BlockDevice{disk}
+
+	// Legacy BIOS mode boots from / without a /boot partition
+	dryRun := &DryRunType{&[]string{}, &[]string{}}
+	if err := setBootPartition(medias, MediaOpts{LegacyBios: true}, dryRun); err != nil {
+		t.Fatalf("clr-boot-manager should boot from / in legacy mode: %v", err)
+	}
+
+	// systemd-boot never boots in legacy BIOS mode
+	opts := MediaOpts{Bootloader: BootloaderSystemdBoot, LegacyBios: true}
+	dryRun = &DryRunType{&[]string{}, &[]string{}}
+	if err := setBootPartition(medias, opts, dryRun); err == nil {
+		t.Fatal("systemd-boot should require a /boot partition")
+	}
+
+	if BootloaderBundle(BootloaderCBM) != "" || BootloaderBundle(BootloaderSystemdBoot) != RequiredBundleBootloader ||
+		BootloaderBundle(BootloaderGRUB) != "" {
+		t.Fatal("Unexpected boot loader bundles")
+	}
+}
+
+func TestValidateDiskCapacity(t *testing.T) {
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name": "nvme0n1", "maj:min": "259:0", "rm": "0", "size": "8G", "rw": "0", "type": "disk", "mountpoint": null}
+   ]
+}`
+
+	newLayout := func(rootSize uint64) []*BlockDevice {
+		bds, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+		if err != nil {
+			t.Fatalf("Could not parser block device descriptor: %s", err)
+		}
+
+		disk := bds[0]
+		disk.AddChild(&BlockDevice{Name: "nvme0n1p1", FsType: "vfat", MountPoint: "/boot",
+			Type: BlockDeviceTypePart, Size: 157286400, MakePartition: true})
+		disk.AddChild(&BlockDevice{Name: "nvme0n1p2", FsType: "ext4", MountPoint: "/",
+			Type: BlockDeviceTypePart, Size: rootSize, MakePartition: true})
+		return bds
+	}
+
+	gig := uint64(1024 * 1024 * 1024)
+
+	if results := validateDiskCapacity(newLayout(4 * gig)); len(results) > 0 {
+		t.Fatalf("The layout should fit on the disk: %v", results)
+	}
+
+	oversized := newLayout(10 * gig)
+	results := validateDiskCapacity(oversized)
+	if len(results) != 1 || results[0] != "Partitions total 10.1GiB exceeds disk nvme0n1 (8GiB)" {
+		t.Fatalf("The oversized layout should be refused: %v", results)
+	}
+
+	if results = ServerValidatePartitions(oversized, MediaOpts{}); !utils.StringSliceContains(results,
+		"Partitions total 10.1GiB exceeds disk nvme0n1 (8GiB)") {
+		t.Fatalf("The oversized layout should fail the validation: %v", results)
+	}
+
+	// The max-fill partition needs some free space
+	full := newLayout(8*gig - 157286400)
+	full[0].AddChild(&BlockDevice{Name: "nvme0n1p3", FsType: "ext4", MountPoint: "/home",
+		Type: BlockDeviceTypePart, Size: 0, MakePartition: true})
+	if results = validateDiskCapacity(full); len(results) != 1 {
+		t.Fatalf("The max-fill partition without free space should be refused: %v", results)
+	}
+
+	full[0].Children[1].Size -= gig
+	if results = validateDiskCapacity(full); len(results) > 0 {
+		t.Fatalf("The max-fill partition should fit on the disk: %v", results)
+	}
+}
+
+func TestNoSwap(t *testing.T) {
+	newMedia := func(swap bool) []*BlockDevice {
+		disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+		disk.AddChild(&BlockDevice{Name: "sda1", FsType: "vfat", MountPoint: "/boot",
+			Type: BlockDeviceTypePart, Size: 157286400})
+		if swap {
+			disk.AddChild(&BlockDevice{Name: "sda2", FsType: "swap", PartitionLabel: "CLR_SWAP",
+				Type: BlockDeviceTypePart, Size: 1024 * 1024})
+		}
+		disk.AddChild(&BlockDevice{Name: "sda3", FsType: "ext4", MountPoint: "/",
+			Type: BlockDeviceTypePart, Size: uint64(20) * 1024 * 1024 * 1024})
+		return []*BlockDevice{disk}
+	}
+
+	// A tiny swapfile would otherwise be refused
+	noSwap := MediaOpts{NoSwap: true, SwapFileSize: "1MiB"}
+	if results := ServerValidatePartitions(newMedia(false), noSwap); len(results) > 0 {
+		t.Fatalf("The swap checks should be skipped without swap: %v", results)
+	}
+
+	if results := ServerValidatePartitions(newMedia(false), MediaOpts{SwapFileSize: "1MiB"}); len(results) == 0 {
+		t.Fatal("A tiny swapfile should fail the validation")
+	}
+
+	expected := "Swap partition sda2 can not be used when swap is disabled"
+	if results := ServerValidatePartitions(newMedia(true), noSwap); !utils.StringSliceContains(results, expected) {
+		t.Fatalf("A swap partition should be refused without swap: %v", results)
+	}
+
+	// Advanced mode refuses the CLR_SWAP labeled partitions too
+	advanced := newMedia(false)
+	advanced[0].AddChild(&BlockDevice{Name: "sda4", Label: "CLR_SWAP", Type: BlockDeviceTypePart, Size: 1024 * 1024})
+	if results := validatePartitions(0, advanced, noSwap, true); !utils.StringSliceContains(results,
+		"Swap partition sda4 can not be used when swap is disabled") {
+		t.Fatalf("A CLR_SWAP partition should be refused without swap: %v", results)
+	}
+
+	if results := validateNoSwap(MediaOpts{NoSwap: true, SwapFileSize: "64MiB", SwapFileSet: true,
+		HibernationSupport: true}); len(results) != 2 {
+		t.Fatalf("The swapfile and hibernation should be refused without swap: %v", results)
+	}
+
+	if results := validateNoSwap(MediaOpts{NoSwap: true, SwapFileSize: "0", SwapFileSet: true}); len(results) > 0 {
+		t.Fatalf("A swapfile size of 0 should be accepted without swap: %v", results)
+	}
+}
+
+func TestUmountAllRetry(t *testing.T) {
+	savedUnmount, savedDelay := unmount, umountRetryDelay
+	savedPoints, savedEncrypts := mountedPoints, mountedEncrypts
+	defer func() {
+		unmount, umountRetryDelay = savedUnmount, savedDelay
+		mountedPoints, mountedEncrypts = savedPoints, savedEncrypts
+	}()
+
+	dir, err := ioutil.TempDir("", "clr-installer-umount-")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	busy := filepath.Join(dir, "busy")
+	stuck := filepath.Join(dir, "stuck")
+
+	attempts := map[string]int{}
+	detached := []string{}
+	order := []string{}
+	unmount = func(target string, flags int) error {
+		attempts[target]++
+		order = append(order, target)
+
+		if flags&syscall.MNT_DETACH != 0 {
+			detached = append(detached, target)
+			return nil
+		}
+
+		if target == stuck || (target == busy && attempts[target] < 3) {
+			return syscall.EBUSY
+		}
+		return nil
+	}
+	umountRetryDelay = 0
+
+	mountedPoints = []string{dir, busy, stuck}
+	mountedEncrypts = nil
+
+	err = UmountAll()
+	if err == nil {
+		t.Fatalf("Should fail to unmount %s", stuck)
+	}
+
+	umountErr, ok := err.(*UmountError)
+	if !ok {
+		t.Fatalf("Expected an *UmountError, got %T: %v", err, err)
+	}
+
+	if len(umountErr.Mounts) != 1 || umountErr.Mounts[0] != stuck || len(umountErr.Encrypted) != 0 {
+		t.Fatalf("Unexpected unmount failures: %+v", umountErr)
+	}
+
+	if attempts[busy] != 3 || attempts[stuck] != umountRetries || attempts[dir] != 1 {
+		t.Fatalf("Unexpected unmount attempts: %v", attempts)
+	}
+
+	// A still busy mount point is reported, not lazily detached
+	if len(detached) != 0 {
+		t.Fatalf("No mount point should be detached: %v", detached)
+	}
+
+	// The top level mount point must still be unmounted last
+	if order[len(order)-1] != dir {
+		t.Fatalf("Expected %s to be unmounted last: %v", dir, order)
+	}
+
+	if len(mountedPoints) != 1 || mountedPoints[0] != stuck {
+		t.Fatalf("Only %s should remain mounted: %v", stuck, mountedPoints)
+	}
+}
+
+func TestBtrfsRaidMakeFs(t *testing.T) {
+	root := &BlockDevice{Name: "sda2", Type: BlockDeviceTypePart, FsType: "btrfs", MountPoint: "/",
+		Label: "root", Size: 10 << 30, BtrfsProfile: "raid1", MakePartition: true}
+	member := &BlockDevice{Name: "sdb1", Type: BlockDeviceTypePart, FsType: "btrfs",
+		Size: 10 << 30, BtrfsMember: true, MakePartition: true}
+	boot := &BlockDevice{Name: "sda1", Type: BlockDeviceTypePart, FsType: "vfat", MountPoint: "/boot",
+		Size: 150 << 20, MakePartition: true}
+
+	medias := []*BlockDevice{
+		{Name: "sda", Type: BlockDeviceTypeDisk, Size: 20 << 30, Children: []*BlockDevice{boot, root}},
+		{Name: "sdb", Type: BlockDeviceTypeDisk, Size: 20 << 30, Children: []*BlockDevice{member}},
+	}
+
+	LinkBtrfsMembers(medias)
+
+	op := bdOps[root.FsType]
+	cmd, err := op.makeFsCommand(root, op.makeFsArgs)
+	if err != nil {
+		t.Fatalf("Failed to create the mkfs command: %v", err)
+	}
+
+	expected := "mkfs.btrfs -L root -f -d raid1 -m raid1 /dev/sda2 /dev/sdb1"
+	if got := strings.Join(makeFsArgs(root, cmd), " "); got != expected {
+		t.Fatalf("Expected mkfs command %q, got %q", expected, got)
+	}
+
+	// A single device btrfs is unchanged
+	single := &BlockDevice{Name: "sdc1", Type: BlockDeviceTypePart, FsType: "btrfs", MountPoint: "/home"}
+	cmd, _ = op.makeFsCommand(single, op.makeFsArgs)
+	if got := strings.Join(makeFsArgs(single, cmd), " "); got != "mkfs.btrfs -f /dev/sdc1" {
+		t.Fatalf("Unexpected single device mkfs command %q", got)
+	}
+
+	args := BtrfsKernelArguments(medias)
+	if len(args) != 1 || args[0] != "rootflags=device=/dev/sda2,device=/dev/sdb1" {
+		t.Fatalf("Unexpected btrfs RAID kernel arguments: %v", args)
+	}
+
+	if args := BtrfsKernelArguments(medias[:1]); args != nil {
+		t.Fatalf("A btrfs root without members should not add kernel arguments: %v", args)
+	}
+
+	if results := validateBtrfsRaid(medias); len(results) > 0 {
+		t.Fatalf("btrfs raid1 should be valid: %v", results)
+	}
+
+	root.BtrfsProfile = "raid10"
+	if results := validateBtrfsRaid(medias); len(results) != 1 {
+		t.Fatalf("btrfs raid10 should require 4 devices: %v", results)
+	}
+	root.BtrfsProfile = "raid5"
+	if results := validateBtrfsRaid(medias); len(results) != 1 {
+		t.Fatalf("btrfs raid5 should not be supported: %v", results)
+	}
+	root.BtrfsProfile = "raid1"
+
+	member.Size = 5 << 30
+	if results := validateBtrfsRaid(medias); len(results) != 1 {
+		t.Fatalf("btrfs RAID members should have a similar size: %v", results)
+	}
+	member.Size = 0
+	if results := validateBtrfsRaid(medias); len(results) != 1 {
+		t.Fatalf("btrfs RAID member filling its disk should be too large: %v", results)
+	}
+	member.Size = 10 << 30
+
+	boot.BtrfsMember = true
+	boot.FsType = "btrfs"
+	if results := validateBtrfsRaid(medias); len(results) != 3 {
+		t.Fatalf("/boot should not be a btrfs RAID member on the root disk: %v", results)
+	}
+}
+
+func TestXfsMakeFs(t *testing.T) {
+	op := bdOps["xfs"]
+
+	tests := []struct {
+		reflink  string
+		crc      string
+		expected string
+	}{
+		{"", "", "mkfs.xfs -L data -f /dev/sda3"},
+		{"false", "", "mkfs.xfs -L data -f -m reflink=0 /dev/sda3"},
+		{"true", "true", "mkfs.xfs -L data -f -m crc=1,reflink=1 /dev/sda3"},
+		{"", "false", "mkfs.xfs -L data -f -m crc=0,reflink=0 /dev/sda3"},
+	}
+
+	for _, curr := range tests {
+		bd := &BlockDevice{Name: "sda3", Type: BlockDeviceTypePart, FsType: "xfs", MountPoint: "/srv",
+			Label: "data", XfsReflink: curr.reflink, XfsCrc: curr.crc}
+
+		cmd, err := op.makeFsCommand(bd, op.makeFsArgs)
+		if err != nil {
+			t.Fatalf("Failed to create the mkfs command: %v", err)
+		}
+
+		if got := strings.Join(makeFsArgs(bd, cmd), " "); got != curr.expected {
+			t.Fatalf("Expected mkfs command %q, got %q", curr.expected, got)
+		}
+	}
+
+	srv := &BlockDevice{Name: "sda3", Type: BlockDeviceTypePart, FsType: "xfs", MountPoint: "/srv",
+		XfsQuota: "uquota,pquota"}
+	medias := []*BlockDevice{{Name: "sda", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{srv}}}
+
+	if results := validateXfs(medias); len(results) > 0 {
+		t.Fatalf("xfs quotas should be valid: %v", results)
+	}
+
+	// The auto mounted /srv needs a fstab entry to mount with the quotas
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, medias, MediaOpts{}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	if fields := strings.Fields(string(content)); len(fields) != 6 || fields[3] != "defaults,uquota,pquota" {
+		t.Fatalf("Unexpected /srv fstab entry: %q", content)
+	}
+
+	invalid := []struct {
+		bd  BlockDevice
+		msg string
+	}{
+		{BlockDevice{FsType: "ext4", MountPoint: "/srv", XfsReflink: "true"}, "non xfs file system"},
+		{BlockDevice{FsType: "xfs", MountPoint: "/srv", XfsCrc: "maybe"}, "invalid crc value"},
+		{BlockDevice{FsType: "xfs", MountPoint: "/srv", XfsCrc: "false", XfsReflink: "true"}, "reflink without crc"},
+		{BlockDevice{FsType: "xfs", MountPoint: "/srv", XfsQuota: "quota"}, "unknown quota"},
+		{BlockDevice{FsType: "xfs", MountPoint: "/srv", XfsCrc: "false", XfsQuota: "gquota,pquota"},
+			"group and project quotas without crc"},
+		{BlockDevice{FsType: "xfs", MountPoint: "/", XfsQuota: "uquota"}, "root quotas"},
+		{BlockDevice{FsType: "xfs", XfsQuota: "uquota"}, "quotas without mount point"},
+	}
+
+	for _, curr := range invalid {
+		bd := curr.bd
+		bd.Name = "sda3"
+		bd.Type = BlockDeviceTypePart
+		medias[0].Children = []*BlockDevice{&bd}
+
+		if results := validateXfs(medias); len(results) != 1 {
+			t.Fatalf("Expected one error for %s: %v", curr.msg, results)
+		}
+	}
+}
+
+func TestVerifyLayout(t *testing.T) {
+	//nolint: lll // WONTFIX
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name": "sdg", "maj:min": "8:96", "rm": "0", "size": "64G", "rw": "0", "type": "disk", "mountpoint": null,
+         "children": [
+            {"name": "sdg1", "maj:min": "8:97", "rm": "0", "fstype": "vfat", "label": "boot", "size": "150M", "rw": "0", "type": "part", "mountpoint": null},
+            {"name": "sdg2", "maj:min": "8:98", "rm": "0", "fstype": "swap", "label": "swap", "size": "256M", "rw": "0", "type": "part", "mountpoint": null},
+            {"name": "sdg3", "maj:min": "8:99", "rm": "0", "fstype": "ext4", "label": "root", "size": "20G", "rw": "0", "type": "part", "mountpoint": null}
+         ]
+      }
+   ]
+}`
+
+	devices, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parser block device descriptor: %s", err)
+	}
+
+	defer func(orig func(*BlockDevice) []*PartedPartition) { listPartitions = orig }(listPartitions)
+	listPartitions = func(bd *BlockDevice) []*PartedPartition {
+		return []*PartedPartition{
+			{Number: 1, Size: 150 << 20, Name: "EFI"},
+			{Number: 2, Size: 256 << 20, Name: "linux-swap"},
+			{Number: 3, Size: 20 << 30, Name: "/"},
+		}
+	}
+
+	boot := &BlockDevice{Name: "sdg1", Type: BlockDeviceTypePart, FsType: "vfat", MountPoint: "/boot",
+		Label: "boot", Size: 150 << 20}
+	swap := &BlockDevice{Name: "sdg2", Type: BlockDeviceTypePart, FsType: "swap", Label: "swap", Size: 256 << 20}
+	root := &BlockDevice{Name: "sdg3", Type: BlockDeviceTypePart, FsType: "ext4", MountPoint: "/",
+		Label: "root", Size: 0}
+	medias := []*BlockDevice{
+		{Name: "sdg", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{boot, swap, root}},
+	}
+
+	if results := VerifyLayout(medias, devices); len(results) > 0 {
+		t.Fatalf("The layout should match: %v", results)
+	}
+
+	root.FsType = "xfs"
+	root.Label = "clr-root"
+	swap.Size = 1 << 30
+	boot.PartitionLabel = "CLR_BOOT"
+
+	expected := []string{
+		"- sdg1 partitionLabel: CLR_BOOT",
+		"+ sdg1 partitionLabel: EFI",
+		"- sdg2 size: 1073741824",
+		"+ sdg2 size: 268435456",
+		"- sdg3 fstype: xfs",
+		"+ sdg3 fstype: ext4",
+		"- sdg3 label: clr-root",
+		"+ sdg3 label: root",
+	}
+
+	if results := VerifyLayout(medias, devices); !reflect.DeepEqual(results, expected) {
+		t.Fatalf("Expected the mismatches %q, got %q", expected, results)
+	}
+
+	// Missing and extra partitions and disks
+	medias[0].Children = []*BlockDevice{boot, root}
+	medias = append(medias, &BlockDevice{Name: "sdz", Type: BlockDeviceTypeDisk})
+	root.FsType = "ext4"
+	root.Label = "root"
+	boot.PartitionLabel = ""
+
+	expected = []string{
+		"- sdg2 partition: not configured",
+		"+ sdg2 partition: present",
+		"- sdz disk: present",
+		"+ sdz disk: missing",
+	}
+
+	if results := VerifyLayout(medias, devices); !reflect.DeepEqual(results, expected) {
+		t.Fatalf("Expected the mismatches %q, got %q", expected, results)
+	}
+}
+
+func TestPartitionLabel(t *testing.T) {
+	boot := &BlockDevice{Name: "sda1", FsType: "vfat", MountPoint: "/boot", MakePartition: true}
+	root := &BlockDevice{Name: "sda2", FsType: "ext4", MountPoint: "/", MakePartition: true}
+	swap := &BlockDevice{Name: "sda3", FsType: "swap", MakePartition: true}
+
+	tests := []struct {
+		bd       *BlockDevice
+		label    string
+		expected string
+	}{
+		{boot, "", "mkpart EFI fat32"},
+		{boot, "CLR_BOOT", "mkpart CLR_BOOT fat32"},
+		{root, "", "mkpart /"},
+		{root, "CLR_ROOT", "mkpart CLR_ROOT"},
+		{swap, "", "mkpart linux-swap"},
+		{swap, "CLR_SWAP", "mkpart CLR_SWAP"},
+	}
+
+	for _, curr := range tests {
+		curr.bd.PartitionLabel = curr.label
+
+		mkPart, err := bdOps[curr.bd.FsType].makePartCommand(curr.bd)
+		if err != nil {
+			t.Fatalf("Failed to create the mkpart command of %s: %v", curr.bd.Name, err)
+		}
+
+		if mkPart != curr.expected {
+			t.Fatalf("Expected %q for %s, got %q", curr.expected, curr.bd.Name, mkPart)
+		}
+	}
+
+	spare := &BlockDevice{Name: "sda4", Label: "spare", CreateOnly: true, PartitionLabel: "CLR_SPARE"}
+	if mkPart := createOnlyMakePartCommand(spare); mkPart != "mkpart CLR_SPARE" {
+		t.Fatalf("Unexpected create only mkpart command %q", mkPart)
+	}
+
+	// The partition name is read back from the parted output
+	partitions := parsePartitionTable(bytes.NewBufferString("BYT;\n" +
+		"/dev/sda:8589934592B:scsi:512:512:gpt:QEMU HARDDISK:;\n" +
+		"1:1048576B:158334975B:157286400B:fat32:CLR_BOOT:boot, esp;\n" +
+		"2:158334976B:8588886015B:8430551040B:ext4:CLR_ROOT:;\n"))
+	if len(partitions) != 2 || partitions[0].Name != "CLR_BOOT" || partitions[1].Name != "CLR_ROOT" {
+		t.Fatalf("Unexpected partition names: %+v", partitions)
+	}
+
+	root.PartitionLabel = "CLR_ROOT"
+	if results := validatePartitionLabel(root); len(results) != 0 {
+		t.Fatalf("Partition label should be valid: %v", results)
+	}
+
+	for _, label := range []string{"CLR ROOT", "CLR:ROOT", strings.Repeat("x", 37)} {
+		root.PartitionLabel = label
+		if results := validatePartitionLabel(root); len(results) != 1 {
+			t.Fatalf("Partition label %q should be invalid: %v", label, results)
+		}
+	}
+
+	var bd BlockDevice
+	if err := yaml.Unmarshal([]byte("{name: sda1, fstype: vfat, partitionLabel: CLR_BOOT}"), &bd); err != nil {
+		t.Fatalf("Failed to unmarshal the partition: %v", err)
+	}
+	if bd.PartitionLabel != "CLR_BOOT" {
+		t.Fatalf("Expected the partition label CLR_BOOT, got %q", bd.PartitionLabel)
+	}
+}
+
+func TestPersistentSwapKey(t *testing.T) {
+	//nolint: lll // WONTFIX
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name": "sdh", "maj:min": "8:112", "rm": "0", "size": "64G", "rw": "0", "type": "disk", "mountpoint": null,
+         "children": [
+            {"name": "sdh1", "maj:min": "8:113", "rm": "0", "fstype": "vfat", "label": "boot", "size": "512M", "rw": "0", "type": "part", "mountpoint": "/boot"},
+            {"name": "sdh2", "maj:min": "8:114", "rm": "0", "fstype": "swap", "uuid": "0d8d0c4f-2f4c-4d2a-9a3d-6f1b6f0a4f11", "size": "8G", "rw": "0", "type": "crypt", "mountpoint": null},
+            {"name": "sdh3", "maj:min": "8:115", "rm": "0", "fstype": "ext4", "label": "root", "size": "50G", "rw": "0", "type": "crypt", "mountpoint": "/"}
+         ]
+      }
+   ]
+}`
+
+	bds, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parser block device descriptor: %s", err)
+	}
+
+	swap := bds[0].Children[1]
+	root := bds[0].Children[2]
+	swap.MappedName = "mapper/swap"
+	swap.luksMapped = true
+
+	children := bds[0].FindAllChildren()
+	if results := validatePersistentSwapKey(children, root, "[swap]"); len(results) != 0 {
+		t.Fatalf("Persistent swap key should be valid: %v", results)
+	}
+
+	root.Type = BlockDeviceTypePart
+	if results := validatePersistentSwapKey(children, root, "[swap]"); len(results) != 1 {
+		t.Fatalf("Persistent swap key should require an encrypted root: %v", results)
+	}
+	root.Type = BlockDeviceTypeCrypt
+
+	swap.Type = BlockDeviceTypePart
+	if results := validatePersistentSwapKey(children, root, "[swap]"); len(results) != 1 {
+		t.Fatalf("Persistent swap key should require an encrypted swap: %v", results)
+	}
+	swap.Type = BlockDeviceTypeCrypt
+
+	// The LUKS volume is formatted as a regular swap
+	cmd, err := swapMakeFsCommand(swap, []string{})
+	if err != nil || len(cmd) == 0 || cmd[0] != "mkswap" {
+		t.Fatalf("Expected mkswap for the LUKS swap, got %v: %v", cmd, err)
+	}
+
+	if results := validateSwap(new(bool), swap, true, true, true, "[swap]"); len(results) != 0 {
+		t.Fatalf("Persistent key swap should allow hibernation: %v", results)
+	}
+	if results := validateSwap(new(bool), swap, true, true, false, "[swap]"); len(results) != 1 {
+		t.Fatalf("Random key swap should not allow hibernation: %v", results)
+	}
+
+	resume, err := ResumeKernelArguments("", bds, false)
+	if err != nil || len(resume) != 1 || resume[0] != "resume=/dev/mapper/swap" {
+		t.Fatalf("Unexpected resume arguments %v: %v", resume, err)
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, bds, MediaOpts{PersistentSwapKey: true}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "crypttab"))
+	if err != nil {
+		t.Fatalf("Failed to read crypttab: %v", err)
+	}
+
+	expected := "swap UUID=0d8d0c4f-2f4c-4d2a-9a3d-6f1b6f0a4f11 none luks"
+	if crypttab := strings.TrimSpace(string(content)); crypttab != expected {
+		t.Fatalf("Expected crypttab %q, got %q", expected, crypttab)
+	}
+
+	content, err = ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	if fstab := strings.TrimSpace(string(content)); fstab != "/dev/mapper/swap none swap defaults 0 0" {
+		t.Fatalf("Unexpected fstab content: %q", fstab)
+	}
+}
+
+func TestMakeFsProgress(t *testing.T) {
+	var reported []int
+
+	w := &mkfsProgressWriter{partial: func(percent int) {
+		reported = append(reported, percent)
+	}}
+
+	chunks := []string{
+		"Discarding device blocks: done\nWriting inode tables:   0/128\b\b\b\b\b\b\b  3",
+		"2/128\b\b\b\b\b\b\b 64/128\b\b\b\b\b\b\b",
+		"128/128\b\b\b\b\b\b\bdone\nCreating journal (16384 blocks): done\n",
+	}
+
+	for _, chunk := range chunks {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Failed to write the mkfs output: %d, %v", n, err)
+		}
+	}
+
+	expected := []int{25, 50, 100}
+	if fmt.Sprintf("%v", reported) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("Expected the progress %v, got %v", expected, reported)
+	}
+
+	if w.output.String() != strings.Join(chunks, "") {
+		t.Fatalf("The mkfs output should be kept, got %q", w.output.String())
+	}
+
+	for fsType, expected := range map[string]bool{"ext4": true, "ext3": true, "xfs": false, "vfat": false} {
+		bd := &BlockDevice{FsType: fsType}
+		if bd.MakeFsReportsProgress() != expected {
+			t.Fatalf("MakeFsReportsProgress() for %s should be %v", fsType, expected)
+		}
+	}
+}
+
+func TestPartitionPassphrases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	homeFile := filepath.Join(dir, "home.pass")
+	if err = ioutil.WriteFile(homeFile, []byte("home-passphrase\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	root := &BlockDevice{Name: "sdj2", Type: BlockDeviceTypeCrypt, FsType: "ext4", MountPoint: "/",
+		MappedName: "mapper/root", UUID: "4b0a5a4c-55c4-4a0f-8f5e-2d4f3b1e6a01"}
+	home := &BlockDevice{Name: "sdj3", Type: BlockDeviceTypeCrypt, FsType: "ext4", MountPoint: "/home",
+		MappedName: "mapper/home", UUID: "4b0a5a4c-55c4-4a0f-8f5e-2d4f3b1e6a02", PassphraseFile: homeFile}
+	disk := &BlockDevice{Name: "sdj", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{root, home}}
+	medias := []*BlockDevice{disk}
+
+	if err = ValidatePassphraseFiles(medias); err != nil {
+		t.Fatalf("Passphrase files should be valid: %v", err)
+	}
+
+	if err = ValidatePassphrases(medias, MediaOpts{}, ""); err == nil {
+		t.Fatal("The root should require the shared passphrase")
+	}
+
+	if err = ValidatePassphrases(medias, MediaOpts{}, "root-passphrase"); err != nil {
+		t.Fatalf("Every encrypted partition should have a passphrase: %v", err)
+	}
+
+	rootPass, err := root.Passphrase("root-passphrase")
+	if err != nil || rootPass != "root-passphrase" {
+		t.Fatalf("Expected the shared passphrase for the root, got %q: %v", rootPass, err)
+	}
+
+	homePass, err := home.Passphrase("root-passphrase")
+	if err != nil || homePass != "home-passphrase" {
+		t.Fatalf("Expected the own passphrase for /home, got %q: %v", homePass, err)
+	}
+
+	root.Type = BlockDeviceTypePart
+	if disk.EncryptionRequiresPassphrase(false) {
+		t.Fatal("A partition with its own passphrase should not require the shared one")
+	}
+	root.Type = BlockDeviceTypeCrypt
+
+	if err = GenerateTabFiles(dir, medias, MediaOpts{}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "etc", "crypttab"))
+	if err != nil {
+		t.Fatalf("Failed to read crypttab: %v", err)
+	}
+
+	expected := "home UUID=4b0a5a4c-55c4-4a0f-8f5e-2d4f3b1e6a02 none"
+	if crypttab := strings.TrimSpace(string(content)); crypttab != expected {
+		t.Fatalf("Expected crypttab %q, got %q", expected, crypttab)
+	}
+
+	if err = ioutil.WriteFile(homeFile, []byte("short\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ValidatePassphraseFiles(medias); err == nil {
+		t.Fatal("A too short passphrase should be invalid")
+	}
+
+	home.PassphraseFile = filepath.Join(dir, "missing")
+	if err = ValidatePassphraseFiles(medias); err == nil {
+		t.Fatal("A missing passphrase file should be invalid")
+	}
+
+	home.Type = BlockDeviceTypePart
+	home.PassphraseFile = homeFile
+	if err = ValidatePassphraseFiles(medias); err == nil {
+		t.Fatal("A passphrase file should require an encrypted partition")
+	}
+}
+
+func TestEncryptedSwapSectorSize(t *testing.T) {
+	tests := []struct {
+		sector   uint64
+		expected string
+	}{
+		{0, "swap,offset=2048,cipher=aes-xts-plain64,size=512"},
+		{512, "swap,offset=2048,cipher=aes-xts-plain64,size=512"},
+		{4096, "swap,offset=2048,cipher=aes-xts-plain64,size=512,sector-size=4096"},
+	}
+
+	for _, test := range tests {
+		swap := &BlockDevice{
+			Name:       "sdi2",
+			Type:       BlockDeviceTypeCrypt,
+			FsType:     "swap",
+			MappedName: "mapper/swap",
+			UUID:       "0d8d0c4f-2f4c-4d2a-9a3d-6f1b6f0a4f11",
+		}
+		disk := &BlockDevice{
+			Name:          "sdi",
+			Type:          BlockDeviceTypeDisk,
+			LogicalSector: test.sector,
+			Children:      []*BlockDevice{swap},
+		}
+
+		rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = GenerateTabFiles(rootDir, []*BlockDevice{disk}, MediaOpts{}); err != nil {
+			_ = os.RemoveAll(rootDir)
+			t.Fatalf("Failed to write tab files: %v", err)
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "crypttab"))
+		_ = os.RemoveAll(rootDir)
+		if err != nil {
+			t.Fatalf("Failed to read crypttab: %v", err)
+		}
+
+		fields := strings.Fields(string(content))
+		if len(fields) != 4 || fields[3] != test.expected {
+			t.Fatalf("Expected crypttab options %q for %d bytes sectors, got %q",
+				test.expected, test.sector, strings.TrimSpace(string(content)))
+		}
+	}
+}
+
+func TestAtimeMode(t *testing.T) {
+	for _, mode := range []string{"", AtimeRelatime, AtimeNoatime, AtimeStrictatime} {
+		if err := ValidateAtimeMode(mode); err != nil {
+			t.Fatalf("Atime mode %q should be valid: %v", mode, err)
+		}
+	}
+
+	for _, mode := range []string{"atime", "nodiratime", "lazytime"} {
+		if err := ValidateAtimeMode(mode); err == nil {
+			t.Fatalf("Atime mode %q should be invalid", mode)
+		}
+	}
+
+	tests := []struct {
+		options  string
+		mode     string
+		expected string
+		flags    uintptr
+	}{
+		{"defaults", "", "defaults", syscall.MS_RELATIME},
+		{"defaults", AtimeRelatime, "defaults", syscall.MS_RELATIME},
+		{"defaults", AtimeNoatime, "defaults,noatime", syscall.MS_NOATIME},
+		{"defaults", AtimeStrictatime, "defaults,strictatime", syscall.MS_STRICTATIME},
+		{"nodiratime", AtimeNoatime, "nodiratime,noatime", syscall.MS_NODIRATIME | syscall.MS_NOATIME},
+		{"defaults,relatime", AtimeNoatime, "defaults,relatime", syscall.MS_RELATIME},
+		{"defaults,noatime", AtimeStrictatime, "defaults,noatime", syscall.MS_NOATIME},
+	}
+
+	for _, curr := range tests {
+		options := atimeMountOptions(curr.options, MediaOpts{AtimeMode: curr.mode})
+		if options != curr.expected {
+			t.Fatalf("Options %q with atime mode %q: got %q, want %q", curr.options, curr.mode,
+				options, curr.expected)
+		}
+
+		// The install time mount gets the same atime flag as the fstab entry
+		flags, _, err := parseMountOptions(options)
+		if err != nil || flags != curr.flags {
+			t.Fatalf("Options %q: got flags %#x, want %#x: %v", options, flags, curr.flags, err)
+		}
+	}
+
+	// The auto mounted partitions get a fstab entry to keep the atime mode
+	root := &BlockDevice{Name: "sda2", Type: BlockDeviceTypePart, FsType: "ext4", Label: "root",
+		MountPoint: "/"}
+	disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{root}}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	if err = GenerateTabFiles(rootDir, []*BlockDevice{disk}, MediaOpts{AtimeMode: AtimeNoatime}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	if !strings.Contains(string(content), "LABEL=root / ext4 defaults,noatime 0 1\n") {
+		t.Fatalf("Unexpected fstab content: %q", string(content))
+	}
+}
+
+func TestPartitionMountOptions(t *testing.T) {
+	tests := []struct {
+		options string
+		flags   uintptr
+		data    string
+	}{
+		{"defaults", syscall.MS_RELATIME, ""},
+		{"noatime,nodiratime", syscall.MS_NOATIME | syscall.MS_NODIRATIME, ""},
+		{"ro,nofail,x-systemd.device-timeout=5", syscall.MS_RDONLY | syscall.MS_RELATIME, ""},
+		{"defaults,noexec,compress=zstd,space_cache=v2", syscall.MS_NOEXEC | syscall.MS_RELATIME,
+			"compress=zstd,space_cache=v2"},
+	}
+
+	for _, curr := range tests {
+		flags, data, err := parseMountOptions(curr.options)
+		if err != nil {
+			t.Fatalf("Failed to parse mount options %q: %v", curr.options, err)
+		}
+
+		if flags != curr.flags || data != curr.data {
+			t.Fatalf("Expected flags %#x and data %q for %q, got %#x and %q", curr.flags, curr.data,
+				curr.options, flags, data)
+		}
+	}
+
+	for _, options := range []string{"ro,rw", "noatime,relatime", "exec,noexec", "sync,async", "noatime,,ro"} {
+		if _, _, err := parseMountOptions(options); err == nil {
+			t.Fatalf("Mount options %q should be refused", options)
+		}
+	}
+
+	db := &BlockDevice{Name: "sda3", Type: BlockDeviceTypePart, FsType: "ext4", Label: "db",
+		MountPoint: "/var/lib/db", MountOptions: "noatime,nodiratime"}
+	archive := &BlockDevice{Name: "sda4", Type: BlockDeviceTypePart, FsType: "xfs", Label: "archive",
+		MountPoint: "/archive", MountOptions: "ro"}
+	data := &BlockDevice{Name: "sda5", Type: BlockDeviceTypePart, FsType: "ext4", Label: "data",
+		MountPoint: "/data"}
+	disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{db, archive, data}}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, []*BlockDevice{disk}, MediaOpts{}); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	expected := "LABEL=archive /archive xfs ro 0 2\n" +
+		"LABEL=data /data ext4 defaults 0 2\n" +
+		"LABEL=db /var/lib/db ext4 noatime,nodiratime 0 2\n"
+	if !strings.HasSuffix(string(content), expected) {
+		t.Fatalf("Unexpected fstab content: %q", string(content))
+	}
+}
+
+func TestCloneLayout(t *testing.T) {
+	lsblkOutput := `{
+    "blockdevices": [
+        {"name": "sda", "size": "10737418240", "type": "disk", "mountpoint": null,
+         "children": [
+            {"name": "sda1", "size": "157286400", "type": "part", "fstype": "vfat",
+             "label": "boot", "mountpoint": null},
+            {"name": "sda2", "size": "268435456", "type": "part", "fstype": "swap",
+             "mountpoint": "[SWAP]"},
+            {"name": "sda3", "size": "1073741824", "type": "part", "fstype": "ext4",
+             "partlabel": "CLR_MNT_/home", "mountpoint": null},
+            {"name": "sda4", "size": "4294967296", "type": "part", "fstype": "ext4",
+             "label": "root", "mountpoint": null},
+            {"name": "sda5", "size": "104857600", "type": "part", "fstype": "ntfs",
+             "mountpoint": null}
+         ]
+        },
+        {"name": "nvme0n1", "size": "21474836480", "type": "disk", "mountpoint": null}
+    ]
+}`
+
+	devices, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parse block device descriptor: %s", err)
+	}
+
+	source := FindBlockDevice(devices, "/dev/sda")
+	target := FindBlockDevice(devices, "nvme0n1")
+	if source == nil || target == nil {
+		t.Fatal("Could not find the source and target disks")
+	}
+
+	layout, err := CloneLayout(source, target)
+	if err != nil {
+		t.Fatalf("Could not clone the layout: %v", err)
+	}
+
+	expected := []struct {
+		name       string
+		fsType     string
+		mountPoint string
+		size       uint64
+		createOnly bool
+	}{
+		{"nvme0n1p1", "vfat", "/boot", 157286400, false},
+		{"nvme0n1p2", "swap", "", 268435456, false},
+		{"nvme0n1p3", "ext4", "/home", 1073741824, false},
+		{"nvme0n1p4", "ext4", "/", 8589000000, false},
+		{"nvme0n1p5", "", "", 104857600, true},
+	}
+
+	if len(layout.Children) != len(expected) {
+		t.Fatalf("Expected %d partitions, got %d", len(expected), len(layout.Children))
+	}
+
+	for i, curr := range expected {
+		ch := layout.Children[i]
+		if ch.Name != curr.name || ch.FsType != curr.fsType || ch.MountPoint != curr.mountPoint ||
+			ch.Size != curr.size || ch.CreateOnly != curr.createOnly || !ch.MakePartition {
+			t.Fatalf("Unexpected cloned partition %d: %+v", i, ch)
+		}
+	}
+
+	// A fully used source disk clones its root as max-fill
+	var used uint64
+	for _, ch := range source.Children[:4] {
+		used += ch.Size
+	}
+	source.Children[4].Size = source.Size - used
+	layout, err = CloneLayout(source, target)
+	if err != nil {
+		t.Fatalf("Could not clone the layout: %v", err)
+	}
+
+	if layout.Children[3].Size != 0 {
+		t.Fatalf("Expected a max-fill root, got size %d", layout.Children[3].Size)
+	}
+
+	source.Children[3].Label = "data"
+	if _, err = CloneLayout(source, target); err == nil {
+		t.Fatal("Cloning a layout without / partition should fail")
+	}
+}
+
+func TestPartitionTable(t *testing.T) {
+	standard := func() *BlockDevice {
+		bd := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, Size: 64 * 1000 * 1000 * 1000}
+		NewStandardPartitions(bd)
+		return bd
+	}
+
+	msdos := MediaOpts{PartitionTable: PartitionTableMSDOS, LegacyBios: true}
+
+	if err := ValidatePartitionTable([]*BlockDevice{standard()}, MediaOpts{}); err != nil {
+		t.Fatalf("The default gpt table should be valid: %v", err)
+	}
+
+	if err := ValidatePartitionTable([]*BlockDevice{standard()}, msdos); err != nil {
+		t.Fatalf("A whole disk msdos table should be valid: %v", err)
+	}
+
+	invalid := []struct {
+		mediaOpts MediaOpts
+		update    func(*BlockDevice)
+	}{
+		{MediaOpts{PartitionTable: "bsd"}, nil},
+		{MediaOpts{PartitionTable: PartitionTableMSDOS}, nil},
+		{MediaOpts{PartitionTable: PartitionTableMSDOS, LegacyBios: true, DeterministicGUIDs: true}, nil},
+		{msdos, func(bd *BlockDevice) { bd.Children[0].PartitionLabel = "EFI" }},
+		{msdos, func(bd *BlockDevice) { bd.Children[1].MakePartition = false }},
+		{msdos, func(bd *BlockDevice) { bd.Children[1].FsType = BlockDeviceTypeLVM2GroupString }},
+		{msdos, func(bd *BlockDevice) { bd.Type = BlockDeviceTypeRAID1 }},
+		{msdos, func(bd *BlockDevice) {
+			for i := 0; i < 3; i++ {
+				bd.AddChild(&BlockDevice{Name: fmt.Sprintf("sda%d", i+3), Type: BlockDeviceTypePart, MakePartition: true})
+			}
+		}},
+	}
+
+	for i, curr := range invalid {
+		bd := standard()
+		if curr.update != nil {
+			curr.update(bd)
+		}
+
+		if err := ValidatePartitionTable([]*BlockDevice{bd}, curr.mediaOpts); err == nil {
+			t.Fatalf("Partition table case %d should be invalid", i)
+		}
+	}
+
+	if results := validateAdvancedPartitions(MinimumServerInstallSize, []*BlockDevice{}, msdos); len(results) == 0 {
+		t.Fatal("Advanced partitioning should be refused with a msdos table")
+	}
+
+	if cmd := msdosMakePartCommand("mkpart EFI fat32"); cmd != "mkpart primary fat32" {
+		t.Fatalf("Unexpected msdos mkpart command %q", cmd)
+	}
+
+	// A msdos disk holds at most 4 primary partitions
+	bd := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, PtType: "dos", Size: 64 * 1000 * 1000 * 1000,
+		PartTable: []*PartedPartition{{Size: 64 * 1000 * 1000 * 1000, End: 64 * 1000 * 1000 * 1000,
+			FileSystem: "free"}}}
+	for i := 0; i < maxMSDOSPartitions+1; i++ {
+		bd.AddFromFreePartition(bd.findFree(bootSizeDefault),
+			&BlockDevice{Size: bootSizeDefault, Type: BlockDeviceTypePart})
+	}
+
+	if len(bd.Children) != maxMSDOSPartitions {
+		t.Fatalf("Expected %d msdos partitions, got %d", maxMSDOSPartitions, len(bd.Children))
+	}
+
+	// Only the disks of the requested table type are safe targets
+	disks := []*BlockDevice{
+		{Name: "sda", Type: BlockDeviceTypeDisk, PtType: "gpt", Size: 64 * 1000 * 1000 * 1000},
+		{Name: "sdb", Type: BlockDeviceTypeDisk, PtType: "dos", Size: 64 * 1000 * 1000 * 1000},
+		{Name: "sdc", Type: BlockDeviceTypeDisk, Size: 64 * 1000 * 1000 * 1000},
+	}
+
+	for _, curr := range []struct {
+		mediaOpts MediaOpts
+		expected  string
+	}{
+		{MediaOpts{}, "sda sdc"},
+		{msdos, "sdb sdc"},
+	} {
+		names := []string{}
+		for _, target := range FindSafeInstallTargets(MinimumServerInstallSize, disks, curr.mediaOpts) {
+			names = append(names, target.Name)
+		}
+		sort.Strings(names)
+
+		if strings.Join(names, " ") != curr.expected {
+			t.Fatalf("Expected the %s safe targets %s, got %v", curr.mediaOpts.GetPartitionTable(),
+				curr.expected, names)
+		}
+	}
+}
+
+func TestReuseESP(t *testing.T) {
+	defer func(freeSpace func(*BlockDevice) (uint64, error)) { espFreeSpace = freeSpace }(espFreeSpace)
+
+	//nolint: lll // WONTFIX
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name": "sda", "maj:min": "8:0", "rm": "0", "size": "256G", "ro": "0", "type": "disk", "pttype": "gpt", "parttype": null, "fstype": null, "mountpoint": null,
+         "children": [
+            {"name": "sda1", "maj:min": "8:1", "rm": "0", "size": "100M", "ro": "0", "type": "part", "pttype": "gpt", "parttype": "c12a7328-f81f-11d2-ba4b-00a0c93ec93b", "fstype": "vfat", "mountpoint": null},
+            {"name": "sda2", "maj:min": "8:2", "rm": "0", "size": "16M", "ro": "0", "type": "part", "pttype": "gpt", "parttype": "e3c9e316-0b5c-4db8-817d-f92df00215ae", "fstype": null, "mountpoint": null},
+            {"name": "sda3", "maj:min": "8:3", "rm": "0", "size": "100G", "ro": "0", "type": "part", "pttype": "gpt", "parttype": "ebd0a0a2-b9e5-4433-87c0-68b6b72699c7", "fstype": "ntfs", "mountpoint": null}
+         ]
+      }
+   ]
+}`
+
+	bds, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parser block device descriptor: %s", err)
+	}
+
+	disk := bds[0]
+	disk.PartTable = []*PartedPartition{{Start: 100 * 1024 * 1024 * 1024, End: disk.Size,
+		Size: disk.Size - 100*1024*1024*1024, FileSystem: "free"}}
+
+	if esp := disk.FindESP(); esp == nil || esp.Name != "sda1" {
+		t.Fatalf("Expected sda1 as the EFI System Partition, got %+v", esp)
+	}
+
+	for _, ch := range disk.Children[1:] {
+		if ch.IsESP() {
+			t.Fatalf("%s is not an EFI System Partition", ch.Name)
+		}
+	}
+
+	targets := FindSafeInstallTargets(MinimumServerInstallSize, bds, MediaOpts{})
+	if len(targets) != 1 || targets[0].ESP != "sda1" {
+		t.Fatalf("Expected the safe target to offer the sda1 EFI System Partition, got %+v", targets)
+	}
+
+	legacy := MediaOpts{LegacyBios: true}
+	if targets = FindSafeInstallTargets(MinimumServerInstallSize, bds, legacy); len(targets) != 1 ||
+		targets[0].ESP != "" {
+		t.Fatalf("No EFI System Partition should be offered in legacy mode, got %+v", targets)
+	}
+
+	if err = UseExistingESP(disk, "sda3"); err == nil {
+		t.Fatal("sda3 is not an EFI System Partition and should not be reused")
+	}
+
+	if err = UseExistingESP(disk, "sda1"); err != nil {
+		t.Fatalf("Failed to reuse the EFI System Partition: %v", err)
+	}
+
+	esp := disk.Children[0]
+	if esp.MountPoint != "/boot" || esp.MakePartition || esp.FormatPartition || !esp.ReuseESP {
+		t.Fatalf("The EFI System Partition should be mounted as /boot without formatting: %+v", esp)
+	}
+
+	// The reused partition must stay unformatted when the configuration is reloaded
+	data, err := yaml.Marshal(esp)
+	if err != nil {
+		t.Fatalf("Failed to marshal the EFI System Partition: %v", err)
+	}
+
+	reloaded := &BlockDevice{}
+	if err = yaml.Unmarshal(data, reloaded); err != nil {
+		t.Fatalf("Failed to unmarshal the EFI System Partition: %v", err)
+	}
+
+	if !reloaded.ReuseESP || reloaded.MakePartition || reloaded.FormatPartition {
+		t.Fatalf("The reloaded EFI System Partition should not be formatted: %+v", reloaded)
+	}
+
+	for _, curr := range []struct {
+		free      uint64
+		mediaOpts MediaOpts
+		valid     bool
+	}{
+		{minESPFreeSize, MediaOpts{}, true},
+		{minESPFreeSize - 1, MediaOpts{}, false},
+		{minESPFreeSize - 1, MediaOpts{SkipValidationSize: true}, true},
+		{minESPFreeSize, legacy, false},
+	} {
+		free := curr.free
+		espFreeSpace = func(*BlockDevice) (uint64, error) { return free, nil }
+
+		found := false
+		results := validateBoot(&found, esp, curr.mediaOpts, "/boot")
+		if (len(results) == 0) != curr.valid {
+			t.Fatalf("Free space %d with %+v: expected valid %v, got %v", free, curr.mediaOpts,
+				curr.valid, results)
+		}
+	}
+}
+
+func TestSectorAlignment(t *testing.T) {
+	gib := uint64(1000 * 1000 * 1000)
+	disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, Size: 64 * gib, LogicalSector: 512,
+		PhysicalSector: 512}
+
+	if disk.Uses4KSectors() || disk.partedUnit() != "MB" || disk.getStartEnd(0, 500*1000*1000) != "0% 500M" {
+		t.Fatalf("512 bytes sectors should keep the MB alignment: %s", disk.getStartEnd(0, 500*1000*1000))
+	}
+
+	disk.LogicalSector = 4096
+	disk.PhysicalSector = 4096
+
+	if !disk.Uses4KSectors() || disk.partedUnit() != "MiB" {
+		t.Fatal("4K native sectors should use the MiB alignment")
+	}
+
+	for _, curr := range []struct {
+		start, end uint64
+		expected   string
+	}{
+		{0, 500 * 1000 * 1000, "1MiB 476MiB"},
+		{500 * 1000 * 1000, 0, "477MiB 100%"},
+		{mibAlignUnit, 513 * mibAlignUnit, "1MiB 513MiB"},
+	} {
+		if startEnd := disk.getStartEnd(curr.start, curr.end); startEnd != curr.expected {
+			t.Fatalf("Expected %q for %d-%d, got %q", curr.expected, curr.start, curr.end, startEnd)
+		}
+	}
+
+	disk.Children = []*BlockDevice{
+		{Name: "sda1", Size: 512 * mibAlignUnit, MakePartition: true},
+		{Name: "sda2", Size: 500*1000*1000 + 1, MakePartition: true},
+		{Name: "sda3", Size: 500*1000*1000 + 1},
+		{Name: "sda4", MakePartition: true},
+	}
+
+	if warnings := disk.misalignedPartitions(); len(warnings) != 1 || !strings.Contains(warnings[0], "sda2") {
+		t.Fatalf("Only sda2 should be reported as misaligned: %v", warnings)
+	}
+}
+
+func TestReadOnlyRoot(t *testing.T) {
+	//nolint: lll // WONTFIX
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name": "sdh", "maj:min": "8:112", "rm": "0", "size": "64G", "rw": "0", "type": "disk", "mountpoint": null,
+         "children": [
+            {"name": "sdh1", "maj:min": "8:113", "rm": "0", "fstype": "vfat", "label": "boot", "size": "512M", "rw": "0", "type": "part", "mountpoint": "/boot"},
+            {"name": "sdh2", "maj:min": "8:114", "rm": "0", "fstype": "ext4", "label": "root", "size": "20G", "rw": "0", "type": "part", "mountpoint": "/"},
+            {"name": "sdh3", "maj:min": "8:115", "rm": "0", "fstype": "ext4", "label": "home", "size": "10G", "rw": "0", "type": "part", "mountpoint": "/home"}
+         ]
+      }
+   ]
+}`
+
+	bds, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parser block device descriptor: %s", err)
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	mediaOpts := MediaOpts{ReadOnlyRoot: true}
+	if err = ValidateReadOnlyRoot(bds, mediaOpts); err != nil {
+		t.Fatalf("The layout should support a read-only root: %v", err)
+	}
+
+	if err = GenerateTabFiles(rootDir, bds, mediaOpts); err != nil {
+		t.Fatalf("Failed to write tab files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	if fstab := strings.TrimSpace(string(content)); fstab != "LABEL=root / ext4 ro 0 1" {
+		t.Fatalf("Unexpected fstab content: %q", fstab)
+	}
+
+	if options := readOnlyMountOptions("rw,noatime,discard"); options != "ro,noatime,discard" {
+		t.Fatalf("Unexpected read-only mount options: %s", options)
+	}
+
+	if err = InstallReadOnlyRootUnits(rootDir); err != nil {
+		t.Fatalf("Failed to install the /var overlay unit: %v", err)
+	}
+
+	unitDir := filepath.Join(rootDir, "etc", "systemd", "system")
+	unit, err := ioutil.ReadFile(filepath.Join(unitDir, VarOverlayUnit))
+	if err != nil || !strings.Contains(string(unit), "lowerdir=/var,upperdir="+varOverlayDir+"/upper") {
+		t.Fatalf("Unexpected /var overlay unit: %q %v", unit, err)
+	}
+
+	link, err := os.Readlink(filepath.Join(unitDir, "local-fs.target.wants", VarOverlayUnit))
+	if err != nil || link != "/etc/systemd/system/"+VarOverlayUnit {
+		t.Fatalf("The /var overlay unit should be enabled: %q %v", link, err)
+	}
+
+	// A separate /var is hidden by the overlay and the read-only root can't grow
+	bds[0].Children[2].MountPoint = "/var/log"
+	if err = ValidateReadOnlyRoot(bds, mediaOpts); err == nil {
+		t.Fatal("A read-only root should not allow a /var/log partition")
+	}
+
+	bds[0].Children[2].MountPoint = "/home"
+	if err = ValidateReadOnlyRoot(bds, MediaOpts{ReadOnlyRoot: true, ExpandLVMRoot: true}); err == nil {
+		t.Fatal("A read-only root should not allow expandLvmRoot")
+	}
+}
+
+func TestTargetMounts(t *testing.T) {
+	savedMounts, savedUnmount, savedSysBlock, savedRemove := procMounts, unmount, sysClassBlockDir, removeMapping
+	defer func() {
+		procMounts, unmount, sysClassBlockDir, removeMapping = savedMounts, savedUnmount, savedSysBlock, savedRemove
+	}()
+
+	dir, err := ioutil.TempDir("", "clr-installer-mounts-")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	fixture := `sysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0
+/dev/sda2 / ext4 rw,relatime 0 0
+/dev/sdb2 /var/tmp/install-root ext4 rw,relatime 0 0
+/dev/sdb1 /var/tmp/install-root/boot vfat rw,relatime 0 0
+/dev/sdb3 /var/tmp/install\040root/home ext4 rw,relatime 0 0
+/dev/sdbc1 /mnt/other ext4 rw,relatime 0 0
+/dev/sdbp1 /mnt/other-p ext4 rw,relatime 0 0
+/dev/mapper/luks-data /mnt/crypt ext4 rw,relatime 0 0
+/dev/mapper/vg-lv /mnt/lvm ext4 rw,relatime 0 0
+/dev/nvme0n1p1 /mnt/nvme vfat rw,relatime 0 0
+/dev/nvme0n11 /mnt/nvme-other vfat rw,relatime 0 0
+`
+	procMounts = filepath.Join(dir, "mounts")
+	if err = ioutil.WriteFile(procMounts, []byte(fixture), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// sdb4 holds a LUKS volume holding a LVM volume
+	sysClassBlockDir = filepath.Join(dir, "sys")
+	for _, curr := range []string{"sdb", "sdb1", "sdb2", "sdb3", "sdbc", "sdbc1",
+		"sdb4/holders/dm-0", "dm-0/dm", "dm-0/holders/dm-1", "dm-1/dm", "dm-2/dm"} {
+		if err = os.MkdirAll(filepath.Join(sysClassBlockDir, curr), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for dm, name := range map[string]string{"dm-0": "luks-data", "dm-1": "vg-lv", "dm-2": "other"} {
+		if err = ioutil.WriteFile(filepath.Join(sysClassBlockDir, dm, "dm", "name"), []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		mounts []string
+	}{
+		{"sdb", []string{"/var/tmp/install-root", "/var/tmp/install-root/boot", "/var/tmp/install root/home",
+			"/mnt/crypt", "/mnt/lvm"}},
+		{"sdbc", []string{"/mnt/other"}},
+		{"nvme0n1", []string{"/mnt/nvme"}},
+		{"sdc", []string{}},
+	}
+
+	for _, curr := range tests {
+		mounts, err := DeviceMounts(curr.name)
+		if err != nil {
+			t.Fatalf("Could not list the mounts of %s: %v", curr.name, err)
+		}
+
+		if !reflect.DeepEqual(mounts, curr.mounts) {
+			t.Fatalf("Expected the mounts %v for %s, got %v", curr.mounts, curr.name, mounts)
+		}
+	}
+
+	mappings, err := DeviceMappings("sdb")
+	if err != nil {
+		t.Fatalf("Could not list the volumes of sdb: %v", err)
+	}
+
+	// The LVM volume must be closed before the LUKS volume holding it
+	if !reflect.DeepEqual(mappings, []string{"vg-lv", "luks-data"}) {
+		t.Fatalf("Unexpected volumes of sdb: %v", mappings)
+	}
+
+	unmounted := []string{}
+	unmount = func(target string, flags int) error {
+		unmounted = append(unmounted, target)
+		return nil
+	}
+
+	removed := []string{}
+	removeMapping = func(name string) error {
+		removed = append(removed, name)
+		return nil
+	}
+
+	targets := map[string]InstallTarget{"sdb": {Name: "sdb"}}
+	if err = ReleaseTargetMounts(targets, false); err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Fatalf("Expected a busy target error, got %v", err)
+	}
+
+	if len(unmounted) != 0 || len(removed) != 0 {
+		t.Fatalf("Nothing should be released without force: %v %v", unmounted, removed)
+	}
+
+	if err = ReleaseTargetMounts(targets, true); err != nil {
+		t.Fatalf("Failed to release the target mounts: %v", err)
+	}
+
+	expected := []string{"/var/tmp/install-root/boot", "/var/tmp/install-root", "/var/tmp/install root/home",
+		"/mnt/lvm", "/mnt/crypt"}
+	if !reflect.DeepEqual(unmounted, expected) {
+		t.Fatalf("Expected the unmounts %v, got %v", expected, unmounted)
+	}
+
+	if !reflect.DeepEqual(removed, mappings) {
+		t.Fatalf("Expected the volumes %v to be closed, got %v", mappings, removed)
+	}
+
+	// An open volume keeps the target busy even when nothing is mounted
+	if err = ioutil.WriteFile(procMounts, []byte("/dev/sda2 / ext4 rw,relatime 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = ReleaseTargetMounts(targets, false)
+	if err == nil || !strings.Contains(err.Error(), "vg-lv, luks-data") {
+		t.Fatalf("Expected the open volumes to be reported, got %v", err)
+	}
+}
+
+func TestPartitionOrder(t *testing.T) {
+	newDisk := func() *BlockDevice {
+		return &BlockDevice{Name: "sdz", Type: BlockDeviceTypeDisk, Size: 20 << 30, Children: []*BlockDevice{
+			{Name: "sdz1", Type: BlockDeviceTypePart, FsType: "ext4", MountPoint: "/",
+				Size: 10 << 30, MakePartition: true},
+			{Name: "sdz2", Type: BlockDeviceTypePart, FsType: "swap", Size: 1 << 30, MakePartition: true},
+			{Name: "sdz3", Type: BlockDeviceTypePart, FsType: "vfat", MountPoint: "/boot",
+				Size: 150 << 20, MakePartition: true},
+		}}
+	}
+
+	partedSequence := func(bd *BlockDevice) []string {
+		dryRun := &DryRunType{&[]string{}, &[]string{}}
+		if err := bd.WritePartitionTable(false, false, dryRun); err != nil {
+			t.Fatalf("Dry run failed: %v", err)
+		}
+
+		sequence := []string{}
+		for _, result := range *dryRun.TargetResults {
+			if strings.Contains(result, AddPartitionInfo) {
+				sequence = append(sequence, result)
+			}
+		}
+		return sequence
+	}
+
+	added := func(size string) string {
+		return fmt.Sprintf("sdz: %s [%s]", AddPartitionInfo, size)
+	}
+
+	// Without orders the partitions are created in name order
+	expected := []string{added("10GiB"), added("1GiB"), added("150MiB")}
+	if sequence := partedSequence(newDisk()); !reflect.DeepEqual(sequence, expected) {
+		t.Fatalf("Expected the name order %v, got %v", expected, sequence)
+	}
+
+	// The ESP named last is created first, the partitions without an order follow
+	bd := newDisk()
+	bd.Children[2].Order = 1
+	bd.Children[0].Order = 2
+
+	expected = []string{added("150MiB"), added("10GiB"), added("1GiB")}
+	if sequence := partedSequence(bd); !reflect.DeepEqual(sequence, expected) {
+		t.Fatalf("Expected the explicit order %v, got %v", expected, sequence)
+	}
+
+	// parted numbers the partitions in creation order, the names must follow
+	bd = newDisk()
+	bd.Children[2].Order = 1
+	bd.Children[0].Order = 2
+	bd.sortPartitions()
+	bd.renamePartitions()
+
+	devices := map[string]string{}
+	for _, ch := range bd.Children {
+		devices[ch.FsType] = ch.GetDeviceFile()
+	}
+
+	expectedDevices := map[string]string{"vfat": "/dev/sdz1", "ext4": "/dev/sdz2", "swap": "/dev/sdz3"}
+	if !reflect.DeepEqual(devices, expectedDevices) {
+		t.Fatalf("Expected the device files %v, got %v", expectedDevices, devices)
+	}
+
+	for idx, ch := range bd.Children {
+		if ch.GetPartitionNumber() != uint64(idx+1) {
+			t.Fatalf("Expected %s to be partition %d, got %d", ch.Name, idx+1, ch.GetPartitionNumber())
+		}
+	}
+
+	bd = newDisk()
+	bd.Children[0].Order = 1
+	bd.Children[1].Order = 1
+	bd.Children[2].Order = -1
+	if results := validatePartitionOrder([]*BlockDevice{bd}); len(results) != 2 {
+		t.Fatalf("Expected a duplicate and a negative order error, got %v", results)
+	}
+}
+
+func TestMountPointMode(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "clr-installer-mount-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	rootInfo, err := os.Stat(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mountPoint string
+		mountMode  string
+		mode       os.FileMode
+	}{
+		{"/srv", "", DefaultMountPointMode},
+		{"/var/lib/db", "0700", 0700},
+	}
+
+	for _, curr := range tests {
+		bd := &BlockDevice{Name: "sda1", MountPoint: curr.mountPoint, MountMode: curr.mountMode}
+		path := filepath.Join(rootDir, curr.mountPoint)
+
+		if err = makeMountPoint(path, bd.mountPointMode()); err != nil {
+			t.Fatalf("Could not create the mount point %s: %v", path, err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode().Perm() != curr.mode {
+			t.Fatalf("Expected mode %o for %s, got %o", curr.mode, curr.mountPoint, info.Mode().Perm())
+		}
+	}
+
+	// The parent directories use the default mode
+	info, err := os.Stat(filepath.Join(rootDir, "var", "lib"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm()&^DefaultMountPointMode != 0 {
+		t.Fatalf("The parent directory mode %o should not exceed %o", info.Mode().Perm(), DefaultMountPointMode)
+	}
+
+	// The existing root directory is left untouched
+	if err = makeMountPoint(rootDir, DefaultMountPointMode); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err = os.Stat(rootDir); err != nil || info.Mode() != rootInfo.Mode() {
+		t.Fatalf("The root directory mode should be unchanged, got %v: %v", info.Mode(), err)
+	}
+
+	invalid := []*BlockDevice{
+		{Name: "sda1", FsType: "ext4", MountPoint: "/srv", MountMode: "0999"},
+		{Name: "sda1", FsType: "ext4", MountPoint: "/srv", MountMode: "1777"},
+		{Name: "sda1", FsType: "ext4", MountPoint: "/srv", MountOwner: "postgres"},
+		{Name: "sda1", FsType: "ext4", MountPoint: "/srv", MountOwner: "1000:"},
+		{Name: "sda1", FsType: "vfat", MountPoint: "/boot", MountMode: "0700"},
+		{Name: "sda1", FsType: "ext4", MountOwner: "1000"},
+	}
+
+	for _, bd := range invalid {
+		if results := validateMountOwnership(bd); len(results) == 0 {
+			t.Fatalf("Mount mode %q and owner %q of %s should be invalid", bd.MountMode, bd.MountOwner, bd.FsType)
+		}
+	}
+
+	valid := &BlockDevice{Name: "sda1", FsType: "ext4", MountPoint: "/srv", MountMode: "0750", MountOwner: "1000:1000"}
+	if results := validateMountOwnership(valid); len(results) != 0 {
+		t.Fatalf("Mount mode and owner should be valid: %v", results)
+	}
+
+	if uid, gid, err := parseMountOwner("1000"); err != nil || uid != 1000 || gid != -1 {
+		t.Fatalf("Expected the owner 1000 with an unchanged group, got %d:%d: %v", uid, gid, err)
+	}
+}
+
+func TestSplitBoot(t *testing.T) {
+	newDisk := func() *BlockDevice {
+		disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, Size: 64 * 1024 * 1024 * 1024}
+		disk.AddChild(&BlockDevice{Name: "sda1", Type: BlockDeviceTypePart, FsType: "vfat",
+			MountPoint: ESPMountPoint, Size: 100 * 1024 * 1024})
+		disk.AddChild(&BlockDevice{Name: "sda2", Type: BlockDeviceTypePart, FsType: "vfat",
+			MountPoint: "/boot", Size: 1024 * 1024 * 1024})
+		disk.AddChild(&BlockDevice{Name: "sda3", Type: BlockDeviceTypePart, FsType: "ext4",
+			MountPoint: "/", Size: 32 * 1024 * 1024 * 1024})
+		return disk
+	}
+
+	disk := newDisk()
+	mediaOpts := MediaOpts{SplitBoot: true, SwapFileSize: "64MiB"}
+
+	if results := validateSplitBoot([]*BlockDevice{disk}, mediaOpts); len(results) != 0 {
+		t.Fatalf("The split boot layout should be valid: %v", results)
+	}
+
+	// Without splitBoot /boot/efi is a regular partition and /boot the ESP
+	if results := validatePartitions(0, []*BlockDevice{disk}, MediaOpts{SwapFileSize: "64MiB"}, false); len(results) != 0 {
+		t.Fatalf("The /boot/efi partition should be valid without splitBoot: %v", results)
+	}
+
+	if guid := disk.partitionGUID(disk.Children[0]); guid == guidMap["efi"] {
+		t.Fatalf("Expected a regular partition type for %s without splitBoot", ESPMountPoint)
+	}
+
+	if guid := disk.partitionGUID(disk.Children[1]); guid != guidMap["efi"] {
+		t.Fatalf("Expected the ESP type for /boot without splitBoot, got %s", guid)
+	}
+
+	disk.splitBoot = true
+	if guid := disk.partitionGUID(disk.Children[0]); guid != guidMap["efi"] {
+		t.Fatalf("Expected the ESP type for %s, got %s", ESPMountPoint, guid)
+	}
+
+	if guid := disk.partitionGUID(disk.Children[1]); guid != xbootldrGUID {
+		t.Fatalf("Expected the XBOOTLDR type for /boot, got %s", guid)
+	}
+
+	// Without the ESP at /boot/efi the /boot partition is the ESP
+	standard := newDisk()
+	standard.splitBoot = true
+	standard.Children = standard.Children[1:]
+	if guid := standard.partitionGUID(standard.Children[0]); guid != guidMap["efi"] {
+		t.Fatalf("Expected the ESP type for a standard /boot, got %s", guid)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(disk *BlockDevice, opts *MediaOpts)
+	}{
+		{"ext4 ESP", func(disk *BlockDevice, opts *MediaOpts) { disk.Children[0].FsType = "ext4" }},
+		{"small ESP", func(disk *BlockDevice, opts *MediaOpts) { disk.Children[0].Size = 16 * 1024 * 1024 }},
+		{"encrypted ESP", func(disk *BlockDevice, opts *MediaOpts) { disk.Children[0].Type = BlockDeviceTypeCrypt }},
+		{"missing ESP", func(disk *BlockDevice, opts *MediaOpts) { disk.Children[0].MountPoint = "/srv" }},
+		{"missing XBOOTLDR", func(disk *BlockDevice, opts *MediaOpts) { disk.Children[1].MountPoint = "/srv" }},
+		{"legacy BIOS", func(disk *BlockDevice, opts *MediaOpts) { opts.LegacyBios = true }},
+		{"msdos", func(disk *BlockDevice, opts *MediaOpts) { opts.PartitionTable = PartitionTableMSDOS }},
+		{"reused XBOOTLDR", func(disk *BlockDevice, opts *MediaOpts) { disk.Children[1].ReuseESP = true }},
+	}
+
+	for _, curr := range tests {
+		disk := newDisk()
+		opts := mediaOpts
+		curr.modify(disk, &opts)
+
+		if results := validatePartitions(0, []*BlockDevice{disk}, opts, false); len(results) == 0 {
+			t.Fatalf("The %s split boot layout should be invalid", curr.name)
+		}
+	}
+
+	if results := validatePartitions(0, []*BlockDevice{newDisk()}, mediaOpts, false); len(results) != 0 {
+		t.Fatalf("The split boot partitions should be valid: %v", results)
+	}
+
+	// The XBOOTLDR is validated as the /boot partition
+	disk = newDisk()
+	disk.Children[1].FsType = "ext4"
+	if results := validatePartitions(0, []*BlockDevice{disk}, mediaOpts, false); len(results) == 0 {
+		t.Fatal("An ext4 /boot XBOOTLDR partition should be invalid")
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-split-boot-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	image := filepath.Join(rootDir, systemdBootImage)
+	if err = utils.MkdirAll(filepath.Dir(image), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(image, []byte("systemd-boot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = InstallSplitBootESP(rootDir); err != nil {
+		t.Fatalf("Failed to install the boot loader on the ESP: %v", err)
+	}
+
+	for _, path := range []string{"EFI/systemd/systemd-bootx64.efi", "EFI/BOOT/BOOTX64.EFI"} {
+		if _, err = os.Stat(filepath.Join(rootDir, ESPMountPoint, path)); err != nil {
+			t.Fatalf("The boot loader was not installed on the ESP: %v", err)
+		}
+	}
+}
+
+func TestPartitionNaming(t *testing.T) {
+	tests := []struct {
+		disk     *BlockDevice
+		name     string
+		devFile  string
+		aliasDev string
+		suffix   string
+	}{
+		{&BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}, "sda2", "/dev/sda2", "/dev/sda", ""},
+		{&BlockDevice{Name: "nvme0n1", Type: BlockDeviceTypeDisk}, "nvme0n1p2", "/dev/nvme0n1p2", "/dev/nvme0n1", "p"},
+		{&BlockDevice{Name: "nvme1n2", Type: BlockDeviceTypeDisk}, "nvme1n2p2", "/dev/nvme1n2p2", "/dev/nvme1n2", "p"},
+		{&BlockDevice{Name: "md0", Type: BlockDeviceTypeRAID1}, "md0p2", "/dev/md0p2", "/dev/md0", "p"},
+		{&BlockDevice{Name: "mpatha", Type: BlockDeviceTypeMultipath, Path: "/dev/mapper/mpatha"},
+			"mpatha-part2", "/dev/mapper/mpatha-part2", "/dev/mapper/mpatha", "-part"},
+		{&BlockDevice{Name: "3600508b400105e210000900000490000", Type: BlockDeviceTypeMultipath,
+			Path: "/dev/mapper/3600508b400105e210000900000490000"},
+			"3600508b400105e210000900000490000-part2", "/dev/mapper/3600508b400105e210000900000490000-part2",
+			"/dev/mapper/3600508b400105e210000900000490000", "-part"},
+	}
+
+	for _, curr := range tests {
+		if name := curr.disk.GetNewPartitionName(2); name != curr.name {
+			t.Fatalf("Expected the partition name %s for %s, got %s", curr.name, curr.disk.Name, name)
+		}
+
+		part := &BlockDevice{Type: BlockDeviceTypePart}
+		part.SetPartitionNumber(2)
+		curr.disk.AddChild(part)
+
+		if part.Name != curr.name || part.GetDeviceFile() != curr.devFile {
+			t.Fatalf("Expected the partition %s (%s) for %s, got %s (%s)", curr.name, curr.devFile,
+				curr.disk.Name, part.Name, part.GetDeviceFile())
+		}
+
+		part.SetPartitionNumber(0)
+		if number := part.GetPartitionNumber(); number != 2 {
+			t.Fatalf("Expected the partition number 2 for %s, got %d", part.Name, number)
+		}
+
+		if suffix := getAliasSuffix(curr.aliasDev); suffix != curr.suffix {
+			t.Fatalf("Expected the alias suffix %q for %s, got %q", curr.suffix, curr.aliasDev, suffix)
+		}
+	}
+
+	//nolint: lll // WONTFIX
+	lsblkOutput := `{
+   "blockdevices": [
+      {"name":"sdb", "kname":"sdb", "path":"/dev/sdb", "maj:min":"8:16", "fstype":"mpath_member", "mountpoint":null, "label":null, "pttype":"gpt", "ro":false, "rm":false, "size":107374182400, "state":"running", "rota":false, "type":"disk", "pkname":null,
+         "children": [
+            {"name":"mpatha", "kname":"dm-0", "path":"/dev/mapper/mpatha", "maj:min":"253:0", "fstype":null, "mountpoint":null, "label":null, "pttype":"gpt", "ro":false, "rm":false, "size":107374182400, "state":"running", "rota":false, "type":"mpath", "pkname":"sdb",
+               "children": [
+                  {"name":"mpatha-part1", "kname":"dm-1", "path":"/dev/mapper/mpatha-part1", "maj:min":"253:1", "fstype":"ext4", "mountpoint":null, "label":null, "pttype":"gpt", "ro":false, "rm":false, "size":107372085248, "state":"running", "rota":false, "type":"part", "pkname":"dm-0"}
+               ]
+            }
+         ]
+      }
+   ]
+}`
+
+	bds, err := parseBlockDevicesDescriptor([]byte(lsblkOutput))
+	if err != nil {
+		t.Fatalf("Could not parse the multipath block devices: %v", err)
+	}
+
+	mpath := bds[0].Children[0]
+	if mpath.Type != BlockDeviceTypeMultipath || mpath.Type.String() != "mpath" {
+		t.Fatalf("Expected %s to be a multipath device, got %q", mpath.Name, mpath.Type.String())
+	}
+
+	if part := mpath.Children[0]; part.GetPartitionNumber() != 1 || part.GetDeviceFile() != "/dev/mapper/mpatha-part1" {
+		t.Fatalf("Unexpected multipath partition %s (%s)", part.Name, part.GetDeviceFile())
+	}
+
+	// The aliases of the multipath devices expand to their partitions in /dev/mapper
+	alias := &BlockDevice{Name: "${disk}", Type: BlockDeviceTypeDisk}
+	alias.Children = []*BlockDevice{{Name: "${disk}1", Type: BlockDeviceTypePart}}
+	alias.ExpandName(map[string]string{"disk": "mapper/mpatha"})
+
+	if file := alias.Children[0].GetDeviceFile(); file != "/dev/mapper/mpatha-part1" {
+		t.Fatalf("Expected the alias partition /dev/mapper/mpatha-part1, got %s", file)
+	}
+}
+
+func TestReuseExistingPartitions(t *testing.T) {
+	newScanned := func() *BlockDevice {
+		bd := &BlockDevice{Name: "sdc", Type: BlockDeviceTypeDisk, Size: 2000398934016}
+		for i := 1; i <= 3; i++ {
+			bd.AddChild(&BlockDevice{Name: fmt.Sprintf("sdc%d", i), Type: BlockDeviceTypePart,
+				FsType: "ext4", UUID: fmt.Sprintf("0000000%d-aaaa-bbbb-cccc-dddddddddddd", i)})
+		}
+		return bd
+	}
+
+	var configured BlockDevice
+	config := `{name: sdc, type: disk, children: [
+	  {reuse: true, name: sdc3, type: part, fstype: xfs, mountpoint: /}]}`
+	if err := yaml.Unmarshal([]byte(config), &configured); err != nil {
+		t.Fatalf("Failed to parse the disk: %v", err)
+	}
+
+	if part := configured.Children[0]; part.MakePartition || !part.FormatPartition {
+		t.Fatalf("A reused partition should only be formatted: %+v", part)
+	}
+
+	scanned := newScanned()
+	if err := ReuseExistingPartitions(scanned, &configured); err != nil {
+		t.Fatalf("Failed to reuse the partitions: %v", err)
+	}
+
+	if err := AddFreeSpacePartitions(scanned, &configured); err != nil {
+		t.Fatalf("A disk with only reused partitions has no free space partitions to add: %v", err)
+	}
+
+	root := scanned.Children[2]
+	if root.MakePartition || !root.FormatPartition || root.FsType != "xfs" || root.MountPoint != "/" {
+		t.Fatalf("Unexpected reused root partition %+v", root)
+	}
+
+	// parted skips the reused partition, it is only formatted
+	dryRun := &DryRunType{&[]string{}, &[]string{}}
+	if err := scanned.WritePartitionTable(false, false, dryRun); err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+
+	formatted := false
+	for _, result := range *dryRun.TargetResults {
+		if strings.Contains(result, AddPartitionInfo) {
+			t.Fatalf("No partition should be created: %q", result)
+		}
+		if strings.HasPrefix(result, "sdc3: ") && strings.Contains(result, "xfs") {
+			formatted = true
+		}
+	}
+
+	if !formatted {
+		t.Fatalf("Expected sdc3 to be formatted, got %v", *dryRun.TargetResults)
+	}
+
+	op := bdOps[root.FsType]
+	command, err := op.makeFsCommand(root, op.makeFsArgs)
+	if err != nil {
+		t.Fatalf("Failed to build the mkfs command: %v", err)
+	}
+
+	if command = makeFsArgs(root, command); command[0] != "mkfs.xfs" || command[len(command)-1] != "/dev/sdc3" {
+		t.Fatalf("Unexpected mkfs command %v", command)
+	}
+
+	// The partition can also be referenced by its file system UUID
+	var byUUID BlockDevice
+	if err = yaml.Unmarshal([]byte(`{name: sdc, children: [
+	  {reuse: true, uuid: 00000002-AAAA-BBBB-CCCC-DDDDDDDDDDDD, fstype: ext4, mountpoint: /home}]}`),
+		&byUUID); err != nil {
+		t.Fatal(err)
+	}
+
+	scanned = newScanned()
+	if err = ReuseExistingPartitions(scanned, &byUUID); err != nil {
+		t.Fatalf("Failed to reuse the partition by UUID: %v", err)
+	}
+
+	if home := scanned.Children[1]; !home.ReusePartition || home.MountPoint != "/home" {
+		t.Fatalf("Unexpected reused /home partition %+v", home)
+	}
+
+	var missing BlockDevice
+	if err = yaml.Unmarshal([]byte("{name: sdc, children: [{reuse: true, name: sdc7, fstype: ext4, mountpoint: /}]}"),
+		&missing); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ReuseExistingPartitions(newScanned(), &missing); !errors.IsValidationError(err) {
+		t.Fatalf("Expected a validation error for a missing partition, got %v", err)
+	}
+
+	invalid := &BlockDevice{Name: "sdc3", ReusePartition: true, FreeSpace: true}
+	if results := validateReusePartition(invalid); len(results) != 2 {
+		t.Fatalf("Expected a free space and a file system type error, got %v", results)
+	}
+}
+
+func TestGrowRoot(t *testing.T) {
+	newDisk := func(rootFsType string) *BlockDevice {
+		return &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{
+			{Name: "sda1", Type: BlockDeviceTypePart, FsType: "vfat", MountPoint: "/boot"},
+			{Name: "sda2", Type: BlockDeviceTypePart, FsType: "swap"},
+			{Name: "sda3", Type: BlockDeviceTypePart, FsType: rootFsType, MountPoint: "/"},
+		}}
+	}
+
+	opts := MediaOpts{GrowRoot: true}
+
+	for _, fsType := range []string{"ext4", "xfs", "btrfs"} {
+		if err := ValidateGrowRoot([]*BlockDevice{newDisk(fsType)}, opts); err != nil {
+			t.Fatalf("Growing the %s root should be valid: %v", fsType, err)
+		}
+	}
+
+	if err := ValidateGrowRoot([]*BlockDevice{newDisk("f2fs")}, opts); err == nil {
+		t.Fatal("A f2fs root can not be grown online")
+	}
+
+	// The root must end the partition table
+	bd := newDisk("ext4")
+	bd.Children[2].Order = 1
+	if err := ValidateGrowRoot([]*BlockDevice{bd}, opts); err == nil {
+		t.Fatal("The root created before /boot can not be grown")
+	}
+
+	if err := ValidateGrowRoot([]*BlockDevice{newDisk("ext4")},
+		MediaOpts{GrowRoot: true, ExpandLVMRoot: true}); err == nil {
+		t.Fatal("growRoot can not be used with expandLvmRoot")
+	}
+
+	if err := ValidateGrowRoot([]*BlockDevice{newDisk("f2fs")}, MediaOpts{}); err != nil {
+		t.Fatalf("growRoot is opt-in: %v", err)
+	}
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-grow-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = InstallGrowRootUnit(rootDir, []*BlockDevice{newDisk("xfs")}); err == nil {
+		t.Fatal("The grow root unit should require growpart in the target")
+	}
+
+	if err = utils.MkdirAll(filepath.Join(rootDir, filepath.Dir(growPartPath)), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(rootDir, growPartPath), []byte{}, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = InstallGrowRootUnit(rootDir, []*BlockDevice{newDisk("xfs")}); err != nil {
+		t.Fatalf("Failed to write the grow root unit: %v", err)
+	}
+
+	unitDir := filepath.Join(rootDir, "etc", "systemd", "system")
+	content, err := ioutil.ReadFile(filepath.Join(unitDir, GrowRootUnit))
+	if err != nil {
+		t.Fatalf("Grow root unit not written: %v", err)
+	}
+
+	unit := string(content)
+	for _, line := range []string{
+		"Type=oneshot",
+		"ExecStart=/usr/bin/sh -c 'root=\"$$(findmnt -no SOURCE /)\"; " +
+			"growpart \"/dev/$$(lsblk -no PKNAME \"$$root\")\" " +
+			"\"$$(cat /sys/class/block/$${root##*/}/partition)\" || [ $$? -eq 1 ]'",
+		"ExecStart=/usr/bin/sh -c 'xfs_growfs /'",
+		"ExecStartPost=/usr/bin/systemctl disable " + GrowRootUnit,
+		"WantedBy=multi-user.target",
+	} {
+		if !strings.Contains(unit, line+"\n") {
+			t.Fatalf("Grow root unit is missing %q:\n%s", line, unit)
+		}
+	}
+
+	link, err := os.Readlink(filepath.Join(unitDir, "multi-user.target.wants", GrowRootUnit))
+	if err != nil || link != "/etc/systemd/system/"+GrowRootUnit {
+		t.Fatalf("Grow root unit not enabled: %q %v", link, err)
+	}
+
+	if commands := growRootCommands("ext4"); commands[1] != "resize2fs \"$(findmnt -no SOURCE /)\"" {
+		t.Fatalf("Unexpected ext4 resize command %q", commands[1])
+	}
+}
+
+func TestMountPointConflicts(t *testing.T) {
+	newDisk := func(name string, mountPoints ...string) *BlockDevice {
+		bd := &BlockDevice{Name: name, Type: BlockDeviceTypeDisk, Size: 64 << 30}
+		for i, mountPoint := range mountPoints {
+			bd.AddChild(&BlockDevice{Name: fmt.Sprintf("%s%d", name, i+1), Type: BlockDeviceTypePart,
+				FsType: "ext4", MountPoint: mountPoint, Size: 10 << 30})
+		}
+		return bd
+	}
+
+	conflicts := func(results []string, mountPoint string, first string, second string) bool {
+		expected := fmt.Sprintf("Mount point %s is used by both %s and %s", mountPoint, first, second)
+		for _, result := range results {
+			if result == expected {
+				return true
+			}
+		}
+		return false
+	}
+
+	// A duplicate /home, spelled differently, on the same disk
+	medias := []*BlockDevice{newDisk("sda", "/", "/home", "/home/")}
+	if results := validateMountPointConflicts(medias[0].Children, MediaOpts{}); len(results) != 1 ||
+		!conflicts(results, "/home", "sda2", "sda3") {
+		t.Fatalf("Expected a /home conflict, got %v", results)
+	}
+
+	if results := ServerValidatePartitions(medias, MediaOpts{}); !conflicts(results, "/home", "sda2", "sda3") {
+		t.Fatalf("Partition validation should report the /home conflict, got %v", results)
+	}
+
+	// A root on each disk
+	medias = []*BlockDevice{newDisk("sda", "/"), newDisk("sdb", "/")}
+	results := ServerValidatePartitions(medias, MediaOpts{})
+	if !conflicts(results, "/", "sda1", "sdb1") {
+		t.Fatalf("Partition validation should report the root conflict, got %v", results)
+	}
+
+	// Nested mount points do not conflict
+	medias = []*BlockDevice{newDisk("sda", "/", "/var", "/var/log")}
+	if results = validateMountPointConflicts(medias[0].Children, MediaOpts{}); len(results) != 0 {
+		t.Fatalf("Nested mount points should not conflict, got %v", results)
+	}
+
+	// The secondary /boot partitions are not mounted
+	medias = []*BlockDevice{newDisk("sda", "/boot", "/"), newDisk("sdb", "/boot")}
+	children := append(medias[0].FindAllChildren(), medias[1].FindAllChildren()...)
+	if results = validateMountPointConflicts(children, MediaOpts{PrimaryBoot: "sda1"}); len(results) != 0 {
+		t.Fatalf("A secondary /boot should not conflict, got %v", results)
+	}
+}
+
+func TestWaitForDeviceNodes(t *testing.T) {
+	defer func(exists func(string) (bool, error), interval time.Duration) {
+		deviceNodeExists = exists
+		deviceNodeRetryInterval = interval
+	}(deviceNodeExists, deviceNodeRetryInterval)
+
+	deviceNodeRetryInterval = 10 * time.Millisecond
+
+	bd := &BlockDevice{Name: "loop0", Type: BlockDeviceTypeLoop}
+	for i := 1; i <= 3; i++ {
+		bd.AddChild(&BlockDevice{Name: fmt.Sprintf("loop0p%d", i), Type: BlockDeviceTypePart})
+	}
+	medias := []*BlockDevice{bd}
+
+	expected := []string{"/dev/loop0p1", "/dev/loop0p2", "/dev/loop0p3"}
+	if files := partitionDeviceFiles(medias); !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Expected the partition devices %v, got %v", expected, files)
+	}
+
+	// loop0p3 shows up after a few checks
+	checks := 0
+	deviceNodeExists = func(file string) (bool, error) {
+		if file == "/dev/loop0p3" {
+			checks++
+			return checks > 3, nil
+		}
+		return true, nil
+	}
+
+	if err := WaitForDeviceNodes(medias, 1); err != nil {
+		t.Fatalf("The late partition device should be found: %v", err)
+	}
+
+	// loop0p3 never shows up
+	deviceNodeExists = func(file string) (bool, error) {
+		return file != "/dev/loop0p3", nil
+	}
+
+	err := WaitForDeviceNodes(medias, 1)
+	if err == nil || !strings.Contains(err.Error(), "Partition device /dev/loop0p3 never appeared") {
+		t.Fatalf("Expected the missing loop0p3 error, got %v", err)
+	}
+}
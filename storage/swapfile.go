@@ -5,12 +5,19 @@
 package storage
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/errors"
 	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
 )
 
 const (
@@ -18,61 +25,201 @@ const (
 	SwapfileName = "/var/swapfile"
 )
 
-// CreateSwapFile is responsible for generating a valid swapfile
-// on the installation target
-func CreateSwapFile(rootDir string, sizeString string) error {
-	size, err := ParseVolumeSize(sizeString)
+var (
+	// physicalMemory returns the amount of RAM in bytes, replaceable for testing
+	physicalMemory = getPhysicalMemory
+
+	// filefrag*Exp match the block size and the first extent of "filefrag -v" output
+	filefragBlockExp  = regexp.MustCompile(`blocks of ([0-9]+) bytes`)
+	filefragExtentExp = regexp.MustCompile(`(?m)^\s*0:\s*[0-9]+\.\.\s*[0-9]+:\s*([0-9]+)\.\.`)
+)
+
+// getPhysicalMemory returns the total amount of RAM of the running system
+func getPhysicalMemory() uint64 {
+	var info syscall.Sysinfo_t
+
+	if err := syscall.Sysinfo(&info); err != nil {
+		log.Warning("Could not determine the physical memory size: %v", err)
+		return 0
+	}
+
+	return uint64(info.Totalram) * uint64(info.Unit)
+}
+
+// SwapFilePartition returns the partition holding the swapfile, the /var
+// partition if any or the root partition otherwise
+func SwapFilePartition(medias []*BlockDevice) *BlockDevice {
+	var swapFileDevice *BlockDevice
+
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			// The swapfile lives in /var if it is a separate partition
+			if ch.MountPoint == "/var" || (ch.MountPoint == "/" && swapFileDevice == nil) {
+				swapFileDevice = ch
+			}
+		}
+	}
+
+	return swapFileDevice
+}
+
+// ResumeKernelArguments returns the kernel arguments required to resume from
+// hibernation, using the swap partition if any or the swapfile otherwise
+func ResumeKernelArguments(rootDir string, medias []*BlockDevice, swapFile bool) ([]string, error) {
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			if ch.FsType != "swap" {
+				continue
+			}
+
+			if ch.Type != BlockDeviceTypeCrypt {
+				return []string{"resume=" + ch.GetDeviceID()}, nil
+			}
+
+			// Only a LUKS swap keeps its key across boots
+			if ch.luksMapped {
+				return []string{"resume=" + ch.GetMappedDeviceFile()}, nil
+			}
+		}
+	}
+
+	swapFileDevice := SwapFilePartition(medias)
+	if !swapFile || swapFileDevice == nil {
+		return nil, errors.Errorf("Hibernation requires an unencrypted or persistent key swap partition or a swapfile")
+	}
+
+	device := swapFileDevice.GetDeviceID()
+	if swapFileDevice.Type == BlockDeviceTypeCrypt {
+		device = swapFileDevice.GetMappedDeviceFile()
+	}
+
+	w := bytes.NewBuffer(nil)
+	if err := cmd.Run(w, "filefrag", "-v", filepath.Join(rootDir, SwapfileName)); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	offset, err := parseResumeOffset(w.String(), uint64(os.Getpagesize()))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// size is in bytes, but we will only create swapfile in MB increments
-	swapFileSize := size / (1024 * 1024)
+	return []string{"resume=" + device, fmt.Sprintf("resume_offset=%d", offset)}, nil
+}
+
+// parseResumeOffset parses the "filefrag -v" output and returns the physical
+// offset, in pages, of the first extent of the file
+func parseResumeOffset(output string, pageSize uint64) (uint64, error) {
+	block := filefragBlockExp.FindStringSubmatch(output)
+	extent := filefragExtentExp.FindStringSubmatch(output)
+	if block == nil || extent == nil {
+		return 0, errors.Errorf("Could not parse the swapfile extents: %s", strings.TrimSpace(output))
+	}
 
-	swapFile := filepath.Join(rootDir, SwapfileName)
+	blockSize, err := strconv.ParseUint(block[1], 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	offset, err := strconv.ParseUint(extent[1], 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	return offset * blockSize / pageSize, nil
+}
+
+// SwapFilePath returns the path of the swapfile created on partition, the
+// default SwapfileName for both the root and a separate /var partition
+func SwapFilePath(partition *BlockDevice) string {
+	if partition.MountPoint == "/" || partition.MountPoint == "/var" {
+		return SwapfileName
+	}
 
-	if err := allocateSwapFile(swapFile, swapFileSize); err != nil {
+	return filepath.Join(partition.MountPoint, filepath.Base(SwapfileName))
+}
+
+// checkSwapFilePartition returns an error if no swapfile can be created on partition
+func checkSwapFilePartition(partition *BlockDevice) error {
+	if partition == nil || partition.MountPoint == "" {
+		return errors.Errorf("The swapfile requires a mounted partition")
+	}
+
+	// btrfs swapfiles must be NOCOW, not compressed nor snapshotted
+	if partition.FsType == "btrfs" {
+		return errors.Errorf("Swapfiles are not supported on the btrfs partition %s, use a swap partition instead",
+			partition.Name)
+	}
+
+	return nil
+}
+
+// CreateSwapFile allocates a swapfile of size bytes on partition and formats
+// it, AddSwapFileFstab adds it to the target fstab once generated
+func CreateSwapFile(rootDir string, partition *BlockDevice, size uint64) error {
+	if err := checkSwapFilePartition(partition); err != nil {
 		return err
 	}
-	args := []string{
-		"mkswap",
-		swapFile,
+
+	// The swapfile is only created in MiB increments
+	size = size / (1024 * 1024) * (1024 * 1024)
+	if size == 0 {
+		return errors.Errorf("The swapfile size must be at least 1MiB")
+	}
+
+	swapFile := SwapFilePath(partition)
+	target := filepath.Join(rootDir, swapFile)
+
+	if err := allocateSwapFile(target, size); err != nil {
+		return err
 	}
 
-	if err := cmd.RunAndLog(args...); err != nil {
+	if err := cmd.RunAndLog("mkswap", target); err != nil {
 		return errors.Wrap(err)
 	}
 
 	return nil
 }
 
-func allocateSwapFile(swapFile string, blockCount uint64) error {
-	// The block size is always in MB
-	block := make([]byte, 1024*1024)
-
+func allocateSwapFile(swapFile string, size uint64) error {
 	// The permissions on the swap file should always be 0600
 	f, err := os.OpenFile(swapFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return err
+		return errors.Wrap(err)
 	}
+	_ = f.Close()
 
-	defer func() {
-		_ = f.Close()
-	}()
+	if err = os.Chmod(swapFile, 0600); err != nil {
+		return errors.Wrap(err)
+	}
 
-	// Write bytes to file
-	bytesWritten := 0
+	if err = cmd.RunAndLog("fallocate", "-l", strconv.FormatUint(size, 10), swapFile); err != nil {
+		return errors.Wrap(err)
+	}
 
-	var i uint64
-	for i = 0; i < blockCount; i++ {
-		byteCount, err := f.Write(block)
-		if err != nil {
-			return err
-		}
-		bytesWritten += byteCount
+	log.Debug("allocateSwapFile: Allocated %d bytes.", size)
+
+	return nil
+}
+
+// AddSwapFileFstab appends the entry of the swapfile created on partition to
+// the target fstab, GenerateTabFiles overwrites it so it must be called after
+func AddSwapFileFstab(rootDir string, partition *BlockDevice) error {
+	swapFile := SwapFilePath(partition)
+
+	etcDir := filepath.Join(rootDir, "etc")
+	if err := utils.MkdirAll(etcDir, 0755); err != nil {
+		return errors.Wrap(err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(etcDir, "fstab"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err)
 	}
+	defer func() { _ = f.Close() }()
 
-	log.Debug("allocateSwapFile: Wrote %d bytes.", bytesWritten)
+	if _, err = fmt.Fprintf(f, "%s none swap defaults 0 0\n", swapFile); err != nil {
+		return errors.Wrap(err)
+	}
 
 	return nil
 }
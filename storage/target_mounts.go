@@ -0,0 +1,238 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+var (
+	// procMounts is the mount table of the running system, replaceable for testing
+	procMounts = "/proc/mounts"
+
+	// mountEscapeExp matches the octal escapes of /proc/mounts, i.e. \040 for a space
+	mountEscapeExp = regexp.MustCompile(`\\[0-7]{3}`)
+
+	// sysClassBlockDir lists the block devices and their partitions, replaceable for testing
+	sysClassBlockDir = "/sys/class/block"
+
+	// removeMapping closes a device-mapper volume, replaceable for testing
+	removeMapping = func(name string) error {
+		return cmd.RunAndLog("dmsetup", "remove", name)
+	}
+)
+
+// unescapeMountField decodes the octal escapes of a /proc/mounts field
+func unescapeMountField(field string) string {
+	return mountEscapeExp.ReplaceAllStringFunc(field, func(esc string) string {
+		value, err := strconv.ParseUint(esc[1:], 8, 8)
+		if err != nil {
+			return esc
+		}
+		return string([]byte{byte(value)})
+	})
+}
+
+// deviceHolders adds to devices the device files of the device-mapper
+// devices, i.e. LUKS or LVM volumes, stacked on top of the kernel device name
+func deviceHolders(name string, devices map[string]bool) {
+	holders, err := ioutil.ReadDir(filepath.Join(sysClassBlockDir, name, "holders"))
+	if err != nil {
+		return
+	}
+
+	for _, curr := range holders {
+		if devices["/dev/"+curr.Name()] {
+			continue
+		}
+		devices["/dev/"+curr.Name()] = true
+
+		if content, err := ioutil.ReadFile(filepath.Join(sysClassBlockDir, curr.Name(), "dm", "name")); err == nil {
+			devices[filepath.Join(mapperDevDir, strings.TrimSpace(string(content)))] = true
+		}
+
+		deviceHolders(curr.Name(), devices)
+	}
+}
+
+// mappingHolders appends to names the device-mapper names of the volumes
+// stacked on top of the kernel device name, the upper volumes first so they
+// can be closed in order
+func mappingHolders(name string, seen map[string]bool, names *[]string) {
+	holders, err := ioutil.ReadDir(filepath.Join(sysClassBlockDir, name, "holders"))
+	if err != nil {
+		return
+	}
+
+	for _, curr := range holders {
+		if seen[curr.Name()] {
+			continue
+		}
+		seen[curr.Name()] = true
+
+		mappingHolders(curr.Name(), seen, names)
+
+		if content, err := ioutil.ReadFile(filepath.Join(sysClassBlockDir, curr.Name(), "dm", "name")); err == nil {
+			*names = append(*names, strings.TrimSpace(string(content)))
+		}
+	}
+}
+
+// devicePartitions returns the kernel names of the partitions of the device
+// name, i.e. not sdap1 for sda
+func devicePartitions(name string) (*regexp.Regexp, []string, error) {
+	base := (&BlockDevice{Name: name}).getBasePartitionName()
+	partExp, err := regexp.Compile("^" + regexp.QuoteMeta(base) + `[0-9]+$`)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	parts := []string{}
+	if entries, err := ioutil.ReadDir(sysClassBlockDir); err == nil {
+		for _, curr := range entries {
+			if partExp.MatchString(curr.Name()) {
+				parts = append(parts, curr.Name())
+			}
+		}
+	}
+
+	return partExp, parts, nil
+}
+
+// DeviceMappings returns the names of the open device-mapper volumes, i.e.
+// LUKS or LVM volumes, stacked on the device name or its partitions, the
+// upper volumes first
+func DeviceMappings(name string) ([]string, error) {
+	_, parts, err := devicePartitions(name)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	seen := map[string]bool{}
+
+	for _, curr := range append([]string{name}, parts...) {
+		mappingHolders(curr, seen, &names)
+	}
+
+	return names, nil
+}
+
+// DeviceMounts returns the mount points of the device name, of its
+// partitions and of the device-mapper volumes stacked on them, as listed
+// by /proc/mounts
+func DeviceMounts(name string) ([]string, error) {
+	partExp, parts, err := devicePartitions(name)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := map[string]bool{"/dev/" + name: true}
+	deviceHolders(name, devices)
+
+	for _, curr := range parts {
+		devices["/dev/"+curr] = true
+		deviceHolders(curr, devices)
+	}
+
+	file, err := os.Open(procMounts)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	mounts := []string{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		device := filepath.Clean(unescapeMountField(fields[0]))
+		if devices[device] || (strings.HasPrefix(device, "/dev/") && partExp.MatchString(device[len("/dev/"):])) {
+			mounts = append(mounts, unescapeMountField(fields[1]))
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return mounts, nil
+}
+
+// ReleaseTargetMounts makes sure no partition of the install targets, nor LUKS
+// or LVM volume on them, is still mounted or open, i.e. by a previous failed
+// install, as parted can not modify a busy device; the mounts are only
+// unmounted and the volumes closed when forceUnmount is set
+func ReleaseTargetMounts(targets map[string]InstallTarget, forceUnmount bool) error {
+	names := []string{}
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		mounts, err := DeviceMounts(name)
+		if err != nil {
+			return err
+		}
+
+		mappings, err := DeviceMappings(name)
+		if err != nil {
+			return err
+		}
+
+		if len(mounts) == 0 && len(mappings) == 0 {
+			continue
+		}
+
+		if !forceUnmount {
+			busy := []string{}
+			if len(mounts) > 0 {
+				busy = append(busy, "partitions mounted at "+strings.Join(mounts, ", "))
+			}
+			if len(mappings) > 0 {
+				busy = append(busy, "volumes open as "+strings.Join(mappings, ", "))
+			}
+
+			return errors.Errorf("Target %s is busy, %s; release them or use --force-unmount",
+				name, strings.Join(busy, " and "))
+		}
+
+		// Nested mount points are unmounted first
+		sort.Sort(sort.Reverse(sort.StringSlice(mounts)))
+
+		for _, point := range mounts {
+			log.Info("Unmounting the stale mount point %s of target %s", point, name)
+			if err = umountRetry(point); err != nil {
+				logMountHolders(point)
+				return errors.Errorf("Could not unmount %s of target %s: %v", point, name, err)
+			}
+		}
+
+		for _, mapping := range mappings {
+			log.Info("Closing the stale volume %s of target %s", mapping, name)
+			if err = removeMapping(mapping); err != nil {
+				return errors.Errorf("Could not close the volume %s of target %s: %v", mapping, name, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+const (
+	// TrimTimer enables the periodic TRIM of the fstrim.timer
+	TrimTimer = "timer"
+
+	// TrimDiscard adds the discard option to the fstab entries, the
+	// blocks are trimmed as soon as they are freed
+	TrimDiscard = "discard"
+
+	// TrimBoth enables both TrimTimer and TrimDiscard
+	TrimBoth = "both"
+
+	// TrimTimerUnit is the unit periodically trimming the mounted file systems
+	TrimTimerUnit = "fstrim.timer"
+)
+
+// discardFileSystems are the file systems supporting the discard mount option
+var discardFileSystems = map[string]bool{
+	"btrfs": true,
+	"ext2":  true,
+	"ext3":  true,
+	"ext4":  true,
+	"f2fs":  true,
+	"vfat":  true,
+	"xfs":   true,
+}
+
+// ValidateTrim checks mode is a known trim mode, an empty mode disables trim
+func ValidateTrim(mode string) error {
+	switch mode {
+	case "", TrimTimer, TrimDiscard, TrimBoth:
+		return nil
+	}
+
+	return errors.ValidationErrorf("Invalid trim value %q, expected %q, %q or %q",
+		mode, TrimTimer, TrimDiscard, TrimBoth)
+}
+
+// TrimTimerEnabled returns true if the fstrim.timer is to be enabled on the
+// target: the trim timer is requested and a target media is non rotational
+func TrimTimerEnabled(medias []*BlockDevice, mediaOpts MediaOpts) bool {
+	if mediaOpts.Trim != TrimTimer && mediaOpts.Trim != TrimBoth {
+		return false
+	}
+
+	found := false
+
+	for _, curr := range medias {
+		if curr.Rotational {
+			log.Info("Skipping the periodic TRIM of the rotational disk %s", curr.Name)
+			continue
+		}
+
+		found = true
+	}
+
+	return found
+}
+
+// discardMountOptions returns the fstab mount options of bd with discard added
+// if requested, the device is non rotational and its file system supports it
+func discardMountOptions(bd *BlockDevice, options string, rotational bool, mediaOpts MediaOpts) string {
+	if mediaOpts.Trim != TrimDiscard && mediaOpts.Trim != TrimBoth {
+		return options
+	}
+
+	if !discardFileSystems[bd.FsType] {
+		return options
+	}
+
+	if rotational {
+		log.Info("Skipping the discard option of %s, on a rotational disk", bd.Name)
+		return options
+	}
+
+	for _, opt := range strings.Split(options, ",") {
+		if opt == "discard" || opt == "nodiscard" {
+			return options
+		}
+	}
+
+	return options + ",discard"
+}
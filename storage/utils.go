@@ -5,32 +5,33 @@
 package storage
 
 import (
+	"bytes"
 	"fmt"
 	"math"
-	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/errors"
 	"github.com/clearlinux/clr-installer/log"
 )
 
 var storageExp = regexp.MustCompile(`^([0-9]*(\.)?[0-9]*)([bkmgtp]{1}(b|ib){0,1}){0,1}$`)
 
-func mountFs(device string, mPointPath string, fsType string, flags uintptr) error {
+func mountFs(device string, mPointPath string, fsType string, flags uintptr, data string) error {
 	var err error
 
-	if _, err = os.Stat(mPointPath); os.IsNotExist(err) {
-		if err = os.MkdirAll(mPointPath, 0777); err != nil {
-			return errors.Errorf("mkdir %s: %v", mPointPath, err)
-		}
+	if err = makeMountPoint(mPointPath, DefaultMountPointMode); err != nil {
+		return err
 	}
 
-	if err = syscall.Mount(device, mPointPath, fsType, flags, ""); err != nil {
+	if err = syscall.Mount(device, mPointPath, fsType, flags, data); err != nil {
 		return errors.Errorf("mount %s %s %s: %v", device, mPointPath, fsType, err)
 	}
 	log.Debug("Mounted ok: %s", mPointPath)
@@ -43,19 +44,27 @@ func mountFs(device string, mPointPath string, fsType string, flags uintptr) err
 func mountDevFs(rootDir string) error {
 	mPointPath := filepath.Join(rootDir, "dev")
 
-	return mountFs("/dev", mPointPath, "devtmpfs", syscall.MS_BIND)
+	return mountFs("/dev", mPointPath, "devtmpfs", syscall.MS_BIND, "")
 }
 
 func mountSysFs(rootDir string) error {
 	mPointPath := filepath.Join(rootDir, "sys")
 
-	return mountFs("/sys", mPointPath, "sysfs", syscall.MS_BIND)
+	return mountFs("/sys", mPointPath, "sysfs", syscall.MS_BIND, "")
 }
 
 func mountProcFs(rootDir string) error {
 	mPointPath := filepath.Join(rootDir, "proc")
 
-	return mountFs("/proc", mPointPath, "proc", syscall.MS_BIND)
+	return mountFs("/proc", mPointPath, "proc", syscall.MS_BIND, "")
+}
+
+// MountEfivarFs mounts the efivars in the target installation directory, the
+// sysfs bind mount does not include them
+func MountEfivarFs(rootDir string) error {
+	mPointPath := filepath.Join(rootDir, "sys", "firmware", "efi", "efivars")
+
+	return mountFs("efivarfs", mPointPath, "efivarfs", 0, "")
 }
 
 // MountMetaFs mounts proc, sysfs and devfs in the target installation directory
@@ -78,19 +87,99 @@ func MountMetaFs(rootDir string) error {
 	return nil
 }
 
-// UmountAll unmounts all previously mounted devices
+var (
+	// unmount is replaced by the tests to simulate a busy mount point
+	unmount = syscall.Unmount
+
+	// umountRetries is the number of attempts to unmount a busy mount point
+	umountRetries = 5
+
+	// umountRetryDelay is the time to wait between the unmount attempts
+	umountRetryDelay = 500 * time.Millisecond
+)
+
+// UmountError lists the mount points and encrypted mappings which
+// UmountAll failed to release
+type UmountError struct {
+	Mounts    []string
+	Encrypted []string
+}
+
+func (e *UmountError) Error() string {
+	fails := []string{}
+
+	if len(e.Mounts) > 0 {
+		fails = append(fails, fmt.Sprintf("mount points: %s", strings.Join(e.Mounts, ", ")))
+	}
+
+	if len(e.Encrypted) > 0 {
+		fails = append(fails, fmt.Sprintf("encrypted devices: %s", strings.Join(e.Encrypted, ", ")))
+	}
+
+	return fmt.Sprintf("Failed to unmount %s", strings.Join(fails, "; "))
+}
+
+// umountRetry unmounts point, retrying while the mount point is busy; it is
+// never detached so a still busy mount point is reported to the caller
+func umountRetry(point string) error {
+	var err error
+
+	for attempt := 1; attempt <= umountRetries; attempt++ {
+		if err = unmount(point, 0); err == nil {
+			return nil
+		}
+
+		if err != syscall.EBUSY {
+			return err
+		}
+
+		if attempt < umountRetries {
+			log.Debug("umount %s: %v, retrying (%d/%d)", point, err, attempt, umountRetries)
+			time.Sleep(umountRetryDelay)
+		}
+	}
+
+	return err
+}
+
+// logMountHolders logs the processes still using point, with fuser or
+// lsof when one of them is available
+func logMountHolders(point string) {
+	var args []string
+
+	if _, err := exec.LookPath("fuser"); err == nil {
+		args = []string{"fuser", "-vm", point}
+	} else if _, err := exec.LookPath("lsof"); err == nil {
+		args = []string{"lsof", "+f", "--", point}
+	} else {
+		log.Debug("Neither fuser nor lsof found, can not list the processes using %s", point)
+		return
+	}
+
+	w := bytes.NewBuffer(nil)
+	if err := cmd.Run(w, args...); err != nil && w.Len() == 0 {
+		log.Debug("Could not list the processes using %s: %v", point, err)
+		return
+	}
+
+	log.Warning("Processes using %s:\n%s", point, w.String())
+}
+
+// UmountAll unmounts all previously mounted devices, busy mount points are
+// retried a few times before giving up. Everything which could not be released
+// is kept for a later call and reported by the returned *UmountError
 func UmountAll() error {
-	var mountError error
-	fails := make([]string, 0)
+	umountErr := &UmountError{}
 
 	// Ensure the top level mount point is unmounted last
 	sort.Sort(sort.Reverse(sort.StringSlice(mountedPoints)))
 
 	for _, point := range mountedPoints {
-		if err := syscall.Unmount(point, syscall.MNT_FORCE|syscall.MNT_DETACH); err != nil {
+		if err := umountRetry(point); err != nil {
 			err = fmt.Errorf("umount %s: %v", point, err)
 			log.ErrorError(err)
-			fails = append(fails, point)
+			logMountHolders(point)
+			umountErr.Mounts = append(umountErr.Mounts, point)
 		} else {
 			log.Debug("Unmounted ok: %s", point)
 		}
@@ -100,17 +189,20 @@ func UmountAll() error {
 		if err := unMapEncrypted(point); err != nil {
 			err = fmt.Errorf("unmap encrypted %s: %v", point, err)
 			log.ErrorError(err)
-			fails = append(fails, "e-"+point)
+			umountErr.Encrypted = append(umountErr.Encrypted, point)
 		} else {
 			log.Debug("Encrypted partition %q unmapped", point)
 		}
 	}
 
-	if len(fails) > 0 {
-		mountError = errors.Errorf("Failed to unmount: %v", fails)
+	mountedPoints = umountErr.Mounts
+	mountedEncrypts = umountErr.Encrypted
+
+	if len(umountErr.Mounts) > 0 || len(umountErr.Encrypted) > 0 {
+		return umountErr
 	}
 
-	return mountError
+	return nil
 }
 
 type convertLookup struct {
@@ -242,6 +334,10 @@ func MaxLabelLength(fstype string) int {
 		maxLen = 12
 	case "f2fs":
 		maxLen = 512
+	case "nilfs2":
+		maxLen = 80
+	case "jfs":
+		maxLen = 16
 	case "btrfs":
 		maxLen = 255
 	case "vfat":
@@ -256,8 +352,9 @@ func MaxLabelLength(fstype string) int {
 
 // ParseVolumeSize will parse a string formatted (1M, 10GiB, 2TB) size
 // and return its representation in bytes
-// Units without suffix 'B' or 'iB' are assumed to be powers of 10
-// to ensure consistency with existing YAML files.
+// Units with the suffix 'iB' are powers of 2 and units with the suffix 'B'
+// are powers of 10. Units without a suffix are assumed to be powers of 2
+// to ensure consistency with existing YAML files and lsblk output.
 func ParseVolumeSize(str string) (uint64, error) {
 	var size uint64
 
@@ -276,16 +373,20 @@ func ParseVolumeSize(str string) (uint64, error) {
 	switch unit {
 	case "b":
 		fsize = fsize * (1 << 0)
-	case "k", "kb":
-		fsize = fsize * (1 << 10)
-	case "m", "mb":
-		fsize = fsize * (1 << 20)
-	case "g", "gb":
-		fsize = fsize * (1 << 30)
-	case "t", "tb":
-		fsize = fsize * (1 << 40)
-	case "p", "pb":
-		fsize = fsize * (1 << 50)
+	case "k", "m", "g", "t", "p":
+		log.Debug("ParseVolumeSize: Interpreting ambiguous unit of %q as binary (%siB)",
+			str, strings.ToUpper(unit))
+		fsize = fsize * math.Exp2(10*float64(strings.Index("kmgtp", unit)+1))
+	case "kb":
+		fsize = fsize * math.Pow10(3)
+	case "mb":
+		fsize = fsize * math.Pow10(6)
+	case "gb":
+		fsize = fsize * math.Pow10(9)
+	case "tb":
+		fsize = fsize * math.Pow10(12)
+	case "pb":
+		fsize = fsize * math.Pow10(15)
 	case "kib":
 		fsize = fsize * math.Exp2(10)
 	case "mib":
@@ -0,0 +1,161 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+var (
+	// verifySizeTolerance is the size difference allowed between a
+	// configured partition and the installed one, parted aligns the
+	// partition boundaries
+	verifySizeTolerance = uint64(4 * 1024 * 1024)
+
+	// listPartitions reads the partitions of a disk, replaceable for testing
+	listPartitions = (*BlockDevice).getPartitionList
+)
+
+// verifyMismatch returns the diff style lines of a mismatching field
+func verifyMismatch(name string, field string, expected string, found string) []string {
+	return []string{
+		fmt.Sprintf("- %s %s: %s", name, field, expected),
+		fmt.Sprintf("+ %s %s: %s", name, field, found),
+	}
+}
+
+// VerifyLayout compares the partitions of the configured medias with the
+// devices installed on the system, without modifying them; every mismatch
+// of the partitions, file system types, labels and sizes is returned as a
+// pair of diff style lines, "-" for the configuration and "+" for the disk
+func VerifyLayout(medias []*BlockDevice, devices []*BlockDevice) []string {
+	results := []string{}
+
+	for _, media := range medias {
+		disk := FindBlockDevice(devices, media.Name)
+		if disk == nil {
+			results = append(results, verifyMismatch(media.Name, "disk", "present", "missing")...)
+			continue
+		}
+
+		parts := map[uint64]*PartedPartition{}
+		for _, part := range listPartitions(disk) {
+			parts[part.Number] = part
+		}
+
+		expected := map[string]bool{}
+
+		for _, ch := range media.Children {
+			expected[ch.Name] = true
+			results = append(results, verifyPartition(ch, FindBlockDevice(disk.Children, ch.Name),
+				parts[ch.GetPartitionNumber()])...)
+		}
+
+		for _, ch := range disk.Children {
+			if !expected[ch.Name] {
+				results = append(results, verifyMismatch(ch.Name, "partition", "not configured", "present")...)
+			}
+		}
+	}
+
+	return results
+}
+
+// verifyPartition compares the configured partition bd with the installed
+// partition found and its partition table entry part
+func verifyPartition(bd *BlockDevice, found *BlockDevice, part *PartedPartition) []string {
+	if found == nil {
+		return verifyMismatch(bd.Name, "partition", "present", "missing")
+	}
+
+	results := []string{}
+
+	if bd.Size != 0 && part != nil {
+		diff := bd.Size - part.Size
+		if part.Size > bd.Size {
+			diff = part.Size - bd.Size
+		}
+
+		if diff > verifySizeTolerance {
+			results = append(results, verifyMismatch(bd.Name, "size",
+				fmt.Sprintf("%d", bd.Size), fmt.Sprintf("%d", part.Size))...)
+		}
+	}
+
+	if bd.PartitionLabel != "" && part != nil && part.Name != bd.PartitionLabel {
+		results = append(results, verifyMismatch(bd.Name, "partitionLabel", bd.PartitionLabel, part.Name)...)
+	}
+
+	// Empty partitions are neither formatted nor labeled
+	if bd.CreateOnly {
+		return results
+	}
+
+	// The file system of an encrypted partition is found on its mapped device
+	fs := found
+	if bd.Type == BlockDeviceTypeCrypt {
+		// A closed LUKS volume can not be checked without the passphrase
+		if found.FsType == "crypto_LUKS" && len(found.Children) == 0 {
+			log.Warning("VerifyLayout: %s is not opened, skipping its file system", found.Name)
+			return results
+		}
+
+		if len(found.Children) != 1 || found.Children[0].Type != BlockDeviceTypeCrypt {
+			return append(results, verifyMismatch(bd.Name, "type", "crypt", found.Type.String())...)
+		}
+		fs = found.Children[0]
+	}
+
+	if bd.FsType != fs.FsType {
+		results = append(results, verifyMismatch(bd.Name, "fstype", bd.FsType, fs.FsType)...)
+	}
+
+	if label, _ := bd.getFsLabel(); label != "" && label != fs.Label {
+		results = append(results, verifyMismatch(bd.Name, "label", label, fs.Label)...)
+	}
+
+	return results
+}
+
+// ReadInstalledVersion mounts the configured / (root) partition read-only
+// in mountDir and returns the installed OS version; the mount is released
+// with UmountAll before returning
+func ReadInstalledVersion(medias []*BlockDevice, mountDir string) (string, error) {
+	var root *BlockDevice
+
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			if ch.MountPoint == "/" {
+				root = ch
+			}
+		}
+	}
+
+	if root == nil {
+		return "", errors.Errorf("No / (root) partition configured")
+	}
+
+	if root.Type != BlockDeviceTypePart {
+		return "", errors.Errorf("Reading the version of a %s root partition is not supported", root.Type)
+	}
+
+	if err := mountFs(root.GetDeviceFile(), mountDir, root.FsType, syscall.MS_RDONLY, ""); err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if err := UmountAll(); err != nil {
+			log.Warning("Failed to unmount %s: %v", mountDir, err)
+		}
+	}()
+
+	return utils.ParseOSVersion(filepath.Join(mountDir, "usr", "lib", "os-release"))
+}
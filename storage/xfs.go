@@ -0,0 +1,182 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+var (
+	// xfsQuotaTypes are the quota mount options supported by XfsQuota
+	xfsQuotaTypes = []string{"uquota", "gquota", "pquota"}
+)
+
+// xfsFeature returns whether the xfs feature value enables it, and whether
+// it was set at all; unset features keep the mkfs.xfs default
+func xfsFeature(value string) (bool, bool, error) {
+	if value == "" {
+		return false, false, nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, false, err
+	}
+
+	return enabled, true, nil
+}
+
+// xfsQuotas returns the quota mount options of bd
+func (bd *BlockDevice) xfsQuotas() []string {
+	quotas := []string{}
+
+	for _, curr := range strings.Split(bd.XfsQuota, ",") {
+		if curr = strings.TrimSpace(curr); curr != "" {
+			quotas = append(quotas, curr)
+		}
+	}
+
+	return quotas
+}
+
+// hasXfsOptions returns true if any of the xfs specific options is set
+func (bd *BlockDevice) hasXfsOptions() bool {
+	return bd.XfsReflink != "" || bd.XfsCrc != "" || bd.XfsQuota != ""
+}
+
+// xfsMakeFsCommand adds the metadata features of the xfs options to the
+// common mkfs command; a v4 (crc=0) file system does not support reflink,
+// so it is disabled along with crc unless set
+func xfsMakeFsCommand(bd *BlockDevice, args []string) ([]string, error) {
+	cmd, err := commonMakeFsCommand(bd, args)
+	if err != nil {
+		return nil, err
+	}
+
+	crc, crcSet, err := xfsFeature(bd.XfsCrc)
+	if err != nil {
+		return nil, err
+	}
+
+	reflink, reflinkSet, err := xfsFeature(bd.XfsReflink)
+	if err != nil {
+		return nil, err
+	}
+
+	if crcSet && !crc && !reflinkSet {
+		reflinkSet = true
+	}
+
+	features := []string{}
+
+	if crcSet {
+		features = append(features, fmt.Sprintf("crc=%d", boolToInt(crc)))
+	}
+
+	if reflinkSet {
+		features = append(features, fmt.Sprintf("reflink=%d", boolToInt(reflink)))
+	}
+
+	if len(features) > 0 {
+		cmd = append(cmd, "-m", strings.Join(features, ","))
+	}
+
+	return cmd, nil
+}
+
+// boolToInt returns 1 for true and 0 for false
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+// xfsMountOptions adds the quota mount options of bd to options
+func xfsMountOptions(bd *BlockDevice, options string) string {
+	for _, quota := range bd.xfsQuotas() {
+		if !utils.StringSliceContains(strings.Split(options, ","), quota) {
+			options = options + "," + quota
+		}
+	}
+
+	return options
+}
+
+// validateXfs checks the xfs options are only set on xfs file systems, the
+// quota types are known and the features are compatible; reflink and mixing
+// group and project quotas require the v5 (crc=1) format
+func validateXfs(medias []*BlockDevice) []string {
+	results := []string{}
+
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			if !ch.hasXfsOptions() {
+				continue
+			}
+
+			if ch.FsType != "xfs" {
+				results = append(results,
+					utils.Locale.Get("xfs options are only supported by xfs file systems, %s is %s",
+						ch.Name, ch.FsType))
+				continue
+			}
+
+			crc, crcSet, err := xfsFeature(ch.XfsCrc)
+			if err != nil {
+				results = append(results,
+					utils.Locale.Get("Invalid xfsCrc value %s for %s, must be true or false", ch.XfsCrc, ch.Name))
+				continue
+			}
+
+			reflink, _, err := xfsFeature(ch.XfsReflink)
+			if err != nil {
+				results = append(results,
+					utils.Locale.Get("Invalid xfsReflink value %s for %s, must be true or false", ch.XfsReflink, ch.Name))
+				continue
+			}
+
+			v4 := crcSet && !crc
+
+			if v4 && reflink {
+				results = append(results,
+					utils.Locale.Get("xfs reflink requires crc on %s", ch.Name))
+			}
+
+			quotas := ch.xfsQuotas()
+			seen := map[string]bool{}
+
+			for _, quota := range quotas {
+				if !utils.StringSliceContains(xfsQuotaTypes, quota) {
+					results = append(results,
+						utils.Locale.Get("Invalid xfs quota %s for %s, must be one of: %s",
+							quota, ch.Name, strings.Join(xfsQuotaTypes, ", ")))
+				}
+				seen[quota] = true
+			}
+
+			if v4 && seen["gquota"] && seen["pquota"] {
+				results = append(results,
+					utils.Locale.Get("xfs group and project quotas can not be combined without crc on %s", ch.Name))
+			}
+
+			// The root quotas can only be enabled with the rootflags kernel argument
+			if len(quotas) > 0 && ch.MountPoint == "/" {
+				results = append(results,
+					utils.Locale.Get("xfs quotas are not supported on the / (root) partition"))
+			} else if len(quotas) > 0 && ch.MountPoint == "" {
+				results = append(results,
+					utils.Locale.Get("xfs quotas of %s require a mount point", ch.Name))
+			}
+		}
+	}
+
+	return results
+}
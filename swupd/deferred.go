@@ -0,0 +1,72 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// DeferredBundlesUnit is the first boot unit installing the deferred bundles
+	DeferredBundlesUnit = "clr-installer-deferred-bundles.service"
+
+	// deferredBundlesWantedBy is the target pulling DeferredBundlesUnit at boot
+	deferredBundlesWantedBy = "multi-user.target"
+)
+
+// deferredBundlesUnit returns the systemd unit adding the bundles once the
+// network is up; the unit disables itself only when swupd succeeded so a
+// failed attempt is retried on the next boot
+func deferredBundlesUnit(bundles []string) string {
+	unit := []string{
+		"[Unit]",
+		"Description=Install the bundles deferred by the installer",
+		"Wants=network-online.target",
+		"After=network-online.target",
+		"",
+		"[Service]",
+		"Type=oneshot",
+		"ExecStart=/usr/bin/swupd bundle-add " + strings.Join(bundles, " "),
+		"ExecStartPost=/usr/bin/systemctl disable " + DeferredBundlesUnit,
+		"",
+		"[Install]",
+		"WantedBy=" + deferredBundlesWantedBy,
+		"",
+	}
+
+	return strings.Join(unit, "\n")
+}
+
+// InstallDeferredBundlesUnit writes and enables, in the target rootDir, the
+// first boot unit installing the deferred bundles
+func InstallDeferredBundlesUnit(rootDir string, bundles []string) error {
+	unitDir := filepath.Join(rootDir, "etc", "systemd", "system")
+	wantsDir := filepath.Join(unitDir, deferredBundlesWantedBy+".wants")
+	unitFile := filepath.Join(unitDir, DeferredBundlesUnit)
+
+	if err := utils.MkdirAll(wantsDir, 0755); err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Info("Deferring the install of bundles to first boot: %s", strings.Join(bundles, ", "))
+
+	if err := ioutil.WriteFile(unitFile, []byte(deferredBundlesUnit(bundles)), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	link := filepath.Join(wantsDir, DeferredBundlesUnit)
+	if err := os.Symlink(filepath.Join("/etc/systemd/system", DeferredBundlesUnit), link); err != nil && !os.IsExist(err) {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
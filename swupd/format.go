@@ -0,0 +1,84 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+// checkVersionFormat makes sure the requested swupd format, if any, is the
+// format required by version; non numeric formats (i.e staging) are not checked
+func checkVersionFormat(version, required, requested string) error {
+	if requested == "" || requested == required {
+		return nil
+	}
+
+	if _, err := strconv.ParseUint(requested, 10, 32); err != nil {
+		log.Debug("Not checking the non numeric swupd format %q", requested)
+		return nil
+	}
+
+	return errors.Errorf("Version %s requires the swupd format %s, but format %s was requested",
+		version, required, requested)
+}
+
+// CheckFormat returns the swupd format published by the content server for
+// version, failing if it does not match the requested format
+func (s *SoftwareUpdater) CheckFormat(version string) (string, error) {
+	contentURL := s.ContentURL()
+	if contentURL == "" {
+		return "", errors.Errorf("Could not determine the swupd content URL")
+	}
+
+	version, err := s.resolveVersion(contentURL, version)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := fetchContent(fmt.Sprintf("%s/%s/format", contentURL, version))
+	if err != nil {
+		return "", errors.Errorf("Could not read the swupd format of version %s: %v", version, err)
+	}
+
+	required := strings.TrimSpace(string(data))
+	if required == "" {
+		return "", errors.Errorf("The content server published an empty swupd format for version %s", version)
+	}
+
+	if err = checkVersionFormat(version, required, s.format); err != nil {
+		return "", err
+	}
+
+	return required, nil
+}
+
+// CheckVersion makes sure the content server publishes version, returning the
+// concrete version "latest" resolves to
+func (s *SoftwareUpdater) CheckVersion(version string) (string, error) {
+	contentURL := s.ContentURL()
+	if contentURL == "" {
+		return "", errors.Errorf("Could not determine the swupd content URL")
+	}
+
+	resolved, err := s.resolveVersion(contentURL, version)
+	if err != nil {
+		return "", errors.Errorf("Could not resolve the %s version: %v", version, err)
+	}
+
+	if resolved != version {
+		log.Info("Resolved the %s version to %s", version, resolved)
+	}
+
+	if err = headContent(fmt.Sprintf("%s/%s/Manifest.MoM", contentURL, resolved)); err != nil {
+		return "", errors.Errorf("Version %s is not published by the content server %s", resolved, contentURL)
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,401 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	hostContentURLFile = "/usr/share/defaults/swupd/contenturl"
+	hostFormatFile     = "/usr/share/defaults/swupd/format"
+)
+
+var (
+	// installSizeSource returns the content size of the bundles at version
+	// from the host content server, replaceable for testing
+	installSizeSource = func(version string, bundles []string) (uint64, error) {
+		return (&SoftwareUpdater{}).ContentSize(version, bundles)
+	}
+
+	// installSizeSlack is the percentage added to the content size for the
+	// file system overhead and the room needed by later updates
+	installSizeSlack = uint64(30)
+
+	// installSizeCache avoids querying the manifests on every media rescan,
+	// a failed estimate is cached as 0 so it is not retried
+	installSizeCache      = map[string]uint64{}
+	installSizeCacheMutex sync.Mutex
+
+	// installSizePending are the estimates running in the background
+	installSizePending = map[string]bool{}
+
+	// headContent checks an url exists without downloading it, replaceable for testing
+	headContent = network.CheckURLHead
+
+	// contentLength returns the size of an url without downloading it, replaceable for testing
+	contentLength = network.URLContentLength
+)
+
+// manifestHeader holds the subset of a bundle manifest header we care about
+type manifestHeader struct {
+	contentSize uint64
+	includes    []string
+}
+
+// parseMoM parses a Manifest.MoM and returns a map of bundle name to the
+// version in which the bundle manifest was last changed
+func parseMoM(data []byte) map[string]string {
+	bundles := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// Entries are in the form: <flags>\t<hash>\t<version>\t<bundle>
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 || !strings.HasPrefix(fields[0], "M") {
+			continue
+		}
+
+		bundles[fields[3]] = fields[2]
+	}
+
+	return bundles
+}
+
+// parseManifestHeader parses the header of a bundle manifest looking for the
+// content size and the list of included bundles
+func parseManifestHeader(data []byte) (*manifestHeader, error) {
+	header := &manifestHeader{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// The header ends with the first empty line
+		if line == "" {
+			break
+		}
+
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(fields[1])
+
+		switch fields[0] {
+		case "contentsize":
+			size, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, errors.Errorf("Invalid manifest contentsize %q", value)
+			}
+			header.contentSize = size
+		case "includes", "also-add":
+			header.includes = append(header.includes, value)
+		}
+	}
+
+	return header, nil
+}
+
+// readHostFile returns the trimmed content of a host swupd defaults file
+func readHostFile(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
+// fetchContent downloads the content of url and returns it
+func fetchContent(url string) ([]byte, error) {
+	file, err := network.FetchRemoteConfigFile(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(file) }()
+
+	return ioutil.ReadFile(file)
+}
+
+// ContentURL returns the content URL swupd will use for the install
+func (s *SoftwareUpdater) ContentURL() string {
+	url := s.contentURL
+
+	if url == "" {
+		url = s.mirrorURL
+	}
+
+	if url == "" {
+		url = readHostFile(hostContentURLFile)
+	}
+
+	return strings.TrimSuffix(url, "/")
+}
+
+// resolveVersion maps "latest" to the actual version published by the content server
+func (s *SoftwareUpdater) resolveVersion(contentURL, version string) (string, error) {
+	if !utils.IsLatestVersion(version) {
+		return version, nil
+	}
+
+	format := s.format
+	if format == "" {
+		format = readHostFile(hostFormatFile)
+	}
+
+	data, err := fetchContent(fmt.Sprintf("%s/version/format%s/latest", contentURL, format))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// bundleManifests walks the manifests of the bundles at version, including
+// their dependencies, and calls visit with the version each bundle manifest
+// was last changed in and its header
+func (s *SoftwareUpdater) bundleManifests(version string, bundles []string,
+	visit func(contentURL, bundle, bundleVersion string, header *manifestHeader) error) error {
+	contentURL := s.ContentURL()
+	if contentURL == "" {
+		return errors.Errorf("Could not determine the swupd content URL")
+	}
+
+	version, err := s.resolveVersion(contentURL, version)
+	if err != nil {
+		return err
+	}
+
+	data, err := fetchContent(fmt.Sprintf("%s/%s/Manifest.MoM", contentURL, version))
+	if err != nil {
+		return err
+	}
+	mom := parseMoM(data)
+
+	visited := map[string]bool{}
+	pending := append([]string{}, CoreBundles...)
+	pending = append(pending, bundles...)
+
+	for len(pending) > 0 {
+		bundle := pending[0]
+		pending = pending[1:]
+
+		if visited[bundle] {
+			continue
+		}
+		visited[bundle] = true
+
+		bundleVersion, ok := mom[bundle]
+		if !ok {
+			return errors.Errorf("Bundle %q not found in the %s manifest", bundle, version)
+		}
+
+		data, err = fetchContent(fmt.Sprintf("%s/%s/Manifest.%s", contentURL, bundleVersion, bundle))
+		if err != nil {
+			return err
+		}
+
+		header, err := parseManifestHeader(data)
+		if err != nil {
+			return err
+		}
+
+		if err = visit(contentURL, bundle, bundleVersion, header); err != nil {
+			return err
+		}
+		pending = append(pending, header.includes...)
+	}
+
+	return nil
+}
+
+// ContentSize returns the approximate number of bytes the bundles, including
+// their dependencies, take once installed at the given version. The size is
+// the sum of the contentsize header of the bundle manifests.
+func (s *SoftwareUpdater) ContentSize(version string, bundles []string) (uint64, error) {
+	var total uint64
+
+	err := s.bundleManifests(version, bundles,
+		func(contentURL, bundle, bundleVersion string, header *manifestHeader) error {
+			log.Debug("Bundle %s content size: %d", bundle, header.contentSize)
+			total += header.contentSize
+			return nil
+		})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// DownloadSize returns the approximate number of bytes swupd needs to download
+// to install the bundles at the given version. A new install downloads the
+// zero pack of each bundle, including the bundle dependencies, the size is the
+// sum of their compressed sizes as reported by the content server.
+func (s *SoftwareUpdater) DownloadSize(version string, bundles []string) (uint64, error) {
+	var total uint64
+
+	err := s.bundleManifests(version, bundles,
+		func(contentURL, bundle, bundleVersion string, header *manifestHeader) error {
+			size, err := contentLength(fmt.Sprintf("%s/%s/pack-%s-from-0.tar", contentURL, bundleVersion, bundle))
+			if err != nil {
+				return err
+			}
+
+			log.Debug("Bundle %s download size: %d", bundle, size)
+			total += size
+			return nil
+		})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// missingBundles returns the bundles not published in the parsed Manifest.MoM
+func missingBundles(mom map[string]string, bundles []string) []string {
+	missing := []string{}
+
+	for _, bundle := range bundles {
+		if _, ok := mom[bundle]; !ok {
+			missing = append(missing, bundle)
+		}
+	}
+
+	return missing
+}
+
+// CheckBundles verifies the bundles are published by the content server at
+// the given version, it allows validating bundles swupd will only install later
+func (s *SoftwareUpdater) CheckBundles(version string, bundles []string) error {
+	contentURL := s.ContentURL()
+	if contentURL == "" {
+		return errors.Errorf("Could not determine the swupd content URL")
+	}
+
+	version, err := s.resolveVersion(contentURL, version)
+	if err != nil {
+		return err
+	}
+
+	data, err := fetchContent(fmt.Sprintf("%s/%s/Manifest.MoM", contentURL, version))
+	if err != nil {
+		return err
+	}
+
+	if missing := missingBundles(parseMoM(data), bundles); len(missing) > 0 {
+		return errors.Errorf("Bundles not found in the %s manifest: %s", version, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// installSizeKey returns the installSizeCache key of the bundles at version
+func installSizeKey(bundles []string, version uint) string {
+	return utils.VersionUintString(version) + ":" + strings.Join(bundles, ",")
+}
+
+// EstimateInstallSize returns the disk size needed to install the bundles at
+// version, 0 meaning the latest one; it is the content size of the bundle
+// manifests, including the dependencies, plus some slack
+func EstimateInstallSize(bundles []string, version uint) (uint64, error) {
+	key := installSizeKey(bundles, version)
+
+	installSizeCacheMutex.Lock()
+	size, found := installSizeCache[key]
+	installSizeCacheMutex.Unlock()
+	if found && size > 0 {
+		return size, nil
+	}
+
+	size, err := installSizeSource(utils.VersionUintString(version), bundles)
+	if err != nil {
+		return 0, err
+	}
+
+	size += size * installSizeSlack / 100
+
+	installSizeCacheMutex.Lock()
+	installSizeCache[key] = size
+	installSizeCacheMutex.Unlock()
+
+	return size, nil
+}
+
+// estimateInstallSizeAsync estimates the install size in the background, the
+// media lists are not blocked by the manifests downloads
+func estimateInstallSizeAsync(bundles []string, version uint) {
+	key := installSizeKey(bundles, version)
+
+	installSizeCacheMutex.Lock()
+	defer installSizeCacheMutex.Unlock()
+
+	if installSizePending[key] {
+		return
+	}
+	installSizePending[key] = true
+
+	go func() {
+		size, err := EstimateInstallSize(bundles, version)
+		if err != nil {
+			log.Warning("Could not estimate the install size, using the static minimum: %v", err)
+		}
+
+		installSizeCacheMutex.Lock()
+		installSizeCache[key] = size
+		delete(installSizePending, key)
+		installSizeCacheMutex.Unlock()
+	}()
+}
+
+// MinimumInstallSize returns the minimum root size for the bundles of md; the
+// static minimum is returned offline and until the estimate, computed in the
+// background, is available; it remains the floor as the partition validation
+// enforces it
+func MinimumInstallSize(md *model.SystemInstall, staticMinimum uint64) uint64 {
+	if md.Offline {
+		return staticMinimum
+	}
+
+	bundles := append([]string{}, md.Bundles...)
+	bundles = append(bundles, md.UserBundles...)
+
+	if md.Kernel != nil && md.Kernel.Bundle != "none" {
+		bundles = append(bundles, md.Kernel.Bundle)
+	}
+
+	installSizeCacheMutex.Lock()
+	size, found := installSizeCache[installSizeKey(bundles, md.Version)]
+	installSizeCacheMutex.Unlock()
+
+	if !found {
+		estimateInstallSizeAsync(bundles, md.Version)
+		return staticMinimum
+	}
+
+	log.Debug("Estimated install size: %d", size)
+
+	if size < staticMinimum {
+		return staticMinimum
+	}
+
+	return size
+}
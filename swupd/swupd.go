@@ -109,6 +109,8 @@ type SoftwareUpdater struct {
 	skipDiskSpaceCheck bool
 	allowInsecureHTTP  bool
 	skipOptional       bool
+	noSigCheck         bool
+	maxParallel        uint
 }
 
 // Bundle maps a map name and description with the actual checkbox
@@ -215,6 +217,22 @@ func (m Message) Process(printPrefix, line string) {
 	}
 }
 
+// spaceOutput processes the swupd messages and records whether one of them
+// reports the target ran out of space
+type spaceOutput struct {
+	Message
+	noSpace bool
+}
+
+// Process processes the message and looks for a space exhaustion
+func (so *spaceOutput) Process(printPrefix, line string) {
+	so.Message.Process(printPrefix, line)
+
+	if errors.IsNoSpaceMessage(line) {
+		so.noSpace = true
+	}
+}
+
 // IsCoreBundle checks if bundle is in the list of core bundles
 func IsCoreBundle(bundle string) bool {
 	for _, curr := range CoreBundles {
@@ -247,29 +265,84 @@ func New(rootDir string, options args.Args, model *model.SystemInstall) *Softwar
 		stateDir = filepath.Join(rootDir, "/var/lib/swupd")
 	}
 
-	stateDirCache := ""
+	// The offline content takes precedence over a persistent cache
+	stateDirCache := options.SwupdCacheDir
 	if IsOfflineContent() {
 		stateDirCache = conf.OfflineContentDir
 	}
 
 	downloadOnly := false
 
+	contentURL := options.SwupdContentURL
+	versionURL := options.SwupdVersionURL
+	mirrorURL := model.SwupdMirror
+	noSigCheck := false
+
+	// A local content directory replaces the mirror, explicit content and
+	// version URLs still take precedence
+	if model.LocalContentDir != "" {
+		localURL := LocalContentURL(model.LocalContentDir)
+		if contentURL == "" {
+			contentURL = localURL
+		}
+		if versionURL == "" {
+			versionURL = localURL
+		}
+		mirrorURL = ""
+		noSigCheck = model.AllowNoSigCheck
+	}
+
 	return &SoftwareUpdater{
 		rootDir,
 		stateDir,
 		stateDirCache,
 		options.SwupdCertPath,
 		model.SwupdFormat,
-		options.SwupdContentURL,
-		options.SwupdVersionURL,
-		model.SwupdMirror,
+		contentURL,
+		versionURL,
+		mirrorURL,
 		downloadOnly,
 		options.SwupdSkipDiskSpaceCheck,
 		model.AllowInsecureHTTP,
 		model.SwupdSkipOptional,
+		noSigCheck,
+		model.SwupdMaxParallel,
 	}
 }
 
+// LocalContentURL returns the file:// URL for a local swupd content directory
+func LocalContentURL(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	return "file://" + dir
+}
+
+// ValidateLocalContentDir checks dir is a swupd content tree, that is, it
+// provides at least one version/format*/latest file
+func ValidateLocalContentDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return errors.Errorf("Local content directory %q is not accessible: %v", dir, err)
+	}
+
+	if !info.IsDir() {
+		return errors.Errorf("Local content %q is not a directory", dir)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "version", "format*", "latest"))
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if len(matches) == 0 {
+		return errors.Errorf("Local content directory %q has no version/format*/latest file", dir)
+	}
+
+	return nil
+}
+
 func (s *SoftwareUpdater) setExtraFlags(args []string) []string {
 	if s.allowInsecureHTTP {
 		args = append(args, "--allow-insecure-http")
@@ -291,6 +364,14 @@ func (s *SoftwareUpdater) setExtraFlags(args []string) []string {
 		args = append(args, "--skip-optional")
 	}
 
+	if s.noSigCheck {
+		args = append(args, "--nosigcheck")
+	}
+
+	if s.maxParallel > 0 {
+		args = append(args, fmt.Sprintf("--max-parallel-downloads=%d", s.maxParallel))
+	}
+
 	if s.stateDirCache != "" {
 		args = append(args, fmt.Sprintf("--statedir-cache=%s", s.stateDirCache))
 	}
@@ -355,9 +436,11 @@ func (s *SoftwareUpdater) OSInstall(version, printPrefix string, bundles []strin
 		args = append(args, "-B", strings.Join(allBundles, ","))
 	}
 
-	m := Message{}
+	m := &spaceOutput{}
 	err := cmd.RunAndProcessOutput(printPrefix, m, args...)
-	if err != nil {
+	if err != nil && m.noSpace {
+		return errors.InsufficientSpaceErrorf("Not enough space on %s to install the bundles", s.rootDir)
+	} else if err != nil {
 		err = fmt.Errorf("The swupd command \"%s\" failed with %s", strings.Join(args, " "), err)
 		return errors.Wrap(err)
 	}
@@ -647,6 +730,21 @@ func LoadBundleList(model *model.SystemInstall) ([]*Bundle, error) {
 	return filteredBundles, nil
 }
 
+// UpdateCache copies the content downloaded in the state directory to the
+// persistent cacheDir, the next installs read it back with --statedir-cache
+func (s *SoftwareUpdater) UpdateCache(cacheDir string) error {
+	log.Debug("Updating the swupd cache %s from %s", cacheDir, s.stateDir)
+
+	args := []string{
+		"cp",
+		"-ar",
+		s.stateDir + "/.",
+		cacheDir,
+	}
+
+	return cmd.RunAndLog(args...)
+}
+
 // CleanUpState removes the swupd state content directory
 func (s *SoftwareUpdater) CleanUpState() error {
 	log.Debug("Removing swupd state directory: %s", s.stateDir)
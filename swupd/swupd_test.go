@@ -5,7 +5,13 @@
 package swupd
 
 import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -112,6 +118,46 @@ func TestNewWithState(t *testing.T) {
 	}
 }
 
+func TestMaxParallel(t *testing.T) {
+	si := &model.SystemInstall{}
+
+	sw := New("/tmp/test", args.Args{}, si)
+	for _, flag := range sw.setExtraFlags([]string{}) {
+		if strings.HasPrefix(flag, "--max-parallel-downloads") {
+			t.Fatalf("swupd's default should be used when unset, got %s", flag)
+		}
+	}
+
+	si.SwupdMaxParallel = 16
+	sw = New("/tmp/test", args.Args{}, si)
+	if !utils.StringSliceContains(sw.setExtraFlags([]string{}), "--max-parallel-downloads=16") {
+		t.Fatalf("The parallel downloads should be passed to swupd: %v", sw.setExtraFlags([]string{}))
+	}
+}
+
+func TestNewWithCache(t *testing.T) {
+	si := &model.SystemInstall{}
+
+	sw := New("/tmp/test", args.Args{}, si)
+	if utils.StringSliceContains(sw.setExtraFlags([]string{}), "--statedir-cache=/tmp/swupd-cache") {
+		t.Fatal("No cache should be used by default")
+	}
+
+	if IsOfflineContent() {
+		t.Skip("The offline content takes precedence over the cache")
+	}
+
+	sw = New("/tmp/test", args.Args{SwupdCacheDir: "/tmp/swupd-cache"}, si)
+	if !utils.StringSliceContains(sw.setExtraFlags([]string{}), "--statedir-cache=/tmp/swupd-cache") {
+		t.Fatalf("The cache should be passed to swupd: %v", sw.setExtraFlags([]string{}))
+	}
+
+	// The state dir is still the one of the target
+	if sw.stateDir != "/tmp/test/var/lib/swupd" {
+		t.Fatalf("stateDir should not be set to: %s", sw.stateDir)
+	}
+}
+
 type MockProgress struct {
 	output      string
 	description string
@@ -239,3 +285,432 @@ func TestOffline(t *testing.T) {
 		t.Fatalf("Offline Content should be usable")
 	}
 }
+
+func TestParseManifest(t *testing.T) {
+	mom := "MANIFEST\t30\nversion:\t31000\n\n" +
+		"M...\tabc\t30900\tos-core\n" +
+		"M...\tdef\t31000\tbootloader\n" +
+		"F...\tghi\t31000\t/usr/bin/foo\n"
+
+	bundles := parseMoM([]byte(mom))
+	if len(bundles) != 2 {
+		t.Fatalf("parseMoM() returned %d bundles, expected 2", len(bundles))
+	}
+
+	if bundles["os-core"] != "30900" {
+		t.Fatalf("parseMoM() returned version %s for os-core, expected 30900", bundles["os-core"])
+	}
+
+	manifest := "MANIFEST\t30\nversion:\t31000\ncontentsize:\t1024\n" +
+		"includes:\tos-core\nalso-add:\tkernel-native\n\n" +
+		"includes:\tignored\n"
+
+	header, err := parseManifestHeader([]byte(manifest))
+	if err != nil {
+		t.Fatalf("parseManifestHeader() failed: %v", err)
+	}
+
+	if header.contentSize != 1024 {
+		t.Fatalf("parseManifestHeader() returned size %d, expected 1024", header.contentSize)
+	}
+
+	if len(header.includes) != 2 {
+		t.Fatalf("parseManifestHeader() returned includes %v, expected 2 entries", header.includes)
+	}
+
+	if _, err = parseManifestHeader([]byte("contentsize:\tbad\n")); err == nil {
+		t.Fatal("parseManifestHeader() should fail with an invalid contentsize")
+	}
+}
+
+func TestLocalContentDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-content-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if err = ValidateLocalContentDir(dir); err == nil {
+		t.Fatal("ValidateLocalContentDir() should fail without a version/format*/latest file")
+	}
+
+	formatDir := filepath.Join(dir, "version", "format29")
+	if err = utils.MkdirAll(formatDir, 0755); err != nil {
+		t.Fatalf("Could not create format dir: %v", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(formatDir, "latest"), []byte("31000\n"), 0644); err != nil {
+		t.Fatalf("Could not write latest file: %v", err)
+	}
+
+	if err = ValidateLocalContentDir(dir); err != nil {
+		t.Fatalf("ValidateLocalContentDir() failed: %v", err)
+	}
+
+	si := &model.SystemInstall{LocalContentDir: dir, SwupdMirror: "https://example.com/update"}
+	sw := New("/tmp/test", args.Args{}, si)
+
+	if sw.contentURL != "file://"+dir || sw.versionURL != "file://"+dir {
+		t.Fatalf("content and version URLs should point to %s", dir)
+	}
+
+	if sw.mirrorURL != "" {
+		t.Fatal("mirrorURL should be ignored with a local content directory")
+	}
+
+	if utils.StringSliceContains(sw.setExtraFlags([]string{}), "--nosigcheck") {
+		t.Fatal("--nosigcheck should only be set when explicitly allowed")
+	}
+
+	si.AllowNoSigCheck = true
+	sw = New("/tmp/test", args.Args{SwupdContentURL: "file:///other"}, si)
+
+	if sw.contentURL != "file:///other" {
+		t.Fatalf("SwupdContentURL should take precedence, got: %s", sw.contentURL)
+	}
+
+	if !utils.StringSliceContains(sw.setExtraFlags([]string{}), "--nosigcheck") {
+		t.Fatal("--nosigcheck should be set when allowed")
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-content-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	files := map[string]string{
+		"version/format29/latest": "31000\n",
+		"31000/format":            "29\n",
+		"30000/format":            "28\n",
+	}
+
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		if err = utils.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Could not create dir: %v", err)
+		}
+
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Could not write %s: %v", file, err)
+		}
+	}
+
+	tests := []struct {
+		version   string
+		requested string
+		expected  string
+		fail      bool
+	}{
+		{"31000", "29", "29", false},
+		{"latest", "29", "29", false},
+		{"30000", "", "28", false},
+		{"30000", "staging", "28", false},
+		{"30000", "29", "", true},
+		{"29000", "", "", true},
+	}
+
+	for _, test := range tests {
+		si := &model.SystemInstall{LocalContentDir: dir, SwupdFormat: test.requested}
+		format, err := New("/tmp/test", args.Args{}, si).CheckFormat(test.version)
+
+		if test.fail {
+			if err == nil {
+				t.Fatalf("CheckFormat(%s) with format %q should fail", test.version, test.requested)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("CheckFormat(%s) with format %q failed: %v", test.version, test.requested, err)
+		}
+
+		if format != test.expected {
+			t.Fatalf("CheckFormat(%s) returned format %q, expected %q", test.version, format, test.expected)
+		}
+	}
+
+	err = checkVersionFormat("30000", "28", "29")
+	if err == nil || !strings.Contains(err.Error(), "Version 30000 requires the swupd format 28, but format 29 was requested") {
+		t.Fatalf("Unexpected format mismatch error: %v", err)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-content-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for file, content := range map[string]string{
+		"version/format29/latest": "31000\n",
+		"31000/Manifest.MoM":      "MANIFEST\t29\n",
+		"30000/Manifest.MoM":      "MANIFEST\t28\n",
+	} {
+		path := filepath.Join(dir, file)
+		if err = utils.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Could not create dir: %v", err)
+		}
+
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Could not write %s: %v", file, err)
+		}
+	}
+
+	savedHead := headContent
+	defer func() { headContent = savedHead }()
+
+	var heads []string
+	headContent = func(url string) error {
+		heads = append(heads, url)
+		_, err := os.Stat(strings.TrimPrefix(url, "file://"))
+		return err
+	}
+
+	sw := New("/tmp/test", args.Args{}, &model.SystemInstall{LocalContentDir: dir, SwupdFormat: "29"})
+
+	tests := []struct {
+		version  string
+		expected string
+		fail     bool
+	}{
+		{"latest", "31000", false},
+		{"30000", "30000", false},
+		{"29990", "", true},
+	}
+
+	for _, test := range tests {
+		version, err := sw.CheckVersion(test.version)
+
+		if test.fail {
+			if err == nil || !strings.Contains(err.Error(), "Version 29990 is not published") {
+				t.Fatalf("CheckVersion(%s) should fail, got: %v", test.version, err)
+			}
+			continue
+		}
+
+		if err != nil || version != test.expected {
+			t.Fatalf("CheckVersion(%s) returned %q, expected %q: %v", test.version, version, test.expected, err)
+		}
+	}
+
+	if len(heads) != 3 || heads[0] != "file://"+dir+"/31000/Manifest.MoM" {
+		t.Fatalf("Unexpected HEAD requests: %v", heads)
+	}
+}
+
+func TestDeferredBundles(t *testing.T) {
+	mom := parseMoM([]byte("MANIFEST\t30\nversion:\t31000\n\n" +
+		"M...\tabc\t30900\tos-core\n" +
+		"M...\tdef\t31000\teditors\n"))
+
+	if missing := missingBundles(mom, []string{"editors"}); len(missing) != 0 {
+		t.Fatalf("missingBundles() should find editors, got: %v", missing)
+	}
+
+	missing := missingBundles(mom, []string{"editors", "bogus"})
+	if len(missing) != 1 || missing[0] != "bogus" {
+		t.Fatalf("missingBundles() should report bogus, got: %v", missing)
+	}
+
+	dir, err := ioutil.TempDir("", "clr-installer-deferred-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if err = InstallDeferredBundlesUnit(dir, []string{"editors", "dev-utils"}); err != nil {
+		t.Fatalf("InstallDeferredBundlesUnit() failed: %v", err)
+	}
+
+	unit, err := ioutil.ReadFile(filepath.Join(dir, "etc/systemd/system", DeferredBundlesUnit))
+	if err != nil {
+		t.Fatalf("Could not read the unit: %v", err)
+	}
+
+	if !strings.Contains(string(unit), "ExecStart=/usr/bin/swupd bundle-add editors dev-utils\n") {
+		t.Fatalf("Unexpected unit content: %s", unit)
+	}
+
+	link := filepath.Join(dir, "etc/systemd/system/multi-user.target.wants", DeferredBundlesUnit)
+	if target, err := os.Readlink(link); err != nil || target != "/etc/systemd/system/"+DeferredBundlesUnit {
+		t.Fatalf("The unit should be enabled: %s %v", target, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	saved := runVerify
+	defer func() { runVerify = saved }()
+
+	var ran []string
+	output := ""
+	var runErr error
+
+	runVerify = func(args []string) (string, error) {
+		ran = args
+		return output, runErr
+	}
+
+	sw := New("/tmp/test", args.Args{}, &model.SystemInstall{})
+
+	failed, err := sw.Verify("33000")
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Clean verification should pass: %v %v", failed, err)
+	}
+
+	cmdline := strings.Join(ran, " ")
+	if !strings.Contains(cmdline, "swupd verify --path=/tmp/test") || !strings.Contains(cmdline, "-m 33000") {
+		t.Fatalf("Unexpected verify command: %s", cmdline)
+	}
+
+	output = "Verifying files\n" +
+		"Hash mismatch for file: /tmp/test/usr/bin/foo\n" +
+		"Missing file: /tmp/test/usr/lib/libbar.so\n" +
+		"Inspected 1234 files\n  2 files did not match\n"
+	runErr = errors.New("exit status 1")
+
+	failed, err = sw.Verify("33000")
+	if err != nil {
+		t.Fatalf("Corrupted files should not be an error: %v", err)
+	}
+
+	if len(failed) != 2 || failed[0] != "/tmp/test/usr/bin/foo" || failed[1] != "/tmp/test/usr/lib/libbar.so" {
+		t.Fatalf("Unexpected failed files: %v", failed)
+	}
+
+	output = "Error: Unable to download the manifest\n"
+	if _, err = sw.Verify("33000"); err == nil {
+		t.Fatal("A failed swupd verify without reported files should be an error")
+	}
+}
+
+func TestDownloadSize(t *testing.T) {
+	files := map[string]string{
+		"/33000/Manifest.MoM": "MANIFEST\t30\nversion:\t33000\n\n" +
+			"M...\tabc\t32900\tos-core\nM...\tabc\t33000\tos-core-update\n" +
+			"M...\tabc\t33000\topenssh-server\nM...\tabc\t32800\tgames\n",
+		"/32900/Manifest.os-core":        "MANIFEST\t30\ncontentsize:\t1000\n\n",
+		"/33000/Manifest.os-core-update": "MANIFEST\t30\ncontentsize:\t2000\nincludes:\tos-core\n\n",
+		"/33000/Manifest.openssh-server": "MANIFEST\t30\ncontentsize:\t3000\n\n",
+		"/32800/Manifest.games":          "MANIFEST\t30\ncontentsize:\t4000\n\n",
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/pack-") {
+			w.Header().Set("Content-Length", "100")
+			return
+		}
+
+		content, found := files[r.URL.Path]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	sw := &SoftwareUpdater{contentURL: ts.URL}
+
+	size, err := sw.ContentSize("33000", []string{"games"})
+	if err != nil || size != 10000 {
+		t.Fatalf("Expected the content size 10000, got %d: %v", size, err)
+	}
+
+	// The download size is the size of the zero packs, not the content size
+	size, err = sw.DownloadSize("33000", []string{"games"})
+	if err != nil || size != 400 {
+		t.Fatalf("Expected the download size 400, got %d: %v", size, err)
+	}
+
+	if _, err = sw.DownloadSize("33000", []string{"unknown"}); err == nil {
+		t.Fatal("An unpublished bundle should fail the download size")
+	}
+}
+
+func TestEstimateInstallSize(t *testing.T) {
+	savedSource := installSizeSource
+	savedCache := installSizeCache
+	defer func() {
+		installSizeSource = savedSource
+		installSizeCache = savedCache
+	}()
+
+	installSizeCache = map[string]uint64{}
+	installSizePending = map[string]bool{}
+	queries := 0
+	offline := false
+
+	installSizeSource = func(version string, bundles []string) (uint64, error) {
+		queries++
+		if offline {
+			return 0, errors.New("network unreachable")
+		}
+		if version != "33000" {
+			t.Fatalf("Unexpected version %q", version)
+		}
+		return uint64(len(bundles)) * 10 * 1000 * 1000 * 1000, nil
+	}
+
+	size, err := EstimateInstallSize([]string{"desktop", "games"}, 33000)
+	if err != nil {
+		t.Fatalf("Could not estimate the install size: %v", err)
+	}
+
+	if expected := uint64(20*1000*1000*1000) * (100 + installSizeSlack) / 100; size != expected {
+		t.Fatalf("Expected the install size %d, got %d", expected, size)
+	}
+
+	if _, err = EstimateInstallSize([]string{"desktop", "games"}, 33000); err != nil || queries != 1 {
+		t.Fatalf("The estimate should have been cached, %d queries: %v", queries, err)
+	}
+
+	// The estimate runs in the background, the static minimum is used meanwhile
+	waitEstimate := func() {
+		for i := 0; i < 100; i++ {
+			installSizeCacheMutex.Lock()
+			pending := len(installSizePending)
+			installSizeCacheMutex.Unlock()
+			if pending == 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("The install size estimate did not complete")
+	}
+
+	md := &model.SystemInstall{Version: 33000, Bundles: []string{"desktop"}}
+	if size = MinimumInstallSize(md, 4*1000*1000*1000); size != 4*1000*1000*1000 {
+		t.Fatalf("The static minimum should be used until estimated, got %d", size)
+	}
+	waitEstimate()
+
+	if size = MinimumInstallSize(md, 4*1000*1000*1000); size <= 10*1000*1000*1000 {
+		t.Fatalf("The estimate should exceed the static minimum, got %d", size)
+	}
+
+	// The static minimum stays the floor
+	if size = MinimumInstallSize(md, 500*1000*1000*1000); size != 500*1000*1000*1000 {
+		t.Fatalf("The static minimum should be used, got %d", size)
+	}
+
+	// A failed estimate is not retried
+	offline = true
+	queries = 0
+	md.Bundles = []string{"editors"}
+	MinimumInstallSize(md, 4*1000*1000*1000)
+	waitEstimate()
+	if size = MinimumInstallSize(md, 4*1000*1000*1000); size != 4*1000*1000*1000 || queries != 1 {
+		t.Fatalf("The static minimum should be used once failed, got %d after %d queries", size, queries)
+	}
+
+	// Offline installs do not query the manifests
+	md.Offline = true
+	md.Bundles = []string{"sysadmin-basic"}
+	if size = MinimumInstallSize(md, 4*1000*1000*1000); size != 4*1000*1000*1000 || queries != 1 {
+		t.Fatalf("The static minimum should be used offline, got %d after %d queries", size, queries)
+	}
+}
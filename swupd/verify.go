@@ -0,0 +1,101 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+var (
+	// verifyFailurePrefixes are the "swupd verify" report lines of a file
+	// failing the verification
+	verifyFailurePrefixes = []string{
+		"Hash mismatch for file:",
+		"Missing file:",
+	}
+
+	// runVerify runs the swupd verify command, replaceable for testing
+	runVerify = func(args []string) (string, error) {
+		w := bytes.NewBuffer(nil)
+		err := cmd.Run(w, args...)
+
+		return w.String(), err
+	}
+)
+
+// parseVerifyOutput returns the files reported as corrupted or missing
+func parseVerifyOutput(output string) []string {
+	failed := []string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		for _, prefix := range verifyFailurePrefixes {
+			if strings.HasPrefix(line, prefix) {
+				failed = append(failed, strings.TrimSpace(strings.TrimPrefix(line, prefix)))
+			}
+		}
+	}
+
+	return failed
+}
+
+// Verify runs "swupd verify" against the installed target and returns the
+// files failing the verification; swupd failing without reporting any file
+// is returned as an error
+func (s *SoftwareUpdater) Verify(version string) ([]string, error) {
+	args := []string{
+		"swupd",
+		"verify",
+		fmt.Sprintf("--path=%s", s.rootDir),
+		fmt.Sprintf("--statedir=%s", s.stateDir),
+		"-m",
+		version,
+	}
+
+	if s.allowInsecureHTTP {
+		args = append(args, "--allow-insecure-http")
+	}
+
+	if s.certPath != "" {
+		args = append(args, fmt.Sprintf("--certpath=%s", s.certPath))
+	}
+
+	if s.format != "" {
+		args = append(args, fmt.Sprintf("--format=%s", s.format))
+	}
+
+	if s.noSigCheck {
+		args = append(args, "--nosigcheck")
+	}
+
+	if s.contentURL != "" {
+		args = append(args, fmt.Sprintf("--contenturl=%s", s.contentURL))
+	}
+
+	if s.versionURL != "" {
+		args = append(args, fmt.Sprintf("--versionurl=%s", s.versionURL))
+	}
+
+	log.Info("Verifying the installed files: %s", strings.Join(args, " "))
+
+	output, err := runVerify(args)
+	failed := parseVerifyOutput(output)
+
+	if err != nil && len(failed) == 0 {
+		err = fmt.Errorf("The swupd command \"%s\" failed with %s", strings.Join(args, " "), err)
+		return nil, errors.Wrap(err)
+	}
+
+	return failed, nil
+}
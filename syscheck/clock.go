@@ -0,0 +1,110 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package syscheck
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// buildDateLayout is the layout of the build date set by the Makefile
+	buildDateLayout = "2006-01-02_15:04:05_MST"
+
+	// clockSlack is the tolerance given to the lower bound of the clock check
+	clockSlack = 24 * time.Hour
+
+	// maxClockAhead is how far after the build date the clock is still considered
+	// sane, the installer images are refreshed well within it
+	maxClockAhead = 2 * 365 * 24 * time.Hour
+
+	// httpDateTimeout is the time allowed to fetch the Date header
+	httpDateTimeout = 10 * time.Second
+)
+
+var (
+	// minimumClockTime is the lower bound used when the build date is unknown
+	minimumClockTime = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// setSystemClock sets the system clock, replaceable for testing
+	setSystemClock = func(t time.Time) error {
+		tv := syscall.NsecToTimeval(t.UnixNano())
+		return syscall.Settimeofday(&tv)
+	}
+)
+
+// CheckClock checks now is within a sane window: not before the build date
+// of the installer, nor unreasonably far after it; without a build date only
+// the lower bound is checked
+func CheckClock(now time.Time, buildDate string) error {
+	lower := minimumClockTime
+
+	built, err := time.Parse(buildDateLayout, buildDate)
+	if err == nil {
+		lower = built
+	} else {
+		log.Debug("Unable to parse the build date %q, using %s", buildDate, lower.Format(time.RFC3339))
+	}
+
+	if now.Before(lower.Add(-clockSlack)) {
+		return errors.New(utils.Locale.Get("System clock %s is before the installer build date %s",
+			now.UTC().Format(time.RFC3339), lower.Format(time.RFC3339)))
+	}
+
+	if err == nil && now.After(lower.Add(maxClockAhead)) {
+		return errors.New(utils.Locale.Get("System clock %s is too far after the installer build date %s",
+			now.UTC().Format(time.RFC3339), lower.Format(time.RFC3339)))
+	}
+
+	return nil
+}
+
+// ClockFromHTTPDate returns the time reported by the Date header of the server
+// at serverURL; the certificates can not be verified with the very clock being
+// fixed so the header is fetched over plain HTTP
+func ClockFromHTTPDate(serverURL string) (time.Time, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	u.Scheme = "http"
+
+	client := &http.Client{
+		Timeout:   httpDateTimeout,
+		Transport: network.NewTransport(),
+	}
+
+	resp, err := client.Head(u.String())
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, errors.New(utils.Locale.Get("No Date header returned by %s", u.String()))
+	}
+
+	return http.ParseTime(date)
+}
+
+// FixClock sets the system clock from the Date header of the server at url
+func FixClock(url string) error {
+	now, err := ClockFromHTTPDate(url)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Setting the system clock to %s from %s", now.UTC().Format(time.RFC3339), url)
+
+	return setSystemClock(now)
+}
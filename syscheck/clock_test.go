@@ -0,0 +1,78 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package syscheck
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckClock(t *testing.T) {
+	buildDate := "2020-03-11_17:42:07_UTC"
+	built := time.Date(2020, time.March, 11, 17, 42, 7, 0, time.UTC)
+
+	good := []time.Time{
+		built,
+		built.Add(-time.Hour),
+		built.Add(30 * 24 * time.Hour),
+	}
+
+	for _, now := range good {
+		if err := CheckClock(now, buildDate); err != nil {
+			t.Fatalf("Clock %s should be sane: %v", now, err)
+		}
+	}
+
+	bad := []time.Time{
+		time.Unix(0, 0),
+		built.Add(-48 * time.Hour),
+		built.Add(3 * 365 * 24 * time.Hour),
+		time.Date(2170, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, now := range bad {
+		if err := CheckClock(now, buildDate); err == nil {
+			t.Fatalf("Clock %s should NOT be sane", now)
+		}
+	}
+
+	// Development builds fall back to the minimum clock time
+	if err := CheckClock(time.Unix(0, 0), "undefined"); err == nil {
+		t.Fatal("Clock at the epoch should NOT be sane without a build date")
+	}
+
+	if err := CheckClock(time.Date(2170, time.January, 1, 0, 0, 0, 0, time.UTC), "undefined"); err != nil {
+		t.Fatalf("Clock should only have a lower bound without a build date: %v", err)
+	}
+}
+
+func TestFixClock(t *testing.T) {
+	date := time.Date(2020, time.April, 2, 10, 20, 30, 0, time.UTC)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", date.Format(http.TimeFormat))
+	}))
+	defer ts.Close()
+
+	var clock time.Time
+	saved := setSystemClock
+	setSystemClock = func(t time.Time) error {
+		clock = t
+		return nil
+	}
+	defer func() { setSystemClock = saved }()
+
+	// The Date header is fetched over plain HTTP
+	if err := FixClock(strings.Replace(ts.URL, "http://", "https://", 1)); err != nil {
+		t.Fatalf("Could not fix the clock: %v", err)
+	}
+
+	if !clock.Equal(date) {
+		t.Fatalf("Clock should be set to %s, got: %s", date, clock)
+	}
+}
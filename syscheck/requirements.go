@@ -0,0 +1,189 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package syscheck
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+var (
+	// procMeminfo is the memory report of the running system, replaceable for testing
+	procMeminfo = "/proc/meminfo"
+
+	// procCPUInfo is the processors report of the running system, replaceable for testing
+	procCPUInfo = "/proc/cpuinfo"
+
+	// machineArch returns the machine hardware name, i.e. x86_64, replaceable for testing
+	machineArch = func() (string, error) {
+		var uts syscall.Utsname
+		if err := syscall.Uname(&uts); err != nil {
+			return "", err
+		}
+
+		machine := []byte{}
+		for _, c := range uts.Machine {
+			if c == 0 {
+				break
+			}
+			machine = append(machine, byte(c))
+		}
+
+		return string(machine), nil
+	}
+)
+
+// Requirements are the minimum hardware a configuration requires to be
+// installed, unset requirements are not checked
+type Requirements struct {
+	MinMemory string   `yaml:"minMemory,omitempty,flow"`
+	MinCPUs   uint     `yaml:"minCPUs,omitempty,flow"`
+	Arch      []string `yaml:"arch,omitempty,flow"`
+}
+
+// Validate checks the requirements are well formed
+func (r *Requirements) Validate() error {
+	if r.MinMemory != "" {
+		if _, err := storage.ParseVolumeSize(r.MinMemory); err != nil {
+			return errors.ValidationErrorf("Invalid systemRequirements minMemory %q: %v", r.MinMemory, err)
+		}
+	}
+
+	for _, arch := range r.Arch {
+		if arch == "" || strings.ContainsAny(arch, " \t") {
+			return errors.ValidationErrorf("Invalid systemRequirements arch %q", arch)
+		}
+	}
+
+	return nil
+}
+
+// readMemTotal returns the total memory in bytes reported by /proc/meminfo
+func readMemTotal() (uint64, error) {
+	file, err := os.Open(procMeminfo)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, errors.Errorf("Invalid MemTotal in %s: %v", procMeminfo, err)
+		}
+
+		return kb * 1024, nil
+	}
+
+	if err = scanner.Err(); err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	return 0, errors.Errorf("No MemTotal found in %s", procMeminfo)
+}
+
+// readCPUCount returns the number of processors listed by /proc/cpuinfo
+func readCPUCount() (uint, error) {
+	file, err := os.Open(procCPUInfo)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var count uint
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if strings.TrimSpace(fields[0]) == "processor" {
+			count++
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	return count, nil
+}
+
+// Check returns an error listing every requirement the running system does
+// not meet, nil when all of them are met or no requirement is set
+func (r *Requirements) Check() error {
+	if r == nil {
+		return nil
+	}
+
+	unmet := []string{}
+
+	if r.MinMemory != "" {
+		required, err := storage.ParseVolumeSize(r.MinMemory)
+		if err != nil {
+			return err
+		}
+
+		total, err := readMemTotal()
+		if err != nil {
+			return err
+		}
+
+		if total < required {
+			unmet = append(unmet, utils.Locale.Get("%s of memory required, %s found",
+				r.MinMemory, humanMemorySize(total)))
+		}
+	}
+
+	if r.MinCPUs > 0 {
+		count, err := readCPUCount()
+		if err != nil {
+			return err
+		}
+
+		if count < r.MinCPUs {
+			unmet = append(unmet, utils.Locale.Get("%d CPUs required, %d found", r.MinCPUs, count))
+		}
+	}
+
+	if len(r.Arch) > 0 {
+		arch, err := machineArch()
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if !utils.StringSliceContains(r.Arch, arch) {
+			unmet = append(unmet, utils.Locale.Get("Architecture %s required, %s found",
+				strings.Join(r.Arch, " or "), arch))
+		}
+	}
+
+	if len(unmet) > 0 {
+		return errors.Errorf("%s: %s", utils.Locale.Get("System requirements not met"), strings.Join(unmet, "; "))
+	}
+
+	return nil
+}
+
+// humanMemorySize returns size in the binary units the requirements use
+func humanMemorySize(size uint64) string {
+	human, err := storage.HumanReadableSizeXiB(size)
+	if err != nil {
+		return strconv.FormatUint(size, 10)
+	}
+
+	return human
+}
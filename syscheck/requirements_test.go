@@ -0,0 +1,74 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package syscheck
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+func init() {
+	utils.SetLocale("en_US.UTF-8")
+}
+
+func TestRequirements(t *testing.T) {
+	savedMeminfo, savedCPUInfo, savedArch := procMeminfo, procCPUInfo, machineArch
+	defer func() { procMeminfo, procCPUInfo, machineArch = savedMeminfo, savedCPUInfo, savedArch }()
+
+	dir, err := ioutil.TempDir("", "clr-installer-requirements-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	procMeminfo = filepath.Join(dir, "meminfo")
+	meminfo := "MemTotal:        8167876 kB\nMemFree:          204312 kB\n"
+	if err = ioutil.WriteFile(procMeminfo, []byte(meminfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	procCPUInfo = filepath.Join(dir, "cpuinfo")
+	cpuinfo := "processor\t: 0\nflags\t\t: lm sse4_2\n\nprocessor\t: 1\nflags\t\t: lm sse4_2\n"
+	if err = ioutil.WriteFile(procCPUInfo, []byte(cpuinfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	machineArch = func() (string, error) { return "x86_64", nil }
+
+	var none *Requirements
+	if err = none.Check(); err != nil {
+		t.Fatalf("No requirement should always be met: %v", err)
+	}
+
+	met := &Requirements{MinMemory: "4G", MinCPUs: 2, Arch: []string{"x86_64"}}
+	if err = met.Check(); err != nil {
+		t.Fatalf("Requirements should be met: %v", err)
+	}
+
+	unmet := &Requirements{MinMemory: "16G", MinCPUs: 4, Arch: []string{"aarch64"}}
+	err = unmet.Check()
+	if err == nil {
+		t.Fatalf("Requirements should not be met")
+	}
+
+	for _, msg := range []string{"16G of memory", "4 CPUs required, 2 found", "aarch64 required, x86_64 found"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Fatalf("Expected %q in the unmet requirements: %v", msg, err)
+		}
+	}
+
+	if err = (&Requirements{MinMemory: "lots"}).Validate(); err == nil {
+		t.Fatalf("An invalid minMemory should be refused")
+	}
+
+	if err = met.Validate(); err != nil {
+		t.Fatalf("Valid requirements refused: %v", err)
+	}
+}
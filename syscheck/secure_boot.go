@@ -0,0 +1,136 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package syscheck
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+const (
+	// SecureBootEnabled is the state of a firmware enforcing Secure Boot
+	SecureBootEnabled = "enabled"
+
+	// SecureBootDisabled is the state of an EFI firmware not enforcing Secure Boot
+	SecureBootDisabled = "disabled"
+
+	// SecureBootUnsupported is the state of a legacy BIOS firmware
+	SecureBootUnsupported = "unsupported"
+
+	// secureBootVar is the global variable the firmware sets to 1 when
+	// Secure Boot is enforced
+	secureBootVar = "SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+	// mokTargetFile is where the certificate is copied for mokutil to import it
+	mokTargetFile = "tmp/clr-installer-mok.der"
+)
+
+var (
+	// efiDir is where the kernel exposes the EFI firmware, replaceable for testing
+	efiDir = "/sys/firmware/efi"
+)
+
+// SecureBootState returns whether the firmware enforces Secure Boot, reading
+// the SecureBoot efivar: 4 bytes of attributes followed by the value
+func SecureBootState() string {
+	if _, err := os.Stat(efiDir); err != nil {
+		return SecureBootUnsupported
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(efiDir, "efivars", secureBootVar))
+	if err != nil {
+		log.Debug("Could not read the SecureBoot efivar: %v", err)
+		return SecureBootDisabled
+	}
+
+	if len(data) < 5 || data[4] != 1 {
+		return SecureBootDisabled
+	}
+
+	return SecureBootEnabled
+}
+
+// readMOKPassword returns the one-time password MokManager asks for on the
+// next boot to confirm the enrollment
+func readMOKPassword(passwordFile string) (string, error) {
+	content, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		return "", errors.Errorf("Could not read the MOK password file: %v", err)
+	}
+
+	password := strings.TrimSpace(string(content))
+	if password == "" {
+		return "", errors.Errorf("The MOK password file %s is empty", passwordFile)
+	}
+
+	return password, nil
+}
+
+// ValidateMOK checks the Machine Owner Key certificate to enroll and its
+// password file, an empty certificate disables the enrollment
+func ValidateMOK(cert, passwordFile string) error {
+	if cert == "" {
+		if passwordFile != "" {
+			return errors.ValidationErrorf("secureBoot mokPasswordFile requires a mokCert")
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(cert); err != nil {
+		return errors.ValidationErrorf("Invalid secureBoot mokCert: %v", err)
+	}
+
+	if passwordFile == "" {
+		return errors.ValidationErrorf("secureBoot mokCert requires a mokPasswordFile")
+	}
+
+	if _, err := readMOKPassword(passwordFile); err != nil {
+		return errors.ValidationErrorf("%v", err)
+	}
+
+	return nil
+}
+
+// EnrollMOK requests the enrollment of the cert Machine Owner Key with the
+// mokutil of the target, the key is enrolled by MokManager on the next boot;
+// the efivars must be mounted in rootDir
+func EnrollMOK(rootDir, cert, passwordFile string) error {
+	if _, err := os.Stat(filepath.Join(rootDir, "usr", "bin", "mokutil")); err != nil {
+		return errors.Errorf("mokutil is not installed in the target, add the bundle providing it")
+	}
+
+	password, err := readMOKPassword(passwordFile)
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(cert)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	targetCert := filepath.Join(rootDir, mokTargetFile)
+	if err = ioutil.WriteFile(targetCert, content, 0600); err != nil {
+		return errors.Wrap(err)
+	}
+	defer func() { _ = os.Remove(targetCert) }()
+
+	// mokutil asks for the password twice
+	err = cmd.PipeRunAndLog(password+"\n"+password+"\n",
+		"chroot", rootDir, "mokutil", "--import", "/"+mokTargetFile)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Info("Requested the enrollment of the Machine Owner Key %s", cert)
+
+	return nil
+}
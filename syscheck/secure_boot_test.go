@@ -0,0 +1,57 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package syscheck
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecureBootState(t *testing.T) {
+	savedDir := efiDir
+	defer func() { efiDir = savedDir }()
+
+	dir, err := ioutil.TempDir("", "clr-installer-efi-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	efiDir = filepath.Join(dir, "missing")
+	if state := SecureBootState(); state != SecureBootUnsupported {
+		t.Fatalf("Expected Secure Boot %s without EFI, got %s", SecureBootUnsupported, state)
+	}
+
+	efiDir = dir
+	if state := SecureBootState(); state != SecureBootDisabled {
+		t.Fatalf("Expected Secure Boot %s without the efivar, got %s", SecureBootDisabled, state)
+	}
+
+	varsDir := filepath.Join(dir, "efivars")
+	if err = os.MkdirAll(varsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data  []byte
+		state string
+	}{
+		{[]byte{0x06, 0x00, 0x00, 0x00, 0x01}, SecureBootEnabled},
+		{[]byte{0x06, 0x00, 0x00, 0x00, 0x00}, SecureBootDisabled},
+		{[]byte{0x06, 0x00}, SecureBootDisabled},
+	}
+
+	for _, curr := range tests {
+		if err = ioutil.WriteFile(filepath.Join(varsDir, secureBootVar), curr.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if state := SecureBootState(); state != curr.state {
+			t.Fatalf("Expected Secure Boot %s for %v, got %s", curr.state, curr.data, state)
+		}
+	}
+}
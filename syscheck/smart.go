@@ -0,0 +1,111 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package syscheck
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+const (
+	// SmartCheckWarn reports the failing target disks before the install
+	SmartCheckWarn = "warn"
+
+	// SmartCheckBlock refuses to install on a failing target disk
+	SmartCheckBlock = "block"
+
+	// SmartPassed is the status of a healthy disk
+	SmartPassed = "passed"
+
+	// SmartPrefail is the status of a disk with pre-fail attributes at or
+	// below their threshold, while its overall assessment still passes
+	SmartPrefail = "prefail"
+
+	// SmartFailing is the status of a disk failing its overall assessment
+	SmartFailing = "failing"
+
+	// SmartUnsupported is the status of a disk without SMART support
+	SmartUnsupported = "unsupported"
+)
+
+var (
+	// runSmartctl returns the smartctl health report of device, replaceable for testing
+	runSmartctl = func(device string) (string, error) {
+		if _, err := exec.LookPath("smartctl"); err != nil {
+			return "", err
+		}
+
+		w := bytes.NewBuffer(nil)
+		err := cmd.Run(w, "smartctl", "-H", device)
+
+		return w.String(), err
+	}
+)
+
+// DiskHealth is the SMART health status of a target disk
+type DiskHealth struct {
+	Device string
+	Status string
+}
+
+// Failing returns true if the disk reports failing or pre-fail attributes
+func (dh DiskHealth) Failing() bool {
+	return dh.Status == SmartFailing || dh.Status == SmartPrefail
+}
+
+// ValidateSmartCheck checks the SMART check mode, empty disables the check
+func ValidateSmartCheck(mode string) error {
+	switch mode {
+	case "", SmartCheckWarn, SmartCheckBlock:
+		return nil
+	}
+
+	return errors.ValidationErrorf("Invalid smartCheck value %q, expected %q or %q",
+		mode, SmartCheckWarn, SmartCheckBlock)
+}
+
+// parseSmartHealth returns the health status reported by "smartctl -H", ATA
+// disks report an overall assessment, SCSI disks a health status
+func parseSmartHealth(output string) string {
+	switch {
+	case strings.Contains(output, "self-assessment test result: FAILED"):
+		return SmartFailing
+	case strings.Contains(output, "FAILING_NOW"):
+		return SmartPrefail
+	case strings.Contains(output, "self-assessment test result: PASSED"),
+		strings.Contains(output, "SMART Health Status: OK"):
+		return SmartPassed
+	case strings.Contains(output, "SMART Health Status:"):
+		return SmartFailing
+	}
+
+	return SmartUnsupported
+}
+
+// CheckDisksHealth runs the SMART health check of the devices, the disks
+// without SMART support, or when smartctl is missing, are reported unsupported
+func CheckDisksHealth(devices []string) []DiskHealth {
+	results := []DiskHealth{}
+
+	for _, device := range devices {
+		// smartctl exits non-zero for failing disks, the report is still parsed
+		output, err := runSmartctl(device)
+		if err != nil && output == "" {
+			log.Debug("SMART health check of %s not available: %v", device, err)
+		}
+
+		health := DiskHealth{Device: device, Status: parseSmartHealth(output)}
+		log.Info("SMART health of %s: %s", device, health.Status)
+
+		results = append(results, health)
+	}
+
+	return results
+}
@@ -0,0 +1,82 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package syscheck
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseSmartHealth(t *testing.T) {
+	tests := []struct {
+		output string
+		status string
+	}{
+		{"SMART overall-health self-assessment test result: PASSED\n", SmartPassed},
+		{"SMART overall-health self-assessment test result: FAILED!\n", SmartFailing},
+		{"SMART overall-health self-assessment test result: PASSED\n" +
+			"  5 Reallocated_Sector_Ct   0x0033   005   005   010    Pre-fail  Always   FAILING_NOW 1890\n",
+			SmartPrefail},
+		{"SMART Health Status: OK\n", SmartPassed},
+		{"SMART Health Status: HARDWARE IMPENDING FAILURE\n", SmartFailing},
+		{"SMART support is: Unavailable - device lacks SMART capability.\n", SmartUnsupported},
+		{"", SmartUnsupported},
+	}
+
+	for _, curr := range tests {
+		if status := parseSmartHealth(curr.output); status != curr.status {
+			t.Fatalf("Expected status %q for %q, got %q", curr.status, curr.output, status)
+		}
+	}
+}
+
+func TestCheckDisksHealth(t *testing.T) {
+	saved := runSmartctl
+	defer func() { runSmartctl = saved }()
+
+	reports := map[string]string{
+		"/dev/sda": "SMART overall-health self-assessment test result: PASSED\n",
+		"/dev/sdb": "SMART overall-health self-assessment test result: FAILED!\n",
+	}
+
+	runSmartctl = func(device string) (string, error) {
+		if output, found := reports[device]; found {
+			return output, nil
+		}
+		return "", fmt.Errorf("smartctl not found")
+	}
+
+	results := CheckDisksHealth([]string{"/dev/sda", "/dev/sdb", "/dev/loop0"})
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+
+	expected := []struct {
+		status  string
+		failing bool
+	}{
+		{SmartPassed, false},
+		{SmartFailing, true},
+		{SmartUnsupported, false},
+	}
+
+	for i, curr := range expected {
+		if results[i].Status != curr.status || results[i].Failing() != curr.failing {
+			t.Fatalf("Unexpected health for %s: %+v", results[i].Device, results[i])
+		}
+	}
+}
+
+func TestValidateSmartCheck(t *testing.T) {
+	for _, mode := range []string{"", SmartCheckWarn, SmartCheckBlock} {
+		if err := ValidateSmartCheck(mode); err != nil {
+			t.Fatalf("Mode %q should be valid: %v", mode, err)
+		}
+	}
+
+	if err := ValidateSmartCheck("ignore"); err == nil {
+		t.Fatal("Mode \"ignore\" should be invalid")
+	}
+}
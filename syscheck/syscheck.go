@@ -29,7 +29,8 @@ func getCPUFeature(feature string) error {
 }
 
 // RunSystemCheck checks compatibility for clear linux. (e.g. EFI firmware, CPU featureset)
-func RunSystemCheck(quiet bool) error {
+// and the minimum hardware requirements of the configuration, if any
+func RunSystemCheck(quiet bool, req *Requirements) error {
 	log.Info("Running system compatibility checks.")
 
 	//Check the following CPU features from /proc/cpuinfo
@@ -60,6 +61,25 @@ func RunSystemCheck(quiet bool) error {
 		}
 	}
 
+	if req != nil {
+		if !quiet {
+			fmt.Printf("Checking the system requirements of the configuration")
+		}
+
+		if err := req.Check(); err != nil {
+			if !quiet {
+				fmt.Printf(" [*failed*]\n")
+				fmt.Println(err)
+			}
+			log.ErrorError(err)
+
+			return err
+		}
+		if !quiet {
+			fmt.Println(" [success]")
+		}
+	}
+
 	if !quiet {
 		fmt.Println("Success: System is compatible")
 	}
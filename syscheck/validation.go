@@ -0,0 +1,84 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package syscheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// validationScriptTimeout is the time allowed to the validation script
+	validationScriptTimeout = 5 * time.Minute
+
+	// validationScriptPath is the only environment given to the validation script
+	validationScriptPath = "PATH=/usr/bin:/bin:/usr/sbin:/sbin"
+)
+
+var (
+	// runValidationScript runs script feeding input to its stdin and returns
+	// its stderr, replaceable for testing
+	runValidationScript = func(script string, input []byte) (string, error) {
+		var stderr bytes.Buffer
+
+		// Run the script in an empty scratch directory with a minimal
+		// environment so it can not rely on the installer's state
+		workDir, err := ioutil.TempDir("", "clr-installer-validation-")
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = os.RemoveAll(workDir) }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), validationScriptTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, script) // #nosec
+		cmd.Dir = workDir
+		cmd.Env = []string{validationScriptPath, "HOME=" + workDir}
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Stdout = ioutil.Discard
+		cmd.Stderr = &stderr
+
+		err = cmd.Run()
+		if ctx.Err() == context.DeadlineExceeded {
+			err = ctx.Err()
+		}
+
+		return strings.TrimSpace(stderr.String()), err
+	}
+)
+
+// RunValidationScript runs the integrator provided script with input, the
+// install configuration as JSON, on its stdin; a non-zero exit vetoes the
+// install and the returned error carries the script's stderr
+func RunValidationScript(script string, input []byte) error {
+	log.Info("Running pre-install validation script: %s", script)
+
+	stderr, err := runValidationScript(script, input)
+	if stderr != "" {
+		log.Debug("Validation script stderr: %s", stderr)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	log.Error("Validation script %s failed: %v", script, err)
+
+	if stderr == "" {
+		return errors.New(utils.Locale.Get("Pre-install validation script %s failed: %s", script, err.Error()))
+	}
+
+	return errors.New(utils.Locale.Get("Pre-install validation script %s rejected the install: %s", script, stderr))
+}
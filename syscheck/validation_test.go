@@ -0,0 +1,55 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package syscheck
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunValidationScript(t *testing.T) {
+	var gotScript, gotInput string
+
+	saved := runValidationScript
+	defer func() { runValidationScript = saved }()
+
+	runValidationScript = func(script string, input []byte) (string, error) {
+		gotScript = script
+		gotInput = string(input)
+		return "", nil
+	}
+
+	if err := RunValidationScript("/usr/bin/policy", []byte(`{"hostname":"clr"}`)); err != nil {
+		t.Fatalf("Passing script should not abort the install: %v", err)
+	}
+
+	if gotScript != "/usr/bin/policy" || gotInput != `{"hostname":"clr"}` {
+		t.Fatalf("Unexpected script %q or input %q", gotScript, gotInput)
+	}
+
+	runValidationScript = func(script string, input []byte) (string, error) {
+		return "hostname is not allowed", errors.New("exit status 1")
+	}
+
+	err := RunValidationScript("/usr/bin/policy", nil)
+	if err == nil {
+		t.Fatal("Failing script should abort the install")
+	}
+
+	if !strings.Contains(err.Error(), "hostname is not allowed") {
+		t.Fatalf("Script stderr should be surfaced, got: %v", err)
+	}
+}
+
+func TestRunValidationScriptExec(t *testing.T) {
+	if err := RunValidationScript("/bin/true", []byte("{}")); err != nil {
+		t.Fatalf("Script exiting 0 should pass: %v", err)
+	}
+
+	if err := RunValidationScript("/bin/false", []byte("{}")); err == nil {
+		t.Fatal("Script exiting non-zero should fail")
+	}
+}
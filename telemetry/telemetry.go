@@ -86,6 +86,19 @@ var (
 	// since telemetry is a component of the model, can directly include the model here
 	ProgVersion string
 
+	// Units are the systemd units of the telemetry bundle, masked on the
+	// target when telemetry is disabled so the choice outlives the install
+	Units = []string{
+		"telemd.service",
+		"telemd.socket",
+		"telemd-update-trigger.path",
+		"telemd-update-trigger.service",
+		"hprobe.timer",
+		"journal-probe.service",
+		"klogscanner.service",
+		"pstore-probe.service",
+	}
+
 	eventID string
 )
 
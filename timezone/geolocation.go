@@ -0,0 +1,66 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package timezone
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/network"
+)
+
+const (
+	// DefaultGeolocationURL is the default service queried for the time zone
+	// matching the public IP address of the system; it answers with the plain
+	// zone name
+	DefaultGeolocationURL = "https://ipapi.co/timezone/"
+
+	// geolocationTimeout is the time allowed to the geolocation query
+	geolocationTimeout = 10 * time.Second
+
+	// geolocationMaxSize limits how much of the answer is read
+	geolocationMaxSize = 256
+)
+
+// Geolocate queries the geolocation service at url for the time zone of
+// the system; only the time zone name is kept from the answer.
+// The query is a plain GET of url through the configured proxy: no data
+// about the system nor the install is sent, but the service sees the public
+// IP address of the system and the default Go client User-Agent header
+func Geolocate(url string) (*TimeZone, error) {
+	client := &http.Client{
+		Timeout:   geolocationTimeout,
+		Transport: network.NewTransport(),
+	}
+
+	log.Info("Querying the time zone from %s", url)
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Time zone geolocation failed: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: geolocationMaxSize})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	tz := &TimeZone{Code: strings.TrimSpace(string(body))}
+	if !IsValidTimezone(tz) {
+		return nil, errors.Errorf("Invalid geolocated time zone %q", tz.Code)
+	}
+
+	return tz, nil
+}
@@ -19,6 +19,7 @@ import (
 type TimeZone struct {
 	Code        string
 	userDefined bool
+	defaulted   bool
 }
 
 const (
@@ -33,12 +34,23 @@ const (
 // validTimezones stores the list of all valid, known timezones
 var validTimezones []*TimeZone
 
+// NewDefault returns the DefaultTimezone used when no time zone was configured
+func NewDefault() *TimeZone {
+	return &TimeZone{Code: DefaultTimezone, defaulted: true}
+}
+
 // IsUserDefined returns true if the configuration was interactively
 // defined by the user
 func (tz *TimeZone) IsUserDefined() bool {
 	return tz.userDefined
 }
 
+// IsDefaulted returns true if no time zone was configured and the
+// DefaultTimezone is used in its place
+func (tz *TimeZone) IsDefaulted() bool {
+	return tz.defaulted
+}
+
 // MarshalYAML marshals TimeZone into YAML format
 func (tz *TimeZone) MarshalYAML() (interface{}, error) {
 	return tz.Code, nil
@@ -95,10 +107,15 @@ func Load() ([]*TimeZone, error) {
 	return validTimezones, nil
 }
 
-// IsValidTimezone verifies if the given keyboard is valid
+// IsValidTimezone verifies if the given timezone is valid
 func IsValidTimezone(t *TimeZone) bool {
 	var result = false
 
+	// The default is always available, even when timedatectl is not
+	if t != nil && t.Code == DefaultTimezone {
+		return true
+	}
+
 	tzs, err := Load()
 	if err != nil {
 		return result
@@ -127,6 +144,7 @@ func SetTargetTimezone(rootDir string, timezone string) error {
 		rootDir,
 		"ln",
 		"-s",
+		"-f",
 		"-r",
 		tzFile,
 		"/etc/localtime",
@@ -0,0 +1,66 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package timezone
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestDefaultTimezone(t *testing.T) {
+	tz := NewDefault()
+
+	if tz.Code != DefaultTimezone || !tz.IsDefaulted() {
+		t.Fatalf("Unexpected default timezone: %+v", tz)
+	}
+
+	if !IsValidTimezone(tz) {
+		t.Fatalf("Default timezone %q should always be valid", tz.Code)
+	}
+
+	var loaded TimeZone
+	if err := yaml.Unmarshal([]byte("UTC"), &loaded); err != nil {
+		t.Fatalf("Failed to unmarshal timezone: %v", err)
+	}
+
+	if loaded.IsDefaulted() || !IsValidTimezone(&loaded) {
+		t.Fatalf("Configured UTC timezone should be valid and not defaulted: %+v", loaded)
+	}
+}
+
+func TestGeolocate(t *testing.T) {
+	answer := "UTC\n"
+	status := http.StatusOK
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = fmt.Fprint(w, answer)
+	}))
+	defer ts.Close()
+
+	tz, err := Geolocate(ts.URL)
+	if err != nil {
+		t.Fatalf("Geolocation should succeed: %v", err)
+	}
+
+	if tz.Code != DefaultTimezone || tz.IsDefaulted() {
+		t.Fatalf("Unexpected geolocated timezone: %+v", tz)
+	}
+
+	answer = "<html>Not a zone</html>"
+	if _, err = Geolocate(ts.URL); err == nil {
+		t.Fatal("Invalid geolocated timezone should fail")
+	}
+
+	status = http.StatusTooManyRequests
+	answer = "UTC"
+	if _, err = Geolocate(ts.URL); err == nil {
+		t.Fatal("Geolocation error status should fail")
+	}
+}
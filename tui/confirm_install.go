@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/VladimirMarkelov/clui"
 	term "github.com/nsf/termbox-go"
@@ -18,6 +19,7 @@ import (
 	"github.com/clearlinux/clr-installer/model"
 	"github.com/clearlinux/clr-installer/storage"
 	"github.com/clearlinux/clr-installer/swupd"
+	"github.com/clearlinux/clr-installer/syscheck"
 	"github.com/clearlinux/clr-installer/utils"
 )
 
@@ -36,6 +38,34 @@ type ConfirmInstallDialog struct {
 	mediaDetail   *clui.TextView
 	cancelButton  *SimpleButton
 	confirmButton *SimpleButton
+
+	updatesMutex sync.Mutex
+	updates      []func()
+}
+
+// postUpdate queues fn to update the dialog widgets from the event loop;
+// clui has no custom events so a resize to the current screen size is
+// posted, its handler runs the queued updates
+func (dialog *ConfirmInstallDialog) postUpdate(fn func()) {
+	dialog.updatesMutex.Lock()
+	dialog.updates = append(dialog.updates, fn)
+	dialog.updatesMutex.Unlock()
+
+	width, height := clui.ScreenSize()
+	clui.PutEvent(clui.Event{Type: clui.EventResize, Width: width, Height: height})
+}
+
+// runUpdates runs the updates queued by postUpdate, it is called by the
+// event loop; the updates are dropped once the dialog is closed
+func (dialog *ConfirmInstallDialog) runUpdates() {
+	dialog.updatesMutex.Lock()
+	updates := dialog.updates
+	dialog.updates = nil
+	dialog.updatesMutex.Unlock()
+
+	for _, fn := range updates {
+		fn()
+	}
 }
 
 // OnClose sets the callback that is called when the
@@ -111,6 +141,9 @@ func initConfirmDiaglogWindow(dialog *ConfirmInstallDialog) error {
 	dialog.DialogBox.SetConstraints(dWidth, dHeight)
 	dialog.DialogBox.SetPack(clui.Vertical)
 	dialog.DialogBox.SetBorder(clui.BorderAuto)
+	dialog.DialogBox.OnScreenResize(func(ev clui.Event) {
+		dialog.runUpdates()
+	})
 
 	borderFrame := clui.CreateFrame(dialog.DialogBox, dWidth, dHeight, clui.BorderNone, clui.Fixed)
 	borderFrame.SetPack(clui.Vertical)
@@ -159,8 +192,7 @@ func initConfirmDiaglogWindow(dialog *ConfirmInstallDialog) error {
 	dialog.mediaDetail.SetWordWrap(true)
 	dialog.mediaDetail.SetStyle("AltEdit")
 
-	dryRunResults := storage.GetPlannedMediaChanges(dialog.modelSI.InstallSelected, dialog.modelSI.TargetMedias,
-		dialog.modelSI.MediaOpts)
+	dryRunResults := model.BuildPlan(dialog.modelSI).DryRun()
 
 	// Create additional bundle removal warning for offline installs
 	if !controller.NetworkPassing && len(dialog.modelSI.UserBundles) != 0 &&
@@ -171,6 +203,18 @@ func initConfirmDiaglogWindow(dialog *ConfirmInstallDialog) error {
 
 	writeToConfirmInstallDialog(dialog, dryRunResults)
 
+	// The manifests are fetched in the background, the dialog does not wait
+	if controller.NetworkPassing {
+		controller.EstimateDownloadSizeAsync(dialog.modelSI, dialog.options, func(downloadSize string) {
+			dialog.postUpdate(func() {
+				dialog.mediaDetail.AddText([]string{utils.Locale.Get("Estimated download size: %s", downloadSize)})
+			})
+		})
+	} else {
+		dialog.mediaDetail.AddText([]string{
+			utils.Locale.Get("Estimated download size: %s", controller.DownloadSizeUnknown)})
+	}
+
 	buttonFrame := clui.CreateFrame(borderFrame, AutoSize, 1, clui.BorderNone, clui.Fixed)
 	buttonFrame.SetPack(clui.Horizontal)
 	buttonFrame.SetGaps(1, 0)
@@ -180,12 +224,31 @@ func initConfirmDiaglogWindow(dialog *ConfirmInstallDialog) error {
 
 	dialog.confirmButton = CreateSimpleButton(buttonFrame, AutoSize, AutoSize, "Confirm Install", Fixed)
 
-	if storage.GetImpactOnOtherDisks() && !dialog.modelSI.MediaOpts.ForceDestructive {
-		dialog.confirmButton.SetEnabled(false)
-		dialog.confirmButton.SetActive(false)
-	} else {
-		dialog.confirmButton.SetEnabled(true)
-		dialog.confirmButton.SetActive(false)
+	// Erasing a member of an active RAID array or volume group requires --force-destructive
+	forceRequired := storage.GetImpactOnOtherDisks() || storage.HasActiveMemberTargets(dialog.modelSI.InstallSelected)
+	forceBlocked := forceRequired && !dialog.modelSI.MediaOpts.ForceDestructive
+
+	// In block mode the install can not be confirmed before the SMART health is known
+	smartBlock := dialog.modelSI.SmartCheck == syscheck.SmartCheckBlock
+	dialog.confirmButton.SetEnabled(!forceBlocked && !smartBlock)
+	dialog.confirmButton.SetActive(false)
+
+	// smartctl may take a while per disk, the dialog does not wait for it
+	if dialog.modelSI.SmartCheck != "" {
+		go func() {
+			results := []string{}
+			smartBlocked := false
+
+			for _, health := range syscheck.CheckDisksHealth(targets) {
+				results = append(results, utils.Locale.Get("SMART health of %s: %s", health.Device, health.Status))
+				smartBlocked = smartBlocked || (health.Failing() && smartBlock)
+			}
+
+			dialog.postUpdate(func() {
+				dialog.mediaDetail.AddText(results)
+				dialog.confirmButton.SetEnabled(!forceBlocked && !smartBlocked)
+			})
+		}()
 	}
 
 	return nil
@@ -203,6 +266,7 @@ func CreateConfirmInstallDialogBox(modelSI *model.SystemInstall, options args.Ar
 		return nil, fmt.Errorf("Missing model for Confirmation of Installation Dialog")
 	}
 	dialog.modelSI = modelSI
+	dialog.options = options
 
 	if err := initConfirmDiaglogWindow(dialog); err != nil {
 		return nil, fmt.Errorf("Failed to create Confirmation of Installation Dialog: %v", err)
@@ -43,7 +43,8 @@ func (page *LanguagePage) GetConfigDefinition() int {
 // SetDone sets the keyboard page flag done, and sets back the configuration to the data model
 func (page *LanguagePage) SetDone(done bool) bool {
 	page.done = done
-	page.getModel().Language = page.avLanguages[page.langListBox.SelectedItem()]
+	selected := page.avLanguages[page.langListBox.SelectedItem()]
+	page.getModel().Language = selected.KeepCategories(page.getModel().Language)
 	return true
 }
 
@@ -16,6 +16,7 @@ import (
 
 	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/swupd"
 	"github.com/clearlinux/clr-installer/utils"
 )
 
@@ -51,7 +52,8 @@ type MediaConfigPage struct {
 	labelWarning     *clui.Label
 	labelDestructive *clui.Label
 
-	encryptCheck *clui.CheckBox
+	encryptCheck  *clui.CheckBox
+	reuseESPCheck *clui.CheckBox
 
 	advancedCfgBtn *SimpleButton
 
@@ -161,6 +163,10 @@ func (page *MediaConfigPage) SetDone(done bool) bool {
 	if page.safeRadio.Selected() {
 		page.getModel().ClearInstallSelected()
 		selected := page.safeTargets[page.chooserList.SelectedItem()]
+		// The existing EFI System Partition is only reused when explicitly selected
+		if page.reuseESPCheck.State() == 0 {
+			selected.ESP = ""
+		}
 		page.getModel().InstallSelected[selected.Name] = selected
 		log.Debug("Safe Install Target %v", page.getModel().InstallSelected)
 		page.getModel().TargetMedias = nil
@@ -189,6 +195,12 @@ func (page *MediaConfigPage) SetDone(done bool) bool {
 					// Using the whole disk
 					if selected.WholeDisk {
 						storage.NewStandardPartitions(installBlockDevice)
+					} else if selected.ESP != "" {
+						// Partial Disk, reuse the existing EFI System Partition as /boot
+						if err := storage.UseExistingESP(installBlockDevice, selected.ESP); err != nil {
+							page.Panic(err)
+						}
+						storage.AddRootStandardPartition(installBlockDevice, selected.FreeEnd-selected.FreeStart)
 					} else {
 						// Partial Disk, Add our partitions
 						size := selected.FreeEnd - selected.FreeStart
@@ -317,6 +329,7 @@ func (page *MediaConfigPage) safeRadioOnChange(active bool) {
 	page.isDestructiveSelected = false
 	page.isAdvancedSelected = false
 	page.encryptCheck.SetEnabled(true)
+	page.reuseESPCheck.SetEnabled(true)
 	page.advancedCfgBtn.SetEnabled(false)
 
 	// Disable the Confirm Button if we toggled
@@ -347,6 +360,8 @@ func (page *MediaConfigPage) destructiveRadioOnChange(active bool) {
 	page.isSafeSelected = false
 	page.isAdvancedSelected = false
 	page.encryptCheck.SetEnabled(true)
+	page.reuseESPCheck.SetEnabled(false)
+	page.reuseESPCheck.SetState(0)
 	page.advancedCfgBtn.SetEnabled(false)
 
 	// Disable the Confirm Button if we toggled
@@ -381,6 +396,8 @@ func (page *MediaConfigPage) advancedRadioOnChange(active bool) {
 
 	page.encryptCheck.SetEnabled(storage.AdvancedPartitionsRequireEncryption(page.getModel().TargetMedias))
 	page.encryptCheck.SetState(0) // Force off for Advance as not support yet
+	page.reuseESPCheck.SetEnabled(false)
+	page.reuseESPCheck.SetState(0)
 
 	page.advancedCfgBtn.SetEnabled(true)
 
@@ -535,6 +552,12 @@ func newMediaConfigPage(tui *Tui) (Page, error) {
 		}
 	})
 
+	// Reuse the existing EFI System Partition Checkbox, i.e. when dual booting
+	// with Windows; it is never formatted, the loader entries are added to it
+	page.reuseESPCheck = clui.CreateCheckBox(contentFrame, AutoSize, "Reuse the existing EFI System Partition",
+		AutoSize)
+	page.reuseESPCheck.SetEnabled(false)
+
 	// Add a Rescan media button
 	rescanBtn := CreateSimpleButton(page.cFrame, AutoSize, AutoSize, "Rescan Media", Fixed)
 	rescanBtn.OnClick(func(ev clui.Event) {
@@ -647,19 +670,19 @@ func (page *MediaConfigPage) buildMediaLists() error {
 	if model.MediaOpts.SwapFileSet {
 		checkSwapSize, _ = storage.ParseVolumeSize(model.MediaOpts.SwapFileSize)
 	}
-	minSize := storage.MinimumServerInstallSize + checkSwapSize
+	minSize := swupd.MinimumInstallSize(model, storage.MinimumServerInstallSize) + checkSwapSize
 	if model.MediaOpts.SkipValidationSize {
 		minSize = 0
 	}
-	page.safeTargets = storage.FindSafeInstallTargets(minSize, page.devs)
-	page.destructiveTargets = storage.FindAllInstallTargets(minSize, page.devs)
+	page.safeTargets = storage.FindSafeInstallTargets(minSize, page.devs, model.MediaOpts)
+	page.destructiveTargets = storage.FindAllInstallTargets(minSize, page.devs, model.MediaOpts)
 
 	model.TargetMedias = nil
-	for _, curr := range storage.FindAdvancedInstallTargets(page.devs) {
+	for _, curr := range storage.FindAdvancedInstallTargets(page.devs, model.MediaOpts) {
 		model.AddTargetMedia(curr)
 		log.Debug("AddTargetMedia %+v", curr)
 		model.InstallSelected[curr.Name] = storage.InstallTarget{Name: curr.Name, Friendly: curr.Model,
-			Removable: curr.RemovableDevice}
+			Removable: curr.RemovableDevice, ActiveMembers: curr.ActiveMembers()}
 		page.isAdvancedSelected = true
 	}
 
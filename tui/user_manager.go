@@ -481,6 +481,7 @@ func (page *UserManagerPage) addUser(addUser *user.User) {
 		UserName: addUser.UserName,
 		Password: addUser.Password,
 		Admin:    addUser.Admin,
+		Groups:   addUser.Groups,
 	}
 
 	page.users = append(page.users, newUser)
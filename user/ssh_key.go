@@ -0,0 +1,120 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package user
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// SSHKeyFormatMessage is the SSH public key requirements
+	SSHKeyFormatMessage = "SSH key must be an OpenSSH public key: [options] <type> <base64 key> [comment]"
+
+	// SSHKeyTypeMessage is reported when the encoded key does not match its type
+	SSHKeyTypeMessage = "SSH key data does not match the key type %s"
+)
+
+var (
+	// sshKeyTypes are the public key types accepted by OpenSSH
+	sshKeyTypes = map[string]bool{
+		"ssh-rsa":                            true,
+		"ssh-dss":                            true,
+		"ssh-ed25519":                        true,
+		"ecdsa-sha2-nistp256":                true,
+		"ecdsa-sha2-nistp384":                true,
+		"ecdsa-sha2-nistp521":                true,
+		"sk-ssh-ed25519@openssh.com":         true,
+		"sk-ecdsa-sha2-nistp256@openssh.com": true,
+	}
+)
+
+// IsValidSSHKey checks the key is a well formed authorized_keys entry, the
+// key type may be preceded by options and the key followed by a comment
+func IsValidSSHKey(key string) (bool, string) {
+	if strings.ContainsAny(key, "\r\n") {
+		return false, utils.Locale.Get(SSHKeyFormatMessage)
+	}
+
+	fields := strings.Fields(key)
+
+	for idx, keyType := range fields {
+		if !sshKeyTypes[keyType] {
+			continue
+		}
+
+		if idx+1 >= len(fields) {
+			break
+		}
+
+		blob, err := base64.StdEncoding.DecodeString(fields[idx+1])
+		if err != nil {
+			break
+		}
+
+		// The key data starts with its length prefixed type
+		if len(blob) < 4 {
+			return false, utils.Locale.Get(SSHKeyTypeMessage, keyType)
+		}
+
+		size := binary.BigEndian.Uint32(blob)
+		if uint64(size) > uint64(len(blob)-4) || string(blob[4:4+size]) != keyType ||
+			len(blob) == int(4+size) {
+			return false, utils.Locale.Get(SSHKeyTypeMessage, keyType)
+		}
+
+		return true, ""
+	}
+
+	return false, utils.Locale.Get(SSHKeyFormatMessage)
+}
+
+// IsSSHKeyFile returns true if key is the absolute path of an existing key
+// file rather than the key itself, the ister configs list the users public
+// key files
+func IsSSHKeyFile(key string) bool {
+	if !filepath.IsAbs(key) {
+		return false
+	}
+
+	fi, err := os.Stat(key)
+
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// ReadSSHKeyFile returns the keys of the public key file path, the empty
+// and comment lines are skipped and every key must be a valid OpenSSH key
+func ReadSSHKeyFile(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	keys := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if ok, msg := IsValidSSHKey(line); !ok {
+			return nil, errors.Errorf("%s: %s", path, msg)
+		}
+		keys = append(keys, line)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.Errorf("%s: %s", path, utils.Locale.Get(SSHKeyFormatMessage))
+	}
+
+	return keys, nil
+}
@@ -28,6 +28,7 @@ type User struct {
 	UserName string   `yaml:"username,omitempty,flow"`
 	Password string   `yaml:"password,omitempty,flow"`
 	Admin    bool     `yaml:"admin,omitempty,flow"`
+	Groups   []string `yaml:"groups,omitempty,flow"`
 	SSHKeys  []string `yaml:"ssh-keys,omitempty,flow"`
 }
 
@@ -36,6 +37,9 @@ const (
 
 	// RequiredBundle the bundle needed to enable non-root user accounts
 	RequiredBundle = "sysadmin-basic"
+
+	// AdminGroup is the group granting sudo rights to its members
+	AdminGroup = "wheel"
 )
 
 var (
@@ -140,8 +144,9 @@ func setTempTargetPAMConfig(rootDir string) error {
 	return nil
 }
 
-// Apply creates the user and sets their password into chroot'ed rootDir
-func Apply(rootDir string, users []*User) error {
+// Apply creates the user and sets their password into chroot'ed rootDir,
+// the root account is locked when lockRoot is set
+func Apply(rootDir string, users []*User, lockRoot bool) error {
 	if len(users) == 0 {
 		return nil
 	}
@@ -186,7 +191,9 @@ func Apply(rootDir string, users []*User) error {
 	// we have user account which are Admin (sudo)
 	// OR
 	// The root account is defined with SSH Keys, no password
-	if (!rootPassSet && haveAdmins) || rootSSHOnly {
+	// OR
+	// The configuration requests the root account to be locked
+	if (!rootPassSet && haveAdmins) || rootSSHOnly || lockRoot {
 		log.Info("Disabling the 'root' account.")
 		if err := disableRoot(rootDir); err != nil {
 			prg.Failure()
@@ -289,6 +296,40 @@ func (u *User) getUserHome(rootDir string) string {
 	return home
 }
 
+// SupplementaryGroups returns the groups the user is added to, including
+// the admin group for administrative users
+func (u *User) SupplementaryGroups() []string {
+	groups := []string{}
+
+	if u.Admin {
+		groups = append(groups, AdminGroup)
+	}
+
+	for _, group := range u.Groups {
+		if !utils.StringSliceContains(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// addGroups creates the groups not yet defined in the target install
+func addGroups(rootDir string, groups []string) error {
+	for _, group := range groups {
+		if err := cmd.RunAndLog("chroot", rootDir, "getent", "group", group); err == nil {
+			continue
+		}
+
+		log.Info("Adding the missing group '%s'", group)
+		if err := cmd.RunAndLog("chroot", rootDir, "groupadd", group); err != nil {
+			return errors.Errorf("Could not create the group %q: %v", group, err)
+		}
+	}
+
+	return nil
+}
+
 // apply applies the user configuration to the target install
 func (u *User) apply(rootDir string) error {
 	accountAdded := false
@@ -305,13 +346,6 @@ func (u *User) apply(rootDir string) error {
 			u.Login,
 		}
 
-		if u.Admin {
-			args = append(args, []string{
-				"-G",
-				"wheel",
-			}...)
-		}
-
 		if err := cmd.RunAndLog(args...); err != nil {
 			return errors.Wrap(err)
 		}
@@ -319,6 +353,26 @@ func (u *User) apply(rootDir string) error {
 		accountAdded = true
 	}
 
+	if groups := u.SupplementaryGroups(); len(groups) > 0 {
+		if err := addGroups(rootDir, groups); err != nil {
+			return err
+		}
+
+		args := []string{
+			"chroot",
+			rootDir,
+			"usermod",
+			"--append",
+			"--groups",
+			strings.Join(groups, ","),
+			u.Login,
+		}
+
+		if err := cmd.RunAndLog(args...); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
 	if u.Password != "" {
 		if !accountAdded {
 			// Unlock the account
@@ -370,6 +424,11 @@ func writeSSHKey(rootDir string, u *User) error {
 		return err
 	}
 
+	// Enforce the modes sshd requires regardless of the umask or existing files
+	if err := os.Chmod(dpath, 0700); err != nil {
+		return err
+	}
+
 	f, err := os.OpenFile(fpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
@@ -379,7 +438,26 @@ func writeSSHKey(rootDir string, u *User) error {
 		_ = f.Close()
 	}()
 
-	cnt := fmt.Sprintf("%s\n", strings.Join(u.SSHKeys, "\n"))
+	if err = f.Chmod(0600); err != nil {
+		return err
+	}
+
+	// The key files are expanded to their keys
+	keys := []string{}
+	for _, key := range u.SSHKeys {
+		if !IsSSHKeyFile(key) {
+			keys = append(keys, key)
+			continue
+		}
+
+		fileKeys, err := ReadSSHKeyFile(key)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, fileKeys...)
+	}
+
+	cnt := fmt.Sprintf("%s\n", strings.Join(keys, "\n"))
 	bt := []byte(cnt)
 	n, err := f.Write(bt)
 	if err != nil {
@@ -395,7 +473,8 @@ func writeSSHKey(rootDir string, u *User) error {
 		rootDir,
 		"/usr/bin/chown",
 		"-R",
-		fmt.Sprintf("%s:%s", u.Login, u.Login),
+		// An empty group selects the login group of the user
+		fmt.Sprintf("%s:", u.Login),
 		sshDir,
 	}
 
@@ -440,6 +519,19 @@ func IsValidLogin(login string) (bool, string) {
 	return true, ""
 }
 
+// IsValidGroup checks the group name restrictions
+func IsValidGroup(group string) (bool, string) {
+	if !groupExp.MatchString(group) {
+		return false, utils.Locale.Get(GroupRegexRequirementMessage)
+	}
+
+	if len(group) > MaxGroupLength {
+		return false, utils.Locale.Get(GroupMaxRequirementMessage, MaxGroupLength)
+	}
+
+	return true, ""
+}
+
 // IsValidPassword checks the minimum password requirements
 func IsValidPassword(pwd string) (bool, string) {
 	validator := NewValidator("", "", pwd)
@@ -28,6 +28,8 @@ const (
 	MinPasswordLength = 8
 	// MaxPasswordLength is the shortest possible password
 	MaxPasswordLength = 255
+	// MaxGroupLength is the longest possible group name
+	MaxGroupLength = 32
 
 	// UsernameCharRequirementMessage is basic username requirements
 	UsernameCharRequirementMessage = "Username must contain only numbers, letters, commas, - or _"
@@ -44,6 +46,12 @@ const (
 	// LoginRegexRequirementMessage is the basic password requirements
 	LoginRegexRequirementMessage = "Login must contain only numbers, letters, -, . or _"
 
+	// GroupRegexRequirementMessage is the basic group name requirements
+	GroupRegexRequirementMessage = "Group must start with a lowercase letter or _ and contain only lowercase letters, numbers, - or _"
+
+	// GroupMaxRequirementMessage is the basic group name requirements
+	GroupMaxRequirementMessage = "Group maximum length is %d"
+
 	// PasswordMinRequirementMessage is the basic password requirements
 	PasswordMinRequirementMessage = "Password must be at least %d characters long"
 
@@ -54,6 +62,7 @@ const (
 var (
 	usernameExp = regexp.MustCompile("^([a-zA-Z]+[0-9a-zA-Z-_ ,'.]*|)$")
 	loginExp    = regexp.MustCompile("^[a-zA-Z]+[0-9a-zA-Z-_.]*$")
+	groupExp    = regexp.MustCompile("^[a-z_][0-9a-z-_]*$")
 )
 
 // NewValidator creates/allocates a new user validation
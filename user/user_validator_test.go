@@ -6,7 +6,10 @@ package user
 
 import (
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -172,3 +175,107 @@ func TestLoginValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestIsValidSSHKey(t *testing.T) {
+	valid := []string{
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4f",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4f user@host",
+		"no-pty,from=\"10.0.0.1\" ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAABADA/+4= user@host",
+	}
+
+	for _, curr := range valid {
+		if ok, msg := IsValidSSHKey(curr); !ok {
+			t.Fatalf("SSH key %q should be valid: %s", curr, msg)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"ssh-rsa xxxxxxxxxxxxxxxxxxxxxxxxxxxx",
+		"ssh-rsa",
+		"ssh-foo AAAAC3NzaC1lZDI1NTE5AAAAIAABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4f",
+		"ssh-rsa AAAAC3NzaC1lZDI1NTE5AAAAIAABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4f",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4f\nssh-rsa AAAA",
+	}
+
+	for _, curr := range invalid {
+		if ok, _ := IsValidSSHKey(curr); ok {
+			t.Fatalf("SSH key %q should be invalid", curr)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "clr-installer-ssh-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	keyFile := filepath.Join(dir, "key.pub")
+	if err = ioutil.WriteFile(keyFile, []byte("# admin keys\n"+valid[0]+"\n\n"+valid[1]+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsSSHKeyFile(keyFile) || IsSSHKeyFile("key.pub") || IsSSHKeyFile(filepath.Join(dir, "missing.pub")) ||
+		IsSSHKeyFile(dir) || IsSSHKeyFile("ssh-rsa") || IsSSHKeyFile(valid[0]) {
+		t.Fatal("Only the existing absolute key file paths should be reported as key files")
+	}
+
+	keys, err := ReadSSHKeyFile(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to read the key file: %v", err)
+	}
+
+	if len(keys) != 2 || keys[0] != valid[0] || keys[1] != valid[1] {
+		t.Fatalf("Unexpected keys %v", keys)
+	}
+
+	for _, content := range []string{"# no key\n", valid[0] + "\n" + invalid[1] + "\n"} {
+		if err = ioutil.WriteFile(keyFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = ReadSSHKeyFile(keyFile); err == nil {
+			t.Fatalf("Key file %q should be invalid", content)
+		}
+	}
+}
+
+func TestGroupValidation(t *testing.T) {
+	tests := []struct {
+		group string
+		valid bool
+	}{
+		{"wheel", true},
+		{"docker", true},
+		{"_build-users1", true},
+		{"", false},
+		{"1group", false},
+		{"Docker", false},
+		{"dev ops", false},
+		{"dev,ops", false},
+		{generateRandomString(MaxGroupLength, ""), true},
+		{generateRandomString(MaxGroupLength+1, ""), false},
+	}
+
+	for _, curr := range tests {
+		if ok, msg := IsValidGroup(curr.group); ok != curr.valid {
+			t.Fatalf("Expected valid %v for group %q, got %v: %s", curr.valid, curr.group, ok, msg)
+		}
+	}
+}
+
+func TestSupplementaryGroups(t *testing.T) {
+	usr := &User{Login: "clrlinux", Admin: true, Groups: []string{"docker", "wheel", "kvm"}}
+
+	groups := usr.SupplementaryGroups()
+	if strings.Join(groups, ",") != "wheel,docker,kvm" {
+		t.Fatalf("Unexpected supplementary groups: %v", groups)
+	}
+
+	usr.Admin = false
+	usr.Groups = nil
+	if groups = usr.SupplementaryGroups(); len(groups) != 0 {
+		t.Fatalf("Expected no supplementary groups, got %v", groups)
+	}
+}
@@ -32,25 +32,34 @@ var ClearVersion string
 
 // ParseOSClearVersion parses the current version of the Clear Linux OS
 func ParseOSClearVersion() error {
-	var versionBuf []byte
-	var err error
-
 	// in order to avoid issues raised by format bumps between installers image
 	// version and the latest released we assume the installers host version
 	// in other words we use the same version swupd is based on
-	if versionBuf, err = ioutil.ReadFile("/usr/lib/os-release"); err != nil {
-		return errors.Errorf("Read version file /usr/lib/os-release: %v", err)
+	version, err := ParseOSVersion("/usr/lib/os-release")
+	if err != nil {
+		return err
+	}
+
+	ClearVersion = version
+
+	return nil
+}
+
+// ParseOSVersion returns the VERSION_ID of the os-release file
+func ParseOSVersion(file string) (string, error) {
+	versionBuf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", errors.Errorf("Read version file %s: %v", file, err)
 	}
+
 	versionExp := regexp.MustCompile(`VERSION_ID=([0-9][0-9]*)`)
 	match := versionExp.FindSubmatch(versionBuf)
 
 	if len(match) < 2 {
-		return errors.Errorf("Version not found in /usr/lib/os-release")
+		return "", errors.Errorf("Version not found in %s", file)
 	}
 
-	ClearVersion = string(match[1])
-
-	return nil
+	return string(match[1]), nil
 }
 
 // MkdirAll similar to go's standard os.MkdirAll() this function creates a directory